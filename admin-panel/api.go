@@ -0,0 +1,422 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"yagnoetik-sdk/adminapi"
+
+	"github.com/gorilla/mux"
+)
+
+// SetupRoutes registers the endpoints the panel page calls. Each one calls
+// the server as the signed-in user, with the key for the user's role, so
+// that the server enforces the role and names the user in its audit log.
+func (a *AdminPanel) SetupRoutes(r *mux.Router, require func(http.Handler) http.Handler) {
+	handle := func(path string, handler http.HandlerFunc, method string) {
+		r.Handle(path, require(handler)).Methods(method)
+	}
+
+	handle("/api/clients", a.listClients, "GET")
+	handle("/api/clients", a.createClient, "POST")
+	handle("/api/clients/bulk", a.bulkCreateClients, "POST")
+	handle("/api/clients/export", a.exportClients, "GET")
+	handle("/api/clients/import", a.importClients, "POST")
+	handle("/api/clients/{uuid}", a.updateClient, "PATCH")
+	handle("/api/clients/{uuid}", a.deleteClient, "DELETE")
+	handle("/api/clients/{uuid}/block", a.blockClient, "POST")
+	handle("/api/clients/{uuid}/unblock", a.unblockClient, "POST")
+	handle("/api/clients/{uuid}/extend", a.extendClient, "POST")
+	handle("/api/clients/{uuid}/rotate", a.rotateClient, "POST")
+	handle("/api/clients/{uuid}/quota/reset", a.resetQuota, "POST")
+	handle("/api/clients/{uuid}/config", a.clientConfig, "GET")
+	handle("/api/clients/{uuid}/usage", a.clientUsage, "GET")
+	handle("/api/sessions", a.listSessions, "GET")
+	handle("/api/sessions/{id}", a.closeSession, "DELETE")
+	handle("/api/tiers", a.listTiers, "GET")
+	handle("/api/events", a.streamEvents, "GET")
+}
+
+// server returns the API client for the signed-in user's role and a
+// context that names the user and their address to the server.
+func (a *AdminPanel) server(r *http.Request) (*adminapi.API, context.Context) {
+	s := currentSession(r)
+	ctx := adminapi.WithActor(r.Context(), s.username)
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		// Anyone else could put any address in the header
+		if prior := r.Header.Get("X-Forwarded-For"); prior != "" && a.proxies.trusts(r) {
+			host = prior + ", " + host
+		}
+		ctx = adminapi.WithForwardedFor(ctx, host)
+	}
+	return a.apis[s.role], ctx
+}
+
+// serverError passes on the server's status and message, or reports that
+// the server could not be reached.
+func serverError(w http.ResponseWriter, err error) {
+	var apiErr *adminapi.Error
+	if errors.As(err, &apiErr) {
+		http.Error(w, apiErr.Message, apiErr.StatusCode)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadGateway)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// clientQuery reads the page's client filters.
+func clientQuery(r *http.Request) (adminapi.ClientQuery, error) {
+	values := r.URL.Query()
+	q := adminapi.ClientQuery{
+		Tag:    values.Get("tag"),
+		Text:   values.Get("q"),
+		Sort:   values.Get("sort"),
+		Cursor: values.Get("cursor"),
+	}
+	if status := values.Get("status"); status != "" {
+		q.Status = strings.Split(status, ",")
+	}
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil {
+			return q, errors.New("Invalid limit")
+		}
+		q.Limit = n
+	}
+	for name, t := range map[string]*time.Time{
+		"expires_after":  &q.ExpiresAfter,
+		"expires_before": &q.ExpiresBefore,
+	} {
+		if s := values.Get(name); s != "" {
+			parsed, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				return q, errors.New("Invalid " + name)
+			}
+			*t = parsed
+		}
+	}
+	return q, nil
+}
+
+// listClients returns one page of clients as an array, with the total and
+// the next page's cursor in X-Total-Count and X-Next-Cursor.
+func (a *AdminPanel) listClients(w http.ResponseWriter, r *http.Request) {
+	q, err := clientQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	api, ctx := a.server(r)
+	page, err := api.ListClients(ctx, q)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	w.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
+	if page.NextCursor != "" {
+		w.Header().Set("X-Next-Cursor", page.NextCursor)
+	}
+	clients := page.Clients
+	if clients == nil {
+		clients = []adminapi.Client{}
+	}
+	writeJSON(w, http.StatusOK, clients)
+}
+
+func (a *AdminPanel) createClient(w http.ResponseWriter, r *http.Request) {
+	var req adminapi.CreateClientRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	api, ctx := a.server(r)
+	client, err := api.CreateClient(ctx, req)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, client)
+}
+
+func (a *AdminPanel) bulkCreateClients(w http.ResponseWriter, r *http.Request) {
+	var req adminapi.BulkCreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	api, ctx := a.server(r)
+	created, err := api.BulkCreateClients(ctx, req)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, created)
+}
+
+func (a *AdminPanel) exportClients(w http.ResponseWriter, r *http.Request) {
+	q, err := clientQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	api, ctx := a.server(r)
+	download, err := api.ExportClients(ctx, q, r.URL.Query().Get("format"), r.URL.Query().Get("include_secrets") == "true")
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	defer download.Body.Close()
+
+	w.Header().Set("Content-Type", download.ContentType)
+	if download.Filename != "" {
+		w.Header().Set("Content-Disposition", `attachment; filename="`+download.Filename+`"`)
+	}
+	io.Copy(w, download.Body)
+}
+
+// importClients returns the report with 409 when the server rejects the
+// import for conflicts, so that the page can show them.
+func (a *AdminPanel) importClients(w http.ResponseWriter, r *http.Request) {
+	api, ctx := a.server(r)
+	report, err := api.ImportClients(ctx, r.Body, r.Header.Get("Content-Type"), adminapi.ImportOptions{
+		OnConflict: r.URL.Query().Get("on_conflict"),
+		DryRun:     r.URL.Query().Get("dry_run") == "true",
+	})
+	switch {
+	case report != nil && err != nil:
+		writeJSON(w, http.StatusConflict, report)
+	case err != nil:
+		serverError(w, err)
+	default:
+		writeJSON(w, http.StatusOK, report)
+	}
+}
+
+func (a *AdminPanel) updateClient(w http.ResponseWriter, r *http.Request) {
+	var req adminapi.UpdateClientRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	api, ctx := a.server(r)
+	client, err := api.UpdateClient(ctx, mux.Vars(r)["uuid"], req, 0)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, client)
+}
+
+func (a *AdminPanel) deleteClient(w http.ResponseWriter, r *http.Request) {
+	api, ctx := a.server(r)
+	if err := api.DeleteClient(ctx, mux.Vars(r)["uuid"], 0); err != nil {
+		serverError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *AdminPanel) blockClient(w http.ResponseWriter, r *http.Request) {
+	api, ctx := a.server(r)
+	client, err := api.BlockClient(ctx, mux.Vars(r)["uuid"])
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, client)
+}
+
+func (a *AdminPanel) unblockClient(w http.ResponseWriter, r *http.Request) {
+	api, ctx := a.server(r)
+	client, err := api.UnblockClient(ctx, mux.Vars(r)["uuid"])
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, client)
+}
+
+func (a *AdminPanel) extendClient(w http.ResponseWriter, r *http.Request) {
+	var req adminapi.ExtendRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	api, ctx := a.server(r)
+	client, err := api.ExtendClient(ctx, mux.Vars(r)["uuid"], req.Duration)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, client)
+}
+
+func (a *AdminPanel) rotateClient(w http.ResponseWriter, r *http.Request) {
+	api, ctx := a.server(r)
+	client, err := api.RotateClient(ctx, mux.Vars(r)["uuid"])
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, client)
+}
+
+func (a *AdminPanel) resetQuota(w http.ResponseWriter, r *http.Request) {
+	api, ctx := a.server(r)
+	client, err := api.ResetQuota(ctx, mux.Vars(r)["uuid"])
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, client)
+}
+
+// clientConfig returns the config with its share link for the QR code
+// when format is bundle, and otherwise the config.json download.
+func (a *AdminPanel) clientConfig(w http.ResponseWriter, r *http.Request) {
+	api, ctx := a.server(r)
+	config, err := api.ClientConfig(ctx, mux.Vars(r)["uuid"])
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if r.URL.Query().Get("format") == "bundle" {
+		writeJSON(w, http.StatusOK, config)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="config.json"`)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(config.Config)
+}
+
+func (a *AdminPanel) clientUsage(w http.ResponseWriter, r *http.Request) {
+	var from, to time.Time
+	for name, t := range map[string]*time.Time{"from": &from, "to": &to} {
+		if s := r.URL.Query().Get(name); s != "" {
+			parsed, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				http.Error(w, "Invalid "+name, http.StatusBadRequest)
+				return
+			}
+			*t = parsed
+		}
+	}
+	api, ctx := a.server(r)
+	usage, err := api.ClientUsage(ctx, mux.Vars(r)["uuid"], from, to, r.URL.Query().Get("step"))
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, usage)
+}
+
+func (a *AdminPanel) listSessions(w http.ResponseWriter, r *http.Request) {
+	api, ctx := a.server(r)
+	sessions, err := api.ListSessions(ctx, r.URL.Query().Get("client"))
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if sessions == nil {
+		sessions = []adminapi.SessionInfo{}
+	}
+	writeJSON(w, http.StatusOK, sessions)
+}
+
+func (a *AdminPanel) closeSession(w http.ResponseWriter, r *http.Request) {
+	api, ctx := a.server(r)
+	if err := api.CloseSession(ctx, mux.Vars(r)["id"], r.URL.Query().Get("reason")); err != nil {
+		serverError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *AdminPanel) listTiers(w http.ResponseWriter, r *http.Request) {
+	api, ctx := a.server(r)
+	tiers, err := api.ListTiers(ctx)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if tiers == nil {
+		tiers = []adminapi.Tier{}
+	}
+	writeJSON(w, http.StatusOK, tiers)
+}
+
+// eventsRelayLifetime bounds one relayed event stream. The browser then
+// reconnects from its last event, which checks the session again, so that
+// a logout or an expired session ends the feed.
+const eventsRelayLifetime = 5 * time.Minute
+
+// streamEvents relays the server's event stream to the page's EventSource,
+// resuming from the Last-Event-ID the browser sends on reconnect.
+func (a *AdminPanel) streamEvents(w http.ResponseWriter, r *http.Request) {
+	q := adminapi.EventQuery{Client: r.URL.Query().Get("client")}
+	if types := r.URL.Query().Get("types"); types != "" {
+		q.Types = strings.Split(types, ",")
+	}
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		after, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+		q.After = after
+	}
+
+	api, ctx := a.server(r)
+	ctx, cancel := context.WithTimeout(ctx, eventsRelayLifetime)
+	defer cancel()
+	stream, err := api.StreamEvents(ctx, q)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	defer stream.Close()
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: 3000\n\n")
+	rc.Flush()
+
+	for {
+		e, err := stream.Next()
+		switch {
+		case errors.Is(err, adminapi.ErrEventGap):
+			fmt.Fprintf(w, "event: gap\ndata: {}\n\n")
+		case err != nil:
+			return
+		default:
+			data, err := json.Marshal(e)
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"html"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	sessionCookie   = "yagnoetik_session"
+	loginCSRFCookie = "yagnoetik_login"
+	csrfHeader      = "X-CSRF-Token"
+
+	// maxLoginFailures failed logins within loginLockout lock the username
+	// and source address for the rest of the window.
+	maxLoginFailures = 5
+	loginLockout     = 15 * time.Minute
+)
+
+// dummyHash is compared against when the username is unknown, so that the
+// response time does not reveal which usernames exist.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+
+type panelSession struct {
+	username string
+	role     string
+	csrf     string
+	created  time.Time
+	lastSeen time.Time
+}
+
+type sessionContextKey struct{}
+
+func currentSession(r *http.Request) *panelSession {
+	s, _ := r.Context().Value(sessionContextKey{}).(*panelSession)
+	return s
+}
+
+// Auth keeps panel sessions in memory; restarting the panel logs everyone
+// out.
+type Auth struct {
+	users        map[string]*PanelUser
+	sessions     map[string]*panelSession
+	failures     map[string][]time.Time
+	totp         *totpVerifier
+	proxies      proxies
+	idleTimeout  time.Duration
+	maxLifetime  time.Duration
+	secureCookie bool
+	mutex        sync.Mutex
+}
+
+// NewAuth creates the panel's login and session handling. Login attempts
+// are limited per address, which is taken from X-Real-IP only when one of
+// proxies sent the request.
+func NewAuth(users map[string]*PanelUser, idleTimeout, maxLifetime time.Duration, secureCookie bool, proxies proxies) *Auth {
+	return &Auth{
+		users:        users,
+		sessions:     make(map[string]*panelSession),
+		failures:     make(map[string][]time.Time),
+		totp:         newTOTPVerifier(),
+		proxies:      proxies,
+		idleTimeout:  idleTimeout,
+		maxLifetime:  maxLifetime,
+		secureCookie: secureCookie,
+	}
+}
+
+// session returns the live session named by the request cookie and
+// refreshes its idle timer.
+func (a *Auth) session(r *http.Request) *panelSession {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	s, exists := a.sessions[cookie.Value]
+	if !exists {
+		return nil
+	}
+	now := time.Now()
+	if a.expired(s, now) {
+		delete(a.sessions, cookie.Value)
+		return nil
+	}
+	s.lastSeen = now
+	return s
+}
+
+func (a *Auth) expired(s *panelSession, now time.Time) bool {
+	return now.Sub(s.lastSeen) > a.idleTimeout || now.Sub(s.created) > a.maxLifetime
+}
+
+// Run drops expired sessions and old login failures every minute until the
+// context is cancelled, so that neither grows with abandoned logins.
+func (a *Auth) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.sweep(time.Now())
+		}
+	}
+}
+
+func (a *Auth) sweep(now time.Time) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	cutoff := now.Add(-loginLockout)
+	for key, times := range a.failures {
+		if !times[len(times)-1].After(cutoff) {
+			delete(a.failures, key)
+		}
+	}
+	for id, s := range a.sessions {
+		if a.expired(s, now) {
+			delete(a.sessions, id)
+		}
+	}
+}
+
+// Require rejects requests without a session: pages redirect to the login
+// form and API calls get 401. Requests that change state must also carry
+// the session's CSRF token in the X-CSRF-Token header.
+func (a *Auth) Require(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := a.session(r)
+		if s == nil {
+			if strings.HasPrefix(r.URL.Path, "/api/") {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+		if !safeMethod(r.Method) && !tokensEqual(r.Header.Get(csrfHeader), s.csrf) {
+			http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), sessionContextKey{}, s)))
+	})
+}
+
+func (a *Auth) loginPage(w http.ResponseWriter, r *http.Request) {
+	if a.session(r) != nil {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	a.renderLogin(w, http.StatusOK, "")
+}
+
+// renderLogin shows the login form with a fresh double-submit token: the
+// same value goes in a cookie and a hidden field, and login checks that
+// they match.
+func (a *Auth) renderLogin(w http.ResponseWriter, status int, message string) {
+	token := randomToken()
+	http.SetCookie(w, &http.Cookie{
+		Name:     loginCSRFCookie,
+		Value:    token,
+		Path:     "/login",
+		HttpOnly: true,
+		Secure:   a.secureCookie,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	page := strings.NewReplacer(
+		"__CSRF_TOKEN__", token,
+		"__MESSAGE__", html.EscapeString(message),
+	).Replace(loginTemplate)
+
+	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write([]byte(page))
+}
+
+func (a *Auth) login(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(loginCSRFCookie)
+	if err != nil || !tokensEqual(r.PostFormValue("csrf_token"), cookie.Value) {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	username := strings.TrimSpace(r.PostFormValue("username"))
+	ip := a.proxies.clientIP(r)
+	limitKey := username + "|" + ip
+	if a.lockedOut(limitKey) {
+		a.renderLogin(w, http.StatusTooManyRequests, "Слишком много попыток, попробуйте позже")
+		return
+	}
+
+	user, exists := a.users[username]
+	hash := dummyHash
+	if exists {
+		hash = []byte(user.PasswordHash)
+	}
+	ok := bcrypt.CompareHashAndPassword(hash, []byte(r.PostFormValue("password"))) == nil && exists
+	if ok && user.TOTPSecret != "" {
+		ok = a.totp.Verify(user.Username, user.TOTPSecret, strings.TrimSpace(r.PostFormValue("code")), time.Now())
+	}
+	if !ok {
+		a.recordFailure(limitKey)
+		log.Printf("Failed panel login for %q from %s", username, ip)
+		a.renderLogin(w, http.StatusUnauthorized, "Неверное имя, пароль или код")
+		return
+	}
+
+	id := randomToken()
+	now := time.Now()
+	a.mutex.Lock()
+	delete(a.failures, limitKey)
+	a.sessions[id] = &panelSession{
+		username: user.Username,
+		role:     user.Role,
+		csrf:     randomToken(),
+		created:  now,
+		lastSeen: now,
+	}
+	a.mutex.Unlock()
+
+	http.SetCookie(w, &http.Cookie{Name: loginCSRFCookie, Path: "/login", MaxAge: -1})
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    id,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   a.secureCookie,
+		SameSite: http.SameSiteStrictMode,
+	})
+	log.Printf("Panel login: %s (%s) from %s", user.Username, user.Role, ip)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// logout ends the session. The logout form carries the CSRF token as a
+// field, so that other sites cannot log users out.
+func (a *Auth) logout(w http.ResponseWriter, r *http.Request) {
+	s := a.session(r)
+	if s != nil && !tokensEqual(r.PostFormValue("csrf_token"), s.csrf) {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		a.mutex.Lock()
+		delete(a.sessions, cookie.Value)
+		a.mutex.Unlock()
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1})
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+func (a *Auth) lockedOut(key string) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	cutoff := time.Now().Add(-loginLockout)
+	recent := a.failures[key][:0]
+	for _, t := range a.failures[key] {
+		if t.After(cutoff) {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) == 0 {
+		delete(a.failures, key)
+		return false
+	}
+	a.failures[key] = recent
+	return len(recent) >= maxLoginFailures
+}
+
+func (a *Auth) recordFailure(key string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.failures[key] = append(a.failures[key], time.Now())
+}
+
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+func tokensEqual(a, b string) bool {
+	return a != "" && subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+func randomToken() string {
+	bytes := make([]byte, 32)
+	rand.Read(bytes)
+	return hex.EncodeToString(bytes)
+}
+
+const loginTemplate = `<!DOCTYPE html>
+<html>
+<head>
+    <title>Yagnoetik VPN - Вход</title>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <style>
+        body { font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif; margin: 0; padding: 20px; background: #f5f5f5; }
+        .card { background: white; padding: 20px; border-radius: 8px; max-width: 360px; margin: 80px auto; box-shadow: 0 2px 4px rgba(0,0,0,0.1); }
+        .form-group { margin-bottom: 15px; }
+        .form-control { width: 100%; padding: 8px; border: 1px solid #ddd; border-radius: 4px; box-sizing: border-box; }
+        .btn { padding: 8px 16px; border: none; border-radius: 4px; cursor: pointer; background: #007bff; color: white; width: 100%; }
+        .error { color: #dc3545; margin-bottom: 15px; }
+    </style>
+</head>
+<body>
+    <div class="card">
+        <h2>Yagnoetik VPN</h2>
+        <div class="error">__MESSAGE__</div>
+        <form method="post" action="/login">
+            <input type="hidden" name="csrf_token" value="__CSRF_TOKEN__">
+            <div class="form-group">
+                <label>Имя пользователя</label>
+                <input type="text" name="username" class="form-control" autocomplete="username" required autofocus>
+            </div>
+            <div class="form-group">
+                <label>Пароль</label>
+                <input type="password" name="password" class="form-control" autocomplete="current-password" required>
+            </div>
+            <div class="form-group">
+                <label>Код из приложения (если включён)</label>
+                <input type="text" name="code" class="form-control" inputmode="numeric" autocomplete="one-time-code" pattern="[0-9]{6}">
+            </div>
+            <button type="submit" class="btn">Войти</button>
+        </form>
+    </div>
+</body>
+</html>`
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func mustParseProxies(t *testing.T, s string) proxies {
+	t.Helper()
+	p, err := parseProxies(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestClientIP(t *testing.T) {
+	p := mustParseProxies(t, "10.0.0.0/8, 192.0.2.1")
+	for _, test := range []struct {
+		name, peer, realIP string
+		want               string
+	}{
+		{"direct", "203.0.113.5:4000", "", "203.0.113.5"},
+		{"direct with a forged header", "203.0.113.5:4000", "198.51.100.1", "203.0.113.5"},
+		{"local proxy", "127.0.0.1:4000", "198.51.100.1", "198.51.100.1"},
+		{"local IPv6 proxy", "[::1]:4000", "2001:db8::1", "2001:db8::1"},
+		{"trusted network", "10.1.2.3:4000", "198.51.100.1", "198.51.100.1"},
+		{"trusted address", "192.0.2.1:4000", "198.51.100.1", "198.51.100.1"},
+		{"trusted proxy without the header", "10.1.2.3:4000", "", "10.1.2.3"},
+		{"trusted proxy with a malformed header", "10.1.2.3:4000", "nonsense", "10.1.2.3"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = test.peer
+			if test.realIP != "" {
+				r.Header.Set("X-Real-IP", test.realIP)
+			}
+			if got := p.clientIP(r); got != test.want {
+				t.Errorf("client IP %q, want %q", got, test.want)
+			}
+		})
+	}
+
+	if _, err := parseProxies("10.0.0.0/33"); err == nil {
+		t.Error("parsed an invalid network")
+	}
+}
+
+func newTestAuth(t *testing.T) *Auth {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte("right"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := map[string]*PanelUser{"ops": {Username: "ops", PasswordHash: string(hash), Role: "admin"}}
+	return NewAuth(users, 30*time.Minute, 12*time.Hour, false, nil)
+}
+
+// tryLogin posts a login from peer, passing realIP in X-Real-IP, and
+// returns the status.
+func tryLogin(a *Auth, peer, realIP, password string) int {
+	form := url.Values{"csrf_token": {"t"}, "username": {"ops"}, "password": {password}}
+	r := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.AddCookie(&http.Cookie{Name: loginCSRFCookie, Value: "t"})
+	r.RemoteAddr = peer
+	if realIP != "" {
+		r.Header.Set("X-Real-IP", realIP)
+	}
+	w := httptest.NewRecorder()
+	a.login(w, r)
+	return w.Code
+}
+
+// TestLoginLockout checks that failures behind the local proxy are counted
+// per user address rather than for the proxy as a whole, and that a direct
+// client cannot escape the limit by setting X-Real-IP.
+func TestLoginLockout(t *testing.T) {
+	a := newTestAuth(t)
+	for range maxLoginFailures {
+		tryLogin(a, "127.0.0.1:5000", "198.51.100.1", "wrong")
+	}
+	if code := tryLogin(a, "127.0.0.1:5000", "198.51.100.1", "right"); code != http.StatusTooManyRequests {
+		t.Errorf("locked-out address: status %d, want 429", code)
+	}
+	if code := tryLogin(a, "127.0.0.1:5000", "198.51.100.2", "right"); code != http.StatusSeeOther {
+		t.Errorf("another address behind the proxy: status %d, want 303", code)
+	}
+
+	a = newTestAuth(t)
+	for i := range maxLoginFailures {
+		tryLogin(a, "203.0.113.5:5000", "198.51.100."+string(rune('1'+i)), "wrong")
+	}
+	if code := tryLogin(a, "203.0.113.5:5000", "198.51.100.9", "right"); code != http.StatusTooManyRequests {
+		t.Errorf("direct client rotating X-Real-IP: status %d, want 429", code)
+	}
+}
+
+func TestSweep(t *testing.T) {
+	a := newTestAuth(t)
+	now := time.Now()
+	a.failures["old|198.51.100.1"] = []time.Time{now.Add(-2 * loginLockout), now.Add(-loginLockout - time.Second)}
+	a.failures["recent|198.51.100.1"] = []time.Time{now.Add(-2 * loginLockout), now.Add(-time.Minute)}
+	a.sessions["idle"] = &panelSession{created: now.Add(-time.Hour), lastSeen: now.Add(-31 * time.Minute)}
+	a.sessions["old"] = &panelSession{created: now.Add(-13 * time.Hour), lastSeen: now}
+	a.sessions["live"] = &panelSession{created: now.Add(-time.Hour), lastSeen: now.Add(-time.Minute)}
+
+	a.sweep(now)
+	if _, ok := a.failures["old|198.51.100.1"]; ok || len(a.failures) != 1 {
+		t.Errorf("failures after sweeping: %v", a.failures)
+	}
+	if _, ok := a.sessions["live"]; !ok || len(a.sessions) != 1 {
+		t.Errorf("%d sessions after sweeping, want only the live one", len(a.sessions))
+	}
+}
+
+// TestForwardedFor checks which X-Forwarded-For chain the panel passes on
+// to the server for its audit log.
+func TestForwardedFor(t *testing.T) {
+	forwarded := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded <- r.Header.Get("X-Forwarded-For")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("[]"))
+	}))
+	defer server.Close()
+	panel := NewAdminPanel(server.URL, map[string]string{"admin": "k"}, nil, mustParseProxies(t, "10.0.0.0/8"))
+
+	for _, test := range []struct {
+		name, peer, prior string
+		want              string
+	}{
+		{"direct", "203.0.113.5:4000", "", "203.0.113.5"},
+		{"direct with a forged header", "203.0.113.5:4000", "198.51.100.1", "203.0.113.5"},
+		{"local proxy", "127.0.0.1:4000", "198.51.100.1", "198.51.100.1, 127.0.0.1"},
+		{"trusted proxy", "10.1.2.3:4000", "198.51.100.1", "198.51.100.1, 10.1.2.3"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/tiers", nil)
+			r.RemoteAddr = test.peer
+			if test.prior != "" {
+				r.Header.Set("X-Forwarded-For", test.prior)
+			}
+			r = r.WithContext(context.WithValue(r.Context(), sessionContextKey{}, &panelSession{username: "ops", role: "admin"}))
+			w := httptest.NewRecorder()
+			panel.listTiers(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", w.Code, w.Body)
+			}
+			if got := <-forwarded; got != test.want {
+				t.Errorf("X-Forwarded-For %q, want %q", got, test.want)
+			}
+		})
+	}
+}
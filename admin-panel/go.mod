@@ -3,3 +3,9 @@ module yagnoetik-admin
 go 1.23
 
 require github.com/gorilla/mux v1.8.1
+
+require golang.org/x/crypto v0.31.0
+
+require yagnoetik-sdk v0.0.0
+
+replace yagnoetik-sdk => ../sdk
@@ -1,35 +1,44 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"html"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
+	"yagnoetik-sdk/adminapi"
+
 	"github.com/gorilla/mux"
 )
 
-type Client struct {
-	UUID      string    `json:"uuid"`
-	Secret    string    `json:"secret"`
-	CreatedAt time.Time `json:"created_at"`
-	ExpiresAt time.Time `json:"expires_at"`
-	Blocked   bool      `json:"blocked"`
-	BytesUp   int64     `json:"bytes_up"`
-	BytesDown int64     `json:"bytes_down"`
-}
-
 type AdminPanel struct {
-	apiURL string
-	apiKey string
+	// apis holds a server client for each panel role, using a key with the
+	// same role.
+	apis map[string]*adminapi.API
+	// proxies may pass on the X-Forwarded-For chain the server is told
+	proxies proxies
 }
 
-func NewAdminPanel(apiURL, apiKey string) *AdminPanel {
-	return &AdminPanel{
-		apiURL: apiURL,
-		apiKey: apiKey,
+func NewAdminPanel(apiURL string, apiKeys map[string]string, tlsConfig *tls.Config, proxies proxies) *AdminPanel {
+	httpClient := &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}
+	apis := make(map[string]*adminapi.API)
+	for role, key := range apiKeys {
+		apis[role] = adminapi.New(apiURL, key, adminapi.Options{
+			HTTPClient: httpClient,
+			UserAgent:  "yagnoetik-admin",
+		})
 	}
+	return &AdminPanel{apis: apis, proxies: proxies}
 }
 
 func (a *AdminPanel) indexHandler(w http.ResponseWriter, r *http.Request) {
@@ -41,6 +50,30 @@ func (a *AdminPanel) indexHandler(w http.ResponseWriter, r *http.Request) {
     <meta name="viewport" content="width=device-width, initial-scale=1">
     <script src="https://unpkg.com/htmx.org@1.9.10"></script>
     <script src="https://unpkg.com/alpinejs@3.x.x/dist/cdn.min.js" defer></script>
+    <script src="https://unpkg.com/qrcodejs@1.0.0/qrcode.min.js"></script>
+    <script src="https://unpkg.com/chart.js@4.4.1/dist/chart.umd.js"></script>
+    <meta name="csrf-token" content="__CSRF_TOKEN__">
+    <script>
+        // Attach the session's CSRF token to every call that changes state
+        // and send the user back to the login form once the session ends
+        (function() {
+            const csrfToken = document.querySelector('meta[name="csrf-token"]').content;
+            const nativeFetch = window.fetch;
+            window.fetch = function(url, options = {}) {
+                const method = (options.method || 'GET').toUpperCase();
+                if (method !== 'GET' && method !== 'HEAD') {
+                    options.headers = new Headers(options.headers || {});
+                    options.headers.set('X-CSRF-Token', csrfToken);
+                }
+                return nativeFetch(url, options).then(response => {
+                    if (response.status === 401) {
+                        window.location.href = '/login';
+                    }
+                    return response;
+                });
+            };
+        })();
+    </script>
     <style>
         body { font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif; margin: 0; padding: 20px; background: #f5f5f5; }
         .container { max-width: 1200px; margin: 0 auto; }
@@ -59,15 +92,37 @@ func (a *AdminPanel) indexHandler(w http.ResponseWriter, r *http.Request) {
         .status-active { color: #28a745; }
         .status-blocked { color: #dc3545; }
         .status-expired { color: #6c757d; }
+        .status-pending { color: #17a2b8; }
+        .filters { display: flex; gap: 10px; margin-bottom: 10px; }
+        .tag { display: inline-block; background: #e9ecef; border-radius: 4px; padding: 1px 6px; margin-right: 4px; font-size: 12px; }
+        .muted { color: #6c757d; font-size: 12px; }
+        .modal { position: fixed; inset: 0; background: rgba(0,0,0,0.5); display: flex; align-items: center; justify-content: center; }
+        .modal-body { background: white; padding: 20px; border-radius: 8px; max-width: 480px; width: 100%; }
+        .share-uri { word-break: break-all; font-family: monospace; font-size: 12px; background: #f8f9fa; padding: 8px; border-radius: 4px; }
+        [x-cloak] { display: none !important; }
+        #qrcode { display: flex; justify-content: center; margin: 15px 0; }
+        .modal-body.wide { max-width: 800px; }
         .stats { display: grid; grid-template-columns: repeat(auto-fit, minmax(200px, 1fr)); gap: 15px; margin-bottom: 20px; }
         .stat-card { background: white; padding: 15px; border-radius: 8px; text-align: center; box-shadow: 0 2px 4px rgba(0,0,0,0.1); }
         .stat-number { font-size: 24px; font-weight: bold; color: #007bff; }
         .stat-label { color: #6c757d; margin-top: 5px; }
+        .user-bar { float: right; }
+        .live { display: inline-block; width: 10px; height: 10px; border-radius: 50%; background: #6c757d; margin-right: 6px; }
+        .live.on { background: #28a745; }
+        .event-feed { max-height: 260px; overflow-y: auto; }
+        .event-feed div { padding: 4px 0; border-bottom: 1px solid #eee; font-size: 14px; }
+        .event-warning { color: #d39e00; }
+        .event-danger { color: #dc3545; }
     </style>
 </head>
 <body>
     <div class="container" x-data="adminPanel()">
         <div class="header">
+            <form class="user-bar" method="post" action="/logout">
+                <span class="muted">__USERNAME__ (__ROLE__)</span>
+                <input type="hidden" name="csrf_token" value="__CSRF_TOKEN__">
+                <button type="submit" class="btn">Выйти</button>
+            </form>
             <h1>Yagnoetik VPN - Admin Panel</h1>
             <p>Управление клиентами и мониторинг системы</p>
         </div>
@@ -89,11 +144,40 @@ func (a *AdminPanel) indexHandler(w http.ResponseWriter, r *http.Request) {
                 <div class="stat-number" x-text="formatBytes(stats.totalTraffic)">0</div>
                 <div class="stat-label">Общий трафик</div>
             </div>
+            <div class="stat-card">
+                <div class="stat-number" x-text="sessions.length">0</div>
+                <div class="stat-label">Сессий онлайн</div>
+            </div>
+        </div>
+
+        <div class="card">
+            <h3><span class="live" :class="{ on: live }" :title="live ? 'Подключено' : 'Нет связи'"></span>События</h3>
+            <p class="muted" x-show="events.length === 0">Новых событий пока нет</p>
+            <div class="event-feed">
+                <template x-for="event in events" :key="event.id">
+                    <div :class="eventClass(event)">
+                        <span class="muted" x-text="formatDate(event.time)"></span>
+                        <span x-text="eventText(event)"></span>
+                    </div>
+                </template>
+            </div>
         </div>
 
         <div class="card">
             <h3>Создать нового клиента</h3>
             <form @submit.prevent="createClient()">
+                <div class="form-group">
+                    <label>Имя:</label>
+                    <input class="form-control" x-model="newClient.name" placeholder="Иван Петров">
+                </div>
+                <div class="form-group">
+                    <label>Email или контакт:</label>
+                    <input class="form-control" x-model="newClient.contact" placeholder="ivan@example.com, @telegram">
+                </div>
+                <div class="form-group">
+                    <label>Теги (через запятую):</label>
+                    <input class="form-control" x-model="newClient.tags" placeholder="company, trial">
+                </div>
                 <div class="form-group">
                     <label>Срок действия:</label>
                     <select class="form-control" x-model="newClient.duration">
@@ -107,18 +191,71 @@ func (a *AdminPanel) indexHandler(w http.ResponseWriter, r *http.Request) {
             </form>
         </div>
 
+        <div class="card">
+            <h3>Массовое создание</h3>
+            <form @submit.prevent="bulkCreate()">
+                <div class="filters">
+                    <input class="form-control" type="number" min="1" max="1000" x-model.number="bulk.count" placeholder="Количество">
+                    <input class="form-control" x-model="bulk.name_prefix" placeholder="Префикс имени, например «ООО Ромашка»">
+                    <input class="form-control" x-model="bulk.tags" placeholder="Теги через запятую">
+                    <select class="form-control" x-model="bulk.duration">
+                        <option value="168h">1 неделя</option>
+                        <option value="720h">1 месяц</option>
+                        <option value="8760h">1 год</option>
+                    </select>
+                </div>
+                <button type="submit" class="btn btn-primary">Создать</button>
+            </form>
+        </div>
+
+        <div class="card">
+            <h3>Импорт и экспорт</h3>
+            <a class="btn btn-primary" :href="'/api/clients/export?format=csv&' + filterParams()">Экспорт CSV</a>
+            <a class="btn btn-primary" :href="'/api/clients/export?format=json&' + filterParams()">Экспорт JSON</a>
+            <div class="filters" style="margin-top: 10px;">
+                <input class="form-control" type="file" accept=".csv,.json" x-ref="importFile">
+                <select class="form-control" x-model="importConflict">
+                    <option value="fail">При совпадении UUID — отменить</option>
+                    <option value="skip">При совпадении UUID — пропустить</option>
+                    <option value="overwrite">При совпадении UUID — перезаписать</option>
+                </select>
+                <button @click="importClients(true)" class="btn btn-warning">Проверить</button>
+                <button @click="importClients(false)" class="btn btn-success">Импортировать</button>
+            </div>
+            <p x-show="importReport" x-text="importReport"></p>
+        </div>
+
         <div class="card">
             <h3>Список клиентов</h3>
-            <button @click="loadClients()" class="btn btn-primary">Обновить</button>
-            
+            <div class="filters">
+                <input class="form-control" x-model="filter.q" @input.debounce.400ms="loadClients()" placeholder="Поиск по имени, контакту, заметкам">
+                <select class="form-control" x-model="filter.status" @change="loadClients()">
+                    <option value="">Все статусы</option>
+                    <option value="pending">Ожидают подключения</option>
+                    <option value="active">Активные</option>
+                    <option value="suspended">Заблокированные</option>
+                    <option value="expired">Истекшие</option>
+                </select>
+                <input class="form-control" x-model="filter.tag" @input.debounce.400ms="loadClients()" placeholder="Тег">
+                <select class="form-control" x-model="filter.sort" @change="loadClients()">
+                    <option value="-created_at">Сначала новые</option>
+                    <option value="expires_at">По сроку действия</option>
+                    <option value="name">По имени</option>
+                    <option value="-traffic">По трафику</option>
+                </select>
+                <button @click="loadClients()" class="btn btn-primary">Обновить</button>
+            </div>
+            <p>Найдено: <span x-text="total"></span></p>
+
             <table>
                 <thead>
                     <tr>
-                        <th>UUID</th>
+                        <th>Клиент</th>
                         <th>Секрет</th>
                         <th>Создан</th>
                         <th>Истекает</th>
                         <th>Статус</th>
+                        <th>Скорость</th>
                         <th>Трафик</th>
                         <th>Действия</th>
                     </tr>
@@ -126,32 +263,170 @@ func (a *AdminPanel) indexHandler(w http.ResponseWriter, r *http.Request) {
                 <tbody>
                     <template x-for="client in clients" :key="client.uuid">
                         <tr>
-                            <td x-text="client.uuid.substring(0, 8) + '...'"></td>
-                            <td x-text="client.secret.substring(0, 8) + '...'"></td>
+                            <td>
+                                <div x-text="client.name || client.uuid.substring(0, 8) + '...'"></div>
+                                <div class="muted" x-text="client.email || client.contact"></div>
+                                <template x-for="tag in client.tags || []" :key="tag">
+                                    <span class="tag" x-text="tag"></span>
+                                </template>
+                            </td>
+                            <td x-text="client.secret ? client.secret.substring(0, 8) + '...' : '—'"></td>
                             <td x-text="formatDate(client.created_at)"></td>
                             <td x-text="formatDate(client.expires_at)"></td>
                             <td>
                                 <span :class="getStatusClass(client)" x-text="getStatusText(client)"></span>
                             </td>
-                            <td x-text="formatBytes(client.bytes_up + client.bytes_down)"></td>
+                            <td>
+                                <select class="form-control" :value="client.speed_tier" @change="setTier(client, $event.target.value)">
+                                    <option value="">Без ограничений</option>
+                                    <template x-for="tier in tiers" :key="tier.name">
+                                        <option :value="tier.name" x-text="tier.name" :selected="tier.name === client.speed_tier"></option>
+                                    </template>
+                                </select>
+                            </td>
+                            <td>
+                                <div x-text="formatBytes(client.bytes_up + client.bytes_down)"></div>
+                                <div class="muted" x-show="client.quota_bytes > 0"
+                                     x-text="formatBytes(client.period_bytes) + ' из ' + formatBytes(client.quota_bytes) + (client.quota_exceeded ? ' — исчерпан' : '')"></div>
+                            </td>
                             <td>
                                 <button @click="toggleBlock(client)" 
                                         :class="client.blocked ? 'btn btn-success' : 'btn btn-warning'"
                                         x-text="client.blocked ? 'Разблокировать' : 'Заблокировать'">
                                 </button>
+                                <button @click="showConfig(client)" class="btn btn-primary">Конфиг</button>
+                                <button @click="showUsage(client)" class="btn btn-primary">График</button>
+                                <button @click="extendClient(client)" class="btn btn-primary">Продлить</button>
+                                <button @click="setQuota(client)" class="btn btn-primary">Лимит</button>
+                                <button x-show="client.quota_bytes > 0" @click="resetQuota(client)" class="btn btn-warning">Сбросить лимит</button>
+                                <button @click="rotateClient(client)" class="btn btn-warning">Новый ключ</button>
                                 <button @click="deleteClient(client.uuid)" class="btn btn-danger">Удалить</button>
                             </td>
                         </tr>
                     </template>
                 </tbody>
             </table>
+            <button x-show="nextCursor" @click="loadMore()" class="btn btn-primary">Загрузить ещё</button>
+        </div>
+
+        <div class="card">
+            <h3>Активные сессии</h3>
+            <button @click="loadSessions()" class="btn btn-primary">Обновить</button>
+            <table>
+                <thead>
+                    <tr>
+                        <th>Клиент</th>
+                        <th>Адрес</th>
+                        <th>IP в туннеле</th>
+                        <th>Начало</th>
+                        <th>RTT</th>
+                        <th>Трафик</th>
+                        <th>Действия</th>
+                    </tr>
+                </thead>
+                <tbody>
+                    <template x-for="session in sessions" :key="session.id">
+                        <tr>
+                            <td x-text="session.client_name || session.client_uuid.substring(0, 8) + '...'"></td>
+                            <td x-text="session.remote_addr"></td>
+                            <td x-text="session.assigned_ip"></td>
+                            <td x-text="formatDate(session.started_at)"></td>
+                            <td x-text="session.rtt_ns ? Math.round(session.rtt_ns / 1e6) + ' мс' : '—'"></td>
+                            <td x-text="formatBytes(session.bytes_up + session.bytes_down)"></td>
+                            <td>
+                                <button @click="kickSession(session)" class="btn btn-danger">Отключить</button>
+                            </td>
+                        </tr>
+                    </template>
+                </tbody>
+            </table>
+        </div>
+
+        <div class="modal" x-show="config" x-cloak @click.self="config = null">
+            <div class="modal-body">
+                <h3>Конфигурация клиента</h3>
+                <div id="qrcode"></div>
+                <div class="share-uri" x-text="config && config.share_uri"></div>
+                <p>
+                    <button @click="navigator.clipboard.writeText(config.share_uri)" class="btn btn-primary">Копировать ссылку</button>
+                    <a :href="config && '/api/clients/' + config.config.uuid + '/config'" class="btn btn-success" download="config.json">Скачать config.json</a>
+                    <button @click="config = null" class="btn btn-warning">Закрыть</button>
+                </p>
+            </div>
+        </div>
+
+        <div class="modal" x-show="usage.client" x-cloak @click.self="closeUsage()">
+            <div class="modal-body wide">
+                <h3 x-text="usage.client && 'Трафик: ' + (usage.client.name || usage.client.uuid)"></h3>
+                <select class="form-control" x-model="usage.range" @change="loadUsage()">
+                    <option value="24h">24 часа</option>
+                    <option value="7d">7 дней</option>
+                    <option value="30d">30 дней</option>
+                    <option value="365d">Год</option>
+                </select>
+                <canvas id="usage-chart"></canvas>
+                <p>
+                    <button @click="closeUsage()" class="btn btn-warning">Закрыть</button>
+                </p>
+            </div>
         </div>
     </div>
 
     <script>
+        // Chart.js objects must stay outside Alpine's reactive state
+        let usageChart = null;
+
+        const usageSteps = { '24h': '5m', '7d': '1h', '30d': '1d', '365d': '1d' };
+        const usageHours = { '24h': 24, '7d': 24 * 7, '30d': 24 * 30, '365d': 24 * 365 };
+
+        const eventLabels = {
+            'client.created': 'Клиент создан',
+            'client.updated': 'Клиент изменён',
+            'client.deleted': 'Клиент удалён',
+            'client.expired': 'Подписка истекла',
+            'client.state_changed': 'Статус клиента изменён',
+            'client.expiring': 'Подписка скоро истечёт',
+            'session.started': 'Подключение',
+            'session.ended': 'Отключение',
+            'quota.warning': 'Лимит трафика почти исчерпан',
+            'quota.exceeded': 'Лимит трафика исчерпан',
+            'quota.restored': 'Лимит трафика восстановлен',
+            'auth.failure_burst': 'Много неудачных попыток входа',
+            'voucher.redeemed': 'Ваучер активирован',
+            'server.cert_expiring': 'Сертификат сервера скоро истечёт'
+        };
+        const maxEvents = 100;
+
         function adminPanel() {
             return {
                 clients: [],
+                sessions: [],
+                events: [],
+                live: false,
+                reloadTimer: null,
+                tiers: [],
+                config: null,
+                usage: {
+                    client: null,
+                    range: '24h'
+                },
+                bulk: {
+                    count: 10,
+                    name_prefix: '',
+                    tags: '',
+                    duration: '720h'
+                },
+                importConflict: 'fail',
+                importReport: '',
+                total: 0,
+                nextCursor: '',
+                pageSize: 50,
+                filter: {
+                    q: '',
+                    status: '',
+                    tag: '',
+                    sort: '-created_at'
+                },
                 stats: {
                     total: 0,
                     active: 0,
@@ -159,17 +434,265 @@ func (a *AdminPanel) indexHandler(w http.ResponseWriter, r *http.Request) {
                     totalTraffic: 0
                 },
                 newClient: {
+                    name: '',
+                    contact: '',
+                    tags: '',
                     duration: '720h'
                 },
 
                 async init() {
+                    await this.loadTiers();
                     await this.loadClients();
+                    await this.loadSessions();
+                    this.connectEvents();
+                },
+
+                // connectEvents keeps the client and session lists current
+                // from the server's event stream. EventSource reconnects on
+                // its own and resumes after the last event it received.
+                connectEvents() {
+                    const source = new EventSource('/api/events');
+                    source.onopen = () => { this.live = true; };
+                    source.onerror = () => { this.live = false; };
+                    // Events were missed while disconnected
+                    source.addEventListener('gap', () => this.scheduleReload());
+                    for (const type of Object.keys(eventLabels)) {
+                        source.addEventListener(type, message => this.handleEvent(JSON.parse(message.data)));
+                    }
+                },
+
+                handleEvent(event) {
+                    this.events.unshift(event);
+                    if (this.events.length > maxEvents) this.events.pop();
+
+                    if (event.type === 'session.started') {
+                        this.sessions = [event.data].concat(this.sessions.filter(s => s.id !== event.data.id));
+                    } else if (event.type === 'session.ended') {
+                        this.sessions = this.sessions.filter(s => s.id !== event.data.id);
+                    }
+                    if (event.type.startsWith('client.') || event.type.startsWith('quota.') || event.type === 'voucher.redeemed') {
+                        this.scheduleReload();
+                    }
+                },
+
+                // scheduleReload reloads the lists once a burst of events,
+                // such as a bulk import, has passed
+                scheduleReload() {
+                    clearTimeout(this.reloadTimer);
+                    this.reloadTimer = setTimeout(async () => {
+                        await this.loadClients();
+                        await this.loadSessions();
+                    }, 1000);
+                },
+
+                eventClientName(event) {
+                    const client = this.clients.find(c => c.uuid === event.client);
+                    if (client && client.name) return client.name;
+                    if (event.data && event.data.client && event.data.client.name) return event.data.client.name;
+                    if (event.data && event.data.client_name) return event.data.client_name;
+                    return event.client ? event.client.substring(0, 8) + '...' : '';
+                },
+
+                eventText(event) {
+                    let text = eventLabels[event.type] || event.type;
+                    const name = this.eventClientName(event);
+                    if (name) text += ': ' + name;
+                    const data = event.data || {};
+                    switch (event.type) {
+                        case 'quota.warning':
+                        case 'quota.exceeded':
+                            text += ' (' + data.percent + '%)';
+                            break;
+                        case 'session.started':
+                            if (data.remote_addr) text += ' с ' + data.remote_addr;
+                            break;
+                        case 'session.ended':
+                            if (data.reason) text += ' (' + data.reason + ')';
+                            break;
+                        case 'client.state_changed':
+                            text += ' (' + data.from + ' → ' + data.to + ')';
+                            break;
+                        case 'auth.failure_burst':
+                            text += ': ' + data.count + ' за ' + data.window;
+                            break;
+                        case 'client.expiring':
+                            text += ' (' + this.formatDate(data.expires_at) + ')';
+                            break;
+                        case 'server.cert_expiring':
+                            text += ': до ' + this.formatDate(data.not_after);
+                            break;
+                    }
+                    return text;
+                },
+
+                eventClass(event) {
+                    switch (event.type) {
+                        case 'quota.exceeded':
+                        case 'auth.failure_burst':
+                        case 'client.deleted':
+                        case 'server.cert_expiring':
+                            return 'event-danger';
+                        case 'quota.warning':
+                        case 'client.expired':
+                        case 'client.expiring':
+                            return 'event-warning';
+                        default:
+                            return '';
+                    }
+                },
+
+                async loadTiers() {
+                    try {
+                        const response = await fetch('/api/tiers');
+                        this.tiers = await response.json();
+                    } catch (error) {
+                        alert('Ошибка загрузки тарифов: ' + error.message);
+                    }
+                },
+
+                async setTier(client, tier) {
+                    try {
+                        const response = await fetch('/api/clients/' + client.uuid, {
+                            method: 'PATCH',
+                            headers: {
+                                'Content-Type': 'application/json'
+                            },
+                            body: JSON.stringify({ speed_tier: tier })
+                        });
+
+                        if (response.ok) {
+                            client.speed_tier = tier;
+                        } else {
+                            throw new Error('Ошибка смены тарифа');
+                        }
+                    } catch (error) {
+                        alert('Ошибка: ' + error.message);
+                    }
+                },
+
+                async loadSessions() {
+                    try {
+                        const response = await fetch('/api/sessions');
+                        this.sessions = await response.json();
+                    } catch (error) {
+                        alert('Ошибка загрузки сессий: ' + error.message);
+                    }
+                },
+
+                async kickSession(session) {
+                    if (!confirm('Отключить сессию?')) return;
+
+                    try {
+                        const response = await fetch('/api/sessions/' + session.id, {
+                            method: 'DELETE'
+                        });
+
+                        if (response.ok) {
+                            await this.loadSessions();
+                        } else {
+                            throw new Error('Ошибка отключения сессии');
+                        }
+                    } catch (error) {
+                        alert('Ошибка: ' + error.message);
+                    }
+                },
+
+                filterParams() {
+                    const params = new URLSearchParams({ sort: this.filter.sort });
+                    if (this.filter.q) params.set('q', this.filter.q);
+                    if (this.filter.status) params.set('status', this.filter.status);
+                    if (this.filter.tag) params.set('tag', this.filter.tag);
+                    return params.toString();
+                },
+
+                clientsURL(cursor) {
+                    const params = new URLSearchParams(this.filterParams());
+                    params.set('limit', this.pageSize);
+                    if (cursor) params.set('cursor', cursor);
+                    return '/api/clients?' + params.toString();
+                },
+
+                async bulkCreate() {
+                    try {
+                        const response = await fetch('/api/clients/bulk', {
+                            method: 'POST',
+                            headers: {
+                                'Content-Type': 'application/json'
+                            },
+                            body: JSON.stringify({
+                                count: this.bulk.count,
+                                duration: this.bulk.duration,
+                                name_prefix: this.bulk.name_prefix,
+                                tags: this.bulk.tags.split(',').map(t => t.trim()).filter(t => t)
+                            })
+                        });
+
+                        if (!response.ok) {
+                            throw new Error(await response.text());
+                        }
+                        const created = await response.json();
+                        alert('Создано клиентов: ' + created.length);
+                        await this.loadClients();
+                    } catch (error) {
+                        alert('Ошибка: ' + error.message);
+                    }
+                },
+
+                async importClients(dryRun) {
+                    const file = this.$refs.importFile.files[0];
+                    if (!file) {
+                        alert('Выберите файл');
+                        return;
+                    }
+
+                    try {
+                        const contentType = file.name.endsWith('.csv') ? 'text/csv' : 'application/json';
+                        const response = await fetch('/api/clients/import?on_conflict=' + this.importConflict + '&dry_run=' + dryRun, {
+                            method: 'POST',
+                            headers: {
+                                'Content-Type': contentType
+                            },
+                            body: await file.text()
+                        });
+
+                        if (response.status === 400) {
+                            throw new Error(await response.text());
+                        }
+                        const report = await response.json();
+                        this.importReport = (report.dry_run ? 'Проверка: ' : 'Импорт: ') +
+                            'новых ' + report.created + ', перезаписано ' + report.overwritten +
+                            ', пропущено ' + report.skipped + ', ошибок ' + report.invalid +
+                            ', конфликтов ' + report.conflicts;
+                        if (!dryRun && response.ok) {
+                            await this.loadClients();
+                        }
+                    } catch (error) {
+                        alert('Ошибка: ' + error.message);
+                    }
+                },
+
+                async fetchPage(cursor) {
+                    const response = await fetch(this.clientsURL(cursor));
+                    if (!response.ok) {
+                        throw new Error(await response.text());
+                    }
+                    this.total = parseInt(response.headers.get('X-Total-Count') || '0');
+                    this.nextCursor = response.headers.get('X-Next-Cursor') || '';
+                    return await response.json();
                 },
 
                 async loadClients() {
                     try {
-                        const response = await fetch('/api/clients');
-                        this.clients = await response.json();
+                        this.clients = await this.fetchPage('');
+                        this.updateStats();
+                    } catch (error) {
+                        alert('Ошибка загрузки клиентов: ' + error.message);
+                    }
+                },
+
+                async loadMore() {
+                    try {
+                        this.clients = this.clients.concat(await this.fetchPage(this.nextCursor));
                         this.updateStats();
                     } catch (error) {
                         alert('Ошибка загрузки клиентов: ' + error.message);
@@ -184,7 +707,10 @@ func (a *AdminPanel) indexHandler(w http.ResponseWriter, r *http.Request) {
                                 'Content-Type': 'application/json'
                             },
                             body: JSON.stringify({
-                                duration: this.newClient.duration
+                                duration: this.newClient.duration,
+                                name: this.newClient.name,
+                                contact: this.newClient.contact,
+                                tags: this.newClient.tags.split(',').map(t => t.trim()).filter(t => t)
                             })
                         });
                         
@@ -217,6 +743,159 @@ func (a *AdminPanel) indexHandler(w http.ResponseWriter, r *http.Request) {
                     }
                 },
 
+                async showConfig(client) {
+                    try {
+                        const response = await fetch('/api/clients/' + client.uuid + '/config?format=bundle');
+                        if (!response.ok) {
+                            throw new Error('Ошибка загрузки конфигурации');
+                        }
+                        this.config = await response.json();
+                        this.$nextTick(() => {
+                            const el = document.getElementById('qrcode');
+                            el.innerHTML = '';
+                            new QRCode(el, { text: this.config.share_uri, width: 256, height: 256 });
+                        });
+                    } catch (error) {
+                        alert('Ошибка: ' + error.message);
+                    }
+                },
+
+                async showUsage(client) {
+                    this.usage.client = client;
+                    await this.loadUsage();
+                },
+
+                async loadUsage() {
+                    const to = new Date();
+                    const from = new Date(to.getTime() - usageHours[this.usage.range] * 3600 * 1000);
+                    const params = new URLSearchParams({
+                        from: from.toISOString(),
+                        to: to.toISOString(),
+                        step: usageSteps[this.usage.range]
+                    });
+
+                    try {
+                        const response = await fetch('/api/clients/' + this.usage.client.uuid + '/usage?' + params);
+                        if (!response.ok) {
+                            throw new Error(await response.text());
+                        }
+                        const series = await response.json();
+                        const labels = series.points.map(p => new Date(p.time).toLocaleString('ru-RU'));
+                        const toMB = bytes => (bytes / 1048576).toFixed(2);
+
+                        if (usageChart) usageChart.destroy();
+                        usageChart = new Chart(document.getElementById('usage-chart'), {
+                            type: 'bar',
+                            data: {
+                                labels: labels,
+                                datasets: [
+                                    { label: 'Загрузка, МБ', data: series.points.map(p => toMB(p.bytes_up)), backgroundColor: '#007bff' },
+                                    { label: 'Отдача, МБ', data: series.points.map(p => toMB(p.bytes_down)), backgroundColor: '#28a745' }
+                                ]
+                            },
+                            options: { scales: { x: { stacked: true }, y: { stacked: true } } }
+                        });
+                    } catch (error) {
+                        alert('Ошибка загрузки статистики: ' + error.message);
+                    }
+                },
+
+                closeUsage() {
+                    if (usageChart) usageChart.destroy();
+                    usageChart = null;
+                    this.usage.client = null;
+                },
+
+                async extendClient(client) {
+                    const extend = prompt('Продлить на (например, 30d, 720h):', '30d');
+                    if (!extend) return;
+
+                    try {
+                        const response = await fetch('/api/clients/' + client.uuid + '/extend', {
+                            method: 'POST',
+                            headers: {
+                                'Content-Type': 'application/json'
+                            },
+                            body: JSON.stringify({ duration: extend })
+                        });
+
+                        if (response.ok) {
+                            await this.loadClients();
+                        } else {
+                            throw new Error('Ошибка продления');
+                        }
+                    } catch (error) {
+                        alert('Ошибка: ' + error.message);
+                    }
+                },
+
+                async setQuota(client) {
+                    const current = client.quota_bytes ? String(client.quota_bytes / Math.pow(1024, 3)) : '0';
+                    const gb = prompt('Лимит трафика в ГБ (0 — без лимита):', current);
+                    if (gb === null) return;
+                    const reset = prompt('Сброс лимита: monthly, rolling или пусто (никогда):', client.quota_reset || 'monthly');
+                    if (reset === null) return;
+
+                    try {
+                        const response = await fetch('/api/clients/' + client.uuid, {
+                            method: 'PATCH',
+                            headers: {
+                                'Content-Type': 'application/json'
+                            },
+                            body: JSON.stringify({
+                                quota_bytes: Math.round(parseFloat(gb) * Math.pow(1024, 3)),
+                                quota_reset: reset.trim()
+                            })
+                        });
+
+                        if (response.ok) {
+                            await this.loadClients();
+                        } else {
+                            throw new Error(await response.text());
+                        }
+                    } catch (error) {
+                        alert('Ошибка: ' + error.message);
+                    }
+                },
+
+                async resetQuota(client) {
+                    if (!confirm('Обнулить использованный трафик за текущий период?')) return;
+
+                    try {
+                        const response = await fetch('/api/clients/' + client.uuid + '/quota/reset', {
+                            method: 'POST'
+                        });
+
+                        if (response.ok) {
+                            await this.loadClients();
+                        } else {
+                            throw new Error('Ошибка сброса лимита');
+                        }
+                    } catch (error) {
+                        alert('Ошибка: ' + error.message);
+                    }
+                },
+
+                async rotateClient(client) {
+                    if (!confirm('Выпустить новые ключи? Текущие сессии будут отключены.')) return;
+
+                    try {
+                        const response = await fetch('/api/clients/' + client.uuid + '/rotate', {
+                            method: 'POST'
+                        });
+
+                        if (response.ok) {
+                            const result = await response.json();
+                            alert('Новые ключи выпущены!\nUUID: ' + result.uuid + '\nSecret: ' + result.secret);
+                            await this.loadClients();
+                        } else {
+                            throw new Error('Ошибка смены ключей');
+                        }
+                    } catch (error) {
+                        alert('Ошибка: ' + error.message);
+                    }
+                },
+
                 async deleteClient(uuid) {
                     if (!confirm('Удалить клиента?')) return;
                     
@@ -236,22 +915,28 @@ func (a *AdminPanel) indexHandler(w http.ResponseWriter, r *http.Request) {
                 },
 
                 updateStats() {
-                    this.stats.total = this.clients.length;
-                    this.stats.active = this.clients.filter(c => !c.blocked && new Date(c.expires_at) > new Date()).length;
-                    this.stats.blocked = this.clients.filter(c => c.blocked).length;
+                    this.stats.total = this.total;
+                    this.stats.active = this.clients.filter(c => c.state === 'active' || c.state === 'pending').length;
+                    this.stats.blocked = this.clients.filter(c => c.state === 'suspended').length;
                     this.stats.totalTraffic = this.clients.reduce((sum, c) => sum + c.bytes_up + c.bytes_down, 0);
                 },
 
                 getStatusClass(client) {
-                    if (client.blocked) return 'status-blocked';
-                    if (new Date(client.expires_at) < new Date()) return 'status-expired';
-                    return 'status-active';
+                    switch (client.state) {
+                        case 'suspended': return 'status-blocked';
+                        case 'expired': return 'status-expired';
+                        case 'pending': return 'status-pending';
+                        default: return 'status-active';
+                    }
                 },
 
                 getStatusText(client) {
-                    if (client.blocked) return 'Заблокирован';
-                    if (new Date(client.expires_at) < new Date()) return 'Истек';
-                    return 'Активен';
+                    switch (client.state) {
+                        case 'suspended': return 'Заблокирован';
+                        case 'expired': return 'Истек';
+                        case 'pending': return 'Ожидает подключения';
+                        default: return 'Активен';
+                    }
                 },
 
                 formatDate(dateStr) {
@@ -271,82 +956,150 @@ func (a *AdminPanel) indexHandler(w http.ResponseWriter, r *http.Request) {
 </body>
 </html>`
 
+	s := currentSession(r)
+	page := strings.NewReplacer(
+		"__CSRF_TOKEN__", s.csrf,
+		"__USERNAME__", html.EscapeString(s.username),
+		"__ROLE__", html.EscapeString(s.role),
+	).Replace(tmpl)
+
 	w.Header().Set("Content-Type", "text/html")
-	w.Write([]byte(tmpl))
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte(page))
 }
 
-func (a *AdminPanel) proxyHandler(w http.ResponseWriter, r *http.Request) {
-	// Proxy requests to the main server API
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "adduser" {
+		if err := addUser(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
-	client := &http.Client{
-		Timeout:   10 * time.Second,
-		Transport: tr,
+
+	apiURL := envString("SERVER_URL", "https://localhost:8443")
+	tlsConfig, err := serverTLSConfig()
+	if err != nil {
+		log.Fatalf("Invalid server TLS configuration: %v", err)
 	}
-	
-	req, err := http.NewRequest(r.Method, a.apiURL+r.URL.Path, r.Body)
+
+	// Each panel role calls the server with a key of the same role
+	apiKeys, err := parseAPIKeys(os.Getenv("PANEL_API_KEYS"))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		log.Fatalf("Invalid PANEL_API_KEYS: %v", err)
+	}
+	if apiKey := os.Getenv("API_KEY"); apiKey != "" && apiKeys["admin"] == "" {
+		apiKeys["admin"] = apiKey
 	}
 
-	// Copy headers
-	for key, values := range r.Header {
-		for _, value := range values {
-			req.Header.Add(key, value)
+	usersFile := envString("PANEL_USERS_FILE", "panel-users.json")
+	users, err := loadUsers(usersFile)
+	if err != nil {
+		log.Fatalf("Failed to load panel users: %v (create one with: %s adduser -name <user>)", err, os.Args[0])
+	}
+	for _, u := range users {
+		if apiKeys[u.Role] == "" {
+			log.Fatalf("User %s has role %s, but PANEL_API_KEYS has no key for it", u.Username, u.Role)
 		}
 	}
 
-	// Add API key
-	req.Header.Set("X-API-Key", a.apiKey)
-
-	resp, err := client.Do(req)
+	// Proxies in front of the panel whose client address headers are believed
+	trustedProxies, err := parseProxies(os.Getenv("PANEL_TRUSTED_PROXIES"))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		log.Fatalf("Invalid PANEL_TRUSTED_PROXIES: %v", err)
 	}
-	defer resp.Body.Close()
 
-	// Copy response headers
-	for key, values := range resp.Header {
-		for _, value := range values {
-			w.Header().Add(key, value)
-		}
-	}
+	panel := NewAdminPanel(apiURL, apiKeys, tlsConfig, trustedProxies)
+	auth := NewAuth(users,
+		envDuration("PANEL_SESSION_IDLE", 30*time.Minute),
+		envDuration("PANEL_SESSION_MAX", 12*time.Hour),
+		envBool("PANEL_SECURE_COOKIE", true),
+		trustedProxies,
+	)
+	go auth.Run(context.Background())
 
-	w.WriteHeader(resp.StatusCode)
-	
-	// Copy response body
-	buf := make([]byte, 1024)
-	for {
-		n, err := resp.Body.Read(buf)
-		if n > 0 {
-			w.Write(buf[:n])
+	r := mux.NewRouter()
+	r.HandleFunc("/login", auth.loginPage).Methods("GET")
+	r.HandleFunc("/login", auth.login).Methods("POST")
+	r.HandleFunc("/logout", auth.logout).Methods("POST")
+	r.Handle("/", auth.Require(http.HandlerFunc(panel.indexHandler))).Methods("GET")
+	panel.SetupRoutes(r, auth.Require)
+
+	log.Printf("Admin panel starting on :8081 with %d users", len(users))
+	log.Fatal(http.ListenAndServe(":8081", r))
+}
+
+// serverTLSConfig verifies the server against the CA in PANEL_SERVER_CA,
+// or the system roots when it is unset, and presents PANEL_CLIENT_CERT
+// when the server requires client certificates. PANEL_SERVER_NAME
+// overrides the name checked against the server certificate.
+func serverTLSConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: os.Getenv("PANEL_SERVER_NAME"),
+	}
+	if caFile := os.Getenv("PANEL_SERVER_CA"); caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
 		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", caFile)
+		}
+	}
+	certFile, keyFile := os.Getenv("PANEL_CLIENT_CERT"), os.Getenv("PANEL_CLIENT_KEY")
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
 		if err != nil {
-			break
+			return nil, fmt.Errorf("load client certificate: %v", err)
 		}
+		config.Certificates = []tls.Certificate{cert}
 	}
+	return config, nil
 }
 
-func main() {
-	apiURL := os.Getenv("SERVER_URL")
-	if apiURL == "" {
-		apiURL = "http://localhost:8443"
-	}
-	
-	apiKey := os.Getenv("API_KEY")
-	if apiKey == "" {
-		log.Fatal("API_KEY environment variable is required")
+// parseAPIKeys parses "role:key" pairs separated by commas.
+func parseAPIKeys(spec string) (map[string]string, error) {
+	keys := make(map[string]string)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		role, key, ok := strings.Cut(entry, ":")
+		if !ok || key == "" || !validRole(role) {
+			return nil, fmt.Errorf("invalid entry for role %q, want role:key", role)
+		}
+		keys[role] = key
 	}
+	return keys, nil
+}
 
-	panel := NewAdminPanel(apiURL, apiKey)
+// envString reads a string from the environment, falling back to def when
+// the variable is unset.
+func envString(name, def string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return def
+}
 
-	r := mux.NewRouter()
-	r.HandleFunc("/", panel.indexHandler).Methods("GET")
-	r.PathPrefix("/api/").HandlerFunc(panel.proxyHandler)
+// envBool reads a boolean such as "true" or "1" from the environment,
+// falling back to def when the variable is unset or malformed.
+func envBool(name string, def bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(name))
+	if err != nil {
+		return def
+	}
+	return value
+}
 
-	log.Println("Admin panel starting on :8081")
-	log.Fatal(http.ListenAndServe(":8081", r))
+// envDuration reads a positive duration such as "30m" from the
+// environment, falling back to def when the variable is unset or malformed.
+func envDuration(name string, def time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return def
+	}
+	return value
 }
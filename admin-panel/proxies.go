@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// proxies are the reverse proxies whose X-Real-IP and X-Forwarded-For are
+// believed. A loopback peer, such as a local Nginx, is always trusted.
+type proxies []netip.Prefix
+
+// parseProxies parses addresses and networks separated by commas.
+func parseProxies(s string) (proxies, error) {
+	var p proxies
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if !strings.Contains(part, "/") {
+			addr, err := netip.ParseAddr(part)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q", part)
+			}
+			p = append(p, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q", part)
+		}
+		p = append(p, prefix.Masked())
+	}
+	return p, nil
+}
+
+// trusts reports whether the request came straight from a trusted proxy.
+func (p proxies) trusts(r *http.Request) bool {
+	peer, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := peer.Addr().Unmap()
+	if ip.IsLoopback() {
+		return true
+	}
+	for _, prefix := range p {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP is the address a request came from: X-Real-IP when a trusted
+// proxy passed the request on, the peer's address otherwise.
+func (p proxies) clientIP(r *http.Request) string {
+	if p.trusts(r) {
+		if forwarded, err := netip.ParseAddr(r.Header.Get("X-Real-IP")); err == nil {
+			return forwarded.Unmap().String()
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
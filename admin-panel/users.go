@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Panel roles match the server's admin key roles.
+var panelRoles = []string{"read-only", "support", "admin"}
+
+// PanelUser is a panel account as stored in the users file. Passwords are
+// kept as bcrypt hashes; TOTPSecret is base32 and empty when the user has
+// no second factor.
+type PanelUser struct {
+	Username     string `json:"username"`
+	PasswordHash string `json:"password_hash"`
+	Role         string `json:"role"`
+	TOTPSecret   string `json:"totp_secret,omitempty"`
+}
+
+func validRole(role string) bool {
+	for _, r := range panelRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func loadUsers(path string) (map[string]*PanelUser, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*PanelUser
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("parse %s: %v", path, err)
+	}
+	users := make(map[string]*PanelUser, len(list))
+	for _, u := range list {
+		if u.Username == "" || u.PasswordHash == "" || !validRole(u.Role) {
+			return nil, fmt.Errorf("invalid user %q in %s", u.Username, path)
+		}
+		users[u.Username] = u
+	}
+	return users, nil
+}
+
+// addUser is the "adduser" command. It creates or replaces a user in the
+// users file, reading the password from standard input.
+func addUser(args []string) error {
+	fs := flag.NewFlagSet("adduser", flag.ExitOnError)
+	file := fs.String("file", envString("PANEL_USERS_FILE", "panel-users.json"), "users file")
+	name := fs.String("name", "", "username")
+	role := fs.String("role", "admin", "read-only, support or admin")
+	withTOTP := fs.Bool("totp", false, "require a TOTP code at login")
+	fs.Parse(args)
+
+	if *name == "" || !validRole(*role) {
+		return errors.New("usage: adduser -name <user> [-role read-only|support|admin] [-totp] [-file path]")
+	}
+
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && password == "" {
+		return fmt.Errorf("read password: %v", err)
+	}
+	password = strings.TrimRight(password, "\r\n")
+	if len(password) < 10 {
+		return errors.New("password must be at least 10 characters")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	var list []*PanelUser
+	if data, err := os.ReadFile(*file); err == nil {
+		if err := json.Unmarshal(data, &list); err != nil {
+			return fmt.Errorf("parse %s: %v", *file, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	user := &PanelUser{Username: *name, PasswordHash: string(hash), Role: *role}
+	if *withTOTP {
+		if user.TOTPSecret, err = newTOTPSecret(); err != nil {
+			return err
+		}
+	}
+
+	replaced := false
+	for i, u := range list {
+		if u.Username == user.Username {
+			list[i], replaced = user, true
+		}
+	}
+	if !replaced {
+		list = append(list, user)
+	}
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(*file, data, 0600); err != nil {
+		return err
+	}
+
+	fmt.Printf("Saved %s (%s) to %s\n", user.Username, user.Role, *file)
+	if user.TOTPSecret != "" {
+		fmt.Printf("TOTP secret: %s\n", user.TOTPSecret)
+		fmt.Printf("otpauth://totp/Yagnoetik:%s?secret=%s&issuer=Yagnoetik\n", url.PathEscape(user.Username), user.TOTPSecret)
+	}
+	return nil
+}
+
+// totpStep and totpSkew follow RFC 6238 defaults: 30-second codes, with
+// one step of clock drift accepted either way.
+const (
+	totpStep = 30 * time.Second
+	totpSkew = 1
+)
+
+func newTOTPSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret), nil
+}
+
+// totpCode computes the six-digit code for a time step.
+func totpCode(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000)
+}
+
+// totpVerifier checks codes and rejects a step that has already been used,
+// so that an intercepted code cannot be replayed.
+type totpVerifier struct {
+	lastStep map[string]int64
+	mutex    sync.Mutex
+}
+
+func newTOTPVerifier() *totpVerifier {
+	return &totpVerifier{lastStep: make(map[string]int64)}
+}
+
+func (v *totpVerifier) Verify(username, encodedSecret, code string, now time.Time) bool {
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(encodedSecret))
+	if err != nil || len(code) != 6 {
+		return false
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	current := now.Unix() / int64(totpStep/time.Second)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= v.lastStep[username] {
+			continue
+		}
+		if hmac.Equal([]byte(totpCode(secret, step)), []byte(code)) {
+			v.lastStep[username] = step
+			return true
+		}
+	}
+	return false
+}
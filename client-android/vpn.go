@@ -7,7 +7,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"sync"
 	"time"
 
@@ -15,6 +15,9 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/metadata"
+
+	"yagnoetik-sdk/logging"
+	"yagnoetik-sdk/shareuri"
 )
 
 // VPNService provides the main VPN functionality for Android
@@ -30,6 +33,9 @@ type VPNService struct {
 	bytesUp    int64
 	bytesDown  int64
 	tunFd      int
+	lastNotice string
+	logger     *slog.Logger
+	packetLog  *logging.Limiter
 }
 
 type Config struct {
@@ -43,11 +49,17 @@ type Cipher struct {
 	aead cipher.AEAD
 }
 
+// Version is reported to the server when connecting
+const Version = "android/1.0.0"
+
 // Protocol frame types
 const (
 	FrameTypeData = 0
 	FrameTypePing = 1
 	FrameTypePong = 2
+	// FrameTypeNotice carries a UTF-8 message for the user, such as a quota
+	// warning.
+	FrameTypeNotice = 3
 )
 
 // NewVPNService creates a new VPN service instance
@@ -55,11 +67,16 @@ func NewVPNService() *VPNService {
 	return &VPNService{}
 }
 
-// LoadConfig loads configuration from JSON string
+// LoadConfig loads configuration from a JSON string or a yagnoetik:// share link
 func (v *VPNService) LoadConfig(configJSON string) error {
 	var config Config
-	err := json.Unmarshal([]byte(configJSON), &config)
-	if err != nil {
+	if shareuri.IsLink(configJSON) {
+		parsed, err := ParseShareURI(configJSON)
+		if err != nil {
+			return err
+		}
+		config = *parsed
+	} else if err := json.Unmarshal([]byte(configJSON), &config); err != nil {
 		return fmt.Errorf("failed to parse config: %v", err)
 	}
 
@@ -75,6 +92,20 @@ func (v *VPNService) LoadConfig(configJSON string) error {
 	return nil
 }
 
+// ParseShareURI decodes a yagnoetik:// link into a config
+func ParseShareURI(s string) (*Config, error) {
+	link, err := shareuri.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	return &Config{
+		ServerAddr: link.ServerAddr,
+		UUID:       link.UUID,
+		Secret:     link.Secret,
+		Key:        link.Key,
+	}, nil
+}
+
 // Connect establishes VPN connection
 func (v *VPNService) Connect(tunFd int) error {
 	v.mutex.Lock()
@@ -104,6 +135,7 @@ func (v *VPNService) Connect(tunFd int) error {
 	ctx := metadata.AppendToOutgoingContext(context.Background(),
 		"uuid", v.config.UUID,
 		"secret", v.config.Secret,
+		"client-version", Version,
 	)
 
 	v.ctx, v.cancel = context.WithCancel(ctx)
@@ -116,6 +148,20 @@ func (v *VPNService) Connect(tunFd int) error {
 	}
 	v.stream = stream
 
+	// The server sends its headers once the session is admitted
+	header, err := stream.Header()
+	if err != nil {
+		v.cleanup()
+		return fmt.Errorf("failed to start stream: %v", err)
+	}
+	var sessionID string
+	if values := header.Get("session-id"); len(values) > 0 {
+		sessionID = values[0]
+	}
+	v.logger = slog.Default().With("session_id", sessionID, "client_id", v.config.UUID)
+	v.packetLog = logging.NewLimiter(5, time.Minute)
+	v.logger.Info("Connected", "server", v.config.ServerAddr)
+
 	v.connected = true
 
 	// Start data transfer goroutines
@@ -166,7 +212,7 @@ func (v *VPNService) handleTunToStream() {
 		n, err := tunConn.Read(buf)
 		if err != nil {
 			if v.connected {
-				log.Printf("TUN read error: %v", err)
+				v.logger.Error("TUN read failed", "error", err)
 			}
 			return
 		}
@@ -174,7 +220,7 @@ func (v *VPNService) handleTunToStream() {
 		if n > 0 {
 			err := v.sendFrame(FrameTypeData, buf[:n])
 			if err != nil {
-				log.Printf("Failed to send frame: %v", err)
+				v.logger.Error("Failed to send frame", "error", err)
 				return
 			}
 
@@ -200,7 +246,7 @@ func (v *VPNService) handleStreamToTun() {
 				return
 			}
 			if v.connected {
-				log.Printf("Stream recv error: %v", err)
+				v.logger.Error("Stream receive failed", "error", err)
 			}
 			return
 		}
@@ -208,7 +254,9 @@ func (v *VPNService) handleStreamToTun() {
 		// Decrypt the frame
 		decrypted, err := v.cipher.Decrypt(msg.Data)
 		if err != nil {
-			log.Printf("Decryption error: %v", err)
+			if ok, suppressed := v.packetLog.Allow(); ok {
+				v.logger.Warn("Decryption failed", "error", err, "suppressed", suppressed)
+			}
 			continue
 		}
 
@@ -225,7 +273,7 @@ func (v *VPNService) handleStreamToTun() {
 			// Write to TUN interface
 			_, err := tunConn.Write(frameData)
 			if err != nil {
-				log.Printf("TUN write error: %v", err)
+				v.logger.Error("TUN write failed", "error", err)
 				return
 			}
 			v.bytesDown += int64(len(frameData))
@@ -236,6 +284,12 @@ func (v *VPNService) handleStreamToTun() {
 
 		case FrameTypePong:
 			// Ping response received
+
+		case FrameTypeNotice:
+			v.logger.Info("Server notice", "message", string(frameData))
+			v.mutex.Lock()
+			v.lastNotice = string(frameData)
+			v.mutex.Unlock()
 		}
 	}
 }
@@ -267,7 +321,7 @@ func (v *VPNService) keepAlive() {
 			return
 		case <-ticker.C:
 			if err := v.sendFrame(FrameTypePing, []byte("ping")); err != nil {
-				log.Printf("Failed to send ping: %v", err)
+				v.logger.Warn("Failed to send ping", "error", err)
 				return
 			}
 		}
@@ -288,6 +342,14 @@ func (v *VPNService) GetStats() (int64, int64) {
 	return v.bytesUp, v.bytesDown
 }
 
+// GetLastNotice returns the most recent message from the server, such as a
+// traffic quota warning
+func (v *VPNService) GetLastNotice() string {
+	v.mutex.RLock()
+	defer v.mutex.RUnlock()
+	return v.lastNotice
+}
+
 // TunConn wraps file descriptor for TUN interface
 type TunConn struct {
 	fd int
@@ -350,6 +412,7 @@ type TunnelFrame struct {
 type TunnelService_ConnectClient interface {
 	Send(*TunnelFrame) error
 	Recv() (*TunnelFrame, error)
+	Header() (metadata.MD, error)
 	CloseSend() error
 }
 
@@ -3,15 +3,29 @@ package main
 import (
 	"encoding/json"
 	"log"
+	"log/slog"
 	"os"
 
 	"yagnoetik-vpn-client/internal/client"
 	"yagnoetik-vpn-client/internal/ui"
+
+	"yagnoetik-sdk/logging"
 )
 
 func main() {
-	// Load configuration
-	config, err := loadConfig("config.json")
+	logger, err := logging.New(os.Stderr, envString("LOG_LEVEL", "info"), envString("LOG_FORMAT", "text"))
+	if err != nil {
+		log.Fatalf("Invalid logging configuration: %v", err)
+	}
+	slog.SetDefault(logger)
+
+	// Load configuration from a path or share link given on the command
+	// line, falling back to config.json next to the executable
+	source := "config.json"
+	if len(os.Args) > 1 {
+		source = os.Args[1]
+	}
+	config, err := loadConfig(source)
 	if err != nil {
 		log.Printf("Failed to load config: %v, using defaults", err)
 		config = &client.Config{
@@ -35,12 +49,22 @@ func main() {
 	}
 }
 
-func loadConfig(filename string) (*client.Config, error) {
-	data, err := os.ReadFile(filename)
+// loadConfig accepts a yagnoetik:// share link, or a file holding either a
+// share link or config.json.
+func loadConfig(source string) (*client.Config, error) {
+	if client.IsShareURI(source) {
+		return client.ParseShareURI(source)
+	}
+
+	data, err := os.ReadFile(source)
 	if err != nil {
 		return nil, err
 	}
 
+	if client.IsShareURI(string(data)) {
+		return client.ParseShareURI(string(data))
+	}
+
 	var config client.Config
 	err = json.Unmarshal(data, &config)
 	if err != nil {
@@ -49,3 +73,12 @@ func loadConfig(filename string) (*client.Config, error) {
 
 	return &config, nil
 }
+
+// envString reads a string from the environment, falling back to def when
+// the variable is unset.
+func envString(name, def string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return def
+}
@@ -14,3 +14,7 @@ require (
 	golang.org/x/text v0.19.0 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20241015192408-796eee8c2d53 // indirect
 )
+
+require yagnoetik-sdk v0.0.0
+
+replace yagnoetik-sdk => ../sdk
@@ -0,0 +1,22 @@
+package client
+
+import "yagnoetik-sdk/shareuri"
+
+// IsShareURI reports whether s looks like a yagnoetik:// link.
+func IsShareURI(s string) bool {
+	return shareuri.IsLink(s)
+}
+
+// ParseShareURI decodes a yagnoetik:// link into a client config.
+func ParseShareURI(s string) (*Config, error) {
+	link, err := shareuri.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	return &Config{
+		ServerAddr: link.ServerAddr,
+		UUID:       link.UUID,
+		Secret:     link.Secret,
+		Key:        link.Key,
+	}, nil
+}
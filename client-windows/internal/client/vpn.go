@@ -5,7 +5,7 @@ import (
 	"crypto/tls"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"sync"
 	"time"
 
@@ -17,6 +17,8 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/metadata"
+
+	"yagnoetik-sdk/logging"
 )
 
 type Config struct {
@@ -26,6 +28,9 @@ type Config struct {
 	Key        []byte `json:"key"`
 }
 
+// Version is reported to the server when connecting.
+const Version = "windows/1.0.0"
+
 type VPNClient struct {
 	config     *Config
 	conn       *grpc.ClientConn
@@ -38,6 +43,8 @@ type VPNClient struct {
 	cancel     context.CancelFunc
 	bytesUp    int64
 	bytesDown  int64
+	logger     *slog.Logger
+	packetLog  *logging.Limiter
 }
 
 func NewVPNClient(config *Config) (*VPNClient, error) {
@@ -49,6 +56,7 @@ func NewVPNClient(config *Config) (*VPNClient, error) {
 	return &VPNClient{
 		config: config,
 		cipher: cipher,
+		logger: slog.Default().With("client_id", config.UUID),
 	}, nil
 }
 
@@ -75,7 +83,7 @@ func (c *VPNClient) Connect() error {
 
 	// Add default route through VPN
 	if err := c.tunIface.AddRoute("0.0.0.0/0", "10.8.0.1"); err != nil {
-		log.Printf("Warning: failed to add default route: %v", err)
+		c.logger.Warn("Failed to add default route", "error", err)
 	}
 
 	// Connect to gRPC server
@@ -97,6 +105,7 @@ func (c *VPNClient) Connect() error {
 	ctx := metadata.AppendToOutgoingContext(context.Background(),
 		"uuid", c.config.UUID,
 		"secret", c.config.Secret,
+		"client-version", Version,
 	)
 
 	c.ctx, c.cancel = context.WithCancel(ctx)
@@ -109,6 +118,20 @@ func (c *VPNClient) Connect() error {
 	}
 	c.stream = stream
 
+	// The server sends its headers once the session is admitted
+	header, err := stream.Header()
+	if err != nil {
+		c.cleanup()
+		return fmt.Errorf("failed to start stream: %v", err)
+	}
+	var sessionID string
+	if values := header.Get("session-id"); len(values) > 0 {
+		sessionID = values[0]
+	}
+	c.logger = slog.Default().With("session_id", sessionID, "client_id", c.config.UUID)
+	c.packetLog = logging.NewLimiter(5, time.Minute)
+	c.logger.Info("Connected", "server", c.config.ServerAddr)
+
 	c.connected = true
 
 	// Start data transfer goroutines
@@ -160,7 +183,7 @@ func (c *VPNClient) handleTunToStream() {
 		n, err := c.tunIface.Read(buf)
 		if err != nil {
 			if c.connected {
-				log.Printf("TUN read error: %v", err)
+				c.logger.Error("TUN read failed", "error", err)
 			}
 			return
 		}
@@ -174,7 +197,7 @@ func (c *VPNClient) handleTunToStream() {
 
 			err := c.sendFrame(frame)
 			if err != nil {
-				log.Printf("Failed to send frame: %v", err)
+				c.logger.Error("Failed to send frame", "error", err)
 				return
 			}
 
@@ -198,7 +221,7 @@ func (c *VPNClient) handleStreamToTun() {
 				return
 			}
 			if c.connected {
-				log.Printf("Stream recv error: %v", err)
+				c.logger.Error("Stream receive failed", "error", err)
 			}
 			return
 		}
@@ -206,7 +229,9 @@ func (c *VPNClient) handleStreamToTun() {
 		// Decrypt the frame
 		decrypted, err := c.cipher.Decrypt(msg.Data)
 		if err != nil {
-			log.Printf("Decryption error: %v", err)
+			if ok, suppressed := c.packetLog.Allow(); ok {
+				c.logger.Warn("Decryption failed", "error", err, "suppressed", suppressed)
+			}
 			continue
 		}
 
@@ -223,7 +248,7 @@ func (c *VPNClient) handleStreamToTun() {
 			// Write to TUN interface
 			_, err := c.tunIface.Write(frameData)
 			if err != nil {
-				log.Printf("TUN write error: %v", err)
+				c.logger.Error("TUN write failed", "error", err)
 				return
 			}
 			c.bytesDown += int64(len(frameData))
@@ -238,6 +263,9 @@ func (c *VPNClient) handleStreamToTun() {
 
 		case protocol.FrameTypePong:
 			// Ping response received
+
+		case protocol.FrameTypeNotice:
+			c.logger.Info("Server notice", "message", string(frameData))
 		}
 	}
 }
@@ -275,7 +303,7 @@ func (c *VPNClient) keepAlive() {
 			}
 
 			if err := c.sendFrame(pingFrame); err != nil {
-				log.Printf("Failed to send ping: %v", err)
+				c.logger.Warn("Failed to send ping", "error", err)
 				return
 			}
 		}
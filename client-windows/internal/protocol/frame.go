@@ -10,6 +10,9 @@ const (
 	FrameTypeData = 0
 	FrameTypePing = 1
 	FrameTypePong = 2
+	// FrameTypeNotice carries a UTF-8 message for the user, such as a quota
+	// warning.
+	FrameTypeNotice = 3
 )
 
 type Frame struct {
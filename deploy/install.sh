@@ -34,6 +34,10 @@ fi
 DOMAIN=$1
 EMAIL=$2
 API_KEY=$(openssl rand -hex 32)
+# Ключи, с которыми админ-панель обращается к серверу от имени своих пользователей
+PANEL_ADMIN_KEY=$(openssl rand -hex 32)
+PANEL_SUPPORT_KEY=$(openssl rand -hex 32)
+PANEL_READONLY_KEY=$(openssl rand -hex 32)
 
 log "🚀 Начинаем установку Yagnoetik VPN"
 log "📍 Домен: $DOMAIN"
@@ -139,6 +143,9 @@ server {
     add_header X-Content-Type-Options nosniff always;
     add_header X-XSS-Protection "1; mode=block" always;
     
+    # Do not keep client addresses in access logs
+    access_log off;
+
     # Proxy to Yagnoetik server
     location / {
         proxy_pass https://127.0.0.1:8444;
@@ -151,6 +158,7 @@ server {
         # gRPC support
         grpc_pass grpc://127.0.0.1:8444;
         grpc_set_header Host \$host;
+        grpc_set_header X-Real-IP \$remote_addr;
     }
 }
 
@@ -169,11 +177,43 @@ server {
         proxy_set_header X-Forwarded-For \$proxy_add_x_forwarded_for;
         proxy_set_header X-Forwarded-Proto \$scheme;
     }
+
+    # Поток событий панели: без буферизации и с долгим ожиданием
+    location /api/events {
+        proxy_pass http://127.0.0.1:8081;
+        proxy_set_header Host \$host;
+        proxy_set_header X-Real-IP \$remote_addr;
+        proxy_set_header X-Forwarded-For \$proxy_add_x_forwarded_for;
+        proxy_set_header X-Forwarded-Proto \$scheme;
+        proxy_buffering off;
+        proxy_read_timeout 10m;
+    }
 }
 EOF
 
 nginx -t && systemctl reload nginx
 
+# Собственный CA для admin API: сервер принимает только клиентов с его
+# сертификатом, а панель проверяет сервер по нему же
+log "🔐 Создание сертификатов admin API..."
+ADMIN_TLS_DIR=/opt/yagnoetik/admin-tls
+mkdir -p $ADMIN_TLS_DIR
+cd $ADMIN_TLS_DIR
+openssl req -x509 -newkey ec -pkeyopt ec_paramgen_curve:P-256 -nodes \
+    -keyout ca.key -out ca.crt -days 3650 -subj "/CN=Yagnoetik admin CA"
+openssl req -newkey ec -pkeyopt ec_paramgen_curve:P-256 -nodes \
+    -keyout admin.key -out admin.csr -subj "/CN=localhost"
+openssl x509 -req -in admin.csr -CA ca.crt -CAkey ca.key -CAcreateserial -out admin.crt -days 3650 \
+    -extfile <(printf "subjectAltName=DNS:localhost,IP:127.0.0.1,IP:::1\nextendedKeyUsage=serverAuth")
+openssl req -newkey ec -pkeyopt ec_paramgen_curve:P-256 -nodes \
+    -keyout panel.key -out panel.csr -subj "/CN=admin-panel"
+openssl x509 -req -in panel.csr -CA ca.crt -CAkey ca.key -CAcreateserial -out panel.crt -days 3650 \
+    -extfile <(printf "extendedKeyUsage=clientAuth")
+rm -f admin.csr panel.csr
+chown -R yagnoetik:yagnoetik $ADMIN_TLS_DIR
+chmod 600 $ADMIN_TLS_DIR/*.key
+cd - > /dev/null
+
 # Создание systemd сервисов
 log "⚙️ Создание systemd сервисов..."
 
@@ -191,6 +231,14 @@ Group=yagnoetik
 WorkingDirectory=/opt/yagnoetik/Yagnoetik/server
 ExecStart=/opt/yagnoetik/Yagnoetik/server/yagnoetik-server
 Environment=API_KEY=$API_KEY
+Environment=ADMIN_KEYS=panel-admin:admin:$PANEL_ADMIN_KEY,panel-support:support:$PANEL_SUPPORT_KEY,panel-readonly:read-only:$PANEL_READONLY_KEY
+Environment=ADMIN_ADDR=127.0.0.1:8443
+Environment=ADMIN_TLS_CERT=$ADMIN_TLS_DIR/admin.crt
+Environment=ADMIN_TLS_KEY=$ADMIN_TLS_DIR/admin.key
+Environment=ADMIN_CLIENT_CA=$ADMIN_TLS_DIR/ca.crt
+Environment=ADMIN_ALLOW_CIDRS=127.0.0.1/32,::1/128
+Environment=ADMIN_SOCKET=/opt/yagnoetik/admin.sock
+Environment=DATA_DIR=/opt/yagnoetik/data
 Environment=TLS_CERT=/etc/letsencrypt/live/$DOMAIN/fullchain.pem
 Environment=TLS_KEY=/etc/letsencrypt/live/$DOMAIN/privkey.pem
 Environment=DOMAIN=$DOMAIN
@@ -224,8 +272,12 @@ User=yagnoetik
 Group=yagnoetik
 WorkingDirectory=/opt/yagnoetik/Yagnoetik/admin-panel
 ExecStart=/opt/yagnoetik/Yagnoetik/admin-panel/yagnoetik-admin
-Environment=SERVER_URL=http://localhost:8443
-Environment=API_KEY=$API_KEY
+Environment=SERVER_URL=https://localhost:8443
+Environment=PANEL_SERVER_CA=$ADMIN_TLS_DIR/ca.crt
+Environment=PANEL_CLIENT_CERT=$ADMIN_TLS_DIR/panel.crt
+Environment=PANEL_CLIENT_KEY=$ADMIN_TLS_DIR/panel.key
+Environment=PANEL_API_KEYS=admin:$PANEL_ADMIN_KEY,support:$PANEL_SUPPORT_KEY,read-only:$PANEL_READONLY_KEY
+Environment=PANEL_USERS_FILE=/opt/yagnoetik/panel-users.json
 Environment=PORT=8081
 Restart=always
 RestartSec=5
@@ -339,6 +391,12 @@ cd ../admin-panel
 /usr/local/go/bin/go build -o yagnoetik-admin .
 chmod +x yagnoetik-admin
 
+# Сборка yagnoetikctl
+echo "🔨 Сборка yagnoetikctl..."
+cd ../sdk
+/usr/local/go/bin/go build -o yagnoetikctl ./cmd/yagnoetikctl
+chmod +x yagnoetikctl
+
 # Сборка Windows клиента (опционально)
 echo "🔨 Сборка Windows клиента..."
 cd ../client-windows
@@ -378,8 +436,17 @@ case "$1" in
     build)
         sudo -u yagnoetik /opt/yagnoetik/build.sh
         ;;
+    ctl)
+        shift
+        sudo -u yagnoetik YAGNOETIK_CONFIG=/opt/yagnoetik/ctl.json /opt/yagnoetik/Yagnoetik/sdk/yagnoetikctl "$@"
+        ;;
+    adduser)
+        shift
+        sudo -u yagnoetik /opt/yagnoetik/Yagnoetik/admin-panel/yagnoetik-admin adduser -file /opt/yagnoetik/panel-users.json "$@"
+        systemctl restart yagnoetik-admin
+        ;;
     *)
-        echo "Использование: $0 {start|stop|restart|status|logs|build}"
+        echo "Использование: $0 {start|stop|restart|status|logs|build|ctl <команда>|adduser -name <имя> [-role read-only|support|admin] [-totp]}"
         exit 1
         ;;
 esac
@@ -387,6 +454,21 @@ EOF
 
 chmod +x /usr/local/bin/yagnoetik
 
+# Контекст yagnoetikctl: admin API через локальный сокет
+cat > /opt/yagnoetik/ctl.json << EOF
+{
+  "current": "local",
+  "contexts": {
+    "local": {
+      "api_key": "$API_KEY",
+      "socket": "/opt/yagnoetik/admin.sock"
+    }
+  }
+}
+EOF
+chown yagnoetik:yagnoetik /opt/yagnoetik/ctl.json
+chmod 600 /opt/yagnoetik/ctl.json
+
 # Настройка автообновления сертификатов
 log "🔄 Настройка автообновления сертификатов..."
 (crontab -l 2>/dev/null; echo "0 12 * * * /usr/bin/certbot renew --quiet && systemctl reload nginx") | crontab -
@@ -410,6 +492,8 @@ echo -e "🔄 Перезапуск: ${GREEN}yagnoetik restart${NC}"
 echo -e "📊 Статус: ${GREEN}yagnoetik status${NC}"
 echo -e "📝 Логи: ${GREEN}yagnoetik logs${NC}"
 echo -e "🔨 Сборка: ${GREEN}yagnoetik build${NC}"
+echo -e "🧰 Управление клиентами: ${GREEN}yagnoetik ctl clients list${NC}"
+echo -e "👤 Пользователь панели: ${GREEN}yagnoetik adduser -name admin -role admin -totp${NC}"
 echo
 echo -e "${YELLOW}⚠️  ВАЖНО: Скопируйте исходный код в /opt/yagnoetik/Yagnoetik/ и выполните 'yagnoetik build'${NC}"
 echo -e "${YELLOW}⚠️  Сохраните API ключ в безопасном месте!${NC}"
@@ -0,0 +1,183 @@
+package adminapi
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ListSessions returns the live sessions, of one client when clientUUID is
+// set.
+func (a *API) ListSessions(ctx context.Context, clientUUID string) ([]SessionInfo, error) {
+	query := make(url.Values)
+	if clientUUID != "" {
+		query.Set("client", clientUUID)
+	}
+	var sessions []SessionInfo
+	err := a.call(ctx, http.MethodGet, "/api/sessions", query, nil, &sessions)
+	return sessions, err
+}
+
+// CloseSession disconnects one session. The reason is recorded in the
+// session history.
+func (a *API) CloseSession(ctx context.Context, id, reason string) error {
+	return a.call(ctx, http.MethodDelete, "/api/sessions/"+url.PathEscape(id), reasonQuery(reason), nil, nil)
+}
+
+// CloseClientSessions disconnects every session of a client and returns
+// how many were closed.
+func (a *API) CloseClientSessions(ctx context.Context, uuid, reason string) (int, error) {
+	var resp CloseSessionsResponse
+	err := a.call(ctx, http.MethodDelete, v1ClientPath(uuid, "/sessions"), reasonQuery(reason), nil, &resp)
+	return resp.Closed, err
+}
+
+func reasonQuery(reason string) url.Values {
+	query := make(url.Values)
+	if reason != "" {
+		query.Set("reason", reason)
+	}
+	return query
+}
+
+type HistoryQuery struct {
+	// Client limits the history to one client.
+	Client string
+	From   time.Time
+	To     time.Time
+	Limit  int
+}
+
+// SessionHistory returns finished sessions, newest first.
+func (a *API) SessionHistory(ctx context.Context, q HistoryQuery) ([]SessionRecord, error) {
+	query := timeRange(q.From, q.To)
+	if q.Client != "" {
+		query.Set("client", q.Client)
+	}
+	if q.Limit > 0 {
+		query.Set("limit", strconv.Itoa(q.Limit))
+	}
+	var records []SessionRecord
+	err := a.call(ctx, http.MethodGet, "/api/sessions/history", query, nil, &records)
+	return records, err
+}
+
+// Transitions returns recent lifecycle state changes of all clients.
+func (a *API) Transitions(ctx context.Context) ([]Transition, error) {
+	var transitions []Transition
+	err := a.call(ctx, http.MethodGet, "/api/transitions", nil, nil, &transitions)
+	return transitions, err
+}
+
+func (a *API) ListTiers(ctx context.Context) ([]Tier, error) {
+	var tiers []Tier
+	err := a.call(ctx, http.MethodGet, "/api/tiers", nil, nil, &tiers)
+	return tiers, err
+}
+
+// PutTier creates or replaces the tier named tier.Name.
+func (a *API) PutTier(ctx context.Context, tier Tier) (*Tier, error) {
+	var saved Tier
+	if err := a.call(ctx, http.MethodPut, "/api/tiers/"+url.PathEscape(tier.Name), nil, tier, &saved); err != nil {
+		return nil, err
+	}
+	return &saved, nil
+}
+
+func (a *API) DeleteTier(ctx context.Context, name string) error {
+	return a.call(ctx, http.MethodDelete, "/api/tiers/"+url.PathEscape(name), nil, nil, nil)
+}
+
+// Retention returns how long the server keeps each kind of data.
+func (a *API) Retention(ctx context.Context) (*RetentionResponse, error) {
+	var policy RetentionResponse
+	if err := a.call(ctx, http.MethodGet, "/api/retention", nil, nil, &policy); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+type AuditQuery struct {
+	Actor  string
+	Action string
+	Target string
+	From   time.Time
+	To     time.Time
+	Limit  int
+}
+
+func (q AuditQuery) values() url.Values {
+	query := timeRange(q.From, q.To)
+	if q.Actor != "" {
+		query.Set("actor", q.Actor)
+	}
+	if q.Action != "" {
+		query.Set("action", q.Action)
+	}
+	if q.Target != "" {
+		query.Set("target", q.Target)
+	}
+	if q.Limit > 0 {
+		query.Set("limit", strconv.Itoa(q.Limit))
+	}
+	return query
+}
+
+// ListAudit returns audit entries matching q, newest first.
+func (a *API) ListAudit(ctx context.Context, q AuditQuery) ([]AuditEntry, error) {
+	var entries []AuditEntry
+	err := a.call(ctx, http.MethodGet, "/api/audit", q.values(), nil, &entries)
+	return entries, err
+}
+
+// ExportAudit exports the audit entries matching q as "jsonl" or "csv".
+func (a *API) ExportAudit(ctx context.Context, q AuditQuery, format string) (*Download, error) {
+	query := q.values()
+	query.Del("limit")
+	query.Set("format", format)
+	return a.download(ctx, "/api/audit/export", query)
+}
+
+// VerifyAudit checks the hash chain of the audit log.
+func (a *API) VerifyAudit(ctx context.Context) (*AuditVerification, error) {
+	var result AuditVerification
+	if err := a.call(ctx, http.MethodGet, "/api/audit/verify", nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (a *API) ListKeys(ctx context.Context) ([]AdminKey, error) {
+	var keys []AdminKey
+	err := a.call(ctx, http.MethodGet, "/api/keys", nil, nil, &keys)
+	return keys, err
+}
+
+// CreateKey creates an admin key. The token in the response is not shown
+// again.
+func (a *API) CreateKey(ctx context.Context, req CreateKeyRequest) (*KeyResponse, error) {
+	var key KeyResponse
+	if err := a.call(ctx, http.MethodPost, "/api/keys", nil, req, &key); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// RotateKey replaces a key's token, keeping its name and role.
+func (a *API) RotateKey(ctx context.Context, id string) (*KeyResponse, error) {
+	var key KeyResponse
+	if err := a.call(ctx, http.MethodPost, "/api/keys/"+url.PathEscape(id)+"/rotate", nil, nil, &key); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (a *API) RevokeKey(ctx context.Context, id string) (*AdminKey, error) {
+	var key AdminKey
+	if err := a.call(ctx, http.MethodDelete, "/api/keys/"+url.PathEscape(id), nil, nil, &key); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
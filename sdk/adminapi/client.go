@@ -0,0 +1,301 @@
+package adminapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Options struct {
+	// HTTPClient sends the requests; nil uses a client with a 30 second
+	// timeout. Set its transport to trust the server's CA or to present a
+	// client certificate.
+	HTTPClient *http.Client
+	// MaxRetries is how many times a failed request is retried; 0 uses 2
+	// and a negative value disables retries. Only requests that are safe
+	// to repeat are retried: GET, PUT, DELETE and POSTs with an
+	// idempotency key.
+	MaxRetries int
+	// RetryBackoff is the delay before the first retry, doubled for each
+	// one after; 0 uses 250ms.
+	RetryBackoff time.Duration
+	UserAgent    string
+}
+
+// API calls the admin API of one server with one key.
+type API struct {
+	baseURL   string
+	apiKey    string
+	client    *http.Client
+	stream    *http.Client // client without a timeout, for event streams
+	retries   int
+	backoff   time.Duration
+	userAgent string
+}
+
+// New returns an API for the server at baseURL, such as
+// "https://vpn.example.com:8443", authenticating with apiKey.
+func New(baseURL, apiKey string, options Options) *API {
+	a := &API{
+		baseURL:   strings.TrimSuffix(baseURL, "/"),
+		apiKey:    apiKey,
+		client:    options.HTTPClient,
+		retries:   options.MaxRetries,
+		backoff:   options.RetryBackoff,
+		userAgent: options.UserAgent,
+	}
+	if a.client == nil {
+		a.client = &http.Client{Timeout: 30 * time.Second}
+	}
+	stream := *a.client
+	stream.Timeout = 0
+	a.stream = &stream
+	if a.retries == 0 {
+		a.retries = 2
+	}
+	if a.backoff == 0 {
+		a.backoff = 250 * time.Millisecond
+	}
+	if a.userAgent == "" {
+		a.userAgent = "yagnoetik-sdk"
+	}
+	return a
+}
+
+// Error is a response with a status of 400 or more. Code and RequestID are
+// set only by v2 endpoints, which return structured errors.
+type Error struct {
+	StatusCode int
+	APIError
+}
+
+func (e *Error) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("admin API: %d %s: %s", e.StatusCode, e.Code, e.Message)
+	}
+	return fmt.Sprintf("admin API: %d: %s", e.StatusCode, e.Message)
+}
+
+// IsNotFound reports whether err is a 404 from the server.
+func IsNotFound(err error) bool {
+	return hasStatus(err, http.StatusNotFound)
+}
+
+// IsPreconditionFailed reports whether err means the client changed since
+// the revision passed to an update or delete.
+func IsPreconditionFailed(err error) bool {
+	return hasStatus(err, http.StatusPreconditionFailed)
+}
+
+func hasStatus(err error, status int) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.StatusCode == status
+}
+
+type contextKey int
+
+const (
+	actorKey contextKey = iota
+	forwardedForKey
+	idempotencyKey
+)
+
+// WithActor names the person a request is made for, such as a panel user.
+// The server records it in the audit log next to the key's name.
+func WithActor(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, actorKey, name)
+}
+
+// WithForwardedFor passes on the address of the person a request is made
+// for, recorded in the audit log.
+func WithForwardedFor(ctx context.Context, addr string) context.Context {
+	return context.WithValue(ctx, forwardedForKey, addr)
+}
+
+// WithIdempotencyKey sets the key sent with v2 POSTs. Without one, each
+// call generates its own, which still makes the call's retries safe.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKey, key)
+}
+
+type request struct {
+	method      string
+	path        string
+	query       url.Values
+	header      http.Header
+	body        []byte
+	contentType string
+}
+
+// newRequest builds a request with in encoded as its JSON body; in may be
+// nil for requests without one.
+func newRequest(method, path string, query url.Values, in any) (request, error) {
+	req := request{method: method, path: path, query: query, header: make(http.Header)}
+	if in != nil {
+		body, err := json.Marshal(in)
+		if err != nil {
+			return req, err
+		}
+		req.body = body
+		req.contentType = "application/json"
+	}
+	return req, nil
+}
+
+// call sends a request and decodes its JSON response into out, which may
+// be nil to discard it.
+func (a *API) call(ctx context.Context, method, path string, query url.Values, in, out any) error {
+	req, err := newRequest(method, path, query, in)
+	if err != nil {
+		return err
+	}
+	resp, err := a.send(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return decode(resp, out)
+}
+
+func decode(resp *http.Response, out any) error {
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("admin API: decode %s response: %v", resp.Request.URL.Path, err)
+	}
+	return nil
+}
+
+// send makes the request, retrying it when that is safe, and returns the
+// response if its status is below 400. The caller closes the body.
+func (a *API) send(ctx context.Context, req request) (*http.Response, error) {
+	if req.method == http.MethodPost && strings.HasPrefix(req.path, "/api/v2/") {
+		key, _ := ctx.Value(idempotencyKey).(string)
+		if key == "" {
+			key = newIdempotencyKey()
+		}
+		req.header.Set("Idempotency-Key", key)
+	}
+	retry := req.method != http.MethodPost && req.method != http.MethodPatch ||
+		req.header.Get("Idempotency-Key") != ""
+
+	backoff := a.backoff
+	for attempt := 0; ; attempt++ {
+		resp, err := a.sendOnce(ctx, req)
+		if !retry || attempt >= a.retries || !retryable(resp, err) {
+			if err != nil {
+				return nil, err
+			}
+			if resp.StatusCode >= 400 {
+				defer resp.Body.Close()
+				return nil, readError(resp)
+			}
+			return resp, nil
+		}
+
+		wait := backoff
+		if resp != nil {
+			if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+				wait = time.Duration(seconds) * time.Second
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+		backoff *= 2
+	}
+}
+
+func (a *API) sendOnce(ctx context.Context, req request) (*http.Response, error) {
+	httpReq, err := a.httpRequest(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return a.client.Do(httpReq)
+}
+
+// httpRequest builds the HTTP request with the key and the context's actor
+// and forwarded address.
+func (a *API) httpRequest(ctx context.Context, req request) (*http.Request, error) {
+	target := a.baseURL + req.path
+	if len(req.query) > 0 {
+		target += "?" + req.query.Encode()
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, target, bytes.NewReader(req.body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range req.header {
+		httpReq.Header[name] = values
+	}
+	if req.contentType != "" {
+		httpReq.Header.Set("Content-Type", req.contentType)
+	}
+	httpReq.Header.Set("X-API-Key", a.apiKey)
+	httpReq.Header.Set("User-Agent", a.userAgent)
+	if actor, ok := ctx.Value(actorKey).(string); ok && actor != "" {
+		httpReq.Header.Set("X-Admin-Actor", actor)
+	}
+	if addr, ok := ctx.Value(forwardedForKey).(string); ok && addr != "" {
+		httpReq.Header.Set("X-Forwarded-For", addr)
+	}
+	return httpReq, nil
+}
+
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		// a cancelled or expired context will not succeed on retry
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// readError turns an error response into an *Error, from the JSON body of
+// v2 endpoints or the plain-text body of v1 ones.
+func readError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	apiErr := &Error{StatusCode: resp.StatusCode}
+
+	var v2 ErrorResponse
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") &&
+		json.Unmarshal(body, &v2) == nil && v2.Error.Code != "" {
+		apiErr.APIError = v2.Error
+		return apiErr
+	}
+	apiErr.Message = strings.TrimSpace(string(body))
+	if apiErr.Message == "" {
+		apiErr.Message = http.StatusText(resp.StatusCode)
+	}
+	apiErr.RequestID = resp.Header.Get("X-Request-ID")
+	return apiErr
+}
+
+func newIdempotencyKey() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func etag(revision int64) string {
+	return `"` + strconv.FormatInt(revision, 10) + `"`
+}
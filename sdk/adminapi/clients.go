@@ -0,0 +1,343 @@
+package adminapi
+
+import (
+	"context"
+	"io"
+	"iter"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ClientQuery filters and orders client lists and exports. Zero fields
+// are not applied.
+type ClientQuery struct {
+	Status        []string
+	Tag           string
+	Text          string
+	ExpiresAfter  time.Time
+	ExpiresBefore time.Time
+	// Sort is created_at, expires_at, name or traffic, with a "-" prefix
+	// for descending order.
+	Sort string
+	// Limit and Cursor page through lists; exports ignore them.
+	Limit  int
+	Cursor string
+}
+
+func (q ClientQuery) values() url.Values {
+	v := make(url.Values)
+	if len(q.Status) > 0 {
+		v.Set("status", strings.Join(q.Status, ","))
+	}
+	if q.Tag != "" {
+		v.Set("tag", q.Tag)
+	}
+	if q.Text != "" {
+		v.Set("q", q.Text)
+	}
+	if !q.ExpiresAfter.IsZero() {
+		v.Set("expires_after", q.ExpiresAfter.Format(time.RFC3339))
+	}
+	if !q.ExpiresBefore.IsZero() {
+		v.Set("expires_before", q.ExpiresBefore.Format(time.RFC3339))
+	}
+	if q.Sort != "" {
+		v.Set("sort", q.Sort)
+	}
+	if q.Limit > 0 {
+		v.Set("limit", strconv.Itoa(q.Limit))
+	}
+	if q.Cursor != "" {
+		v.Set("cursor", q.Cursor)
+	}
+	return v
+}
+
+func clientPath(uuid string, parts ...string) string {
+	return "/api/v2/clients/" + url.PathEscape(uuid) + strings.Join(parts, "")
+}
+
+func v1ClientPath(uuid string, parts ...string) string {
+	return "/api/clients/" + url.PathEscape(uuid) + strings.Join(parts, "")
+}
+
+func (a *API) CreateClient(ctx context.Context, req CreateClientRequest) (*ClientResponse, error) {
+	var client ClientResponse
+	if err := a.call(ctx, http.MethodPost, "/api/v2/clients", nil, req, &client); err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+// ListClients returns one page of clients. Pass the page's NextCursor as
+// the next query's Cursor, or use AllClients.
+func (a *API) ListClients(ctx context.Context, q ClientQuery) (*ClientListResponse, error) {
+	var page ClientListResponse
+	if err := a.call(ctx, http.MethodGet, "/api/v2/clients", q.values(), nil, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// AllClients iterates over every client matching q, fetching pages as it
+// goes. It stops after yielding the first error.
+func (a *API) AllClients(ctx context.Context, q ClientQuery) iter.Seq2[Client, error] {
+	return func(yield func(Client, error) bool) {
+		for {
+			page, err := a.ListClients(ctx, q)
+			if err != nil {
+				yield(Client{}, err)
+				return
+			}
+			for _, client := range page.Clients {
+				if !yield(client, nil) {
+					return
+				}
+			}
+			if page.NextCursor == "" {
+				return
+			}
+			q.Cursor = page.NextCursor
+		}
+	}
+}
+
+func (a *API) GetClient(ctx context.Context, uuid string) (*ClientResponse, error) {
+	var client ClientResponse
+	if err := a.call(ctx, http.MethodGet, clientPath(uuid), nil, nil, &client); err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+// UpdateClient applies a partial update. A non-zero revision makes it
+// conditional: it fails with an error for which IsPreconditionFailed is
+// true if the client has changed since.
+func (a *API) UpdateClient(ctx context.Context, uuid string, req UpdateClientRequest, revision int64) (*ClientResponse, error) {
+	r, err := newRequest(http.MethodPatch, clientPath(uuid), nil, req)
+	if err != nil {
+		return nil, err
+	}
+	if revision != 0 {
+		r.header.Set("If-Match", etag(revision))
+	}
+	var client ClientResponse
+	if err := a.sendJSON(ctx, r, &client); err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+// DeleteClient deletes a client, only if it is still at revision when
+// that is non-zero.
+func (a *API) DeleteClient(ctx context.Context, uuid string, revision int64) error {
+	r, _ := newRequest(http.MethodDelete, clientPath(uuid), nil, nil)
+	if revision != 0 {
+		r.header.Set("If-Match", etag(revision))
+	}
+	return a.sendJSON(ctx, r, nil)
+}
+
+func (a *API) BlockClient(ctx context.Context, uuid string) (*ClientResponse, error) {
+	return a.clientAction(ctx, uuid, "/block", nil)
+}
+
+func (a *API) UnblockClient(ctx context.Context, uuid string) (*ClientResponse, error) {
+	return a.clientAction(ctx, uuid, "/unblock", nil)
+}
+
+// ExtendClient moves the client's expiry forward by duration, such as
+// "30d", from now if it has already expired.
+func (a *API) ExtendClient(ctx context.Context, uuid, duration string) (*ClientResponse, error) {
+	return a.clientAction(ctx, uuid, "/extend", ExtendRequest{Duration: duration})
+}
+
+// RotateClient issues a new secret and key and closes the client's
+// sessions. The response carries the new secret.
+func (a *API) RotateClient(ctx context.Context, uuid string) (*ClientResponse, error) {
+	return a.clientAction(ctx, uuid, "/rotate", nil)
+}
+
+func (a *API) clientAction(ctx context.Context, uuid, action string, in any) (*ClientResponse, error) {
+	var client ClientResponse
+	if err := a.call(ctx, http.MethodPost, clientPath(uuid, action), nil, in, &client); err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+func (a *API) sendJSON(ctx context.Context, req request, out any) error {
+	resp, err := a.send(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return decode(resp, out)
+}
+
+// ClientConfig returns the client's config.json with its share link.
+func (a *API) ClientConfig(ctx context.Context, uuid string) (*ClientConfigResponse, error) {
+	var config ClientConfigResponse
+	query := url.Values{"format": {"bundle"}}
+	if err := a.call(ctx, http.MethodGet, v1ClientPath(uuid, "/config"), query, nil, &config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// ResetQuota zeroes the traffic counted against the client's quota in the
+// current period.
+func (a *API) ResetQuota(ctx context.Context, uuid string) (*Client, error) {
+	var client Client
+	if err := a.call(ctx, http.MethodPost, v1ClientPath(uuid, "/quota/reset"), nil, nil, &client); err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+// ClientUsage returns the client's traffic between from and to in buckets
+// of step, such as "1h" or "1d". Zero times and an empty step use the
+// server's defaults.
+func (a *API) ClientUsage(ctx context.Context, uuid string, from, to time.Time, step string) (*UsageResponse, error) {
+	query := timeRange(from, to)
+	if step != "" {
+		query.Set("step", step)
+	}
+	var usage UsageResponse
+	if err := a.call(ctx, http.MethodGet, v1ClientPath(uuid, "/usage"), query, nil, &usage); err != nil {
+		return nil, err
+	}
+	return &usage, nil
+}
+
+func (a *API) ClientTransitions(ctx context.Context, uuid string) ([]Transition, error) {
+	var transitions []Transition
+	err := a.call(ctx, http.MethodGet, v1ClientPath(uuid, "/transitions"), nil, nil, &transitions)
+	return transitions, err
+}
+
+// BulkCreateClients creates req.Count clients at once. The response holds
+// their secrets, which are not shown again.
+func (a *API) BulkCreateClients(ctx context.Context, req BulkCreateRequest) ([]CreateClientResponse, error) {
+	var created []CreateClientResponse
+	err := a.call(ctx, http.MethodPost, "/api/clients/bulk", nil, req, &created)
+	return created, err
+}
+
+// Download is a file returned by an export. The caller closes Body.
+type Download struct {
+	Body        io.ReadCloser
+	ContentType string
+	Filename    string
+}
+
+func (a *API) download(ctx context.Context, path string, query url.Values) (*Download, error) {
+	req, _ := newRequest(http.MethodGet, path, query, nil)
+	resp, err := a.send(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	d := &Download{Body: resp.Body, ContentType: resp.Header.Get("Content-Type")}
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		d.Filename = params["filename"]
+	}
+	return d, nil
+}
+
+// ExportClients exports the clients matching q as "json" or "csv".
+// Secrets and keys are included only when includeSecrets is set, which
+// needs an admin key.
+func (a *API) ExportClients(ctx context.Context, q ClientQuery, format string, includeSecrets bool) (*Download, error) {
+	query := q.values()
+	query.Del("limit")
+	query.Del("cursor")
+	query.Set("format", format)
+	if includeSecrets {
+		query.Set("include_secrets", "true")
+	}
+	return a.download(ctx, "/api/clients/export", query)
+}
+
+type ImportOptions struct {
+	// OnConflict is skip, overwrite or fail; empty uses the server's
+	// default of fail.
+	OnConflict string
+	// DryRun validates the file without changing anything.
+	DryRun bool
+}
+
+// ImportClients imports a JSON or CSV export read from body. The report is
+// returned along with the error when the server rejects the import for
+// conflicts, so that callers can show which records clashed.
+func (a *API) ImportClients(ctx context.Context, body io.Reader, contentType string, options ImportOptions) (*ImportReport, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	query := make(url.Values)
+	if options.OnConflict != "" {
+		query.Set("on_conflict", options.OnConflict)
+	}
+	if options.DryRun {
+		query.Set("dry_run", "true")
+	}
+	req := request{
+		method:      http.MethodPost,
+		path:        "/api/clients/import",
+		query:       query,
+		header:      make(http.Header),
+		body:        data,
+		contentType: contentType,
+	}
+
+	resp, err := a.sendOnce(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusConflict {
+		var report ImportReport
+		if err := decode(resp, &report); err != nil {
+			return nil, err
+		}
+		return &report, &Error{StatusCode: resp.StatusCode, APIError: APIError{Message: "import has conflicts"}}
+	}
+	if resp.StatusCode >= 400 {
+		return nil, readError(resp)
+	}
+	var report ImportReport
+	if err := decode(resp, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// ExportClientData returns everything the server holds about a client,
+// for data subject requests.
+func (a *API) ExportClientData(ctx context.Context, uuid string) (*ClientDataExport, error) {
+	var data ClientDataExport
+	if err := a.call(ctx, http.MethodGet, v1ClientPath(uuid, "/data"), nil, nil, &data); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// EraseClientData deletes a client with its history and usage.
+func (a *API) EraseClientData(ctx context.Context, uuid string) error {
+	return a.call(ctx, http.MethodDelete, v1ClientPath(uuid, "/data"), nil, nil, nil)
+}
+
+func timeRange(from, to time.Time) url.Values {
+	query := make(url.Values)
+	if !from.IsZero() {
+		query.Set("from", from.Format(time.RFC3339))
+	}
+	if !to.IsZero() {
+		query.Set("to", to.Format(time.RFC3339))
+	}
+	return query
+}
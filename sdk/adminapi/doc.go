@@ -0,0 +1,5 @@
+// Package adminapi is a Go client for the Yagnoetik admin API. The request
+// and response types are generated from the server's OpenAPI document.
+package adminapi
+
+//go:generate go run ../internal/openapigen -spec ../../server/internal/api/openapi.json -out types.go -package adminapi
@@ -0,0 +1,157 @@
+package adminapi
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ErrEventGap is returned when some of the events after the ID a stream or
+// poll resumed from were dropped by the server. The events that follow are
+// still valid; callers that keep derived state should reload it.
+var ErrEventGap = errors.New("admin API: events were missed")
+
+// EventQuery filters events. Zero fields are not applied.
+type EventQuery struct {
+	// Types holds event types, such as "quota.warning", or groups, such
+	// as "session".
+	Types  []string
+	Client string
+	// After resumes after this event ID.
+	After int64
+	// Replay starts a stream with the last Replay events when After is 0.
+	Replay int
+	// Limit caps RecentEvents; 0 uses the server's default of 100.
+	Limit int
+}
+
+func (q EventQuery) values() url.Values {
+	v := make(url.Values)
+	if len(q.Types) > 0 {
+		v.Set("types", strings.Join(q.Types, ","))
+	}
+	if q.Client != "" {
+		v.Set("client", q.Client)
+	}
+	return v
+}
+
+// RecentEvents returns the events the server still buffers, oldest first.
+// With q.After set it returns the events after that ID, so that it can be
+// polled; if some of them were dropped it returns them with ErrEventGap.
+func (a *API) RecentEvents(ctx context.Context, q EventQuery) ([]Event, error) {
+	query := q.values()
+	if q.After > 0 {
+		query.Set("after", strconv.FormatInt(q.After, 10))
+	}
+	if q.Limit > 0 {
+		query.Set("limit", strconv.Itoa(q.Limit))
+	}
+	req, _ := newRequest(http.MethodGet, "/api/events/recent", query, nil)
+	resp, err := a.send(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var events []Event
+	if err := decode(resp, &events); err != nil {
+		return nil, err
+	}
+	if resp.Header.Get("X-Events-Gap") != "" {
+		return events, ErrEventGap
+	}
+	return events, nil
+}
+
+// EventStream reads server-sent events. The caller closes it.
+type EventStream struct {
+	body   io.ReadCloser
+	reader *bufio.Reader
+	// LastID is the ID of the last event read. Pass it as EventQuery.After
+	// to resume after the stream ends.
+	LastID int64
+}
+
+// StreamEvents opens the event stream. The stream has no timeout; it ends
+// when ctx is cancelled or the server closes it, after which Next returns
+// an error and the caller may reconnect from LastID.
+func (a *API) StreamEvents(ctx context.Context, q EventQuery) (*EventStream, error) {
+	query := q.values()
+	if q.After == 0 && q.Replay > 0 {
+		query.Set("replay", strconv.Itoa(q.Replay))
+	}
+	req, _ := newRequest(http.MethodGet, "/api/events", query, nil)
+	if q.After > 0 {
+		req.header.Set("Last-Event-ID", strconv.FormatInt(q.After, 10))
+	}
+	req.header.Set("Accept", "text/event-stream")
+
+	httpReq, err := a.httpRequest(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := a.stream.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		return nil, readError(resp)
+	}
+	return &EventStream{body: resp.Body, reader: bufio.NewReader(resp.Body), LastID: q.After}, nil
+}
+
+// Next returns the next event. It returns ErrEventGap, without an event,
+// when the server reports that events were dropped, and io.EOF when the
+// server closed the stream.
+func (s *EventStream) Next() (Event, error) {
+	var eventType, data string
+	for {
+		line, err := s.reader.ReadString('\n')
+		if err != nil {
+			return Event{}, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		if line == "" {
+			// A blank line ends an event; comments and retry hints
+			// leave nothing to dispatch
+			switch {
+			case eventType == "gap":
+				return Event{}, ErrEventGap
+			case data == "":
+				continue
+			}
+			var e Event
+			if err := json.Unmarshal([]byte(data), &e); err != nil {
+				return Event{}, fmt.Errorf("admin API: decode event: %v", err)
+			}
+			s.LastID = e.ID
+			return e, nil
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			eventType = value
+		case "data":
+			if data != "" {
+				data += "\n"
+			}
+			data += value
+		}
+	}
+}
+
+func (s *EventStream) Close() error {
+	return s.body.Close()
+}
@@ -0,0 +1,122 @@
+package adminapi
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// Voucher statuses. Expired vouchers passed their expiry unredeemed;
+// redeeming ones are having their client created or extended.
+const (
+	VoucherUnredeemed = "unredeemed"
+	VoucherRedeeming  = "redeeming"
+	VoucherRedeemed   = "redeemed"
+	VoucherExpired    = "expired"
+	VoucherRevoked    = "revoked"
+)
+
+// VoucherQuery filters the voucher report. Zero fields are not applied.
+type VoucherQuery struct {
+	Batch string
+	Plan  string
+	// Status is one of the Voucher* statuses.
+	Status string
+}
+
+func (q VoucherQuery) values() url.Values {
+	query := make(url.Values)
+	if q.Batch != "" {
+		query.Set("batch", q.Batch)
+	}
+	if q.Plan != "" {
+		query.Set("plan", q.Plan)
+	}
+	if q.Status != "" {
+		query.Set("status", q.Status)
+	}
+	return query
+}
+
+func voucherPath(code string) string {
+	return "/api/vouchers/" + url.PathEscape(code)
+}
+
+func (a *API) ListPlans(ctx context.Context) ([]Plan, error) {
+	var plans []Plan
+	err := a.call(ctx, http.MethodGet, "/api/plans", nil, nil, &plans)
+	return plans, err
+}
+
+// PutPlan creates or replaces the named plan. Clients already on it keep
+// their settings until they redeem another voucher.
+func (a *API) PutPlan(ctx context.Context, name string, req PlanRequest) (*Plan, error) {
+	var plan Plan
+	if err := a.call(ctx, http.MethodPut, "/api/plans/"+url.PathEscape(name), nil, req, &plan); err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// DeletePlan removes a plan. It fails while vouchers that can still be
+// redeemed grant the plan.
+func (a *API) DeletePlan(ctx context.Context, name string) error {
+	return a.call(ctx, http.MethodDelete, "/api/plans/"+url.PathEscape(name), nil, nil, nil)
+}
+
+// GenerateVouchers creates a batch of single-use codes for a plan.
+func (a *API) GenerateVouchers(ctx context.Context, req GenerateVouchersRequest) (*VoucherBatchResponse, error) {
+	var batch VoucherBatchResponse
+	if err := a.call(ctx, http.MethodPost, "/api/vouchers", nil, req, &batch); err != nil {
+		return nil, err
+	}
+	return &batch, nil
+}
+
+// ListVouchers returns the vouchers matching q, oldest first.
+func (a *API) ListVouchers(ctx context.Context, q VoucherQuery) ([]Voucher, error) {
+	var vouchers []Voucher
+	err := a.call(ctx, http.MethodGet, "/api/vouchers", q.values(), nil, &vouchers)
+	return vouchers, err
+}
+
+// ExportVouchers downloads the vouchers matching q as "json" or "csv".
+func (a *API) ExportVouchers(ctx context.Context, q VoucherQuery, format string) (*Download, error) {
+	query := q.values()
+	query.Set("format", format)
+	return a.download(ctx, "/api/vouchers", query)
+}
+
+func (a *API) GetVoucher(ctx context.Context, code string) (*Voucher, error) {
+	var voucher Voucher
+	if err := a.call(ctx, http.MethodGet, voucherPath(code), nil, nil, &voucher); err != nil {
+		return nil, err
+	}
+	return &voucher, nil
+}
+
+// RevokeVoucher stops an unredeemed voucher from being redeemed.
+func (a *API) RevokeVoucher(ctx context.Context, code string) (*Voucher, error) {
+	var voucher Voucher
+	if err := a.call(ctx, http.MethodDelete, voucherPath(code), nil, nil, &voucher); err != nil {
+		return nil, err
+	}
+	return &voucher, nil
+}
+
+// ListVoucherBatches returns the batches with their counts by status,
+// newest first.
+func (a *API) ListVoucherBatches(ctx context.Context) ([]VoucherBatch, error) {
+	var batches []VoucherBatch
+	err := a.call(ctx, http.MethodGet, "/api/vouchers/batches", nil, nil, &batches)
+	return batches, err
+}
+
+// RevokeVoucherBatch revokes every unredeemed voucher of a batch.
+func (a *API) RevokeVoucherBatch(ctx context.Context, id string) (*VoucherBatch, error) {
+	var batch VoucherBatch
+	if err := a.call(ctx, http.MethodDelete, "/api/vouchers/batches/"+url.PathEscape(id), nil, nil, &batch); err != nil {
+		return nil, err
+	}
+	return &batch, nil
+}
@@ -0,0 +1,135 @@
+package adminapi
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// Delivery statuses. Dead deliveries used up their attempts and wait in the
+// dead-letter queue.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+// DeliveryQuery filters the webhook delivery log. Zero fields are not
+// applied.
+type DeliveryQuery struct {
+	Webhook string
+	// Status is DeliveryPending, DeliveryDelivered or DeliveryDead.
+	Status string
+	// Event is an event type or group.
+	Event string
+	// Limit caps the result; 0 uses the server's default of 100.
+	Limit int
+}
+
+func webhookPath(id string) string {
+	return "/api/webhooks/" + url.PathEscape(id)
+}
+
+func deliveryPath(id string) string {
+	return "/api/webhooks/deliveries/" + url.PathEscape(id)
+}
+
+func (a *API) ListWebhooks(ctx context.Context) ([]Webhook, error) {
+	var webhooks []Webhook
+	err := a.call(ctx, http.MethodGet, "/api/webhooks", nil, nil, &webhooks)
+	return webhooks, err
+}
+
+// CreateWebhook subscribes a URL to events. The signing secret in the
+// response is not shown again.
+func (a *API) CreateWebhook(ctx context.Context, req CreateWebhookRequest) (*WebhookResponse, error) {
+	var webhook WebhookResponse
+	if err := a.call(ctx, http.MethodPost, "/api/webhooks", nil, req, &webhook); err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+func (a *API) GetWebhook(ctx context.Context, id string) (*Webhook, error) {
+	var webhook Webhook
+	if err := a.call(ctx, http.MethodGet, webhookPath(id), nil, nil, &webhook); err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+func (a *API) UpdateWebhook(ctx context.Context, id string, req UpdateWebhookRequest) (*Webhook, error) {
+	var webhook Webhook
+	if err := a.call(ctx, http.MethodPatch, webhookPath(id), nil, req, &webhook); err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+// DeleteWebhook removes a webhook and drops its pending deliveries.
+func (a *API) DeleteWebhook(ctx context.Context, id string) error {
+	return a.call(ctx, http.MethodDelete, webhookPath(id), nil, nil, nil)
+}
+
+// RotateWebhookSecret replaces a webhook's signing secret. Retries of
+// earlier deliveries are signed with the new one.
+func (a *API) RotateWebhookSecret(ctx context.Context, id string) (*WebhookResponse, error) {
+	var webhook WebhookResponse
+	if err := a.call(ctx, http.MethodPost, webhookPath(id)+"/rotate", nil, nil, &webhook); err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+// TestWebhook queues a webhook.ping delivery to the webhook.
+func (a *API) TestWebhook(ctx context.Context, id string) (*Delivery, error) {
+	var delivery Delivery
+	if err := a.call(ctx, http.MethodPost, webhookPath(id)+"/test", nil, nil, &delivery); err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// ListDeliveries returns the deliveries matching q, newest first.
+func (a *API) ListDeliveries(ctx context.Context, q DeliveryQuery) ([]Delivery, error) {
+	query := make(url.Values)
+	if q.Webhook != "" {
+		query.Set("subscription", q.Webhook)
+	}
+	if q.Status != "" {
+		query.Set("status", q.Status)
+	}
+	if q.Event != "" {
+		query.Set("event", q.Event)
+	}
+	if q.Limit > 0 {
+		query.Set("limit", strconv.Itoa(q.Limit))
+	}
+	var deliveries []Delivery
+	err := a.call(ctx, http.MethodGet, "/api/webhooks/deliveries", query, nil, &deliveries)
+	return deliveries, err
+}
+
+// GetDelivery returns a delivery with the payload it sends.
+func (a *API) GetDelivery(ctx context.Context, id string) (*DeliveryDetail, error) {
+	var delivery DeliveryDetail
+	if err := a.call(ctx, http.MethodGet, deliveryPath(id), nil, nil, &delivery); err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// Redeliver moves a delivery from the dead-letter queue back to the queue.
+func (a *API) Redeliver(ctx context.Context, id string) (*Delivery, error) {
+	var delivery Delivery
+	if err := a.call(ctx, http.MethodPost, deliveryPath(id)+"/redeliver", nil, nil, &delivery); err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// DiscardDelivery drops a delivery from the dead-letter queue.
+func (a *API) DiscardDelivery(ctx context.Context, id string) error {
+	return a.call(ctx, http.MethodDelete, deliveryPath(id), nil, nil, nil)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+	"time"
+
+	"yagnoetik-sdk/adminapi"
+)
+
+// pollInterval is how often -f asks the server for new entries.
+const pollInterval = 2 * time.Second
+
+// follow calls poll every pollInterval until the context is cancelled,
+// which ends the command without an error.
+func (c *cli) follow(poll func() error) error {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := poll(); err != nil && c.ctx.Err() == nil {
+				return err
+			}
+		}
+	}
+}
+
+// printLines writes one JSON object per line, which suits a stream that
+// -f keeps appending to.
+func printLines[T any](items []T) error {
+	enc := json.NewEncoder(os.Stdout)
+	for _, item := range items {
+		if err := enc.Encode(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func auditTail(c *cli, args []string) error {
+	fs := c.flags()
+	var q adminapi.AuditQuery
+	n := fs.Int("n", 20, "number of entries to show")
+	follow := fs.Bool("f", false, "keep showing new entries")
+	fs.StringVar(&q.Actor, "actor", "", "")
+	fs.StringVar(&q.Action, "action", "", "substring of the action, such as DELETE or /block")
+	fs.StringVar(&q.Target, "target", "", "client UUID or other target")
+	if _, err := parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	q.Limit = *n
+	entries, err := c.api.ListAudit(c.ctx, q)
+	if err != nil {
+		return err
+	}
+	var lastID int64
+	var lastTime time.Time
+	show := func(newestFirst []adminapi.AuditEntry) error {
+		var batch []adminapi.AuditEntry
+		for i := len(newestFirst) - 1; i >= 0; i-- {
+			if e := newestFirst[i]; e.ID > lastID {
+				batch = append(batch, e)
+				lastID, lastTime = e.ID, e.Time
+			}
+		}
+		if c.json {
+			return printLines(batch)
+		}
+		t := newTable()
+		for _, e := range batch {
+			t.row(formatTime(e.Time), e.Actor, e.Action, orDash(e.Target), strconv.Itoa(e.Status))
+		}
+		return t.flush()
+	}
+	if err := show(entries); err != nil || !*follow {
+		return err
+	}
+
+	q.Limit = 0
+	return c.follow(func() error {
+		q.From = lastTime
+		entries, err := c.api.ListAudit(c.ctx, q)
+		if err != nil {
+			return err
+		}
+		return show(entries)
+	})
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"yagnoetik-sdk/adminapi"
+)
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func clientsCreate(c *cli, args []string) error {
+	fs := c.flags()
+	var req adminapi.CreateClientRequest
+	var tags string
+	fs.StringVar(&req.Duration, "duration", "", "subscription length, such as 30d or 12h; defaults to the plan's or 30d")
+	fs.StringVar(&req.Plan, "plan", "", "plan that sets the duration, quota, speed tier and devices")
+	fs.StringVar(&req.Name, "name", "", "")
+	fs.StringVar(&req.Email, "email", "", "")
+	fs.StringVar(&req.Contact, "contact", "", "")
+	fs.StringVar(&tags, "tags", "", "comma-separated tags")
+	fs.StringVar(&req.Notes, "notes", "", "")
+	if _, err := parse(fs, args, 0); err != nil {
+		return err
+	}
+	req.Tags = splitList(tags)
+	if req.Duration == "" && req.Plan == "" {
+		req.Duration = "30d"
+	}
+
+	client, err := c.api.CreateClient(c.ctx, req)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(client)
+	}
+	fmt.Printf("UUID:      %s\nSecret:    %s\nExpires:   %s\nShare URI: %s\n",
+		client.UUID, client.Secret, formatTime(client.ExpiresAt), client.ShareURI)
+	return nil
+}
+
+func clientsList(c *cli, args []string) error {
+	fs := c.flags()
+	var q adminapi.ClientQuery
+	var status string
+	var all bool
+	fs.StringVar(&status, "status", "", "comma-separated states: pending, active, suspended, expired")
+	fs.StringVar(&q.Tag, "tag", "", "")
+	fs.StringVar(&q.Text, "q", "", "free-text search")
+	fs.StringVar(&q.Sort, "sort", "", `created_at, expires_at, name or traffic; "-" prefix for descending`)
+	fs.IntVar(&q.Limit, "limit", 50, "clients per page")
+	fs.BoolVar(&all, "all", false, "fetch every page")
+	if _, err := parse(fs, args, 0); err != nil {
+		return err
+	}
+	q.Status = splitList(status)
+
+	var clients []adminapi.Client
+	if all {
+		for client, err := range c.api.AllClients(c.ctx, q) {
+			if err != nil {
+				return err
+			}
+			clients = append(clients, client)
+		}
+	} else {
+		page, err := c.api.ListClients(c.ctx, q)
+		if err != nil {
+			return err
+		}
+		clients = page.Clients
+		if page.NextCursor != "" && !c.json {
+			defer fmt.Fprintf(os.Stderr, "Showing %d of %d clients; use -all for the rest.\n", len(clients), page.Total)
+		}
+	}
+
+	if c.json {
+		if clients == nil {
+			clients = []adminapi.Client{}
+		}
+		return c.printJSON(clients)
+	}
+	t := newTable("UUID", "NAME", "STATE", "EXPIRES", "TRAFFIC", "TAGS")
+	for _, client := range clients {
+		state := client.State
+		if client.Blocked {
+			state += " (blocked)"
+		}
+		t.row(client.UUID, orDash(client.Name), state, formatTime(client.ExpiresAt),
+			formatBytes(client.BytesUp+client.BytesDown), orDash(strings.Join(client.Tags, ",")))
+	}
+	return t.flush()
+}
+
+func (c *cli) printClient(client *adminapi.ClientResponse) error {
+	if c.json {
+		return c.printJSON(client)
+	}
+	quota := "unlimited"
+	if client.QuotaBytes > 0 {
+		quota = fmt.Sprintf("%s of %s", formatBytes(client.PeriodBytes), formatBytes(client.QuotaBytes))
+		if client.QuotaReset != "" {
+			quota += ", resets " + client.QuotaReset
+		}
+	}
+	fields := [][2]string{
+		{"UUID", client.UUID},
+		{"Name", orDash(client.Name)},
+		{"Email", orDash(client.Email)},
+		{"Contact", orDash(client.Contact)},
+		{"Tags", orDash(strings.Join(client.Tags, ","))},
+		{"Notes", orDash(client.Notes)},
+		{"State", client.State},
+		{"Blocked", fmt.Sprint(client.Blocked)},
+		{"Created", formatTime(client.CreatedAt)},
+		{"Activated", formatTime(client.ActivatedAt)},
+		{"Expires", formatTime(client.ExpiresAt)},
+		{"Traffic", fmt.Sprintf("%s up, %s down", formatBytes(client.BytesUp), formatBytes(client.BytesDown))},
+		{"Quota", quota},
+		{"Plan", orDash(client.Plan)},
+		{"Speed tier", orDash(client.SpeedTier)},
+		{"Share URI", client.ShareURI},
+	}
+	t := newTable()
+	for _, f := range fields {
+		t.row(f[0]+":", f[1])
+	}
+	return t.flush()
+}
+
+func clientsShow(c *cli, args []string) error {
+	rest, err := parse(c.flags(), args, 1)
+	if err != nil {
+		return err
+	}
+	client, err := c.api.GetClient(c.ctx, rest[0])
+	if err != nil {
+		return err
+	}
+	return c.printClient(client)
+}
+
+func clientsExtend(c *cli, args []string) error {
+	rest, err := parse(c.flags(), args, 2)
+	if err != nil {
+		return err
+	}
+	client, err := c.api.ExtendClient(c.ctx, rest[0], rest[1])
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(client)
+	}
+	fmt.Printf("%s now expires %s\n", client.UUID, formatTime(client.ExpiresAt))
+	return nil
+}
+
+func clientsBlock(c *cli, args []string) error {
+	return c.clientAction(args, "blocked", c.api.BlockClient)
+}
+
+func clientsUnblock(c *cli, args []string) error {
+	return c.clientAction(args, "unblocked", c.api.UnblockClient)
+}
+
+func (c *cli) clientAction(args []string, done string, action func(ctx context.Context, uuid string) (*adminapi.ClientResponse, error)) error {
+	rest, err := parse(c.flags(), args, 1)
+	if err != nil {
+		return err
+	}
+	client, err := action(c.ctx, rest[0])
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(client)
+	}
+	fmt.Printf("%s %s\n", client.UUID, done)
+	return nil
+}
+
+func clientsDelete(c *cli, args []string) error {
+	rest, err := parse(c.flags(), args, 1)
+	if err != nil {
+		return err
+	}
+	if err := c.api.DeleteClient(c.ctx, rest[0], 0); err != nil {
+		return err
+	}
+	if !c.json {
+		fmt.Printf("%s deleted\n", rest[0])
+	}
+	return nil
+}
+
+// clientConfig prints a client's config.json or share link, or writes it
+// to a file.
+func clientConfig(c *cli, args []string) error {
+	fs := c.flags()
+	format := fs.String("format", "json", "json for config.json or uri for the share link")
+	out := fs.String("out", "", "file to write instead of stdout")
+	rest, err := parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	config, err := c.api.ClientConfig(c.ctx, rest[0])
+	if err != nil {
+		return err
+	}
+	var data []byte
+	switch *format {
+	case "json":
+		data, err = json.MarshalIndent(config.Config, "", "  ")
+		if err != nil {
+			return err
+		}
+	case "uri":
+		data = []byte(config.ShareURI)
+	default:
+		return errors.New("-format must be json or uri")
+	}
+	data = append(data, '\n')
+
+	if *out != "" {
+		// the config holds the client's secret and key
+		return os.WriteFile(*out, data, 0600)
+	}
+	_, err = os.Stdout.Write(data)
+	return err
+}
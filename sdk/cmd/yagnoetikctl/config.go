@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"yagnoetik-sdk/adminapi"
+)
+
+// Context is one server the tool can manage.
+type Context struct {
+	Server string `json:"server,omitempty"` // e.g. https://vpn.example.com:8443
+	APIKey string `json:"api_key"`
+	// Socket is the server's ADMIN_SOCKET, used instead of Server on the
+	// server's own host.
+	Socket string `json:"socket,omitempty"`
+	// CAFile verifies the server certificate; empty uses the system roots.
+	CAFile string `json:"ca_file,omitempty"`
+	// CertFile and KeyFile are presented when the server requires client
+	// certificates.
+	CertFile string `json:"cert_file,omitempty"`
+	KeyFile  string `json:"key_file,omitempty"`
+}
+
+// Config is the context file, by default yagnoetik/contexts.json in the
+// user's config directory.
+type Config struct {
+	Current  string              `json:"current"`
+	Contexts map[string]*Context `json:"contexts"`
+}
+
+func configPath() (string, error) {
+	if path := os.Getenv("YAGNOETIK_CONFIG"); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "yagnoetik", "contexts.json"), nil
+}
+
+func loadConfig(path string) (*Config, error) {
+	config := &Config{Contexts: make(map[string]*Context)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("parse %s: %v", path, err)
+	}
+	if config.Contexts == nil {
+		config.Contexts = make(map[string]*Context)
+	}
+	return config, nil
+}
+
+// save writes the file readable only by the user, since it holds keys.
+func (c *Config) save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0600)
+}
+
+func (c *Config) names() []string {
+	names := make([]string, 0, len(c.Contexts))
+	for name := range c.Contexts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resolve picks the context to use: YAGNOETIK_SERVER and YAGNOETIK_API_KEY
+// when set, then the named context, then the current one.
+func (c *Config) resolve(name string) (*Context, error) {
+	if server := os.Getenv("YAGNOETIK_SERVER"); server != "" {
+		return &Context{Server: server, APIKey: os.Getenv("YAGNOETIK_API_KEY")}, nil
+	}
+	if name == "" {
+		name = c.Current
+	}
+	if name == "" {
+		return nil, errors.New("no context selected; add one with: yagnoetikctl context add <name> -server <url> -key <key>")
+	}
+	ctx, ok := c.Contexts[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown context %q", name)
+	}
+	if key := os.Getenv("YAGNOETIK_API_KEY"); key != "" {
+		copied := *ctx
+		copied.APIKey = key
+		ctx = &copied
+	}
+	return ctx, nil
+}
+
+// api returns a client for the context's server.
+func (c *Context) api() (*adminapi.API, error) {
+	if c.APIKey == "" {
+		return nil, errors.New("the context has no API key")
+	}
+	transport := &http.Transport{}
+	baseURL := c.Server
+
+	if c.Socket != "" {
+		socket := c.Socket
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		}
+		baseURL = "http://localhost"
+	} else {
+		if baseURL == "" {
+			return nil, errors.New("the context has neither a server nor a socket")
+		}
+		tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+		if c.CAFile != "" {
+			pem, err := os.ReadFile(c.CAFile)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates in %s", c.CAFile)
+			}
+		}
+		if c.CertFile != "" {
+			cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("load client certificate: %v", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	return adminapi.New(baseURL, c.APIKey, adminapi.Options{
+		HTTPClient: &http.Client{Timeout: 30 * time.Second, Transport: transport},
+		UserAgent:  "yagnoetikctl",
+	}), nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+func contextList(c *cli, args []string) error {
+	if _, err := parse(c.flags(), args, 0); err != nil {
+		return err
+	}
+	if c.json {
+		// keys are left out so that the output can be shared
+		type entry struct {
+			Name    string `json:"name"`
+			Current bool   `json:"current"`
+			Server  string `json:"server,omitempty"`
+			Socket  string `json:"socket,omitempty"`
+		}
+		entries := make([]entry, 0, len(c.config.Contexts))
+		for _, name := range c.config.names() {
+			ctx := c.config.Contexts[name]
+			entries = append(entries, entry{name, name == c.config.Current, ctx.Server, ctx.Socket})
+		}
+		return c.printJSON(entries)
+	}
+
+	t := newTable("CURRENT", "NAME", "SERVER")
+	for _, name := range c.config.names() {
+		ctx := c.config.Contexts[name]
+		current, server := "", ctx.Server
+		if name == c.config.Current {
+			current = "*"
+		}
+		if ctx.Socket != "" {
+			server = "unix:" + ctx.Socket
+		}
+		t.row(current, name, server)
+	}
+	return t.flush()
+}
+
+func contextAdd(c *cli, args []string) error {
+	fs := c.flags()
+	ctx := &Context{}
+	fs.StringVar(&ctx.Server, "server", "", "admin API URL, such as https://vpn.example.com:8443")
+	fs.StringVar(&ctx.APIKey, "key", "", "admin API key")
+	fs.StringVar(&ctx.Socket, "socket", "", "admin API unix socket, instead of -server")
+	fs.StringVar(&ctx.CAFile, "ca", "", "CA certificate that signed the server certificate")
+	fs.StringVar(&ctx.CertFile, "cert", "", "client certificate, when the server requires one")
+	fs.StringVar(&ctx.KeyFile, "cert-key", "", "private key of the client certificate")
+	rest, err := parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	if ctx.Server == "" && ctx.Socket == "" {
+		return errors.New("either -server or -socket is required")
+	}
+	if ctx.APIKey == "" {
+		return errors.New("-key is required")
+	}
+	if (ctx.CertFile == "") != (ctx.KeyFile == "") {
+		return errors.New("-cert and -cert-key go together")
+	}
+
+	name := rest[0]
+	c.config.Contexts[name] = ctx
+	if c.config.Current == "" {
+		c.config.Current = name
+	}
+	if err := c.config.save(c.configPath); err != nil {
+		return err
+	}
+	fmt.Printf("Context %s saved to %s\n", name, c.configPath)
+	return nil
+}
+
+func contextUse(c *cli, args []string) error {
+	rest, err := parse(c.flags(), args, 1)
+	if err != nil {
+		return err
+	}
+	if _, ok := c.config.Contexts[rest[0]]; !ok {
+		return fmt.Errorf("unknown context %q", rest[0])
+	}
+	c.config.Current = rest[0]
+	return c.config.save(c.configPath)
+}
+
+func contextRemove(c *cli, args []string) error {
+	rest, err := parse(c.flags(), args, 1)
+	if err != nil {
+		return err
+	}
+	if _, ok := c.config.Contexts[rest[0]]; !ok {
+		return fmt.Errorf("unknown context %q", rest[0])
+	}
+	delete(c.config.Contexts, rest[0])
+	if c.config.Current == rest[0] {
+		c.config.Current = ""
+	}
+	return c.config.save(c.configPath)
+}
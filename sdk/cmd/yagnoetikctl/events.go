@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"yagnoetik-sdk/adminapi"
+)
+
+// eventsTail shows the latest operational events and, with -f, follows the
+// server's event stream, reconnecting from the last event it printed.
+func eventsTail(c *cli, args []string) error {
+	fs := c.flags()
+	n := fs.Int("n", 20, "number of events to show")
+	follow := fs.Bool("f", false, "keep showing new events")
+	var q adminapi.EventQuery
+	fs.StringVar(&q.Client, "client", "", "only the events of this client")
+	types := fs.String("types", "", "comma-separated event types or groups, such as session,quota.warning")
+	if _, err := parse(fs, args, 0); err != nil {
+		return err
+	}
+	if *types != "" {
+		q.Types = strings.Split(*types, ",")
+	}
+
+	q.Limit = *n
+	events, err := c.api.RecentEvents(c.ctx, q)
+	if err != nil {
+		return err
+	}
+	if err := c.printEvents(events); err != nil || !*follow {
+		return err
+	}
+	if len(events) > 0 {
+		q.After = events[len(events)-1].ID
+	}
+
+	for {
+		stream, err := c.api.StreamEvents(c.ctx, q)
+		if err == nil {
+			err = c.readEvents(stream)
+			q.After = stream.LastID
+			stream.Close()
+		}
+		if c.ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Event stream interrupted: %v, reconnecting\n", err)
+		}
+		select {
+		case <-c.ctx.Done():
+			return nil
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// readEvents prints events until the stream ends.
+func (c *cli) readEvents(stream *adminapi.EventStream) error {
+	for {
+		e, err := stream.Next()
+		if errors.Is(err, adminapi.ErrEventGap) {
+			fmt.Fprintln(os.Stderr, "Some events were missed while disconnected")
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := c.printEvents([]adminapi.Event{e}); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *cli) printEvents(events []adminapi.Event) error {
+	if c.json {
+		return printLines(events)
+	}
+	t := newTable()
+	for _, e := range events {
+		t.row(formatTime(e.Time), e.Type, orDash(e.Client), orDash(eventDetails(e)))
+	}
+	return t.flush()
+}
+
+// eventDetails picks the most telling fields of an event's data.
+func eventDetails(e adminapi.Event) string {
+	var data struct {
+		From       string    `json:"from"`
+		To         string    `json:"to"`
+		Reason     string    `json:"reason"`
+		Percent    int       `json:"percent"`
+		RemoteAddr string    `json:"remote_addr"`
+		Count      int       `json:"count"`
+		Window     string    `json:"window"`
+		Name       string    `json:"name"`
+		ExpiresAt  time.Time `json:"expires_at"`
+		NotAfter   time.Time `json:"not_after"`
+		Plan       string    `json:"plan"`
+		Extended   bool      `json:"extended"`
+	}
+	if len(e.Data) > 0 {
+		json.Unmarshal(e.Data, &data)
+	}
+
+	switch {
+	case strings.HasPrefix(e.Type, "quota."):
+		return fmt.Sprintf("%d%%", data.Percent)
+	case e.Type == "session.started":
+		return data.RemoteAddr
+	case e.Type == "session.ended":
+		return data.Reason
+	case e.Type == "auth.failure_burst":
+		return fmt.Sprintf("%d failures in %s", data.Count, data.Window)
+	case e.Type == "client.updated":
+		return data.Name
+	case e.Type == "client.expiring":
+		return "expires " + formatTime(data.ExpiresAt)
+	case e.Type == "voucher.redeemed":
+		if data.Extended {
+			return data.Plan + ", extended"
+		}
+		return data.Plan
+	case e.Type == "server.cert_expiring":
+		return "certificate expires " + formatTime(data.NotAfter)
+	case data.To != "":
+		return fmt.Sprintf("%s -> %s (%s)", orDash(data.From), data.To, data.Reason)
+	}
+	return ""
+}
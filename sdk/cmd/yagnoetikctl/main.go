@@ -0,0 +1,214 @@
+// Command yagnoetikctl manages Yagnoetik servers through their admin API.
+//
+//	yagnoetikctl context add prod -server https://vpn.example.com:8443 -key <key>
+//	yagnoetikctl clients create -duration 30d -name "Ivan"
+//	yagnoetikctl -o json clients list -status active
+//
+// Run it without arguments for the list of commands.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"os/user"
+	"strings"
+
+	"yagnoetik-sdk/adminapi"
+)
+
+// cli is the state shared by every command.
+type cli struct {
+	ctx        context.Context
+	json       bool
+	configPath string
+	config     *Config
+	context    string
+	api        *adminapi.API
+	cmd        *command
+}
+
+type command struct {
+	name    string
+	args    string
+	summary string
+	// offline commands do not call a server
+	offline bool
+	run     func(c *cli, args []string) error
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"context list", "", "List server contexts", true, contextList},
+		{"context add", "<name> -server <url> -key <key> [-socket <path>] [-ca <file>] [-cert <file> -cert-key <file>]", "Add or replace a context", true, contextAdd},
+		{"context use", "<name>", "Make a context the current one", true, contextUse},
+		{"context remove", "<name>", "Remove a context", true, contextRemove},
+		{"clients create", "[-duration <30d>] [-plan] [-name] [-email] [-contact] [-tags a,b] [-notes]", "Create a client", false, clientsCreate},
+		{"clients list", "[-status active,pending] [-tag] [-q] [-sort] [-limit] [-all]", "List clients", false, clientsList},
+		{"clients show", "<uuid>", "Show a client", false, clientsShow},
+		{"clients extend", "<uuid> <duration>", "Extend a client's subscription", false, clientsExtend},
+		{"clients block", "<uuid>", "Block a client", false, clientsBlock},
+		{"clients unblock", "<uuid>", "Unblock a client", false, clientsUnblock},
+		{"clients delete", "<uuid>", "Delete a client", false, clientsDelete},
+		{"config", "<uuid> [-format json|uri] [-out <file>]", "Export a client's config.json or share link", false, clientConfig},
+		{"sessions list", "[-client <uuid>]", "List live sessions", false, sessionsList},
+		{"sessions kick", "<id> | -client <uuid> [-reason]", "Disconnect a session or all of a client's sessions", false, sessionsKick},
+		{"usage", "<uuid> [-since 24h] [-step 1h]", "Show a client's traffic", false, usageShow},
+		{"audit tail", "[-n 20] [-f] [-actor] [-action] [-target]", "Show the latest audit entries", false, auditTail},
+		{"events tail", "[-n 20] [-f] [-client <uuid>] [-types session,quota]", "Show operational events", false, eventsTail},
+		{"webhooks list", "", "List webhooks", false, webhooksList},
+		{"webhooks create", "<url> [-events session,quota] [-description] [-secret]", "Subscribe a URL to events", false, webhooksCreate},
+		{"webhooks delete", "<id>", "Delete a webhook", false, webhooksDelete},
+		{"webhooks test", "<id>", "Send a webhook.ping delivery", false, webhooksTest},
+		{"webhooks deliveries", "[-webhook <id>] [-status dead] [-event] [-n 20]", "Show the webhook delivery log", false, webhooksDeliveries},
+		{"webhooks redeliver", "<delivery id>", "Retry a delivery from the dead-letter queue", false, webhooksRedeliver},
+		{"plans list", "", "List subscription plans", false, plansList},
+		{"plans set", "<name> -duration <30d> [-quota] [-quota-reset] [-tier] [-devices] [-description]", "Create or replace a plan", false, plansSet},
+		{"plans delete", "<name>", "Delete a plan", false, plansDelete},
+		{"vouchers generate", "<plan> [-n 1] [-expires 90d] [-note]", "Generate a batch of voucher codes", false, vouchersGenerate},
+		{"vouchers list", "[-batch <id>] [-plan] [-status redeemed] [-csv]", "Show the voucher redemption report", false, vouchersList},
+		{"vouchers batches", "", "List voucher batches", false, vouchersBatches},
+		{"vouchers revoke", "<code> | -batch <id>", "Revoke a voucher or a whole batch", false, vouchersRevoke},
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: yagnoetikctl [-context <name>] [-o table|json] <command> [arguments]
+
+Commands:
+`)
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-20s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(os.Stderr, `
+Contexts are kept in %s (YAGNOETIK_CONFIG overrides the path).
+YAGNOETIK_SERVER and YAGNOETIK_API_KEY override the context.
+`, defaultConfigPath())
+}
+
+func defaultConfigPath() string {
+	path, err := configPath()
+	if err != nil {
+		return "the user config directory"
+	}
+	return path
+}
+
+func main() {
+	global := flag.NewFlagSet("yagnoetikctl", flag.ExitOnError)
+	global.Usage = usage
+	contextName := global.String("context", os.Getenv("YAGNOETIK_CONTEXT"), "context to use instead of the current one")
+	format := global.String("o", "table", "output format: table or json")
+	global.Parse(os.Args[1:])
+
+	if *format != "table" && *format != "json" {
+		fatal(fmt.Errorf("unknown output format %q", *format))
+	}
+	cmd, args := findCommand(global.Args())
+	if cmd == nil {
+		usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	c := &cli{ctx: ctx, json: *format == "json", context: *contextName, cmd: cmd}
+	if err := c.setup(cmd.offline); err != nil {
+		fatal(err)
+	}
+	if err := cmd.run(c, args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(2)
+		}
+		fatal(err)
+	}
+}
+
+// findCommand returns the command named by the first words of args and the
+// arguments after its name.
+func findCommand(args []string) (*command, []string) {
+	for i := range commands {
+		words := strings.Fields(commands[i].name)
+		if len(args) >= len(words) && strings.Join(args[:len(words)], " ") == commands[i].name {
+			return &commands[i], args[len(words):]
+		}
+	}
+	return nil, nil
+}
+
+func (c *cli) setup(offline bool) error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	c.configPath = path
+	if c.config, err = loadConfig(path); err != nil {
+		return err
+	}
+	if offline {
+		return nil
+	}
+
+	server, err := c.config.resolve(c.context)
+	if err != nil {
+		return err
+	}
+	if c.api, err = server.api(); err != nil {
+		return err
+	}
+	// name the operator in the server's audit log
+	if u, err := user.Current(); err == nil {
+		c.ctx = adminapi.WithActor(c.ctx, u.Username)
+	}
+	return nil
+}
+
+// flags returns a flag set for the command that prints its usage.
+func (c *cli) flags() *flag.FlagSet {
+	fs := flag.NewFlagSet(c.cmd.name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: yagnoetikctl %s %s\n", c.cmd.name, c.cmd.args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parse parses flags that may come before or after the positional
+// arguments, and checks the number of positional arguments unless it is
+// negative.
+func parse(fs *flag.FlagSet, args []string, positional int) ([]string, error) {
+	var rest []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		rest = append(rest, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if positional >= 0 && len(rest) != positional {
+		fs.Usage()
+		return nil, flag.ErrHelp
+	}
+	return rest, nil
+}
+
+func (c *cli) printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "yagnoetikctl: %v\n", err)
+	os.Exit(1)
+}
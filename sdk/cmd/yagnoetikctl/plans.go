@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"yagnoetik-sdk/adminapi"
+)
+
+func plansList(c *cli, args []string) error {
+	if _, err := parse(c.flags(), args, 0); err != nil {
+		return err
+	}
+
+	plans, err := c.api.ListPlans(c.ctx)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		if plans == nil {
+			plans = []adminapi.Plan{}
+		}
+		return c.printJSON(plans)
+	}
+	t := newTable("NAME", "DURATION", "QUOTA", "TIER", "DEVICES", "DESCRIPTION")
+	for _, p := range plans {
+		quota := "unlimited"
+		if p.QuotaBytes > 0 {
+			quota = formatBytes(p.QuotaBytes)
+			if p.QuotaReset != "" {
+				quota += " " + p.QuotaReset
+			}
+		}
+		devices := "default"
+		switch {
+		case p.MaxSessions < 0:
+			devices = "unlimited"
+		case p.MaxSessions > 0:
+			devices = strconv.Itoa(p.MaxSessions)
+		}
+		t.row(p.Name, p.Duration, quota, orDash(p.SpeedTier), devices, orDash(p.Description))
+	}
+	return t.flush()
+}
+
+func plansSet(c *cli, args []string) error {
+	fs := c.flags()
+	var req adminapi.PlanRequest
+	fs.StringVar(&req.Duration, "duration", "30d", "subscription length, such as 30d")
+	fs.Int64Var(&req.QuotaBytes, "quota", 0, "traffic quota in bytes; 0 is unlimited")
+	fs.StringVar(&req.QuotaReset, "quota-reset", "", "monthly or rolling; empty never resets")
+	fs.StringVar(&req.SpeedTier, "tier", "", "speed tier")
+	fs.IntVar(&req.MaxSessions, "devices", 0, "devices connected at once; 0 uses the server default, -1 allows any number")
+	fs.StringVar(&req.Description, "description", "", "")
+	rest, err := parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	plan, err := c.api.PutPlan(c.ctx, rest[0], req)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(plan)
+	}
+	fmt.Printf("%s saved\n", plan.Name)
+	return nil
+}
+
+func plansDelete(c *cli, args []string) error {
+	rest, err := parse(c.flags(), args, 1)
+	if err != nil {
+		return err
+	}
+	if err := c.api.DeletePlan(c.ctx, rest[0]); err != nil {
+		return err
+	}
+	if !c.json {
+		fmt.Printf("%s deleted\n", rest[0])
+	}
+	return nil
+}
+
+// vouchersGenerate prints one code per line, so the output can go straight
+// to a file for printing.
+func vouchersGenerate(c *cli, args []string) error {
+	fs := c.flags()
+	var req adminapi.GenerateVouchersRequest
+	fs.IntVar(&req.Count, "n", 1, "number of codes")
+	fs.StringVar(&req.ExpiresIn, "expires", "", "how long the codes can be redeemed, such as 90d; empty never expires")
+	fs.StringVar(&req.Note, "note", "", "")
+	rest, err := parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	req.Plan = rest[0]
+
+	batch, err := c.api.GenerateVouchers(c.ctx, req)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(batch)
+	}
+	for _, v := range batch.Vouchers {
+		fmt.Println(v.Code)
+	}
+	fmt.Fprintf(os.Stderr, "Batch %s: %d codes for %s\n", batch.Batch.ID, batch.Batch.Total, batch.Batch.Plan)
+	return nil
+}
+
+func vouchersList(c *cli, args []string) error {
+	fs := c.flags()
+	var q adminapi.VoucherQuery
+	fs.StringVar(&q.Batch, "batch", "", "")
+	fs.StringVar(&q.Plan, "plan", "", "")
+	fs.StringVar(&q.Status, "status", "", "unredeemed, redeeming, redeemed, expired or revoked")
+	csv := fs.Bool("csv", false, "print the report as CSV")
+	if _, err := parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	if *csv {
+		d, err := c.api.ExportVouchers(c.ctx, q, "csv")
+		if err != nil {
+			return err
+		}
+		defer d.Body.Close()
+		_, err = io.Copy(os.Stdout, d.Body)
+		return err
+	}
+
+	vouchers, err := c.api.ListVouchers(c.ctx, q)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		if vouchers == nil {
+			vouchers = []adminapi.Voucher{}
+		}
+		return c.printJSON(vouchers)
+	}
+	t := newTable("CODE", "PLAN", "BATCH", "STATUS", "EXPIRES", "REDEEMED", "CLIENT")
+	for _, v := range vouchers {
+		t.row(v.Code, v.Plan, v.Batch, v.Status, formatTime(v.ExpiresAt), formatTime(v.RedeemedAt), orDash(v.Client))
+	}
+	return t.flush()
+}
+
+func vouchersBatches(c *cli, args []string) error {
+	if _, err := parse(c.flags(), args, 0); err != nil {
+		return err
+	}
+
+	batches, err := c.api.ListVoucherBatches(c.ctx)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		if batches == nil {
+			batches = []adminapi.VoucherBatch{}
+		}
+		return c.printJSON(batches)
+	}
+	t := newTable("ID", "PLAN", "CREATED", "EXPIRES", "TOTAL", "REDEEMED", "UNREDEEMED", "EXPIRED", "REVOKED", "NOTE")
+	for _, b := range batches {
+		t.row(b.ID, b.Plan, formatTime(b.CreatedAt), formatTime(b.ExpiresAt), strconv.Itoa(b.Total),
+			strconv.Itoa(b.Redeemed), strconv.Itoa(b.Unredeemed), strconv.Itoa(b.Expired), strconv.Itoa(b.Revoked), orDash(b.Note))
+	}
+	return t.flush()
+}
+
+// vouchersRevoke revokes one code, or with -batch every unredeemed code of
+// a batch.
+func vouchersRevoke(c *cli, args []string) error {
+	fs := c.flags()
+	batch := fs.Bool("batch", false, "the argument is a batch ID")
+	rest, err := parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	if *batch {
+		b, err := c.api.RevokeVoucherBatch(c.ctx, rest[0])
+		if err != nil {
+			return err
+		}
+		if c.json {
+			return c.printJSON(b)
+		}
+		fmt.Printf("%s: %d revoked, %d redeemed\n", b.ID, b.Revoked, b.Redeemed)
+		return nil
+	}
+	v, err := c.api.RevokeVoucher(c.ctx, rest[0])
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(v)
+	}
+	fmt.Printf("%s revoked\n", v.Code)
+	return nil
+}
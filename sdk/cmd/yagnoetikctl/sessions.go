@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"yagnoetik-sdk/adminapi"
+)
+
+func sessionsList(c *cli, args []string) error {
+	fs := c.flags()
+	client := fs.String("client", "", "only the sessions of this client")
+	if _, err := parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	sessions, err := c.api.ListSessions(c.ctx, *client)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		if sessions == nil {
+			sessions = []adminapi.SessionInfo{}
+		}
+		return c.printJSON(sessions)
+	}
+	t := newTable("ID", "CLIENT", "NAME", "REMOTE", "IP", "STARTED", "RTT", "TRAFFIC")
+	for _, s := range sessions {
+		t.row(s.ID, s.ClientUUID, orDash(s.ClientName), orDash(s.RemoteAddr), orDash(s.AssignedIP),
+			formatTime(s.StartedAt), time.Duration(s.RTT).Round(time.Millisecond).String(),
+			formatBytes(s.BytesUp+s.BytesDown))
+	}
+	return t.flush()
+}
+
+// sessionsKick closes one session by ID, or every session of a client.
+func sessionsKick(c *cli, args []string) error {
+	fs := c.flags()
+	client := fs.String("client", "", "disconnect every session of this client")
+	reason := fs.String("reason", "", "reason recorded in the session history")
+	rest, err := parse(fs, args, -1)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case *client != "" && len(rest) == 0:
+		closed, err := c.api.CloseClientSessions(c.ctx, *client, *reason)
+		if err != nil {
+			return err
+		}
+		if c.json {
+			return c.printJSON(adminapi.CloseSessionsResponse{Closed: closed})
+		}
+		fmt.Printf("Closed %d sessions\n", closed)
+	case *client == "" && len(rest) == 1:
+		if err := c.api.CloseSession(c.ctx, rest[0], *reason); err != nil {
+			return err
+		}
+		if !c.json {
+			fmt.Printf("Session %s closed\n", rest[0])
+		}
+	default:
+		fs.Usage()
+		return errors.New("give either a session ID or -client")
+	}
+	return nil
+}
+
+func usageShow(c *cli, args []string) error {
+	fs := c.flags()
+	since := fs.Duration("since", 24*time.Hour, "how far back to show")
+	step := fs.String("step", "", "bucket size, such as 5m, 1h or 1d; empty uses the server's choice")
+	rest, err := parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	to := time.Now()
+	usage, err := c.api.ClientUsage(c.ctx, rest[0], to.Add(-*since), to, *step)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(usage)
+	}
+	var up, down int64
+	t := newTable("TIME", "UP", "DOWN")
+	for _, p := range usage.Points {
+		t.row(formatTime(p.Time), formatBytes(p.BytesUp), formatBytes(p.BytesDown))
+		up += p.BytesUp
+		down += p.BytesDown
+	}
+	t.row("TOTAL", formatBytes(up), formatBytes(down))
+	return t.flush()
+}
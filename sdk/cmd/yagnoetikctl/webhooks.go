@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"yagnoetik-sdk/adminapi"
+)
+
+func webhooksList(c *cli, args []string) error {
+	if _, err := parse(c.flags(), args, 0); err != nil {
+		return err
+	}
+
+	webhooks, err := c.api.ListWebhooks(c.ctx)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		if webhooks == nil {
+			webhooks = []adminapi.Webhook{}
+		}
+		return c.printJSON(webhooks)
+	}
+	t := newTable("ID", "URL", "EVENTS", "ENABLED", "DESCRIPTION")
+	for _, w := range webhooks {
+		events := strings.Join(w.Events, ",")
+		if events == "" {
+			events = "all"
+		}
+		t.row(w.ID, w.URL, events, strconv.FormatBool(w.Enabled), orDash(w.Description))
+	}
+	return t.flush()
+}
+
+func webhooksCreate(c *cli, args []string) error {
+	fs := c.flags()
+	var req adminapi.CreateWebhookRequest
+	var events string
+	fs.StringVar(&events, "events", "", "comma-separated event types or groups; empty sends every event")
+	fs.StringVar(&req.Description, "description", "", "")
+	fs.StringVar(&req.Secret, "secret", "", "signing secret; empty generates one")
+	rest, err := parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	req.URL = rest[0]
+	req.Events = splitList(events)
+
+	webhook, err := c.api.CreateWebhook(c.ctx, req)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(webhook)
+	}
+	fmt.Printf("ID:     %s\nSecret: %s\n", webhook.ID, webhook.Secret)
+	return nil
+}
+
+func webhooksDelete(c *cli, args []string) error {
+	rest, err := parse(c.flags(), args, 1)
+	if err != nil {
+		return err
+	}
+	if err := c.api.DeleteWebhook(c.ctx, rest[0]); err != nil {
+		return err
+	}
+	if !c.json {
+		fmt.Printf("%s deleted\n", rest[0])
+	}
+	return nil
+}
+
+func webhooksTest(c *cli, args []string) error {
+	rest, err := parse(c.flags(), args, 1)
+	if err != nil {
+		return err
+	}
+	delivery, err := c.api.TestWebhook(c.ctx, rest[0])
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(delivery)
+	}
+	fmt.Printf("Ping queued as delivery %s\n", delivery.ID)
+	return nil
+}
+
+// webhooksDeliveries shows the delivery log; -status dead lists the
+// dead-letter queue.
+func webhooksDeliveries(c *cli, args []string) error {
+	fs := c.flags()
+	var q adminapi.DeliveryQuery
+	fs.StringVar(&q.Webhook, "webhook", "", "only the deliveries of this webhook")
+	fs.StringVar(&q.Status, "status", "", "pending, delivered or dead")
+	fs.StringVar(&q.Event, "event", "", "event type or group")
+	fs.IntVar(&q.Limit, "n", 20, "number of deliveries to show")
+	if _, err := parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	deliveries, err := c.api.ListDeliveries(c.ctx, q)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		if deliveries == nil {
+			deliveries = []adminapi.Delivery{}
+		}
+		return c.printJSON(deliveries)
+	}
+	t := newTable("ID", "WEBHOOK", "EVENT", "STATUS", "ATTEMPTS", "CREATED", "LAST RESULT")
+	for _, d := range deliveries {
+		t.row(d.ID, d.SubscriptionID, d.EventType, d.Status, strconv.Itoa(len(d.Attempts)),
+			formatTime(d.CreatedAt), orDash(lastAttempt(d)))
+	}
+	return t.flush()
+}
+
+// lastAttempt describes how the latest attempt of a delivery went.
+func lastAttempt(d adminapi.Delivery) string {
+	if len(d.Attempts) == 0 {
+		return ""
+	}
+	a := d.Attempts[len(d.Attempts)-1]
+	took := time.Duration(a.Duration).Round(time.Millisecond)
+	if a.Error != "" {
+		return fmt.Sprintf("%s (%s)", a.Error, took)
+	}
+	return fmt.Sprintf("%d (%s)", a.StatusCode, took)
+}
+
+func webhooksRedeliver(c *cli, args []string) error {
+	rest, err := parse(c.flags(), args, 1)
+	if err != nil {
+		return err
+	}
+	delivery, err := c.api.Redeliver(c.ctx, rest[0])
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(delivery)
+	}
+	fmt.Printf("%s queued again\n", delivery.ID)
+	return nil
+}
@@ -0,0 +1,3 @@
+module yagnoetik-sdk
+
+go 1.23
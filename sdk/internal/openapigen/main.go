@@ -0,0 +1,255 @@
+// Command openapigen writes Go types for the schemas of an OpenAPI 3
+// document. It supports the subset the admin API uses: objects, arrays,
+// maps, allOf composition, $ref, nullable and the date-time and byte
+// string formats.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/format"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// object is a JSON object that remembers the order of its keys, so that
+// generated fields follow the document.
+type object struct {
+	keys   []string
+	values map[string]any
+}
+
+func (o *object) get(key string) any {
+	if o == nil {
+		return nil
+	}
+	return o.values[key]
+}
+
+func (o *object) obj(key string) *object {
+	v, _ := o.get(key).(*object)
+	return v
+}
+
+func (o *object) str(key string) string {
+	v, _ := o.get(key).(string)
+	return v
+}
+
+func (o *object) boolean(key string) bool {
+	v, _ := o.get(key).(bool)
+	return v
+}
+
+func decode(dec *json.Decoder) (any, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		o := &object{values: make(map[string]any)}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decode(dec)
+			if err != nil {
+				return nil, err
+			}
+			o.keys = append(o.keys, key.(string))
+			o.values[key.(string)] = value
+		}
+		_, err := dec.Token()
+		return o, err
+	case json.Delim('['):
+		var list []any
+		for dec.More() {
+			value, err := decode(dec)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		_, err := dec.Token()
+		return list, err
+	}
+	return tok, nil
+}
+
+// initialisms are written in upper case in Go names.
+var initialisms = map[string]bool{
+	"id": true, "uuid": true, "uri": true, "url": true, "ip": true, "api": true, "rtt": true,
+}
+
+func goName(s string) string {
+	var b strings.Builder
+	for _, part := range strings.FieldsFunc(s, func(r rune) bool { return r == '_' || r == '-' }) {
+		if initialisms[part] {
+			b.WriteString(strings.ToUpper(part))
+			continue
+		}
+		runes := []rune(part)
+		runes[0] = unicode.ToUpper(runes[0])
+		b.WriteString(string(runes))
+	}
+	return b.String()
+}
+
+type generator struct {
+	buf     bytes.Buffer
+	imports map[string]bool
+}
+
+func (g *generator) typeOf(schema *object) string {
+	t := g.baseType(schema)
+	if schema.boolean("nullable") {
+		return "*" + t
+	}
+	return t
+}
+
+func (g *generator) baseType(schema *object) string {
+	if ref := schema.str("$ref"); ref != "" {
+		return ref[strings.LastIndex(ref, "/")+1:]
+	}
+	switch schema.str("type") {
+	case "string":
+		switch schema.str("format") {
+		case "date-time":
+			g.imports["time"] = true
+			return "time.Time"
+		case "byte":
+			return "[]byte"
+		}
+		return "string"
+	case "integer":
+		switch schema.str("format") {
+		case "int64":
+			return "int64"
+		}
+		return "int"
+	case "number":
+		return "float64"
+	case "boolean":
+		return "bool"
+	case "array":
+		return "[]" + g.typeOf(schema.obj("items"))
+	case "object":
+		if items := schema.obj("additionalProperties"); items != nil {
+			return "map[string]" + g.typeOf(items)
+		}
+		return "map[string]any"
+	}
+	g.imports["encoding/json"] = true
+	return "json.RawMessage"
+}
+
+func (g *generator) comment(indent, name, description string) {
+	if description == "" {
+		return
+	}
+	if name != "" {
+		runes := []rune(description)
+		runes[0] = unicode.ToLower(runes[0])
+		description = name + " is " + string(runes)
+	}
+	fmt.Fprintf(&g.buf, "%s// %s\n", indent, description)
+}
+
+func (g *generator) fields(schema *object) {
+	required := make(map[string]bool)
+	if list, ok := schema.get("required").([]any); ok {
+		for _, name := range list {
+			required[name.(string)] = true
+		}
+	}
+	props := schema.obj("properties")
+	if props == nil {
+		return
+	}
+	for _, name := range props.keys {
+		prop := props.obj(name)
+		field := prop.str("x-go-name")
+		if field == "" {
+			field = goName(name)
+		}
+		tag := name
+		if !required[name] {
+			tag += ",omitempty"
+		}
+		g.comment("\t", "", prop.str("description"))
+		fmt.Fprintf(&g.buf, "\t%s %s `json:%q`\n", field, g.typeOf(prop), tag)
+	}
+}
+
+func (g *generator) schema(name string, schema *object) {
+	g.comment("", name, schema.str("description"))
+	fmt.Fprintf(&g.buf, "type %s struct {\n", name)
+	if parts, ok := schema.get("allOf").([]any); ok {
+		for _, part := range parts {
+			part := part.(*object)
+			if part.str("$ref") != "" {
+				fmt.Fprintf(&g.buf, "\t%s\n", g.baseType(part))
+				continue
+			}
+			g.fields(part)
+		}
+	} else {
+		g.fields(schema)
+	}
+	fmt.Fprintf(&g.buf, "}\n\n")
+}
+
+func main() {
+	specFile := flag.String("spec", "", "OpenAPI document")
+	outFile := flag.String("out", "", "output file")
+	pkg := flag.String("package", "", "Go package name")
+	flag.Parse()
+
+	data, err := os.ReadFile(*specFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	root, err := decode(json.NewDecoder(bytes.NewReader(data)))
+	if err != nil && err != io.EOF {
+		log.Fatalf("parse %s: %v", *specFile, err)
+	}
+	schemas := root.(*object).obj("components").obj("schemas")
+	if schemas == nil {
+		log.Fatalf("%s has no component schemas", *specFile)
+	}
+
+	g := &generator{imports: make(map[string]bool)}
+	for _, name := range schemas.keys {
+		g.schema(name, schemas.obj(name))
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by openapigen from %s; DO NOT EDIT.\n\n", *specFile)
+	fmt.Fprintf(&out, "package %s\n\n", *pkg)
+	if len(g.imports) > 0 {
+		var imports []string
+		for path := range g.imports {
+			imports = append(imports, fmt.Sprintf("%q", path))
+		}
+		sort.Strings(imports)
+		fmt.Fprintf(&out, "import (\n%s\n)\n\n", strings.Join(imports, "\n"))
+	}
+	out.Write(g.buf.Bytes())
+
+	source, err := format.Source(out.Bytes())
+	if err != nil {
+		log.Fatalf("format generated code: %v", err)
+	}
+	if err := os.WriteFile(*outFile, source, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
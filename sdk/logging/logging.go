@@ -0,0 +1,67 @@
+// Package logging sets up the structured loggers of the server and the
+// clients.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// New creates a logger writing text or JSON lines at or above level, which
+// is one of debug, info, warn or error.
+func New(w io.Writer, level, format string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch strings.ToLower(format) {
+	case "", "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("invalid log format %q", format)
+}
+
+// Limiter rate-limits a repetitive log line, such as a per-packet error, to
+// burst lines per interval. Lines over the limit are counted and the count
+// is reported with the next line that gets through.
+type Limiter struct {
+	burst      int
+	interval   time.Duration
+	count      int
+	suppressed int
+	window     time.Time
+	mutex      sync.Mutex
+}
+
+func NewLimiter(burst int, interval time.Duration) *Limiter {
+	return &Limiter{burst: burst, interval: interval}
+}
+
+// Allow reports whether the line may be logged, and if so how many lines
+// were suppressed since the last one that was.
+func (l *Limiter) Allow() (bool, int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.window) >= l.interval {
+		l.window = now
+		l.count = 0
+	}
+	if l.count >= l.burst {
+		l.suppressed++
+		return false, 0
+	}
+	l.count++
+	suppressed := l.suppressed
+	l.suppressed = 0
+	return true, suppressed
+}
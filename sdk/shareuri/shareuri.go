@@ -0,0 +1,64 @@
+// Package shareuri parses the yagnoetik:// links the admin API exports, so
+// that every client accepts exactly the same links.
+package shareuri
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Scheme is the scheme of links exported by the admin API:
+//
+//	yagnoetik://<uuid>:<secret>@<server_addr>?key=<base64url key>#<name>
+const Scheme = "yagnoetik"
+
+// KeySize is the length of the client's encryption key.
+const KeySize = 32
+
+// Link is a decoded share link.
+type Link struct {
+	ServerAddr string
+	UUID       string
+	Secret     string
+	Key        []byte
+	// Name is the client's name from the fragment; it may be empty.
+	Name string
+}
+
+// IsLink reports whether s looks like a yagnoetik:// link.
+func IsLink(s string) bool {
+	return strings.HasPrefix(strings.TrimSpace(s), Scheme+"://")
+}
+
+// Parse decodes a yagnoetik:// link.
+func Parse(s string) (*Link, error) {
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("invalid share link: %v", err)
+	}
+	if u.Scheme != Scheme {
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.User == nil || u.Host == "" {
+		return nil, fmt.Errorf("share link is missing credentials or server")
+	}
+
+	secret, _ := u.User.Password()
+	key, err := base64.RawURLEncoding.DecodeString(u.Query().Get("key"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid key in share link: %v", err)
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes", KeySize)
+	}
+
+	return &Link{
+		ServerAddr: u.Host,
+		UUID:       u.User.Username(),
+		Secret:     secret,
+		Key:        key,
+		Name:       u.Fragment,
+	}, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+)
+
+// adminTLSConfig builds the admin listener's TLS settings. The listener
+// uses ADMIN_TLS_CERT and ADMIN_TLS_KEY, or the main certificate when they
+// are unset. With ADMIN_CLIENT_CA set, clients must present a certificate
+// signed by that CA.
+func adminTLSConfig(mainCert tls.Certificate) (*tls.Config, error) {
+	cert := mainCert
+	certFile, keyFile := os.Getenv("ADMIN_TLS_CERT"), os.Getenv("ADMIN_TLS_KEY")
+	if certFile != "" || keyFile != "" {
+		var err error
+		if cert, err = tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+			return nil, fmt.Errorf("load admin certificate: %v", err)
+		}
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if caFile := os.Getenv("ADMIN_CLIENT_CA"); caFile != "" {
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+func loadCertPool(file string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in %s", file)
+	}
+	return pool, nil
+}
+
+// serveAdminSocket serves the admin API over a unix socket for local
+// tooling. The socket is only accessible to the server's user; requests
+// still need an API key.
+func serveAdminSocket(path string, handler http.Handler) (*http.Server, error) {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	// Create the socket 0600 rather than chmod it afterwards, which would
+	// leave a window in which anyone could connect. The umask is process
+	// wide, but the files the server writes meanwhile are 0600 anyway.
+	umask := syscall.Umask(0177)
+	listener, err := net.Listen("unix", path)
+	syscall.Umask(umask)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &http.Server{Handler: handler}
+	go func() {
+		log.Printf("Starting admin socket on %s", path)
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Admin socket failed: %v", err)
+		}
+	}()
+	return server, nil
+}
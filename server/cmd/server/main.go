@@ -1,28 +1,211 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
+	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
+	"yagnoetik-vpn/internal/adminkeys"
 	"yagnoetik-vpn/internal/api"
+	"yagnoetik-vpn/internal/audit"
 	"yagnoetik-vpn/internal/auth"
+	"yagnoetik-vpn/internal/events"
+	"yagnoetik-vpn/internal/history"
+	"yagnoetik-vpn/internal/metrics"
+	"yagnoetik-vpn/internal/plans"
+	"yagnoetik-vpn/internal/retention"
+	"yagnoetik-vpn/internal/shaping"
 	"yagnoetik-vpn/internal/tunnel"
+	"yagnoetik-vpn/internal/usage"
+	"yagnoetik-vpn/internal/webhooks"
 	pb "yagnoetik-vpn/proto"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+
+	"yagnoetik-sdk/logging"
 )
 
 func main() {
+	// Route both slog and the standard logger through the configured handler
+	logger, err := logging.New(os.Stderr, envString("LOG_LEVEL", "info"), envString("LOG_FORMAT", "text"))
+	if err != nil {
+		log.Fatalf("Invalid logging configuration: %v", err)
+	}
+	slog.SetDefault(logger)
+
+	// State that outlives a restart is kept under the data directory
+	dataDir := envString("DATA_DIR", "data")
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		log.Fatalf("Failed to create DATA_DIR: %v", err)
+	}
+
 	// Initialize client manager
-	clientManager := auth.NewClientManager()
+	clientManager, err := auth.Open(filepath.Join(dataDir, "clients.json"))
+	if err != nil {
+		log.Fatalf("Failed to load clients: %v", err)
+	}
 	
 	// Create tunnel server
-	tunnelServer := tunnel.NewServer(clientManager)
+	sessionPolicy, err := auth.ParseSessionPolicy(envString("DEFAULT_SESSION_POLICY", string(auth.SessionPolicyReject)))
+	if err != nil {
+		log.Fatalf("Invalid DEFAULT_SESSION_POLICY: %v", err)
+	}
+	tiers, err := shaping.ParseTiers(os.Getenv("SPEED_TIERS"))
+	if err != nil {
+		log.Fatalf("Invalid SPEED_TIERS: %v", err)
+	}
+	shaper := shaping.NewShaper(tiers, os.Getenv("DEFAULT_SPEED_TIER"))
+
+	// Configure traffic quotas
+	quotaPolicy := auth.DefaultQuotaPolicy()
+	if quotaPolicy.Action, err = auth.ParseQuotaAction(envString("QUOTA_ACTION", string(quotaPolicy.Action))); err != nil {
+		log.Fatalf("Invalid QUOTA_ACTION: %v", err)
+	}
+	if value := os.Getenv("QUOTA_WARN_THRESHOLDS"); value != "" {
+		if quotaPolicy.Thresholds, err = parseThresholds(value); err != nil {
+			log.Fatalf("Invalid QUOTA_WARN_THRESHOLDS: %v", err)
+		}
+	}
+	quotaPolicy.RollingPeriod = envDuration("QUOTA_ROLLING_PERIOD", quotaPolicy.RollingPeriod)
+	clientManager.SetQuotaPolicy(quotaPolicy)
+	throttleTier := os.Getenv("QUOTA_THROTTLE_TIER")
+	if quotaPolicy.Action == auth.QuotaActionThrottle && !shaper.HasTier(throttleTier) {
+		log.Fatalf("QUOTA_ACTION=throttle requires QUOTA_THROTTLE_TIER to name one of SPEED_TIERS")
+	}
+
+	// Decide what connection metadata is kept and for how long
+	policy := retention.DefaultPolicy()
+	policy.NoLog = envBool("NO_LOG", false)
+	if policy.RemoteIP, err = retention.ParseIPMode(envString("LOG_REMOTE_IP", string(policy.RemoteIP))); err != nil {
+		log.Fatalf("Invalid LOG_REMOTE_IP: %v", err)
+	}
+	policy.SessionHistory = envDuration("SESSION_HISTORY_RETENTION", policy.SessionHistory)
+	switch value := os.Getenv("USAGE_RESOLUTIONS"); value {
+	case "":
+	case "off":
+		policy.Usage = nil
+	default:
+		if policy.Usage, err = usage.ParseResolutions(value); err != nil {
+			log.Fatalf("Invalid USAGE_RESOLUTIONS: %v", err)
+		}
+	}
+	policy.Audit = envDuration("AUDIT_RETENTION", 0)
+	policy = policy.Effective()
+	if policy.NoLog {
+		log.Println("No-log mode: remote addresses, session history and traffic history are not recorded")
+	}
+	clientManager.SetTransitionRetention(policy.Audit)
+
+	// Record traffic history, dropping it when a client is deleted
+	var usageStore *usage.Store
+	if len(policy.Usage) > 0 {
+		usageStore = usage.NewStore(policy.Usage)
+		clientManager.OnTransition(func(t auth.Transition) {
+			if t.To == auth.StateDeleted {
+				usageStore.Delete(t.UUID)
+			}
+		})
+	}
+	var sessionHistory *history.Store
+	sessionsPath := filepath.Join(dataDir, "sessions.jsonl")
+	if policy.SessionHistory > 0 {
+		if sessionHistory, err = history.Open(sessionsPath, policy.SessionHistory); err != nil {
+			log.Fatalf("Failed to load session history: %v", err)
+		}
+	} else if err := os.Remove(sessionsPath); err == nil {
+		log.Println("Session history is not kept: deleted the saved history")
+	} else if !os.IsNotExist(err) {
+		log.Fatalf("Failed to delete session history: %v", err)
+	}
+	// Drop history past its retention even for clients without new traffic
+	var pruned []retention.Pruner
+	if usageStore != nil {
+		pruned = append(pruned, usageStore)
+	}
+	if sessionHistory != nil {
+		pruned = append(pruned, sessionHistory)
+	}
+	// The audit chain is keyed so that edits cannot be covered up by
+	// recomputing the hashes
+	auditKey, err := audit.LoadKey(envString("AUDIT_KEY_FILE", filepath.Join(dataDir, "audit.key")))
+	if err != nil {
+		log.Fatalf("Failed to load the audit key: %v", err)
+	}
+	auditLog, err := audit.Open(filepath.Join(dataDir, "audit.jsonl"), auditKey, policy.Audit)
+	if err != nil {
+		log.Fatalf("Failed to load the audit log: %v", err)
+	}
+	pruned = append(pruned, auditLog)
+	retentionSweeper := retention.NewSweeper(envDuration("RETENTION_SWEEP_INTERVAL", 10*time.Minute), pruned...)
+
+	// Tunnel addresses, and the proxies whose X-Real-IP is believed
+	tunnelNetwork, err := tunnel.ParseNetwork(envString("TUNNEL_NETWORK", "10.8.0.0/24"))
+	if err != nil {
+		log.Fatalf("Invalid TUNNEL_NETWORK: %v", err)
+	}
+	trustedProxies, err := api.ParseCIDRs(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+
+	// Publish operational events for the admin API's event stream
+	eventBus := events.NewBus(envInt("EVENTS_BUFFER", 1000))
+	eventBus.WatchClients(clientManager)
+
+	tunnelServer := tunnel.NewServer(clientManager, tunnel.Options{
+		DefaultMaxSessions:   envInt("DEFAULT_MAX_SESSIONS", 0),
+		DefaultSessionPolicy: sessionPolicy,
+		Shaper:               shaper,
+		QuotaThrottleTier:    throttleTier,
+		Usage:                usageStore,
+		History:              sessionHistory,
+		Retention:            policy,
+		Events:               eventBus,
+		TunnelNetwork:        tunnelNetwork,
+		TrustedProxies:       trustedProxies,
+		AuthFailureBurst:     envInt("AUTH_FAILURE_BURST", 20),
+		AuthFailureWindow:    envDuration("AUTH_FAILURE_WINDOW", time.Minute),
+		Logger:               logger,
+	})
+
+	if envBool("METRICS_PER_CLIENT", false) {
+		metrics.RegisterPerClient(clientManager)
+	}
+
+	// Start the expiry sweeper
+	sweeper := auth.NewSweeper(clientManager,
+		envDuration("SWEEP_INTERVAL", time.Minute),
+		envDuration("EXPIRED_GRACE", 7*24*time.Hour),
+	)
+	sweepCtx, stopSweeper := context.WithCancel(context.Background())
+	go sweeper.Run(sweepCtx)
+	go retentionSweeper.Run(sweepCtx)
+
+	// Send events to webhook subscriptions, retrying failed deliveries
+	dispatcher, err := webhooks.Open(filepath.Join(dataDir, "webhooks.json"), eventBus, webhooks.Options{
+		MaxAttempts: envInt("WEBHOOK_MAX_ATTEMPTS", 8),
+		Backoff:     envDuration("WEBHOOK_RETRY_BACKOFF", 30*time.Second),
+		MaxBackoff:  envDuration("WEBHOOK_MAX_BACKOFF", time.Hour),
+		Timeout:     envDuration("WEBHOOK_TIMEOUT", 10*time.Second),
+		LogSize:     envInt("WEBHOOK_LOG_SIZE", 10000),
+		Logger:      logger,
+	})
+	if err != nil {
+		log.Fatalf("Failed to load webhooks: %v", err)
+	}
+	eventsCtx, stopEvents := context.WithCancel(context.Background())
+	go dispatcher.Run(eventsCtx)
 	
 	// Setup gRPC server
 	cert, err := tls.LoadX509KeyPair("server.crt", "server.key")
@@ -44,7 +227,65 @@ func main() {
 	if apiKey == "" {
 		log.Fatal("API_KEY environment variable is required")
 	}
-	adminAPI := api.NewAdminAPI(clientManager, apiKey)
+	adminKeys, err := adminkeys.Open(filepath.Join(dataDir, "admin-keys.json"))
+	if err != nil {
+		log.Fatalf("Failed to load admin keys: %v", err)
+	}
+	adminKeys.AddStatic("api-key", adminkeys.RoleAdmin, apiKey)
+	if err := adminKeys.ParseStatic(os.Getenv("ADMIN_KEYS")); err != nil {
+		log.Fatalf("Invalid ADMIN_KEYS: %v", err)
+	}
+	serverAddr := os.Getenv("SERVER_ADDR")
+	if serverAddr == "" {
+		serverAddr = os.Getenv("DOMAIN")
+	}
+	if serverAddr == "" {
+		log.Println("SERVER_ADDR is not set, exported client configs will use localhost")
+		serverAddr = "localhost"
+	}
+	notifications, err := notificationService(eventBus, clientManager, serverAddr, logger)
+	if err != nil {
+		log.Fatalf("Invalid notification settings: %v", err)
+	}
+	if notifications != nil {
+		go notifications.Run(eventsCtx)
+	}
+
+	// Warn ahead of subscription and certificate expiry
+	expiryWatcher := events.NewExpiryWatcher(eventBus, clientManager, events.ExpiryOptions{
+		ClientWarning: envDuration("CLIENT_EXPIRY_WARNING", 72*time.Hour),
+		CertFile:      "server.crt",
+		CertWarning:   envDuration("CERT_EXPIRY_WARNING", 14*24*time.Hour),
+		Interval:      envDuration("EXPIRY_CHECK_INTERVAL", time.Hour),
+		Logger:        logger,
+	})
+	go expiryWatcher.Run(eventsCtx)
+
+	// Subscription plans, and the vouchers redeemed for them on the main
+	// listener
+	planStore, err := plans.Open(filepath.Join(dataDir, "plans.json"))
+	if err != nil {
+		log.Fatalf("Failed to load plans: %v", err)
+	}
+	redeemAPI := api.NewRedeemAPI(clientManager, planStore, api.RedeemOptions{
+		ServerAddr:     serverAddr,
+		Events:         eventBus,
+		FailureLimit:   envInt("REDEEM_FAILURE_LIMIT", 10),
+		FailureWindow:  envDuration("REDEEM_FAILURE_WINDOW", time.Minute),
+		TrustedProxies: trustedProxies,
+		Logger:         logger,
+	})
+	adminAPI := api.NewAdminAPI(clientManager, tunnelServer, adminKeys, api.Options{
+		ServerAddr: serverAddr,
+		Shaper:     shaper,
+		Usage:      usageStore,
+		History:    sessionHistory,
+		Retention:  policy,
+		Audit:      auditLog,
+		Events:     eventBus,
+		Webhooks:   dispatcher,
+		Plans:      planStore,
+	})
 	
 	// Main HTTPS server (port 443) - combines gRPC and HTTP
 	mainMux := http.NewServeMux()
@@ -52,6 +293,7 @@ func main() {
 	// Add cover routes
 	coverRouter := coverAPI.SetupRoutes()
 	mainMux.Handle("/", coverRouter)
+	mainMux.Handle("/api/v1/redeem", redeemAPI)
 	
 	// Create combined server that handles both HTTP and gRPC
 	mainServer := &http.Server{
@@ -69,14 +311,29 @@ func main() {
 		},
 	}
 	
-	// Admin API server (port 8443)
+	// Admin API server (port 8443), over TLS unless ADMIN_TLS=false
 	adminRouter := adminAPI.SetupRoutes()
+	allowed, err := api.ParseCIDRs(os.Getenv("ADMIN_ALLOW_CIDRS"))
+	if err != nil {
+		log.Fatalf("Invalid ADMIN_ALLOW_CIDRS: %v", err)
+	}
+	adminTLS := envBool("ADMIN_TLS", true)
 	adminServer := &http.Server{
-		Addr:    ":8443",
-		Handler: adminRouter,
-		TLSConfig: &tls.Config{
-			Certificates: []tls.Certificate{cert},
-		},
+		Addr:    envString("ADMIN_ADDR", ":8443"),
+		Handler: api.AllowList(allowed, adminRouter),
+	}
+	if adminTLS {
+		if adminServer.TLSConfig, err = adminTLSConfig(cert); err != nil {
+			log.Fatalf("Invalid admin TLS configuration: %v", err)
+		}
+	} else {
+		log.Println("ADMIN_TLS=false: the admin API is served over plain HTTP")
+	}
+	var adminSocket *http.Server
+	if path := os.Getenv("ADMIN_SOCKET"); path != "" {
+		if adminSocket, err = serveAdminSocket(path, adminRouter); err != nil {
+			log.Fatalf("Failed to listen on admin socket: %v", err)
+		}
 	}
 	
 	// Start servers
@@ -88,8 +345,14 @@ func main() {
 	}()
 	
 	go func() {
-		log.Println("Starting admin server on :8443")
-		if err := adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Printf("Starting admin server on %s (TLS: %t)", adminServer.Addr, adminTLS)
+		var err error
+		if adminTLS {
+			err = adminServer.ListenAndServeTLS("", "")
+		} else {
+			err = adminServer.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Admin server failed: %v", err)
 		}
 	}()
@@ -100,7 +363,86 @@ func main() {
 	<-c
 	
 	log.Println("Shutting down servers...")
+	stopSweeper()
+	stopEvents()
 	grpcServer.GracefulStop()
+	// Keep the traffic of the sessions that just ended
+	clientManager.Save()
 	mainServer.Close()
 	adminServer.Close()
+	if adminSocket != nil {
+		adminSocket.Close()
+	}
+}
+
+// envString reads a string from the environment, falling back to def when
+// the variable is unset.
+func envString(name, def string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return def
+}
+
+// envBool reads a boolean such as "true" or "1" from the environment,
+// falling back to def when the variable is unset or malformed.
+func envBool(name string, def bool) bool {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Invalid %s %q, using %t", name, value, def)
+		return def
+	}
+	return b
+}
+
+// envInt reads an integer from the environment, falling back to def when the
+// variable is unset or malformed.
+func envInt(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid %s %q, using %d", name, value, def)
+		return def
+	}
+	return n
+}
+
+// envDuration reads a duration from the environment, falling back to def
+// when the variable is unset or malformed.
+func envDuration(name string, def time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid %s %q, using %s", name, value, def)
+		return def
+	}
+	return d
+}
+
+// parseThresholds parses a comma-separated list of percentages such as
+// "80,90".
+func parseThresholds(value string) ([]int, error) {
+	thresholds := make([]int, 0)
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil || n <= 0 || n >= 100 {
+			return nil, fmt.Errorf("invalid threshold %q", part)
+		}
+		thresholds = append(thresholds, n)
+	}
+	return thresholds, nil
 }
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+
+	"yagnoetik-vpn/internal/auth"
+	"yagnoetik-vpn/internal/events"
+	"yagnoetik-vpn/internal/notify"
+)
+
+// notificationService sets up the notification channels configured with
+// NOTIFY_SMTP_* and NOTIFY_TELEGRAM_*. It returns nil when there are none.
+func notificationService(bus *events.Bus, clientManager *auth.ClientManager, server string, logger *slog.Logger) (*notify.Service, error) {
+	var notifiers []notify.Notifier
+	if addr := os.Getenv("NOTIFY_SMTP_ADDR"); addr != "" {
+		options := notify.SMTPOptions{
+			Addr:        addr,
+			Username:    os.Getenv("NOTIFY_SMTP_USERNAME"),
+			Password:    os.Getenv("NOTIFY_SMTP_PASSWORD"),
+			From:        os.Getenv("NOTIFY_SMTP_FROM"),
+			ImplicitTLS: envBool("NOTIFY_SMTP_TLS", false),
+		}
+		if file := os.Getenv("NOTIFY_SMTP_CA"); file != "" {
+			pool, err := loadCertPool(file)
+			if err != nil {
+				return nil, err
+			}
+			options.RootCAs = pool
+		}
+		smtp, err := notify.NewSMTP(options)
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, smtp)
+	}
+	if token := os.Getenv("NOTIFY_TELEGRAM_TOKEN"); token != "" {
+		notifiers = append(notifiers, notify.NewTelegram(notify.TelegramOptions{
+			Token:  token,
+			APIURL: os.Getenv("NOTIFY_TELEGRAM_API_URL"),
+		}))
+	}
+	if len(notifiers) == 0 {
+		return nil, nil
+	}
+
+	var operators []notify.Recipient
+	for _, email := range envList("NOTIFY_OPERATOR_EMAILS") {
+		operators = append(operators, notify.Recipient{Email: email})
+	}
+	for _, chat := range envList("NOTIFY_OPERATOR_TELEGRAM") {
+		operators = append(operators, notify.Recipient{Telegram: chat})
+	}
+	return notify.NewService(bus, clientManager, notifiers, notify.Options{
+		Language:       envString("NOTIFY_LANGUAGE", "ru"),
+		Operators:      operators,
+		OperatorEvents: envList("NOTIFY_OPERATOR_EVENTS"),
+		UserEvents:     envList("NOTIFY_USER_EVENTS"),
+		QuotaPercent:   envInt("NOTIFY_QUOTA_PERCENT", 90),
+		Server:         server,
+		Logger:         logger,
+	})
+}
+
+// envList reads a comma-separated list from the environment; it is nil when
+// the variable is unset.
+func envList(name string) []string {
+	var items []string
+	for _, item := range strings.Split(os.Getenv(name), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,327 @@
+package adminkeys
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"yagnoetik-vpn/internal/storage"
+)
+
+// Role is what a key may do. Each role includes the ones below it.
+type Role string
+
+const (
+	RoleReadOnly Role = "read-only"
+	// RoleSupport may also block, unblock and extend clients.
+	RoleSupport Role = "support"
+	RoleAdmin   Role = "admin"
+)
+
+func (r Role) rank() int {
+	switch r {
+	case RoleReadOnly:
+		return 1
+	case RoleSupport:
+		return 2
+	case RoleAdmin:
+		return 3
+	}
+	return 0
+}
+
+// Allows reports whether the role includes required.
+func (r Role) Allows(required Role) bool {
+	return r.rank() > 0 && r.rank() >= required.rank()
+}
+
+func ParseRole(s string) (Role, error) {
+	role := Role(strings.ToLower(strings.TrimSpace(s)))
+	if role.rank() == 0 {
+		return "", fmt.Errorf("unknown role %q", s)
+	}
+	return role, nil
+}
+
+// tokenPrefix marks keys issued by the server.
+const tokenPrefix = "yak_"
+
+var (
+	ErrNotFound = errors.New("key not found")
+	// ErrStatic is returned for keys configured in the environment, which
+	// can only be changed there.
+	ErrStatic = errors.New("key is configured in the environment")
+)
+
+// Key is a named admin credential. Only the SHA-256 of its token is kept.
+type Key struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Role       Role      `json:"role"`
+	Static     bool      `json:"static,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"` // zero never expires
+	RevokedAt  time.Time `json:"revoked_at"` // zero while valid
+	RotatedAt  time.Time `json:"rotated_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	hash       []byte
+}
+
+// Active reports whether the key may be used at the given time.
+func (k *Key) Active(now time.Time) bool {
+	return k.RevokedAt.IsZero() && (k.ExpiresAt.IsZero() || now.Before(k.ExpiresAt))
+}
+
+// Store holds admin keys in memory.
+type Store struct {
+	keys map[string]*Key
+	// path, if set, is the file issued keys are saved to. Static keys come
+	// from the environment and are not saved.
+	path  string
+	mutex sync.RWMutex
+}
+
+func NewStore() *Store {
+	return &Store{keys: make(map[string]*Key)}
+}
+
+// savedKey is an issued key as saved to the store's file, with the hash of
+// its token.
+type savedKey struct {
+	Key
+	Hash string `json:"hash"`
+}
+
+// Open creates a store whose issued keys are saved to the file at path and
+// loads the keys already there. Last-use times are saved along with the
+// next change to the keys rather than on every request.
+func Open(path string) (*Store, error) {
+	var saved []savedKey
+	if err := storage.ReadJSON(path, &saved); err != nil {
+		return nil, err
+	}
+
+	s := NewStore()
+	s.path = path
+	for _, k := range saved {
+		hash, err := hex.DecodeString(k.Hash)
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("%s: key %s has an invalid hash", path, k.ID)
+		}
+		key := k.Key
+		key.hash = hash
+		s.keys[key.ID] = &key
+	}
+	return s, nil
+}
+
+// save writes the issued keys to the store's file. The caller must hold
+// s.mutex.
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+	saved := make([]savedKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		if !key.Static {
+			saved = append(saved, savedKey{Key: *key, Hash: hex.EncodeToString(key.hash)})
+		}
+	}
+	sort.Slice(saved, func(i, j int) bool {
+		return saved[i].CreatedAt.Before(saved[j].CreatedAt)
+	})
+	return storage.WriteJSON(s.path, saved)
+}
+
+// AddStatic registers a key whose token comes from configuration, such as
+// API_KEY. Static keys never expire and cannot be rotated or revoked
+// through the API.
+func (s *Store) AddStatic(name string, role Role, token string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	id := "static-" + name
+	s.keys[id] = &Key{
+		ID:        id,
+		Name:      name,
+		Role:      role,
+		Static:    true,
+		CreatedAt: time.Now(),
+		hash:      hashToken(token),
+	}
+}
+
+// Create issues a key and returns it with its token, which is not stored
+// and cannot be retrieved again. A zero expiresAt never expires.
+func (s *Store) Create(name string, role Role, expiresAt time.Time) (Key, string, error) {
+	id, err := randomHex(8)
+	if err != nil {
+		return Key{}, "", err
+	}
+	token, err := newToken(id)
+	if err != nil {
+		return Key{}, "", err
+	}
+
+	key := &Key{
+		ID:        id,
+		Name:      name,
+		Role:      role,
+		CreatedAt: time.Now(),
+		ExpiresAt: expiresAt,
+		hash:      hashToken(token),
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.keys[id] = key
+	if err := s.save(); err != nil {
+		delete(s.keys, id)
+		return Key{}, "", err
+	}
+	return *key, token, nil
+}
+
+// Rotate replaces the key's token; the old one stops working at once.
+func (s *Store) Rotate(id string) (Key, string, error) {
+	token, err := newToken(id)
+	if err != nil {
+		return Key{}, "", err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key, exists := s.keys[id]
+	switch {
+	case !exists:
+		return Key{}, "", ErrNotFound
+	case key.Static:
+		return Key{}, "", ErrStatic
+	case !key.RevokedAt.IsZero():
+		return Key{}, "", fmt.Errorf("key is revoked")
+	}
+	previous := *key
+	key.hash = hashToken(token)
+	key.RotatedAt = time.Now()
+	if err := s.save(); err != nil {
+		*key = previous
+		return Key{}, "", err
+	}
+	return *key, token, nil
+}
+
+// Revoke disables the key. Revoked keys stay listed so that audit entries
+// made with them can still be attributed.
+func (s *Store) Revoke(id string) (Key, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key, exists := s.keys[id]
+	switch {
+	case !exists:
+		return Key{}, ErrNotFound
+	case key.Static:
+		return Key{}, ErrStatic
+	}
+	if key.RevokedAt.IsZero() {
+		key.RevokedAt = time.Now()
+		if err := s.save(); err != nil {
+			key.RevokedAt = time.Time{}
+			return Key{}, err
+		}
+	}
+	return *key, nil
+}
+
+// Authenticate returns the active key matching the token. Every key's hash
+// is compared in constant time so that timing does not reveal which, if
+// any, matched.
+func (s *Store) Authenticate(token string) (Key, bool) {
+	if token == "" {
+		return Key{}, false
+	}
+	hash := hashToken(token)
+	now := time.Now()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var match *Key
+	for _, key := range s.keys {
+		if subtle.ConstantTimeCompare(hash, key.hash) == 1 {
+			match = key
+		}
+	}
+	if match == nil || !match.Active(now) {
+		return Key{}, false
+	}
+	match.LastUsedAt = now
+	return *match, true
+}
+
+// List returns all keys, including revoked and expired ones, oldest first.
+func (s *Store) List() []Key {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	keys := make([]Key, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, *key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	return keys
+}
+
+// ParseStatic parses "name:role:token" entries separated by commas, as in
+// ADMIN_KEYS, and adds them to the store. Errors name the entry by its
+// position, since an entry may be nothing but a token.
+func (s *Store) ParseStatic(spec string) error {
+	for i, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+			return fmt.Errorf("entry %d: want name:role:token", i+1)
+		}
+		role, err := ParseRole(parts[1])
+		if err != nil {
+			return fmt.Errorf("entry %d: %w", i+1, err)
+		}
+		s.AddStatic(parts[0], role, parts[2])
+	}
+	return nil
+}
+
+func newToken(id string) (string, error) {
+	secret, err := randomHex(32)
+	if err != nil {
+		return "", err
+	}
+	return tokenPrefix + id + "_" + secret, nil
+}
+
+func hashToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+func randomHex(n int) (string, error) {
+	bytes := make([]byte, n)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
@@ -2,58 +2,218 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
+	"yagnoetik-vpn/internal/adminkeys"
+	"yagnoetik-vpn/internal/audit"
 	"yagnoetik-vpn/internal/auth"
+	"yagnoetik-vpn/internal/durations"
+	"yagnoetik-vpn/internal/events"
+	"yagnoetik-vpn/internal/history"
+	"yagnoetik-vpn/internal/idempotency"
+	"yagnoetik-vpn/internal/metrics"
+	"yagnoetik-vpn/internal/plans"
+	"yagnoetik-vpn/internal/retention"
+	"yagnoetik-vpn/internal/shaping"
+	"yagnoetik-vpn/internal/tunnel"
+	"yagnoetik-vpn/internal/usage"
+	"yagnoetik-vpn/internal/webhooks"
 
 	"github.com/gorilla/mux"
 )
 
+const maxPageSize = 1000
+
 type AdminAPI struct {
 	clientManager *auth.ClientManager
-	apiKey        string
+	tunnelServer  *tunnel.Server
+	shaper        *shaping.Shaper
+	usage         *usage.Store
+	history       *history.Store
+	retention     retention.Policy
+	audit         *audit.Log
+	events        *events.Bus
+	webhooks      *webhooks.Dispatcher
+	plans         *plans.Store
+	keys          *adminkeys.Store
+	idempotency   *idempotency.Store
+	serverAddr    string
+}
+
+// Options holds the services and settings the admin API exposes besides the
+// client manager and tunnel server.
+type Options struct {
+	// ServerAddr is the public tunnel address written into exported client
+	// configs.
+	ServerAddr string
+	Shaper     *shaping.Shaper
+	// Usage and History are nil when the retention policy disables them.
+	Usage     *usage.Store
+	History   *history.Store
+	Retention retention.Policy
+	// Audit records admin mutations; nil disables it.
+	Audit *audit.Log
+	// Events is the operational event stream; nil disables /api/events.
+	Events *events.Bus
+	// Webhooks sends events to subscribed URLs; nil disables
+	// /api/webhooks.
+	Webhooks *webhooks.Dispatcher
+	// Plans holds the subscription plans and their vouchers.
+	Plans *plans.Store
 }
 
+// CreateClientRequest creates a client. With a plan the client gets the
+// plan's limits, and Duration may be left out to use the plan's.
 type CreateClientRequest struct {
-	Duration string `json:"duration"` // e.g., "30d", "1h"
+	Duration string   `json:"duration"` // e.g., "30d", "1h"
+	Plan     string   `json:"plan,omitempty"`
+	Name     string   `json:"name,omitempty"`
+	Email    string   `json:"email,omitempty"`
+	Contact  string   `json:"contact,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	Notes    string   `json:"notes,omitempty"`
 }
 
 type CreateClientResponse struct {
 	UUID      string    `json:"uuid"`
 	Secret    string    `json:"secret"`
 	ExpiresAt time.Time `json:"expires_at"`
+	ShareURI  string    `json:"share_uri"`
 }
 
-func NewAdminAPI(clientManager *auth.ClientManager, apiKey string) *AdminAPI {
+// UpdateClientRequest is a partial update; omitted fields are unchanged.
+// Extend is added to the current expiry, or to now if already expired.
+type UpdateClientRequest struct {
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	Extend     string     `json:"extend,omitempty"` // e.g., "30d"
+	Name       *string    `json:"name,omitempty"`
+	Email      *string    `json:"email,omitempty"`
+	Contact    *string    `json:"contact,omitempty"`
+	Tags       *[]string  `json:"tags,omitempty"`
+	Notes      *string    `json:"notes,omitempty"`
+	QuotaBytes *int64     `json:"quota_bytes,omitempty"`
+	QuotaReset *string    `json:"quota_reset,omitempty"` // "", "monthly" or "rolling"
+	SpeedTier  *string    `json:"speed_tier,omitempty"`
+
+	// MaxSessions of 0 uses the server default and -1 allows unlimited
+	// sessions. SessionPolicy is "reject" or "replace".
+	MaxSessions   *int    `json:"max_sessions,omitempty"`
+	SessionPolicy *string `json:"session_policy,omitempty"`
+}
+
+// NewAdminAPI creates the API. keys holds the admin credentials and their
+// roles.
+func NewAdminAPI(clientManager *auth.ClientManager, tunnelServer *tunnel.Server, keys *adminkeys.Store, options Options) *AdminAPI {
 	return &AdminAPI{
 		clientManager: clientManager,
-		apiKey:        apiKey,
+		tunnelServer:  tunnelServer,
+		shaper:        options.Shaper,
+		usage:         options.Usage,
+		history:       options.History,
+		retention:     options.Retention,
+		audit:         options.Audit,
+		events:        options.Events,
+		webhooks:      options.Webhooks,
+		plans:         options.Plans,
+		keys:          keys,
+		idempotency:   idempotency.NewStore(idempotencyTTL),
+		serverAddr:    options.ServerAddr,
 	}
 }
 
 func (a *AdminAPI) SetupRoutes() *mux.Router {
 	r := mux.NewRouter()
+	r.NotFoundHandler = http.HandlerFunc(notFound)
+	r.MethodNotAllowedHandler = http.HandlerFunc(methodNotAllowed)
+	r.Use(requestIDMiddleware)
 	r.Use(a.authMiddleware)
+	r.Use(a.auditMiddleware)
 	
 	r.HandleFunc("/api/clients", a.createClient).Methods("POST")
 	r.HandleFunc("/api/clients", a.listClients).Methods("GET")
+	r.HandleFunc("/api/clients/bulk", a.bulkCreateClients).Methods("POST")
+	r.HandleFunc("/api/clients/export", a.exportClients).Methods("GET")
+	r.HandleFunc("/api/clients/import", a.importClients).Methods("POST")
+	r.HandleFunc("/api/clients/{uuid}", a.updateClient).Methods("PATCH")
 	r.HandleFunc("/api/clients/{uuid}", a.deleteClient).Methods("DELETE")
+	r.HandleFunc("/api/clients/{uuid}/rotate", a.rotateClient).Methods("POST")
+	r.HandleFunc("/api/clients/{uuid}/config", a.clientConfig).Methods("GET")
 	r.HandleFunc("/api/clients/{uuid}/block", a.blockClient).Methods("POST")
 	r.HandleFunc("/api/clients/{uuid}/unblock", a.unblockClient).Methods("POST")
+	r.HandleFunc("/api/clients/{uuid}/extend", a.extendClient).Methods("POST")
+	r.HandleFunc("/api/clients/{uuid}/transitions", a.clientTransitions).Methods("GET")
+	r.HandleFunc("/api/clients/{uuid}/sessions", a.closeClientSessions).Methods("DELETE")
+	r.HandleFunc("/api/clients/{uuid}/sessions/history", a.clientSessionHistory).Methods("GET")
+	r.HandleFunc("/api/clients/{uuid}/quota/reset", a.resetQuota).Methods("POST")
+	r.HandleFunc("/api/clients/{uuid}/usage", a.clientUsage).Methods("GET")
+	r.HandleFunc("/api/clients/{uuid}/data", a.exportClientData).Methods("GET")
+	r.HandleFunc("/api/clients/{uuid}/data", a.eraseClientData).Methods("DELETE")
+	r.HandleFunc("/api/transitions", a.listTransitions).Methods("GET")
+	r.HandleFunc("/api/events", a.streamEvents).Methods("GET")
+	r.HandleFunc("/api/events/recent", a.recentEvents).Methods("GET")
+	r.HandleFunc("/api/webhooks", a.listWebhooks).Methods("GET")
+	r.HandleFunc("/api/webhooks", a.createWebhook).Methods("POST")
+	r.HandleFunc("/api/webhooks/deliveries", a.listDeliveries).Methods("GET")
+	r.HandleFunc("/api/webhooks/deliveries/{id}", a.getDelivery).Methods("GET")
+	r.HandleFunc("/api/webhooks/deliveries/{id}", a.discardDelivery).Methods("DELETE")
+	r.HandleFunc("/api/webhooks/deliveries/{id}/redeliver", a.redeliverDelivery).Methods("POST")
+	r.HandleFunc("/api/webhooks/{id}", a.getWebhook).Methods("GET")
+	r.HandleFunc("/api/webhooks/{id}", a.updateWebhook).Methods("PATCH")
+	r.HandleFunc("/api/webhooks/{id}", a.deleteWebhook).Methods("DELETE")
+	r.HandleFunc("/api/webhooks/{id}/rotate", a.rotateWebhookSecret).Methods("POST")
+	r.HandleFunc("/api/webhooks/{id}/test", a.testWebhook).Methods("POST")
+	r.HandleFunc("/api/sessions", a.listSessions).Methods("GET")
+	r.HandleFunc("/api/sessions/history", a.sessionHistory).Methods("GET")
+	r.HandleFunc("/api/sessions/{id}", a.closeSession).Methods("DELETE")
+	r.HandleFunc("/api/retention", a.retentionPolicy).Methods("GET")
+	r.HandleFunc("/api/audit", a.listAudit).Methods("GET")
+	r.HandleFunc("/api/audit/export", a.exportAudit).Methods("GET")
+	r.HandleFunc("/api/audit/verify", a.verifyAudit).Methods("GET")
+	r.HandleFunc("/api/keys", a.listKeys).Methods("GET")
+	r.HandleFunc("/api/keys", a.createKey).Methods("POST")
+	r.HandleFunc("/api/keys/{id}/rotate", a.rotateKey).Methods("POST")
+	r.HandleFunc("/api/keys/{id}", a.revokeKey).Methods("DELETE")
+	r.Handle("/metrics", metrics.Handler()).Methods("GET")
+	r.HandleFunc("/api/tiers", a.listTiers).Methods("GET")
+	r.HandleFunc("/api/tiers/{name}", a.putTier).Methods("PUT")
+	r.HandleFunc("/api/tiers/{name}", a.deleteTier).Methods("DELETE")
+	r.HandleFunc("/api/plans", a.listPlans).Methods("GET")
+	r.HandleFunc("/api/plans/{name}", a.putPlan).Methods("PUT")
+	r.HandleFunc("/api/plans/{name}", a.deletePlan).Methods("DELETE")
+	r.HandleFunc("/api/vouchers", a.listVouchers).Methods("GET")
+	r.HandleFunc("/api/vouchers", a.generateVouchers).Methods("POST")
+	r.HandleFunc("/api/vouchers/batches", a.listVoucherBatches).Methods("GET")
+	r.HandleFunc("/api/vouchers/batches/{id}", a.revokeVoucherBatch).Methods("DELETE")
+	r.HandleFunc("/api/vouchers/{code}", a.getVoucher).Methods("GET")
+	r.HandleFunc("/api/vouchers/{code}", a.revokeVoucher).Methods("DELETE")
+	r.HandleFunc("/api/openapi.json", a.openAPI).Methods("GET")
+	a.setupV2Routes(r)
 	
 	return r
 }
 
 func (a *AdminAPI) authMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Prometheus and other scrapers send the key as a bearer token
 		apiKey := r.Header.Get("X-API-Key")
-		if apiKey != a.apiKey {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		if apiKey == "" {
+			apiKey = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		}
+		key, ok := a.keys.Authenticate(apiKey)
+		if !ok {
+			writeError(w, r, http.StatusUnauthorized, CodeUnauthorized, "Unauthorized", nil)
 			return
 		}
-		next.ServeHTTP(w, r)
+		if !key.Role.Allows(requiredRole(r)) {
+			writeError(w, r, http.StatusForbidden, CodeForbidden, "Forbidden", map[string]any{"required_role": requiredRole(r)})
+			return
+		}
+		next.ServeHTTP(w, withKey(r, key))
 	})
 }
 
@@ -64,13 +224,13 @@ func (a *AdminAPI) createClient(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	duration, err := parseDuration(req.Duration)
-	if err != nil {
-		http.Error(w, "Invalid duration", http.StatusBadRequest)
+	duration, profile, ferr := a.clientProfile(req)
+	if ferr != nil {
+		http.Error(w, ferr.Message, http.StatusBadRequest)
 		return
 	}
 
-	client, err := a.clientManager.CreateClient(duration)
+	client, err := a.clientManager.CreateClient(duration, profile)
 	if err != nil {
 		http.Error(w, "Failed to create client", http.StatusInternalServerError)
 		return
@@ -80,17 +240,212 @@ func (a *AdminAPI) createClient(w http.ResponseWriter, r *http.Request) {
 		UUID:      client.UUID,
 		Secret:    client.Secret,
 		ExpiresAt: client.ExpiresAt,
+		ShareURI:  newClientConfig(client, a.serverAddr).ShareURI(client.Name),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
 
+// listClients returns a JSON array of clients. Optional query parameters:
+// status (comma-separated states), tag, q (free text), expires_after and
+// expires_before (RFC 3339), sort (created_at, expires_at, name, traffic;
+// "-" prefix for descending), limit and cursor. The total match count and the
+// cursor of the next page are returned in X-Total-Count and X-Next-Cursor.
 func (a *AdminAPI) listClients(w http.ResponseWriter, r *http.Request) {
-	clients := a.clientManager.ListClients()
-	
+	query, err := parseClientQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := a.clientManager.QueryClients(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
+	if page.NextCursor != "" {
+		w.Header().Set("X-Next-Cursor", page.NextCursor)
+	}
+	json.NewEncoder(w).Encode(clientsFor(r, page.Clients))
+}
+
+func parseClientQuery(values url.Values) (auth.ClientQuery, error) {
+	query := auth.ClientQuery{
+		Tag:    values.Get("tag"),
+		Text:   values.Get("q"),
+		Sort:   values.Get("sort"),
+		Cursor: values.Get("cursor"),
+	}
+
+	if status := values.Get("status"); status != "" {
+		for _, s := range strings.Split(status, ",") {
+			query.States = append(query.States, auth.ClientState(strings.TrimSpace(s)))
+		}
+	}
+
+	var err error
+	if v := values.Get("expires_after"); v != "" {
+		if query.ExpiresAfter, err = time.Parse(time.RFC3339, v); err != nil {
+			return query, fmt.Errorf("invalid expires_after")
+		}
+	}
+	if v := values.Get("expires_before"); v != "" {
+		if query.ExpiresBefore, err = time.Parse(time.RFC3339, v); err != nil {
+			return query, fmt.Errorf("invalid expires_before")
+		}
+	}
+	if v := values.Get("limit"); v != "" {
+		query.Limit, err = strconv.Atoi(v)
+		if err != nil || query.Limit < 1 || query.Limit > maxPageSize {
+			return query, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+	}
+
+	return query, nil
+}
+
+func (a *AdminAPI) updateClient(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uuid := vars["uuid"]
+
+	var req UpdateClientRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	update, err := a.clientUpdate(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	client, ok := a.clientManager.UpdateClient(uuid, update)
+	if !ok {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(client)
+}
+
+// clientUpdate validates an update request and converts it for the client
+// manager.
+func (a *AdminAPI) clientUpdate(req UpdateClientRequest) (auth.ClientUpdate, *fieldError) {
+	update := auth.ClientUpdate{
+		ExpiresAt:  req.ExpiresAt,
+		Name:       req.Name,
+		Email:      req.Email,
+		Contact:    req.Contact,
+		Tags:       req.Tags,
+		Notes:      req.Notes,
+		QuotaBytes: req.QuotaBytes,
+		SpeedTier:  req.SpeedTier,
+	}
+	if req.Extend != "" {
+		extend, err := durations.Parse(req.Extend)
+		if err != nil || extend <= 0 {
+			return update, &fieldError{"extend", "Invalid extend duration"}
+		}
+		update.Extend = extend
+	}
+	if req.QuotaBytes != nil && *req.QuotaBytes < 0 {
+		return update, &fieldError{"quota_bytes", "Invalid quota"}
+	}
+	if req.QuotaReset != nil {
+		reset, err := auth.ParseQuotaReset(*req.QuotaReset)
+		if err != nil {
+			return update, &fieldError{"quota_reset", err.Error()}
+		}
+		update.QuotaReset = &reset
+	}
+	if req.SpeedTier != nil && !a.validTier(*req.SpeedTier) {
+		return update, &fieldError{"speed_tier", "Unknown speed tier"}
+	}
+	if req.MaxSessions != nil {
+		if *req.MaxSessions < -1 {
+			return update, &fieldError{"max_sessions", "Invalid max_sessions"}
+		}
+		update.MaxSessions = req.MaxSessions
+	}
+	if req.SessionPolicy != nil {
+		policy := auth.SessionPolicy("")
+		if *req.SessionPolicy != "" {
+			var err error
+			if policy, err = auth.ParseSessionPolicy(*req.SessionPolicy); err != nil {
+				return update, &fieldError{"session_policy", err.Error()}
+			}
+		}
+		update.SessionPolicy = &policy
+	}
+	return update, nil
+}
+
+func (a *AdminAPI) rotateClient(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uuid := vars["uuid"]
+
+	client, err := a.clientManager.RotateCredentials(uuid)
+	if err == auth.ErrClientNotFound {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to rotate credentials", http.StatusInternalServerError)
+		return
+	}
+	// Sessions still use the old secret and key
+	a.tunnelServer.CloseClientSessions(uuid, tunnel.ReasonRevoked)
+
+	resp := CreateClientResponse{
+		UUID:      client.UUID,
+		Secret:    client.Secret,
+		ExpiresAt: client.ExpiresAt,
+		ShareURI:  newClientConfig(client, a.serverAddr).ShareURI(client.Name),
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(clients)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// clientConfig returns the client's config.json. With ?format=uri it returns
+// the yagnoetik:// share link as text, with ?format=bundle both as JSON.
+func (a *AdminAPI) clientConfig(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uuid := vars["uuid"]
+
+	client, ok := a.clientManager.FindClient(uuid)
+	if !ok {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	}
+
+	config := newClientConfig(client, a.serverAddr)
+
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="config.json"`)
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(config)
+	case "uri":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(config.ShareURI(client.Name)))
+	case "bundle":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ClientConfigResponse{
+			Config:   config,
+			ShareURI: config.ShareURI(client.Name),
+		})
+	default:
+		http.Error(w, "Unknown format", http.StatusBadRequest)
+	}
 }
 
 func (a *AdminAPI) deleteClient(w http.ResponseWriter, r *http.Request) {
@@ -129,14 +484,15 @@ func (a *AdminAPI) unblockClient(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
-func parseDuration(s string) (time.Duration, error) {
-	if strings.HasSuffix(s, "d") {
-		days := strings.TrimSuffix(s, "d")
-		d, err := time.ParseDuration(days + "h")
-		if err != nil {
-			return 0, err
-		}
-		return d * 24, nil
-	}
-	return time.ParseDuration(s)
+func (a *AdminAPI) clientTransitions(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uuid := vars["uuid"]
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.clientManager.Transitions(uuid))
+}
+
+func (a *AdminAPI) listTransitions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.clientManager.Transitions(""))
 }
@@ -0,0 +1,279 @@
+package api
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"yagnoetik-vpn/internal/audit"
+
+	"github.com/gorilla/mux"
+)
+
+// maxAuditBody is the largest request body stored in an audit entry;
+// larger bodies, such as imports, are recorded by size only.
+const maxAuditBody = 64 << 10
+
+// actorHeader names the person behind a request made with a shared key.
+const actorHeader = "X-Admin-Actor"
+
+// redactedKeys are the parameter names, or parts of them, whose values are
+// never stored.
+var redactedKeys = []string{"secret", "key", "password", "token", "totp"}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// auditMiddleware records every request that changes state, whether or not
+// it succeeded.
+func (a *AdminAPI) auditMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.audit == nil || r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Keep a copy of small bodies; large ones are passed through
+		// without buffering them whole
+		var body []byte
+		if r.Body != nil {
+			body, _ = io.ReadAll(io.LimitReader(r.Body, maxAuditBody+1))
+			r.Body = readCloser{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		action := r.Method + " " + r.URL.Path
+		if route := mux.CurrentRoute(r); route != nil {
+			if tmpl, err := route.GetPathTemplate(); err == nil {
+				action = r.Method + " " + tmpl
+			}
+		}
+		e := a.audit.Append(audit.Entry{
+			Actor:        requestActor(r),
+			Action:       action,
+			Target:       auditTarget(mux.Vars(r)),
+			Params:       auditParams(r, body),
+			SourceIP:     a.retention.RemoteAddr(sourceIP(r)),
+			ForwardedFor: a.forwardedFor(r),
+			Status:       rec.status,
+		})
+		slog.Info("Admin action", "audit_id", e.ID, "request_id", requestID(r), "actor", e.Actor, "action", e.Action, "target", e.Target, "status", e.Status)
+	})
+}
+
+// requestActor identifies who made the request: the name of the key, and
+// the person named in the actor header when a key is shared.
+func requestActor(r *http.Request) string {
+	actor := "unknown"
+	if key, ok := requestKey(r); ok {
+		actor = key.Name
+	}
+	if person := strings.TrimSpace(r.Header.Get(actorHeader)); person != "" {
+		actor += "/" + person
+	}
+	return actor
+}
+
+func auditTarget(vars map[string]string) string {
+	for _, name := range []string{"uuid", "id", "name", "code"} {
+		if v := vars[name]; v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func sourceIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// forwardedFor returns the X-Forwarded-For chain with the retention IP mode
+// applied to every address in it.
+func (a *AdminAPI) forwardedFor(r *http.Request) string {
+	var kept []string
+	for _, addr := range strings.Split(r.Header.Get("X-Forwarded-For"), ",") {
+		if addr = a.retention.RemoteAddr(strings.TrimSpace(addr)); addr != "" {
+			kept = append(kept, addr)
+		}
+	}
+	return strings.Join(kept, ", ")
+}
+
+// auditParams combines the query string and the request body. JSON bodies
+// are stored with secrets redacted; anything else only by size.
+func auditParams(r *http.Request, body []byte) json.RawMessage {
+	params := make(map[string]any)
+	if len(r.URL.Query()) > 0 {
+		query := make(map[string]any)
+		for name, values := range r.URL.Query() {
+			query[name] = strings.Join(values, ",")
+		}
+		params["query"] = redact(query)
+	}
+
+	var decoded any
+	switch {
+	case len(body) == 0:
+	case len(body) <= maxAuditBody && json.Unmarshal(body, &decoded) == nil:
+		params["body"] = redact(decoded)
+	default:
+		params["body_bytes"] = r.ContentLength
+		params["content_type"] = r.Header.Get("Content-Type")
+	}
+
+	if len(params) == 0 {
+		return nil
+	}
+	data, _ := json.Marshal(params)
+	return data
+}
+
+func redact(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for name, value := range v {
+			if isSecretKey(name) {
+				v[name] = "[redacted]"
+			} else {
+				v[name] = redact(value)
+			}
+		}
+	case []any:
+		for i, value := range v {
+			v[i] = redact(value)
+		}
+	}
+	return v
+}
+
+func isSecretKey(name string) bool {
+	name = strings.ToLower(name)
+	for _, key := range redactedKeys {
+		if strings.Contains(name, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// listAudit returns audit entries, newest first, filtered by actor, action
+// (substring), target, from and to.
+func (a *AdminAPI) listAudit(w http.ResponseWriter, r *http.Request) {
+	query, ok := a.auditQuery(w, r)
+	if !ok {
+		return
+	}
+	if query.Limit == 0 {
+		query.Limit = 100
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.audit.Query(query))
+}
+
+// exportAudit downloads matching entries oldest first as JSON Lines or
+// CSV. An unfiltered export can be checked against the hash chain with the
+// server's audit key.
+func (a *AdminAPI) exportAudit(w http.ResponseWriter, r *http.Request) {
+	query, ok := a.auditQuery(w, r)
+	if !ok {
+		return
+	}
+	entries := a.audit.Export(query)
+
+	stamp := time.Now().Format("20060102-150405")
+	switch r.URL.Query().Get("format") {
+	case "", "jsonl":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="audit-%s.jsonl"`, stamp))
+		enc := json.NewEncoder(w)
+		for _, e := range entries {
+			enc.Encode(e)
+		}
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="audit-%s.csv"`, stamp))
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"id", "time", "actor", "action", "target", "params", "source_ip", "forwarded_for", "status", "prev_hash", "hash"})
+		for _, e := range entries {
+			cw.Write([]string{
+				strconv.FormatUint(e.ID, 10), e.Time.Format(time.RFC3339Nano), e.Actor, e.Action, e.Target,
+				string(e.Params), e.SourceIP, e.ForwardedFor, strconv.Itoa(e.Status), e.PrevHash, e.Hash,
+			})
+		}
+		cw.Flush()
+	default:
+		http.Error(w, "Unknown format", http.StatusBadRequest)
+	}
+}
+
+// verifyAudit checks the hash chain of the retained entries and reports a
+// break found when the log was loaded.
+func (a *AdminAPI) verifyAudit(w http.ResponseWriter, r *http.Request) {
+	if a.audit == nil {
+		http.Error(w, "Audit log is disabled", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.audit.Verify())
+}
+
+func (a *AdminAPI) auditQuery(w http.ResponseWriter, r *http.Request) (audit.Query, bool) {
+	if a.audit == nil {
+		http.Error(w, "Audit log is disabled", http.StatusNotFound)
+		return audit.Query{}, false
+	}
+
+	values := r.URL.Query()
+	query := audit.Query{
+		Actor:  values.Get("actor"),
+		Action: values.Get("action"),
+		Target: values.Get("target"),
+	}
+	for name, dst := range map[string]*time.Time{"from": &query.From, "to": &query.To} {
+		if v := values.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				http.Error(w, "Invalid "+name, http.StatusBadRequest)
+				return query, false
+			}
+			*dst = t
+		}
+	}
+	if v := values.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return query, false
+		}
+		query.Limit = limit
+	}
+	return query, true
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"yagnoetik-vpn/internal/adminkeys"
+	"yagnoetik-vpn/internal/audit"
+	"yagnoetik-vpn/internal/auth"
+	"yagnoetik-vpn/internal/retention"
+)
+
+// TestAuditAddresses checks that the addresses in an audit entry are cut
+// down by the retention policy before the entry is written.
+func TestAuditAddresses(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		policy    retention.Policy
+		source    string
+		forwarded string
+	}{
+		{"full", retention.Policy{RemoteIP: retention.IPFull}, "203.0.113.7", "198.51.100.9, 2001:db8:1:2::5"},
+		{"truncated", retention.Policy{RemoteIP: retention.IPTruncated}, "203.0.113.0/24", "198.51.100.0/24, 2001:db8:1::/48"},
+		{"none", retention.Policy{RemoteIP: retention.IPNone}, "", ""},
+		{"no log", retention.Policy{RemoteIP: retention.IPFull, NoLog: true}, "", ""},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			log := audit.NewLog([]byte("test key"), 0)
+			a := NewAdminAPI(auth.NewClientManager(), nil, adminkeys.NewStore(), Options{Audit: log, Retention: test.policy})
+			handler := a.auditMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			}))
+
+			r := httptest.NewRequest(http.MethodPost, "/api/clients/abc/block", nil)
+			r.RemoteAddr = "203.0.113.7:40000"
+			r.Header.Set("X-Forwarded-For", "198.51.100.9,2001:db8:1:2::5")
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+
+			entries := log.Export(audit.Query{})
+			if len(entries) != 1 {
+				t.Fatalf("%d entries, want 1", len(entries))
+			}
+			if e := entries[0]; e.SourceIP != test.source || e.ForwardedFor != test.forwarded {
+				t.Errorf("source %q forwarded %q, want %q and %q", e.SourceIP, e.ForwardedFor, test.source, test.forwarded)
+			}
+		})
+	}
+}
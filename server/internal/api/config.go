@@ -0,0 +1,49 @@
+package api
+
+import (
+	"encoding/base64"
+	"net/url"
+
+	"yagnoetik-vpn/internal/auth"
+)
+
+// ShareURIScheme is the scheme of compact client configuration links:
+//
+//	yagnoetik://<uuid>:<secret>@<server_addr>?key=<base64url key>#<name>
+const ShareURIScheme = "yagnoetik"
+
+// ClientConfig mirrors the config.json read by the Windows and Android
+// clients. Key is encoded as standard base64 by encoding/json.
+type ClientConfig struct {
+	ServerAddr string `json:"server_addr"`
+	UUID       string `json:"uuid"`
+	Secret     string `json:"secret"`
+	Key        []byte `json:"key"`
+}
+
+type ClientConfigResponse struct {
+	Config   ClientConfig `json:"config"`
+	ShareURI string       `json:"share_uri"`
+}
+
+func newClientConfig(client *auth.Client, serverAddr string) ClientConfig {
+	return ClientConfig{
+		ServerAddr: serverAddr,
+		UUID:       client.UUID,
+		Secret:     client.Secret,
+		Key:        client.Key,
+	}
+}
+
+// ShareURI encodes the config as a yagnoetik:// link. The name is only a
+// label for the importing user and is not needed to connect.
+func (c ClientConfig) ShareURI(name string) string {
+	u := url.URL{
+		Scheme:   ShareURIScheme,
+		User:     url.UserPassword(c.UUID, c.Secret),
+		Host:     c.ServerAddr,
+		RawQuery: url.Values{"key": {base64.RawURLEncoding.EncodeToString(c.Key)}}.Encode(),
+		Fragment: name,
+	}
+	return u.String()
+}
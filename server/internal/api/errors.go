@@ -0,0 +1,135 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// requestIDHeader carries the request ID in both directions: callers may set
+// their own, and every response reports the one used.
+const requestIDHeader = "X-Request-ID"
+
+// Error codes of the v2 API. Integrations should switch on these rather
+// than on messages, which may change.
+const (
+	CodeInvalidJSON           = "invalid_json"
+	CodeInvalidField          = "invalid_field"
+	CodeUnauthorized          = "unauthorized"
+	CodeForbidden             = "forbidden"
+	CodeNotFound              = "not_found"
+	CodeMethodNotAllowed      = "method_not_allowed"
+	CodePreconditionFailed    = "precondition_failed"
+	CodeIdempotencyInUse      = "idempotency_key_in_use"
+	CodeIdempotencyMismatch   = "idempotency_key_reused"
+	CodeRequestTooLarge       = "request_too_large"
+	CodeInvalidIdempotencyKey = "invalid_idempotency_key"
+	CodeInternal              = "internal_error"
+)
+
+// ErrorResponse is the body of every v2 error response.
+type ErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+type APIError struct {
+	Code      string         `json:"code"`
+	Message   string         `json:"message"`
+	Details   map[string]any `json:"details,omitempty"`
+	RequestID string         `json:"request_id"`
+}
+
+// fieldError is a validation failure of one request field.
+type fieldError struct {
+	Field   string
+	Message string
+}
+
+func (e *fieldError) Error() string {
+	return e.Message
+}
+
+type requestIDContextKey struct{}
+
+// requestIDMiddleware assigns every request an ID, reusing the caller's if
+// it is reasonable, and echoes it in the response.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, withRequestID(w, r))
+	})
+}
+
+func withRequestID(w http.ResponseWriter, r *http.Request) *http.Request {
+	if requestID(r) != "" {
+		return r
+	}
+	id := r.Header.Get(requestIDHeader)
+	if !validRequestID(id) {
+		bytes := make([]byte, 16)
+		rand.Read(bytes)
+		id = hex.EncodeToString(bytes)
+	}
+	w.Header().Set(requestIDHeader, id)
+	return r.WithContext(context.WithValue(r.Context(), requestIDContextKey{}, id))
+}
+
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey{}).(string)
+	return id
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func isV2(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/api/v2/")
+}
+
+// writeError reports an error in the format of the request's API version:
+// a JSON ErrorResponse for v2 and plain text for v1.
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, message string, details map[string]any) {
+	if !isV2(r) {
+		http.Error(w, message, status)
+		return
+	}
+	r = withRequestID(w, r)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: APIError{
+		Code:      code,
+		Message:   message,
+		Details:   details,
+		RequestID: requestID(r),
+	}})
+}
+
+// notFound and methodNotAllowed keep mux's default responses for v1.
+func notFound(w http.ResponseWriter, r *http.Request) {
+	if !isV2(r) {
+		http.NotFound(w, r)
+		return
+	}
+	writeError(w, r, http.StatusNotFound, CodeNotFound, "Not found", nil)
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	if !isV2(r) {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed", nil)
+}
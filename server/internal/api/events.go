@@ -0,0 +1,189 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"yagnoetik-vpn/internal/events"
+)
+
+// eventsHeartbeat is how often an idle event stream sends a comment line, so
+// that proxies do not time it out.
+const eventsHeartbeat = 15 * time.Second
+
+// eventFilter selects events by type and client. A type without a dot, such
+// as "session", matches every event of that group.
+type eventFilter struct {
+	types  []string
+	client string
+}
+
+func parseEventFilter(r *http.Request) eventFilter {
+	f := eventFilter{client: r.URL.Query().Get("client")}
+	if types := r.URL.Query().Get("types"); types != "" {
+		for _, t := range strings.Split(types, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				f.types = append(f.types, t)
+			}
+		}
+	}
+	return f
+}
+
+func (f eventFilter) match(e events.Event) bool {
+	if f.client != "" && e.Client != f.client {
+		return false
+	}
+	return events.Match(f.types, e.Type)
+}
+
+// lastEventID reads the ID to resume after from the Last-Event-ID header,
+// which EventSource sends on reconnect, or ?last_event_id=.
+func lastEventID(r *http.Request) (uint64, error) {
+	value := r.Header.Get("Last-Event-ID")
+	if value == "" {
+		value = r.URL.Query().Get("last_event_id")
+	}
+	if value == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid last event ID")
+	}
+	return id, nil
+}
+
+// streamEvents sends operational events as server-sent events. Filters:
+// ?types= (comma-separated types or groups such as "session") and
+// ?client=<uuid>. A client that reconnects with Last-Event-ID gets the
+// events it missed; if some of them are no longer buffered a "gap" event is
+// sent first. ?replay=N starts a new stream with the last N events.
+func (a *AdminAPI) streamEvents(w http.ResponseWriter, r *http.Request) {
+	if a.events == nil {
+		http.Error(w, "Events are disabled", http.StatusNotFound)
+		return
+	}
+	after, err := lastEventID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var replay int
+	if value := r.URL.Query().Get("replay"); value != "" {
+		if replay, err = strconv.Atoi(value); err != nil || replay < 0 {
+			http.Error(w, "Invalid replay", http.StatusBadRequest)
+			return
+		}
+	}
+	filter := parseEventFilter(r)
+
+	sub, backlog, complete := a.events.Subscribe(after, replay)
+	defer sub.Close()
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(w, "retry: 3000\n\n")
+	if !complete {
+		fmt.Fprintf(w, "event: gap\ndata: {\"last_event_id\":%d}\n\n", after)
+	}
+	for _, e := range backlog {
+		if filter.match(e) {
+			writeEvent(w, e)
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprintf(w, ": ping\n\n")
+		case e, ok := <-sub.C:
+			if !ok {
+				// Dropped for falling behind; the client resumes from
+				// its last event ID
+				return
+			}
+			if !filter.match(e) {
+				continue
+			}
+			writeEvent(w, e)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, e events.Event) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+}
+
+// recentEvents returns the buffered events as a JSON array, oldest first.
+// It takes the same filters as the stream, plus ?after=<id> to poll for
+// newer events and ?limit= (default 100). Without ?after= the newest events
+// are kept, with it the oldest, so that polling pages forward. The
+// X-Events-Gap header is set when events after ?after= were dropped.
+func (a *AdminAPI) recentEvents(w http.ResponseWriter, r *http.Request) {
+	if a.events == nil {
+		http.Error(w, "Events are disabled", http.StatusNotFound)
+		return
+	}
+	var after uint64
+	if value := r.URL.Query().Get("after"); value != "" {
+		var err error
+		if after, err = strconv.ParseUint(value, 10, 64); err != nil {
+			http.Error(w, "Invalid after", http.StatusBadRequest)
+			return
+		}
+	}
+	limit := 100
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 || n > maxPageSize {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	filter := parseEventFilter(r)
+
+	buffered, complete := a.events.Recent(after)
+	result := make([]events.Event, 0)
+	for _, e := range buffered {
+		if filter.match(e) {
+			result = append(result, e)
+		}
+	}
+	if len(result) > limit {
+		if after > 0 {
+			result = result[:limit]
+		} else {
+			result = result[len(result)-limit:]
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !complete {
+		w.Header().Set("X-Events-Gap", "true")
+	}
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,221 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"yagnoetik-vpn/internal/adminkeys"
+	"yagnoetik-vpn/internal/auth"
+	"yagnoetik-vpn/internal/durations"
+
+	"github.com/gorilla/mux"
+)
+
+type keyContextKey struct{}
+
+type CreateKeyRequest struct {
+	Name      string     `json:"name"`
+	Role      string     `json:"role"`                 // read-only, support or admin
+	ExpiresIn string     `json:"expires_in,omitempty"` // e.g. "90d"
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// KeyResponse carries the token of a created or rotated key. It is shown
+// only once.
+type KeyResponse struct {
+	adminkeys.Key
+	Token string `json:"token"`
+}
+
+// supportRoutes are the mutations the support role may make.
+var supportRoutes = map[string]bool{
+	"POST /api/clients/{uuid}/block":   true,
+	"POST /api/clients/{uuid}/unblock": true,
+	"POST /api/clients/{uuid}/extend":  true,
+}
+
+// requiredRole is the least role that may make the request. Reads are open
+// to every role except those that reveal credentials, including voucher
+// codes, or manage keys and webhooks, whose URLs may carry tokens.
+func requiredRole(r *http.Request) adminkeys.Role {
+	tmpl := r.URL.Path
+	if route := mux.CurrentRoute(r); route != nil {
+		if t, err := route.GetPathTemplate(); err == nil {
+			tmpl = t
+		}
+	}
+	// v2 routes need the same roles as their v1 counterparts
+	tmpl = strings.Replace(tmpl, "/api/v2/", "/api/", 1)
+
+	switch {
+	case strings.HasPrefix(tmpl, "/api/keys"), strings.HasPrefix(tmpl, "/api/webhooks"), strings.HasPrefix(tmpl, "/api/vouchers"):
+		return adminkeys.RoleAdmin
+	case r.Method != http.MethodGet && r.Method != http.MethodHead:
+		if supportRoutes[r.Method+" "+tmpl] {
+			return adminkeys.RoleSupport
+		}
+		return adminkeys.RoleAdmin
+	case tmpl == "/api/clients/{uuid}/config":
+		return adminkeys.RoleSupport
+	case tmpl == "/api/clients/export" && r.URL.Query().Get("include_secrets") == "true":
+		return adminkeys.RoleAdmin
+	}
+	return adminkeys.RoleReadOnly
+}
+
+func requestKey(r *http.Request) (adminkeys.Key, bool) {
+	key, ok := r.Context().Value(keyContextKey{}).(adminkeys.Key)
+	return key, ok
+}
+
+func withKey(r *http.Request, key adminkeys.Key) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), keyContextKey{}, key))
+}
+
+// canSeeSecrets reports whether the request's key may see client secrets,
+// as the support keys that can fetch a client's config already do.
+func canSeeSecrets(r *http.Request) bool {
+	key, ok := requestKey(r)
+	return ok && key.Role.Allows(adminkeys.RoleSupport)
+}
+
+// withoutSecret returns a copy of the client with the secret cleared, which
+// leaves it out of the JSON.
+func withoutSecret(client *auth.Client) *auth.Client {
+	c := *client
+	c.Secret = ""
+	return &c
+}
+
+// clientsFor strips the secrets from a list of clients unless the request's
+// key may see them.
+func clientsFor(r *http.Request, clients []*auth.Client) []*auth.Client {
+	if canSeeSecrets(r) {
+		return clients
+	}
+	stripped := make([]*auth.Client, len(clients))
+	for i, c := range clients {
+		stripped[i] = withoutSecret(c)
+	}
+	return stripped
+}
+
+// extendClient moves a client's expiry forward, from now if it has already
+// expired. It is separate from updateClient so that support keys can
+// extend subscriptions without editing anything else.
+func (a *AdminAPI) extendClient(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uuid := vars["uuid"]
+
+	var req struct {
+		Duration string `json:"duration"` // e.g., "30d"
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	extend, err := durations.Parse(req.Duration)
+	if err != nil || extend <= 0 {
+		http.Error(w, "Invalid duration", http.StatusBadRequest)
+		return
+	}
+
+	client, ok := a.clientManager.UpdateClient(uuid, auth.ClientUpdate{Extend: extend})
+	if !ok {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(client)
+}
+
+func (a *AdminAPI) listKeys(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.keys.List())
+}
+
+func (a *AdminAPI) createKey(w http.ResponseWriter, r *http.Request) {
+	var req CreateKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		http.Error(w, "Name is required", http.StatusBadRequest)
+		return
+	}
+	role, err := adminkeys.ParseRole(req.Role)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var expiresAt time.Time
+	switch {
+	case req.ExpiresAt != nil:
+		expiresAt = *req.ExpiresAt
+	case req.ExpiresIn != "":
+		d, err := durations.Parse(req.ExpiresIn)
+		if err != nil || d <= 0 {
+			http.Error(w, "Invalid expires_in", http.StatusBadRequest)
+			return
+		}
+		expiresAt = time.Now().Add(d)
+	}
+	if !expiresAt.IsZero() && !expiresAt.After(time.Now()) {
+		http.Error(w, "Expiry must be in the future", http.StatusBadRequest)
+		return
+	}
+
+	key, token, err := a.keys.Create(name, role, expiresAt)
+	if err != nil {
+		http.Error(w, "Failed to create key", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(KeyResponse{Key: key, Token: token})
+}
+
+func (a *AdminAPI) rotateKey(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	key, token, err := a.keys.Rotate(vars["id"])
+	if err != nil {
+		keyError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(KeyResponse{Key: key, Token: token})
+}
+
+func (a *AdminAPI) revokeKey(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	key, err := a.keys.Revoke(vars["id"])
+	if err != nil {
+		keyError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(key)
+}
+
+func keyError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, adminkeys.ErrNotFound):
+		http.Error(w, "Key not found", http.StatusNotFound)
+	default:
+		http.Error(w, err.Error(), http.StatusConflict)
+	}
+}
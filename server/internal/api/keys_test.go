@@ -0,0 +1,68 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"yagnoetik-vpn/internal/adminkeys"
+
+	"github.com/gorilla/mux"
+)
+
+func TestRequiredRole(t *testing.T) {
+	for _, test := range []struct {
+		method, route, path string
+		want                adminkeys.Role
+	}{
+		{"GET", "/api/clients", "/api/clients", adminkeys.RoleReadOnly},
+		{"HEAD", "/api/clients", "/api/clients", adminkeys.RoleReadOnly},
+		{"GET", "/api/v2/clients", "/api/v2/clients", adminkeys.RoleReadOnly},
+		{"GET", "/api/clients/export", "/api/clients/export?include_secrets=false", adminkeys.RoleReadOnly},
+		{"GET", "/api/clients/export", "/api/clients/export?include_secrets=true", adminkeys.RoleAdmin},
+		{"GET", "/api/clients/{uuid}/config", "/api/clients/abc/config", adminkeys.RoleSupport},
+		{"GET", "/api/v2/clients/{uuid}/config", "/api/v2/clients/abc/config", adminkeys.RoleSupport},
+		{"POST", "/api/clients/{uuid}/block", "/api/clients/abc/block", adminkeys.RoleSupport},
+		{"POST", "/api/clients/{uuid}/unblock", "/api/clients/abc/unblock", adminkeys.RoleSupport},
+		{"POST", "/api/clients/{uuid}/extend", "/api/clients/abc/extend", adminkeys.RoleSupport},
+		{"POST", "/api/v2/clients/{uuid}/extend", "/api/v2/clients/abc/extend", adminkeys.RoleSupport},
+		{"POST", "/api/clients", "/api/clients", adminkeys.RoleAdmin},
+		{"PATCH", "/api/clients/{uuid}", "/api/clients/abc", adminkeys.RoleAdmin},
+		{"DELETE", "/api/clients/{uuid}", "/api/clients/abc", adminkeys.RoleAdmin},
+		{"POST", "/api/clients/{uuid}/rotate", "/api/clients/abc/rotate", adminkeys.RoleAdmin},
+		{"DELETE", "/api/sessions/{id}", "/api/sessions/s1", adminkeys.RoleAdmin},
+		// Reads that reveal tokens or codes
+		{"GET", "/api/keys", "/api/keys", adminkeys.RoleAdmin},
+		{"GET", "/api/webhooks", "/api/webhooks", adminkeys.RoleAdmin},
+		{"GET", "/api/webhooks/deliveries/{id}", "/api/webhooks/deliveries/d1", adminkeys.RoleAdmin},
+		{"GET", "/api/vouchers", "/api/vouchers", adminkeys.RoleAdmin},
+		{"GET", "/api/vouchers/{code}", "/api/vouchers/ABCD", adminkeys.RoleAdmin},
+		{"GET", "/api/plans", "/api/plans", adminkeys.RoleReadOnly},
+		{"GET", "/api/audit/verify", "/api/audit/verify", adminkeys.RoleReadOnly},
+	} {
+		t.Run(test.method+" "+test.path, func(t *testing.T) {
+			var got adminkeys.Role
+			router := mux.NewRouter()
+			router.HandleFunc(test.route, func(w http.ResponseWriter, r *http.Request) {
+				got = requiredRole(r)
+			}).Methods(test.method)
+
+			router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(test.method, test.path, nil))
+			if got != test.want {
+				t.Errorf("required role %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+// TestRoleAllows checks that each role includes the ones below it.
+func TestRoleAllows(t *testing.T) {
+	roles := []adminkeys.Role{adminkeys.RoleReadOnly, adminkeys.RoleSupport, adminkeys.RoleAdmin}
+	for i, role := range roles {
+		for j, required := range roles {
+			if got, want := role.Allows(required), i >= j; got != want {
+				t.Errorf("%s allows %s: %t, want %t", role, required, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package api
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openAPISpec documents every admin route. The SDK's types are generated
+// from it, and TestOpenAPIMatchesRoutes keeps it in step with SetupRoutes.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+func (a *AdminAPI) openAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
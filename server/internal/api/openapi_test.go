@@ -0,0 +1,67 @@
+package api
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"testing"
+
+	"yagnoetik-vpn/internal/adminkeys"
+	"yagnoetik-vpn/internal/auth"
+
+	"github.com/gorilla/mux"
+)
+
+// TestOpenAPIMatchesRoutes compares the operations in the OpenAPI document
+// with the routes SetupRoutes registers and reports any that are missing
+// from either side.
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		t.Fatalf("parse openapi.json: %v", err)
+	}
+	documented := make(map[string]bool)
+	for path, operations := range spec.Paths {
+		for method := range operations {
+			documented[strings.ToUpper(method)+" "+path] = true
+		}
+	}
+
+	router := NewAdminAPI(auth.NewClientManager(), nil, adminkeys.NewStore(), Options{}).SetupRoutes()
+	routed := make(map[string]bool)
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		tmpl, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		for _, method := range methods {
+			routed[method+" "+tmpl] = true
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var problems []string
+	for op := range routed {
+		if !documented[op] {
+			problems = append(problems, "undocumented route "+op)
+		}
+	}
+	for op := range documented {
+		if !routed[op] {
+			problems = append(problems, "documented route "+op+" is not registered")
+		}
+	}
+	sort.Strings(problems)
+	for _, problem := range problems {
+		t.Error(problem)
+	}
+}
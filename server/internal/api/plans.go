@@ -0,0 +1,286 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"yagnoetik-vpn/internal/auth"
+	"yagnoetik-vpn/internal/durations"
+	"yagnoetik-vpn/internal/plans"
+
+	"github.com/gorilla/mux"
+)
+
+// PlanRequest creates or replaces a plan; the name comes from the path.
+type PlanRequest struct {
+	Description string `json:"description,omitempty"`
+	Duration    string `json:"duration"` // e.g., "30d"
+	QuotaBytes  int64  `json:"quota_bytes,omitempty"`
+	QuotaReset  string `json:"quota_reset,omitempty"` // "", "monthly" or "rolling"
+	SpeedTier   string `json:"speed_tier,omitempty"`
+	// MaxSessions of 0 uses the server default and -1 allows unlimited
+	// sessions.
+	MaxSessions int `json:"max_sessions,omitempty"`
+}
+
+// PlanResponse is a plan with its duration written as in requests.
+type PlanResponse struct {
+	plans.Plan
+	Duration string `json:"duration"`
+}
+
+type GenerateVouchersRequest struct {
+	Plan  string `json:"plan"`
+	Count int    `json:"count"`
+	// ExpiresIn or ExpiresAt limit how long the codes can be redeemed;
+	// without either they do not expire.
+	ExpiresIn string     `json:"expires_in,omitempty"` // e.g. "90d"
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Note      string     `json:"note,omitempty"`
+}
+
+// VoucherBatchResponse is a generated batch with its codes.
+type VoucherBatchResponse struct {
+	Batch    plans.Batch     `json:"batch"`
+	Vouchers []plans.Voucher `json:"vouchers"`
+}
+
+var voucherCSVHeader = []string{
+	"code", "plan", "batch", "status", "expires_at", "created_at", "redeemed_at", "revoked_at", "client", "extended",
+}
+
+func newPlanResponse(p plans.Plan) PlanResponse {
+	return PlanResponse{Plan: p, Duration: durations.Format(p.Duration)}
+}
+
+func (a *AdminAPI) listPlans(w http.ResponseWriter, r *http.Request) {
+	resp := make([]PlanResponse, 0)
+	for _, p := range a.plans.Plans() {
+		resp = append(resp, newPlanResponse(p))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// putPlan creates or replaces a plan. Clients already on the plan keep their
+// settings until they redeem another voucher.
+func (a *AdminAPI) putPlan(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var req PlanRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	duration, err := durations.Parse(req.Duration)
+	if err != nil {
+		http.Error(w, "Invalid duration", http.StatusBadRequest)
+		return
+	}
+	if !a.validTier(req.SpeedTier) {
+		http.Error(w, "Unknown speed tier", http.StatusBadRequest)
+		return
+	}
+
+	plan, err := a.plans.PutPlan(plans.Plan{
+		Name:        vars["name"],
+		Description: req.Description,
+		Duration:    duration,
+		QuotaBytes:  req.QuotaBytes,
+		QuotaReset:  auth.QuotaReset(req.QuotaReset),
+		SpeedTier:   req.SpeedTier,
+		MaxSessions: req.MaxSessions,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newPlanResponse(plan))
+}
+
+func (a *AdminAPI) deletePlan(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	if err := a.plans.DeletePlan(vars["name"]); err != nil {
+		planError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *AdminAPI) generateVouchers(w http.ResponseWriter, r *http.Request) {
+	var req GenerateVouchersRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	var expiresAt time.Time
+	switch {
+	case req.ExpiresAt != nil:
+		expiresAt = *req.ExpiresAt
+	case req.ExpiresIn != "":
+		d, err := durations.Parse(req.ExpiresIn)
+		if err != nil || d <= 0 {
+			http.Error(w, "Invalid expires_in", http.StatusBadRequest)
+			return
+		}
+		expiresAt = time.Now().Add(d)
+	}
+
+	batch, vouchers, err := a.plans.Generate(req.Plan, req.Count, expiresAt, req.Note)
+	if errors.Is(err, plans.ErrPlanNotFound) {
+		http.Error(w, "Unknown plan", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(VoucherBatchResponse{Batch: batch, Vouchers: vouchers})
+}
+
+// listVouchers is the redemption report: the vouchers matching the batch,
+// plan and status filters, oldest first, as JSON or, with ?format=csv, as
+// CSV for printing or spreadsheets.
+func (a *AdminAPI) listVouchers(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	status, err := plans.ParseVoucherStatus(values.Get("status"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	vouchers := a.plans.Vouchers(plans.VoucherQuery{
+		Batch:  values.Get("batch"),
+		Plan:   values.Get("plan"),
+		Status: status,
+	})
+
+	switch values.Get("format") {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Total-Count", strconv.Itoa(len(vouchers)))
+		json.NewEncoder(w).Encode(vouchers)
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="vouchers.csv"`)
+		cw := csv.NewWriter(w)
+		cw.Write(voucherCSVHeader)
+		for _, v := range vouchers {
+			cw.Write([]string{
+				v.Code, v.Plan, v.Batch, string(v.Status),
+				csvTime(v.ExpiresAt), csvTime(v.CreatedAt), csvTime(v.RedeemedAt), csvTime(v.RevokedAt),
+				v.Client, strconv.FormatBool(v.Extended),
+			})
+		}
+		cw.Flush()
+	default:
+		http.Error(w, "Unknown format", http.StatusBadRequest)
+	}
+}
+
+func (a *AdminAPI) getVoucher(w http.ResponseWriter, r *http.Request) {
+	voucher, err := a.plans.Voucher(mux.Vars(r)["code"])
+	if err != nil {
+		planError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(voucher)
+}
+
+// revokeVoucher stops an unredeemed voucher from being redeemed. It stays in
+// the report as revoked.
+func (a *AdminAPI) revokeVoucher(w http.ResponseWriter, r *http.Request) {
+	voucher, err := a.plans.Revoke(mux.Vars(r)["code"])
+	if err != nil {
+		planError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(voucher)
+}
+
+func (a *AdminAPI) listVoucherBatches(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.plans.Batches())
+}
+
+// revokeVoucherBatch revokes the unredeemed vouchers of a batch.
+func (a *AdminAPI) revokeVoucherBatch(w http.ResponseWriter, r *http.Request) {
+	batch, err := a.plans.RevokeBatch(mux.Vars(r)["id"])
+	if err != nil {
+		planError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(batch)
+}
+
+func planError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, plans.ErrPlanNotFound):
+		http.Error(w, "Plan not found", http.StatusNotFound)
+	case errors.Is(err, plans.ErrVoucherNotFound):
+		http.Error(w, "Voucher not found", http.StatusNotFound)
+	case errors.Is(err, plans.ErrBatchNotFound):
+		http.Error(w, "Batch not found", http.StatusNotFound)
+	default:
+		http.Error(w, err.Error(), http.StatusConflict)
+	}
+}
+
+// clientProfile converts the profile fields of a create request. When the
+// request names a plan the client is put on it, and the duration defaults
+// to the plan's.
+func (a *AdminAPI) clientProfile(req CreateClientRequest) (time.Duration, auth.ClientUpdate, *fieldError) {
+	profile := auth.ClientUpdate{
+		Name:    &req.Name,
+		Email:   &req.Email,
+		Contact: &req.Contact,
+		Tags:    &req.Tags,
+		Notes:   &req.Notes,
+	}
+	var duration time.Duration
+	if req.Plan != "" {
+		plan, err := a.plans.Plan(req.Plan)
+		if err != nil {
+			return 0, profile, &fieldError{"plan", "Unknown plan"}
+		}
+		settings := plan.Update()
+		profile.Plan = settings.Plan
+		profile.QuotaBytes = settings.QuotaBytes
+		profile.QuotaReset = settings.QuotaReset
+		profile.SpeedTier = settings.SpeedTier
+		profile.MaxSessions = settings.MaxSessions
+		duration = plan.Duration
+	}
+	if req.Duration != "" || req.Plan == "" {
+		var err error
+		if duration, err = durations.Parse(req.Duration); err != nil {
+			return 0, profile, &fieldError{"duration", "Invalid duration"}
+		}
+	}
+	return duration, profile, nil
+}
+
+func csvTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
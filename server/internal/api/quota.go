@@ -0,0 +1,23 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// resetQuota starts a new quota period for the client, restoring access if
+// the quota was exhausted.
+func (a *AdminAPI) resetQuota(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	client, ok := a.clientManager.ResetQuota(vars["uuid"])
+	if !ok {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(client)
+}
@@ -0,0 +1,333 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+
+	"yagnoetik-vpn/internal/auth"
+	"yagnoetik-vpn/internal/events"
+	"yagnoetik-vpn/internal/metrics"
+	"yagnoetik-vpn/internal/plans"
+)
+
+// Error codes of the redemption endpoint, besides invalid_json,
+// invalid_field and internal_error.
+const (
+	CodeVoucherNotFound    = "voucher_not_found"
+	CodeVoucherRedeemed    = "voucher_redeemed"
+	CodeVoucherRedeeming   = "voucher_redeeming"
+	CodeVoucherExpired     = "voucher_expired"
+	CodeVoucherRevoked     = "voucher_revoked"
+	CodeVoucherPlanDeleted = "voucher_plan_deleted"
+	CodeInvalidClient      = "invalid_client"
+	CodeTooManyRequests    = "too_many_requests"
+)
+
+const (
+	// maxRedeemBody bounds the size of a redemption request.
+	maxRedeemBody = 16 << 10
+	// maxProfileField bounds the name, email and contact of a client
+	// created by redemption.
+	maxProfileField = 256
+)
+
+// RedeemRequest exchanges a voucher code for a new client or, with UUID and
+// Secret, for more time on an existing one.
+type RedeemRequest struct {
+	Code   string `json:"code"`
+	UUID   string `json:"uuid,omitempty"`
+	Secret string `json:"secret,omitempty"`
+	// Name, Email and Contact describe a new client; they are ignored when
+	// extending.
+	Name    string `json:"name,omitempty"`
+	Email   string `json:"email,omitempty"`
+	Contact string `json:"contact,omitempty"`
+}
+
+// RedeemResponse carries the client the voucher created or extended. Secret
+// is only returned for a new client.
+type RedeemResponse struct {
+	UUID      string    `json:"uuid"`
+	Secret    string    `json:"secret,omitempty"`
+	Plan      string    `json:"plan"`
+	ExpiresAt time.Time `json:"expires_at"`
+	ShareURI  string    `json:"share_uri"`
+	Extended  bool      `json:"extended"`
+}
+
+type RedeemOptions struct {
+	// ServerAddr is the public tunnel address written into share links.
+	ServerAddr string
+	// Events receives voucher.redeemed events; nil publishes none.
+	Events *events.Bus
+	// FailureLimit is how many rejected codes or client credentials one
+	// address may send per FailureWindow, default 1m, before its requests
+	// are refused; 0 disables the check.
+	FailureLimit  int
+	FailureWindow time.Duration
+	// TrustedProxies may set X-Real-IP; loopback peers always may.
+	TrustedProxies []netip.Prefix
+	// Logger defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// RedeemAPI serves the public voucher redemption endpoint on the main
+// listener. It needs no API key: the voucher code is the credential.
+type RedeemAPI struct {
+	clientManager *auth.ClientManager
+	plans         *plans.Store
+	options       RedeemOptions
+	failures      *failureLimiter
+}
+
+func NewRedeemAPI(clientManager *auth.ClientManager, store *plans.Store, options RedeemOptions) *RedeemAPI {
+	if options.FailureWindow <= 0 {
+		options.FailureWindow = time.Minute
+	}
+	if options.Logger == nil {
+		options.Logger = slog.Default()
+	}
+	return &RedeemAPI{
+		clientManager: clientManager,
+		plans:         store,
+		options:       options,
+		failures:      newFailureLimiter(options.FailureLimit, options.FailureWindow),
+	}
+}
+
+func (a *RedeemAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		redeemError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	addr := clientAddr(r, a.options.TrustedProxies)
+	if ok, retry := a.failures.allow(addr, time.Now()); !ok {
+		metrics.VoucherRedemptions.WithLabelValues(metrics.RedemptionRateLimited).Inc()
+		w.Header().Set("Retry-After", strconv.Itoa(int(retry.Seconds())+1))
+		redeemError(w, r, http.StatusTooManyRequests, CodeTooManyRequests, "Too many failed attempts, try again later")
+		return
+	}
+
+	var req RedeemRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRedeemBody)).Decode(&req); err != nil {
+		redeemError(w, r, http.StatusBadRequest, CodeInvalidJSON, "Invalid JSON")
+		return
+	}
+	if req.Code == "" {
+		redeemError(w, r, http.StatusBadRequest, CodeInvalidField, "Code is required")
+		return
+	}
+	if len(req.Name) > maxProfileField || len(req.Email) > maxProfileField || len(req.Contact) > maxProfileField {
+		redeemError(w, r, http.StatusBadRequest, CodeInvalidField, fmt.Sprintf("Name, email and contact must be at most %d bytes", maxProfileField))
+		return
+	}
+	if req.UUID != "" && !a.validClient(req.UUID, req.Secret) {
+		a.reject(w, r, addr, http.StatusUnauthorized, CodeInvalidClient, "Unknown client or wrong secret")
+		return
+	}
+
+	var client *auth.Client
+	voucher, err := a.plans.Redeem(req.Code, req.UUID, func(plan plans.Plan) (string, error) {
+		var err error
+		client, err = a.apply(plan, req)
+		if err != nil {
+			return "", err
+		}
+		return client.UUID, nil
+	})
+	switch {
+	case errors.Is(err, plans.ErrVoucherNotFound):
+		a.reject(w, r, addr, http.StatusNotFound, CodeVoucherNotFound, "Unknown voucher code")
+		return
+	case errors.Is(err, plans.ErrRedeeming):
+		// Most likely a repeated submission of a genuine code, so it is not
+		// held against the sender
+		redeemError(w, r, http.StatusConflict, CodeVoucherRedeeming, "Voucher is being redeemed, try again shortly")
+		return
+	case errors.Is(err, plans.ErrRedeemed):
+		a.reject(w, r, addr, http.StatusConflict, CodeVoucherRedeemed, "Voucher has already been redeemed")
+		return
+	case errors.Is(err, plans.ErrExpired):
+		a.reject(w, r, addr, http.StatusGone, CodeVoucherExpired, "Voucher has expired")
+		return
+	case errors.Is(err, plans.ErrRevoked):
+		a.reject(w, r, addr, http.StatusGone, CodeVoucherRevoked, "Voucher has been revoked")
+		return
+	case errors.Is(err, plans.ErrPlanNotFound):
+		// The code is genuine, so the attempt is not held against the sender
+		a.options.Logger.Warn("Voucher grants a deleted plan", "plan", voucher.Plan, "batch", voucher.Batch)
+		metrics.VoucherRedemptions.WithLabelValues(metrics.RedemptionRejected).Inc()
+		redeemError(w, r, http.StatusGone, CodeVoucherPlanDeleted, "The voucher's plan is no longer offered")
+		return
+	case errors.Is(err, auth.ErrClientNotFound):
+		a.reject(w, r, addr, http.StatusUnauthorized, CodeInvalidClient, "Unknown client or wrong secret")
+		return
+	case err != nil:
+		a.options.Logger.Error("Failed to redeem voucher", "error", err)
+		redeemError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to redeem voucher")
+		return
+	}
+
+	result, status := metrics.RedemptionCreated, http.StatusCreated
+	if voucher.Extended {
+		result, status = metrics.RedemptionExtended, http.StatusOK
+	}
+	metrics.VoucherRedemptions.WithLabelValues(result).Inc()
+	a.options.Logger.Info("Voucher redeemed", "plan", voucher.Plan, "batch", voucher.Batch, "client_id", client.UUID, "extended", voucher.Extended)
+	if a.options.Events != nil {
+		a.options.Events.Publish(events.VoucherRedeemed, client.UUID, voucher)
+	}
+
+	resp := RedeemResponse{
+		UUID:      client.UUID,
+		Plan:      voucher.Plan,
+		ExpiresAt: client.ExpiresAt,
+		ShareURI:  newClientConfig(client, a.options.ServerAddr).ShareURI(client.Name),
+		Extended:  voucher.Extended,
+	}
+	if !voucher.Extended {
+		resp.Secret = client.Secret
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// apply creates a client on the plan, or extends the requested one and
+// starts a new quota period for it.
+func (a *RedeemAPI) apply(plan plans.Plan, req RedeemRequest) (*auth.Client, error) {
+	update := plan.Update()
+	if req.UUID == "" {
+		update.Name = &req.Name
+		update.Email = &req.Email
+		update.Contact = &req.Contact
+		return a.clientManager.CreateClient(plan.Duration, update)
+	}
+
+	client, ok := a.clientManager.UpdateClient(req.UUID, update)
+	if !ok {
+		return nil, auth.ErrClientNotFound
+	}
+	if plan.QuotaBytes > 0 {
+		if client, ok = a.clientManager.ResetQuota(req.UUID); !ok {
+			return nil, auth.ErrClientNotFound
+		}
+	}
+	return client, nil
+}
+
+func (a *RedeemAPI) validClient(uuid, secret string) bool {
+	client, exists := a.clientManager.FindClient(uuid)
+	return exists && subtle.ConstantTimeCompare([]byte(client.Secret), []byte(secret)) == 1
+}
+
+// reject answers a request with a wrong code or client and counts it
+// against the sender's address.
+func (a *RedeemAPI) reject(w http.ResponseWriter, r *http.Request, addr string, status int, code, message string) {
+	a.failures.fail(addr, time.Now())
+	metrics.VoucherRedemptions.WithLabelValues(metrics.RedemptionRejected).Inc()
+	redeemError(w, r, status, code, message)
+}
+
+// redeemError writes an ErrorResponse, as the v2 API does.
+func redeemError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	r = withRequestID(w, r)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: APIError{
+		Code:      code,
+		Message:   message,
+		RequestID: requestID(r),
+	}})
+}
+
+// clientAddr is the address a request came from. Behind the local Nginx
+// proxy or a trusted one it is taken from X-Real-IP.
+func clientAddr(r *http.Request, trusted []netip.Prefix) string {
+	peer, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	ip := peer.Addr().Unmap()
+	if ip.IsLoopback() || inPrefixes(trusted, ip) {
+		if forwarded, err := netip.ParseAddr(r.Header.Get("X-Real-IP")); err == nil {
+			return forwarded.String()
+		}
+	}
+	return ip.String()
+}
+
+// failureLimiter counts failures per address in fixed windows and refuses
+// addresses that reached the limit until their window ends.
+type failureLimiter struct {
+	limit     int
+	window    time.Duration
+	counts    map[string]*failureCount
+	lastPrune time.Time
+	mutex     sync.Mutex
+}
+
+type failureCount struct {
+	start time.Time
+	n     int
+}
+
+func newFailureLimiter(limit int, window time.Duration) *failureLimiter {
+	return &failureLimiter{
+		limit:  limit,
+		window: window,
+		counts: make(map[string]*failureCount),
+	}
+}
+
+// allow reports whether the address may make another attempt, and if not,
+// how long until it may.
+func (l *failureLimiter) allow(addr string, now time.Time) (bool, time.Duration) {
+	if l.limit <= 0 {
+		return true, 0
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	c, exists := l.counts[addr]
+	if !exists || now.Sub(c.start) >= l.window || c.n < l.limit {
+		return true, 0
+	}
+	return false, c.start.Add(l.window).Sub(now)
+}
+
+func (l *failureLimiter) fail(addr string, now time.Time) {
+	if l.limit <= 0 {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	// Forget addresses whose window has ended, so the map stays small
+	if now.Sub(l.lastPrune) >= l.window {
+		for a, c := range l.counts {
+			if now.Sub(c.start) >= l.window {
+				delete(l.counts, a)
+			}
+		}
+		l.lastPrune = now
+	}
+
+	c, exists := l.counts[addr]
+	if !exists || now.Sub(c.start) >= l.window {
+		c = &failureCount{start: now}
+		l.counts[addr] = c
+	}
+	c.n++
+}
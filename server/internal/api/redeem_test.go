@@ -0,0 +1,93 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"yagnoetik-vpn/internal/auth"
+	"yagnoetik-vpn/internal/plans"
+)
+
+func redeem(handler http.Handler, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/api/redeem", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func errorCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var resp ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decoding %q: %v", w.Body, err)
+	}
+	return resp.Error.Code
+}
+
+func TestRedeemAPI(t *testing.T) {
+	cm := auth.NewClientManager()
+	store := plans.NewStore()
+	store.PutPlan(plans.Plan{Name: "month", Duration: 30 * 24 * time.Hour})
+	_, vouchers, err := store.Generate("month", 2, time.Time{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewRedeemAPI(cm, store, RedeemOptions{ServerAddr: "vpn.example.com:443"})
+
+	w := redeem(handler, `{"code":"`+strings.ToLower(vouchers[0].Code)+`","name":"Ivan"}`)
+	var created RedeemResponse
+	json.Unmarshal(w.Body.Bytes(), &created)
+	if w.Code != http.StatusCreated || created.Secret == "" || created.Extended {
+		t.Fatalf("redeeming a new code: %d %s", w.Code, w.Body)
+	}
+
+	for _, test := range []struct {
+		name, body string
+		status     int
+		code       string
+	}{
+		{"redeemed", `{"code":"` + vouchers[0].Code + `"}`, http.StatusConflict, CodeVoucherRedeemed},
+		{"unknown", `{"code":"0000-0000-0000-0000"}`, http.StatusNotFound, CodeVoucherNotFound},
+		{"wrong secret", `{"code":"` + vouchers[1].Code + `","uuid":"` + created.UUID + `","secret":"x"}`, http.StatusUnauthorized, CodeInvalidClient},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			w := redeem(handler, test.body)
+			if w.Code != test.status || errorCode(t, w) != test.code {
+				t.Errorf("got %d %s, want %d %s", w.Code, w.Body, test.status, test.code)
+			}
+		})
+	}
+
+	w = redeem(handler, `{"code":"`+vouchers[1].Code+`","uuid":"`+created.UUID+`","secret":"`+created.Secret+`"}`)
+	var extended RedeemResponse
+	json.Unmarshal(w.Body.Bytes(), &extended)
+	if w.Code != http.StatusOK || !extended.Extended || extended.Secret != "" || !extended.ExpiresAt.After(created.ExpiresAt) {
+		t.Errorf("extending: %d %s", w.Code, w.Body)
+	}
+}
+
+// TestRedeemDeletedPlan checks a voucher whose plan is missing from the
+// saved store.
+func TestRedeemDeletedPlan(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plans.json")
+	saved := `{"plans":[],"batches":[],"vouchers":[{"code":"ABCD-EFGH-JKMN-PQRS","plan":"gone","batch":"b","status":"unredeemed"}]}`
+	if err := os.WriteFile(path, []byte(saved), 0600); err != nil {
+		t.Fatal(err)
+	}
+	store, err := plans.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewRedeemAPI(auth.NewClientManager(), store, RedeemOptions{})
+
+	w := redeem(handler, `{"code":"ABCD-EFGH-JKMN-PQRS"}`)
+	if w.Code != http.StatusGone || errorCode(t, w) != CodeVoucherPlanDeleted {
+		t.Errorf("got %d %s, want 410 %s", w.Code, w.Body, CodeVoucherPlanDeleted)
+	}
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"yagnoetik-vpn/internal/auth"
+	"yagnoetik-vpn/internal/history"
+	"yagnoetik-vpn/internal/retention"
+	"yagnoetik-vpn/internal/tunnel"
+	"yagnoetik-vpn/internal/usage"
+
+	"github.com/gorilla/mux"
+)
+
+type RetentionResponse struct {
+	NoLog          bool             `json:"no_log"`
+	RemoteIP       retention.IPMode `json:"remote_ip"`
+	SessionHistory string           `json:"session_history"` // "0s" when disabled
+	Usage          []UsageRetention `json:"usage"`
+	Audit          string           `json:"audit"` // "0s" keeps entries until the size cap
+}
+
+type UsageRetention struct {
+	Step      string `json:"step"`
+	Retention string `json:"retention"`
+}
+
+// ClientDataExport is everything the server holds about one client.
+type ClientDataExport struct {
+	Client         ClientRecord             `json:"client"`
+	Transitions    []auth.Transition        `json:"transitions"`
+	LiveSessions   []tunnel.SessionInfo     `json:"live_sessions"`
+	SessionHistory []history.Record         `json:"session_history"`
+	Usage          map[string][]usage.Point `json:"usage"`
+}
+
+func (a *AdminAPI) retentionPolicy(w http.ResponseWriter, r *http.Request) {
+	policy := a.retention.Effective()
+	resp := RetentionResponse{
+		NoLog:          policy.NoLog,
+		RemoteIP:       policy.RemoteIP,
+		SessionHistory: policy.SessionHistory.String(),
+		Usage:          make([]UsageRetention, 0, len(policy.Usage)),
+		Audit:          policy.Audit.String(),
+	}
+	for _, res := range policy.Usage {
+		resp.Usage = append(resp.Usage, UsageRetention{Step: res.Step.String(), Retention: res.Retention.String()})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// exportClientData returns everything recorded about the client, without
+// its credentials.
+func (a *AdminAPI) exportClientData(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uuid := vars["uuid"]
+
+	client, exists := a.clientManager.FindClient(uuid)
+	if !exists {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	}
+
+	export := ClientDataExport{
+		Client:         newClientRecord(client, false),
+		Transitions:    a.clientManager.Transitions(uuid),
+		LiveSessions:   make([]tunnel.SessionInfo, 0),
+		SessionHistory: make([]history.Record, 0),
+		Usage:          make(map[string][]usage.Point),
+	}
+	for _, session := range a.tunnelServer.Sessions() {
+		if session.ClientUUID == uuid {
+			export.LiveSessions = append(export.LiveSessions, session)
+		}
+	}
+	if a.history != nil {
+		export.SessionHistory = a.history.Query(history.Query{ClientUUID: uuid})
+	}
+	if a.usage != nil {
+		export.Usage = a.usage.Series(uuid)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="client-`+uuid+`-data.json"`)
+	json.NewEncoder(w).Encode(export)
+}
+
+// eraseClientData deletes the client, disconnecting its sessions, and
+// removes every record about it, including its transition log.
+func (a *AdminAPI) eraseClientData(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uuid := vars["uuid"]
+
+	if !a.clientManager.DeleteClient(uuid) {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	}
+	a.clientManager.EraseTransitions(uuid)
+	if a.history != nil {
+		a.history.DeleteClient(uuid)
+	}
+	if a.usage != nil {
+		a.usage.Delete(uuid)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"yagnoetik-vpn/internal/history"
+	"yagnoetik-vpn/internal/tunnel"
+
+	"github.com/gorilla/mux"
+)
+
+type CloseSessionsResponse struct {
+	Closed int `json:"closed"`
+}
+
+// listSessions returns live sessions, newest first. ?client=<uuid> limits
+// the list to one client.
+func (a *AdminAPI) listSessions(w http.ResponseWriter, r *http.Request) {
+	clientUUID := r.URL.Query().Get("client")
+
+	sessions := make([]tunnel.SessionInfo, 0)
+	for _, session := range a.tunnelServer.Sessions() {
+		if clientUUID == "" || session.ClientUUID == clientUUID {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].StartedAt.After(sessions[j].StartedAt)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
+
+// closeSession kicks one session. The optional ?reason= is sent to the
+// client as the stream error.
+func (a *AdminAPI) closeSession(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	if !a.tunnelServer.CloseSession(id, closeReason(r)) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *AdminAPI) closeClientSessions(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uuid := vars["uuid"]
+
+	closed := a.tunnelServer.CloseClientSessions(uuid, closeReason(r))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(CloseSessionsResponse{Closed: closed})
+}
+
+// sessionHistory returns finished sessions, newest first. Filters:
+// ?client=<uuid>, ?from= and ?to= (RFC 3339, by end time) and ?limit=
+// (default 100). Page backwards by passing the last ended_at as ?to=.
+func (a *AdminAPI) sessionHistory(w http.ResponseWriter, r *http.Request) {
+	a.writeSessionHistory(w, r, r.URL.Query().Get("client"))
+}
+
+func (a *AdminAPI) clientSessionHistory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uuid := vars["uuid"]
+
+	if _, exists := a.clientManager.FindClient(uuid); !exists {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	}
+	a.writeSessionHistory(w, r, uuid)
+}
+
+func (a *AdminAPI) writeSessionHistory(w http.ResponseWriter, r *http.Request, clientUUID string) {
+	if a.history == nil {
+		http.Error(w, "Session history is disabled", http.StatusNotFound)
+		return
+	}
+
+	values := r.URL.Query()
+	query := history.Query{ClientUUID: clientUUID, Limit: 100}
+
+	for name, dst := range map[string]*time.Time{"from": &query.From, "to": &query.To} {
+		if v := values.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				http.Error(w, "Invalid "+name, http.StatusBadRequest)
+				return
+			}
+			*dst = t
+		}
+	}
+	if v := values.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		query.Limit = limit
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.history.Query(query))
+}
+
+func closeReason(r *http.Request) string {
+	if reason := r.URL.Query().Get("reason"); reason != "" {
+		return reason
+	}
+	return tunnel.ReasonKicked
+}
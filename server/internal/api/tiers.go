@@ -0,0 +1,53 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"yagnoetik-vpn/internal/shaping"
+
+	"github.com/gorilla/mux"
+)
+
+func (a *AdminAPI) listTiers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.shaper.Tiers())
+}
+
+// putTier creates or replaces a speed tier. Sessions of clients on the tier
+// pick up the new limits without reconnecting.
+func (a *AdminAPI) putTier(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var tier shaping.Tier
+	if err := json.NewDecoder(r.Body).Decode(&tier); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	tier.Name = vars["name"]
+
+	if err := a.shaper.SetTier(tier); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tier)
+}
+
+func (a *AdminAPI) deleteTier(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	if err := a.shaper.DeleteTier(vars["name"]); err != nil {
+		http.Error(w, "Tier not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validTier reports whether a client may be assigned the tier. The empty
+// name clears the assignment.
+func (a *AdminAPI) validTier(name string) bool {
+	return name == "" || a.shaper.HasTier(name)
+}
@@ -0,0 +1,375 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"yagnoetik-vpn/internal/auth"
+	"yagnoetik-vpn/internal/durations"
+)
+
+// maxBulkCreate bounds a single bulk provisioning request.
+const maxBulkCreate = 1000
+
+// maxImportBody bounds the size of an import upload.
+const maxImportBody = 32 << 20
+
+type BulkCreateRequest struct {
+	Count      int      `json:"count"`
+	Duration   string   `json:"duration"`
+	NamePrefix string   `json:"name_prefix,omitempty"` // names become "<prefix> 1", "<prefix> 2", ...
+	Tags       []string `json:"tags,omitempty"`
+	Notes      string   `json:"notes,omitempty"`
+	QuotaBytes int64    `json:"quota_bytes,omitempty"`
+	SpeedTier  string   `json:"speed_tier,omitempty"`
+}
+
+// ClientRecord is the export and import representation of a client. Secret
+// and Key are only exported on request; on import they are kept for
+// existing clients and generated for new ones when missing.
+type ClientRecord struct {
+	UUID          string             `json:"uuid"`
+	Name          string             `json:"name"`
+	Email         string             `json:"email"`
+	Contact       string             `json:"contact"`
+	Tags          []string           `json:"tags"`
+	Notes         string             `json:"notes"`
+	State         auth.ClientState   `json:"state"`
+	Blocked       bool               `json:"blocked"`
+	CreatedAt     time.Time          `json:"created_at"`
+	ExpiresAt     time.Time          `json:"expires_at"`
+	QuotaBytes    int64              `json:"quota_bytes"`
+	QuotaReset    auth.QuotaReset    `json:"quota_reset"`
+	PeriodStart   time.Time          `json:"period_start"`
+	PeriodBytes   int64              `json:"period_bytes"`
+	SpeedTier     string             `json:"speed_tier"`
+	Plan          string             `json:"plan"`
+	MaxSessions   int                `json:"max_sessions"`
+	SessionPolicy auth.SessionPolicy `json:"session_policy"`
+	BytesUp       int64              `json:"bytes_up"`
+	BytesDown     int64              `json:"bytes_down"`
+	Secret        string             `json:"secret,omitempty"`
+	Key           []byte             `json:"key,omitempty"`
+}
+
+var csvHeader = []string{
+	"uuid", "name", "email", "contact", "tags", "notes", "state", "blocked",
+	"created_at", "expires_at", "quota_bytes", "quota_reset", "period_start", "period_bytes", "speed_tier", "plan", "max_sessions", "session_policy",
+	"bytes_up", "bytes_down",
+	"secret", "key",
+}
+
+func newClientRecord(c *auth.Client, withSecrets bool) ClientRecord {
+	record := ClientRecord{
+		UUID:          c.UUID,
+		Name:          c.Name,
+		Email:         c.Email,
+		Contact:       c.Contact,
+		Tags:          c.Tags,
+		Notes:         c.Notes,
+		State:         c.State,
+		Blocked:       c.Blocked,
+		CreatedAt:     c.CreatedAt,
+		ExpiresAt:     c.ExpiresAt,
+		QuotaBytes:    c.QuotaBytes,
+		QuotaReset:    c.QuotaReset,
+		PeriodStart:   c.PeriodStart,
+		PeriodBytes:   c.PeriodBytes,
+		SpeedTier:     c.SpeedTier,
+		Plan:          c.Plan,
+		MaxSessions:   c.MaxSessions,
+		SessionPolicy: c.SessionPolicy,
+		BytesUp:       c.BytesUp,
+		BytesDown:     c.BytesDown,
+	}
+	if withSecrets {
+		record.Secret = c.Secret
+		record.Key = c.Key
+	}
+	return record
+}
+
+func (rec ClientRecord) client() *auth.Client {
+	return &auth.Client{
+		UUID:          rec.UUID,
+		Secret:        rec.Secret,
+		Key:           rec.Key,
+		State:         rec.State,
+		CreatedAt:     rec.CreatedAt,
+		ExpiresAt:     rec.ExpiresAt,
+		Blocked:       rec.Blocked,
+		Name:          rec.Name,
+		Email:         rec.Email,
+		Contact:       rec.Contact,
+		Tags:          rec.Tags,
+		Notes:         rec.Notes,
+		QuotaBytes:    rec.QuotaBytes,
+		QuotaReset:    rec.QuotaReset,
+		PeriodStart:   rec.PeriodStart,
+		PeriodBytes:   rec.PeriodBytes,
+		SpeedTier:     rec.SpeedTier,
+		Plan:          rec.Plan,
+		MaxSessions:   rec.MaxSessions,
+		SessionPolicy: rec.SessionPolicy,
+		BytesUp:       rec.BytesUp,
+		BytesDown:     rec.BytesDown,
+	}
+}
+
+func (rec ClientRecord) csvRow() []string {
+	return []string{
+		rec.UUID, rec.Name, rec.Email, rec.Contact, strings.Join(rec.Tags, ";"), rec.Notes,
+		string(rec.State), strconv.FormatBool(rec.Blocked),
+		rec.CreatedAt.Format(time.RFC3339), rec.ExpiresAt.Format(time.RFC3339),
+		strconv.FormatInt(rec.QuotaBytes, 10), string(rec.QuotaReset),
+		rec.PeriodStart.Format(time.RFC3339), strconv.FormatInt(rec.PeriodBytes, 10), rec.SpeedTier, rec.Plan,
+		strconv.Itoa(rec.MaxSessions), string(rec.SessionPolicy),
+		strconv.FormatInt(rec.BytesUp, 10), strconv.FormatInt(rec.BytesDown, 10),
+		rec.Secret, base64.StdEncoding.EncodeToString(rec.Key),
+	}
+}
+
+func (a *AdminAPI) bulkCreateClients(w http.ResponseWriter, r *http.Request) {
+	var req BulkCreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.Count < 1 || req.Count > maxBulkCreate {
+		http.Error(w, fmt.Sprintf("count must be between 1 and %d", maxBulkCreate), http.StatusBadRequest)
+		return
+	}
+	if req.QuotaBytes < 0 {
+		http.Error(w, "Invalid quota", http.StatusBadRequest)
+		return
+	}
+	if !a.validTier(req.SpeedTier) {
+		http.Error(w, "Unknown speed tier", http.StatusBadRequest)
+		return
+	}
+
+	duration, err := durations.Parse(req.Duration)
+	if err != nil {
+		http.Error(w, "Invalid duration", http.StatusBadRequest)
+		return
+	}
+
+	resp := make([]CreateClientResponse, 0, req.Count)
+	for i := 1; i <= req.Count; i++ {
+		name := ""
+		if req.NamePrefix != "" {
+			name = fmt.Sprintf("%s %d", req.NamePrefix, i)
+		}
+		profile := auth.ClientUpdate{
+			Name:       &name,
+			Tags:       &req.Tags,
+			Notes:      &req.Notes,
+			QuotaBytes: &req.QuotaBytes,
+			SpeedTier:  &req.SpeedTier,
+		}
+
+		client, err := a.clientManager.CreateClient(duration, profile)
+		if err != nil {
+			http.Error(w, "Failed to create client", http.StatusInternalServerError)
+			return
+		}
+		resp = append(resp, CreateClientResponse{
+			UUID:      client.UUID,
+			Secret:    client.Secret,
+			ExpiresAt: client.ExpiresAt,
+			ShareURI:  newClientConfig(client, a.serverAddr).ShareURI(client.Name),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// exportClients writes the clients matching the list filters as JSON or,
+// with ?format=csv, as CSV. Credentials are included only with
+// ?include_secrets=true, which is required for migrating to another server.
+func (a *AdminAPI) exportClients(w http.ResponseWriter, r *http.Request) {
+	query, err := parseClientQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query.Limit, query.Cursor = 0, ""
+
+	page, err := a.clientManager.QueryClients(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	withSecrets := r.URL.Query().Get("include_secrets") == "true"
+	records := make([]ClientRecord, 0, len(page.Clients))
+	for _, c := range page.Clients {
+		records = append(records, newClientRecord(c, withSecrets))
+	}
+
+	stamp := time.Now().Format("20060102-150405")
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="clients-%s.json"`, stamp))
+		json.NewEncoder(w).Encode(records)
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="clients-%s.csv"`, stamp))
+		cw := csv.NewWriter(w)
+		cw.Write(csvHeader)
+		for _, rec := range records {
+			cw.Write(rec.csvRow())
+		}
+		cw.Flush()
+	default:
+		http.Error(w, "Unknown format", http.StatusBadRequest)
+	}
+}
+
+// importClients accepts a JSON array of ClientRecord or, with a text/csv
+// content type, CSV in the export layout. ?dry_run=true validates without
+// storing; ?on_conflict=skip|overwrite|fail (default fail) decides what
+// happens to UUIDs that already exist.
+func (a *AdminAPI) importClients(w http.ResponseWriter, r *http.Request) {
+	body := http.MaxBytesReader(w, r.Body, maxImportBody)
+
+	var records []ClientRecord
+	var err error
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "text/csv" {
+		records, err = readCSVRecords(body)
+	} else {
+		err = json.NewDecoder(body).Decode(&records)
+	}
+	if err != nil {
+		http.Error(w, "Invalid import data: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	mode := auth.ConflictMode(r.URL.Query().Get("on_conflict"))
+	if mode == "" {
+		mode = auth.ConflictFail
+	}
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+
+	clients := make([]*auth.Client, 0, len(records))
+	for _, rec := range records {
+		clients = append(clients, rec.client())
+	}
+
+	report, err := a.clientManager.ImportClients(clients, mode, dryRun)
+	if report == nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		w.WriteHeader(http.StatusConflict)
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+func readCSVRecords(r io.Reader) ([]ClientRecord, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	if _, ok := columns["expires_at"]; !ok {
+		return nil, fmt.Errorf("missing expires_at column")
+	}
+
+	records := make([]ClientRecord, 0)
+	for line := 2; ; line++ {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(row) {
+				return strings.TrimSpace(row[i])
+			}
+			return ""
+		}
+
+		rec := ClientRecord{
+			UUID:          field("uuid"),
+			Name:          field("name"),
+			Email:         field("email"),
+			Contact:       field("contact"),
+			Notes:         field("notes"),
+			State:         auth.ClientState(field("state")),
+			QuotaReset:    auth.QuotaReset(field("quota_reset")),
+			SpeedTier:     field("speed_tier"),
+			Plan:          field("plan"),
+			SessionPolicy: auth.SessionPolicy(field("session_policy")),
+			Secret:        field("secret"),
+			Tags:          []string{},
+		}
+		if tags := field("tags"); tags != "" {
+			rec.Tags = strings.Split(tags, ";")
+		}
+		if v := field("blocked"); v != "" {
+			if rec.Blocked, err = strconv.ParseBool(v); err != nil {
+				return nil, fmt.Errorf("line %d: invalid blocked", line)
+			}
+		}
+		if v := field("created_at"); v != "" {
+			if rec.CreatedAt, err = time.Parse(time.RFC3339, v); err != nil {
+				return nil, fmt.Errorf("line %d: invalid created_at", line)
+			}
+		}
+		if rec.ExpiresAt, err = time.Parse(time.RFC3339, field("expires_at")); err != nil {
+			return nil, fmt.Errorf("line %d: invalid expires_at", line)
+		}
+		if v := field("period_start"); v != "" {
+			if rec.PeriodStart, err = time.Parse(time.RFC3339, v); err != nil {
+				return nil, fmt.Errorf("line %d: invalid period_start", line)
+			}
+		}
+		for name, dst := range map[string]*int64{
+			"quota_bytes":  &rec.QuotaBytes,
+			"period_bytes": &rec.PeriodBytes,
+			"bytes_up":     &rec.BytesUp,
+			"bytes_down":   &rec.BytesDown,
+		} {
+			if v := field(name); v != "" {
+				if *dst, err = strconv.ParseInt(v, 10, 64); err != nil {
+					return nil, fmt.Errorf("line %d: invalid %s", line, name)
+				}
+			}
+		}
+		if v := field("max_sessions"); v != "" {
+			if rec.MaxSessions, err = strconv.Atoi(v); err != nil {
+				return nil, fmt.Errorf("line %d: invalid max_sessions", line)
+			}
+		}
+		if v := field("key"); v != "" {
+			if rec.Key, err = base64.StdEncoding.DecodeString(v); err != nil {
+				return nil, fmt.Errorf("line %d: invalid key", line)
+			}
+		}
+
+		records = append(records, rec)
+	}
+}
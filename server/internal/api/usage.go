@@ -0,0 +1,89 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"yagnoetik-vpn/internal/durations"
+	"yagnoetik-vpn/internal/usage"
+
+	"github.com/gorilla/mux"
+)
+
+// defaultUsageRange is the period returned when from is omitted.
+const defaultUsageRange = 24 * time.Hour
+
+type UsageResponse struct {
+	UUID   string        `json:"uuid"`
+	From   time.Time     `json:"from"`
+	To     time.Time     `json:"to"`
+	Step   string        `json:"step"`
+	Points []usage.Point `json:"points"`
+}
+
+// clientUsage returns the client's traffic history. from and to are RFC 3339
+// times and default to the last 24 hours; step such as "5m", "1h" or "1d"
+// defaults to the finest resolution still kept for the range.
+func (a *AdminAPI) clientUsage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uuid := vars["uuid"]
+
+	if a.usage == nil {
+		http.Error(w, "Traffic history is disabled", http.StatusNotFound)
+		return
+	}
+	if _, exists := a.clientManager.FindClient(uuid); !exists {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	}
+
+	q := r.URL.Query()
+	to := time.Now()
+	if v := q.Get("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, "Invalid to", http.StatusBadRequest)
+			return
+		}
+		to = t
+	}
+	from := to.Add(-defaultUsageRange)
+	if v := q.Get("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, "Invalid from", http.StatusBadRequest)
+			return
+		}
+		from = t
+	}
+	var step time.Duration
+	if v := q.Get("step"); v != "" {
+		d, err := durations.Parse(v)
+		if err != nil || d <= 0 {
+			http.Error(w, "Invalid step", http.StatusBadRequest)
+			return
+		}
+		step = d
+	}
+
+	points, step, err := a.usage.Query(uuid, from, to, step)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp := UsageResponse{
+		UUID:   uuid,
+		From:   from,
+		To:     to,
+		Step:   step.String(),
+		Points: points,
+	}
+	if len(points) > 0 {
+		resp.From = points[0].Time
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
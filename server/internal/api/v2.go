@@ -0,0 +1,379 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"yagnoetik-vpn/internal/auth"
+	"yagnoetik-vpn/internal/durations"
+	"yagnoetik-vpn/internal/idempotency"
+	"yagnoetik-vpn/internal/tunnel"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// idempotencyTTL is how long a response is replayed for retries.
+	idempotencyTTL = 24 * time.Hour
+	// maxIdempotentBody bounds the requests whose body is fingerprinted.
+	maxIdempotentBody = 1 << 20
+)
+
+// ClientResponse is a client as returned by v2, with its share link. Keys
+// that may not see credentials get neither the secret nor the link.
+type ClientResponse struct {
+	*auth.Client
+	ShareURI string `json:"share_uri,omitempty"`
+}
+
+// ClientListResponse is a page of GET /api/v2/clients.
+type ClientListResponse struct {
+	Clients    []*auth.Client `json:"clients"`
+	Total      int            `json:"total"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+// setupV2Routes registers the v2 client API. Compared to v1, errors are
+// JSON ErrorResponses, POST requests accept an Idempotency-Key header and
+// single-client responses carry an ETag that PATCH and DELETE can be made
+// conditional on with If-Match.
+func (a *AdminAPI) setupV2Routes(r *mux.Router) {
+	r.Handle("/api/v2/clients", a.idempotent(a.createClientV2)).Methods("POST")
+	r.HandleFunc("/api/v2/clients", a.listClientsV2).Methods("GET")
+	r.HandleFunc("/api/v2/clients/{uuid}", a.getClientV2).Methods("GET")
+	r.HandleFunc("/api/v2/clients/{uuid}", a.updateClientV2).Methods("PATCH")
+	r.HandleFunc("/api/v2/clients/{uuid}", a.deleteClientV2).Methods("DELETE")
+	r.Handle("/api/v2/clients/{uuid}/rotate", a.idempotent(a.rotateClientV2)).Methods("POST")
+	r.Handle("/api/v2/clients/{uuid}/block", a.idempotent(a.blockClientV2)).Methods("POST")
+	r.Handle("/api/v2/clients/{uuid}/unblock", a.idempotent(a.unblockClientV2)).Methods("POST")
+	r.Handle("/api/v2/clients/{uuid}/extend", a.idempotent(a.extendClientV2)).Methods("POST")
+}
+
+func (a *AdminAPI) createClientV2(w http.ResponseWriter, r *http.Request) {
+	var req CreateClientRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidJSON, "Invalid JSON", nil)
+		return
+	}
+	duration, profile, ferr := a.clientProfile(req)
+	if ferr == nil && duration <= 0 {
+		ferr = &fieldError{"duration", "Invalid duration"}
+	}
+	if ferr != nil {
+		invalidField(w, r, ferr)
+		return
+	}
+
+	client, err := a.clientManager.CreateClient(duration, profile)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to create client", nil)
+		return
+	}
+
+	w.Header().Set("Location", "/api/v2/clients/"+client.UUID)
+	a.writeClient(w, r, http.StatusCreated, client)
+}
+
+func (a *AdminAPI) listClientsV2(w http.ResponseWriter, r *http.Request) {
+	query, err := parseClientQuery(r.URL.Query())
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidField, err.Error(), nil)
+		return
+	}
+	page, err := a.clientManager.QueryClients(query)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidField, err.Error(), map[string]any{"field": "cursor"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ClientListResponse{
+		Clients:    clientsFor(r, page.Clients),
+		Total:      page.Total,
+		NextCursor: page.NextCursor,
+	})
+}
+
+func (a *AdminAPI) getClientV2(w http.ResponseWriter, r *http.Request) {
+	client, ok := a.clientManager.FindClient(mux.Vars(r)["uuid"])
+	if !ok {
+		clientNotFound(w, r)
+		return
+	}
+	if match := r.Header.Get("If-None-Match"); match != "" && etagMatches(match, client.Revision) {
+		w.Header().Set("ETag", clientETag(client))
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	a.writeClient(w, r, http.StatusOK, client)
+}
+
+func (a *AdminAPI) updateClientV2(w http.ResponseWriter, r *http.Request) {
+	revision, ok := ifMatchRevision(w, r)
+	if !ok {
+		return
+	}
+
+	var req UpdateClientRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidJSON, "Invalid JSON", nil)
+		return
+	}
+	update, ferr := a.clientUpdate(req)
+	if ferr != nil {
+		invalidField(w, r, ferr)
+		return
+	}
+
+	client, err := a.clientManager.UpdateClientRevision(mux.Vars(r)["uuid"], revision, update)
+	if err != nil {
+		clientError(w, r, client, err)
+		return
+	}
+	a.writeClient(w, r, http.StatusOK, client)
+}
+
+func (a *AdminAPI) deleteClientV2(w http.ResponseWriter, r *http.Request) {
+	revision, ok := ifMatchRevision(w, r)
+	if !ok {
+		return
+	}
+
+	uuid := mux.Vars(r)["uuid"]
+	if err := a.clientManager.DeleteClientRevision(uuid, revision); err != nil {
+		client, _ := a.clientManager.FindClient(uuid)
+		clientError(w, r, client, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *AdminAPI) rotateClientV2(w http.ResponseWriter, r *http.Request) {
+	client, err := a.clientManager.RotateCredentials(mux.Vars(r)["uuid"])
+	if errors.Is(err, auth.ErrClientNotFound) {
+		clientNotFound(w, r)
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to rotate credentials", nil)
+		return
+	}
+	a.tunnelServer.CloseClientSessions(client.UUID, tunnel.ReasonRevoked)
+	a.writeClient(w, r, http.StatusOK, client)
+}
+
+func (a *AdminAPI) blockClientV2(w http.ResponseWriter, r *http.Request) {
+	uuid := mux.Vars(r)["uuid"]
+	if !a.clientManager.BlockClient(uuid) {
+		clientNotFound(w, r)
+		return
+	}
+	a.writeCurrentClient(w, r, uuid)
+}
+
+func (a *AdminAPI) unblockClientV2(w http.ResponseWriter, r *http.Request) {
+	uuid := mux.Vars(r)["uuid"]
+	if !a.clientManager.UnblockClient(uuid) {
+		clientNotFound(w, r)
+		return
+	}
+	a.writeCurrentClient(w, r, uuid)
+}
+
+func (a *AdminAPI) extendClientV2(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Duration string `json:"duration"` // e.g., "30d"
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidJSON, "Invalid JSON", nil)
+		return
+	}
+	extend, err := durations.Parse(req.Duration)
+	if err != nil || extend <= 0 {
+		invalidField(w, r, &fieldError{"duration", "Invalid duration"})
+		return
+	}
+
+	client, ok := a.clientManager.UpdateClient(mux.Vars(r)["uuid"], auth.ClientUpdate{Extend: extend})
+	if !ok {
+		clientNotFound(w, r)
+		return
+	}
+	a.writeClient(w, r, http.StatusOK, client)
+}
+
+func (a *AdminAPI) writeCurrentClient(w http.ResponseWriter, r *http.Request, uuid string) {
+	client, ok := a.clientManager.FindClient(uuid)
+	if !ok {
+		clientNotFound(w, r)
+		return
+	}
+	a.writeClient(w, r, http.StatusOK, client)
+}
+
+func (a *AdminAPI) writeClient(w http.ResponseWriter, r *http.Request, status int, client *auth.Client) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", clientETag(client))
+	w.WriteHeader(status)
+	resp := ClientResponse{Client: client}
+	if canSeeSecrets(r) {
+		resp.ShareURI = newClientConfig(client, a.serverAddr).ShareURI(client.Name)
+	} else {
+		resp.Client = withoutSecret(client)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func clientNotFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusNotFound, CodeNotFound, "Client not found", map[string]any{"uuid": mux.Vars(r)["uuid"]})
+}
+
+// clientError reports a failed conditional change. client is the current
+// state, if any, so that the caller can see the ETag it should retry with.
+func clientError(w http.ResponseWriter, r *http.Request, client *auth.Client, err error) {
+	if errors.Is(err, auth.ErrRevisionMismatch) && client != nil {
+		writeError(w, r, http.StatusPreconditionFailed, CodePreconditionFailed,
+			"Client has been modified since it was read", map[string]any{"etag": clientETag(client)})
+		return
+	}
+	clientNotFound(w, r)
+}
+
+func invalidField(w http.ResponseWriter, r *http.Request, err *fieldError) {
+	writeError(w, r, http.StatusBadRequest, CodeInvalidField, err.Message, map[string]any{"field": err.Field})
+}
+
+func clientETag(client *auth.Client) string {
+	return `"` + strconv.FormatUint(client.Revision, 10) + `"`
+}
+
+// ifMatchRevision returns the revision named by the If-Match header, or 0
+// when the header is absent or "*" so that the change is unconditional.
+func ifMatchRevision(w http.ResponseWriter, r *http.Request) (uint64, bool) {
+	match := strings.TrimSpace(r.Header.Get("If-Match"))
+	if match == "" || match == "*" {
+		return 0, true
+	}
+	revision, err := strconv.ParseUint(strings.Trim(match, `"`), 10, 64)
+	if err != nil || revision == 0 || !strings.HasPrefix(match, `"`) {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidField, "Invalid If-Match header", map[string]any{"field": "If-Match"})
+		return 0, false
+	}
+	return revision, true
+}
+
+// etagMatches reports whether an If-None-Match list names the revision.
+func etagMatches(header string, revision uint64) bool {
+	etag := `"` + strconv.FormatUint(revision, 10) + `"`
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// responseRecorder passes a response through while keeping a copy of it.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// idempotent makes a POST handler safe to retry with an Idempotency-Key
+// header: the first response is stored and replayed for requests with the
+// same key and body. Keys are scoped to the API key that used them. Server
+// errors are not stored, so that such requests can be retried.
+func (a *AdminAPI) idempotent(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Idempotency-Key")
+		if header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(header) > 255 {
+			writeError(w, r, http.StatusBadRequest, CodeInvalidIdempotencyKey, "Idempotency-Key must be at most 255 characters", nil)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxIdempotentBody+1))
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, CodeInvalidJSON, "Failed to read request body", nil)
+			return
+		}
+		if len(body) > maxIdempotentBody {
+			writeError(w, r, http.StatusRequestEntityTooLarge, CodeRequestTooLarge, "Request body is too large for an idempotent request", nil)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		keyID := ""
+		if key, ok := requestKey(r); ok {
+			keyID = key.ID
+		}
+		scoped := keyID + "\x00" + header
+		sum := sha256.Sum256(body)
+		fingerprint := r.Method + " " + r.URL.Path + " " + hex.EncodeToString(sum[:])
+
+		stored, err := a.idempotency.Begin(scoped, fingerprint)
+		switch {
+		case errors.Is(err, idempotency.ErrMismatch):
+			writeError(w, r, http.StatusUnprocessableEntity, CodeIdempotencyMismatch, err.Error(), nil)
+			return
+		case errors.Is(err, idempotency.ErrInProgress):
+			writeError(w, r, http.StatusConflict, CodeIdempotencyInUse, err.Error(), nil)
+			return
+		case stored != nil:
+			for name, values := range stored.Header {
+				w.Header()[name] = values
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(stored.Status)
+			w.Write(stored.Body)
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		if rec.status >= 500 {
+			a.idempotency.Abort(scoped)
+			return
+		}
+		a.idempotency.Finish(scoped, &idempotency.Response{
+			Status: rec.status,
+			Header: storedHeader(w.Header()),
+			Body:   rec.body.Bytes(),
+		})
+	})
+}
+
+// storedHeader keeps the response headers worth replaying. The request ID
+// is left out, since the retry has its own.
+func storedHeader(h http.Header) http.Header {
+	stored := make(http.Header)
+	for _, name := range []string{"Content-Type", "Location", "ETag"} {
+		if v := h.Values(name); len(v) > 0 {
+			stored[http.CanonicalHeaderKey(name)] = append([]string(nil), v...)
+		}
+	}
+	return stored
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"yagnoetik-vpn/internal/adminkeys"
+	"yagnoetik-vpn/internal/auth"
+)
+
+// newTestAPI returns the admin API's router with an admin key "k" and a
+// read-only key "ro".
+func newTestAPI(t *testing.T) http.Handler {
+	t.Helper()
+	keys := adminkeys.NewStore()
+	keys.AddStatic("admin", adminkeys.RoleAdmin, "k")
+	keys.AddStatic("viewer", adminkeys.RoleReadOnly, "ro")
+	return NewAdminAPI(auth.NewClientManager(), nil, keys, Options{}).SetupRoutes()
+}
+
+// call makes a request with the admin key and the given headers, given as
+// name-value pairs.
+func call(handler http.Handler, method, path, body string, headers ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("X-API-Key", "k")
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func decodeClient(t *testing.T, w *httptest.ResponseRecorder) ClientResponse {
+	t.Helper()
+	var resp ClientResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decoding %q: %v", w.Body, err)
+	}
+	return resp
+}
+
+func TestIdempotentCreate(t *testing.T) {
+	handler := newTestAPI(t)
+	body := `{"duration":"30d","name":"Ivan"}`
+
+	first := call(handler, "POST", "/api/v2/clients", body, "Idempotency-Key", "create-1")
+	if first.Code != http.StatusCreated {
+		t.Fatalf("first request: %d %s", first.Code, first.Body)
+	}
+	created := decodeClient(t, first)
+
+	for _, test := range []struct {
+		name     string
+		key      string
+		body     string
+		status   int
+		replayed bool
+	}{
+		{"retry", "create-1", body, http.StatusCreated, true},
+		{"other body", "create-1", `{"duration":"30d","name":"Oleg"}`, http.StatusUnprocessableEntity, false},
+		{"other key", "create-2", body, http.StatusCreated, false},
+		{"without a key", "", body, http.StatusCreated, false},
+		{"too long", strings.Repeat("x", 256), body, http.StatusBadRequest, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			w := call(handler, "POST", "/api/v2/clients", test.body, "Idempotency-Key", test.key)
+			if w.Code != test.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, test.status, w.Body)
+			}
+			if replayed := w.Header().Get("Idempotent-Replayed") == "true"; replayed != test.replayed {
+				t.Errorf("replayed %t, want %t", replayed, test.replayed)
+			}
+			if w.Code != http.StatusCreated {
+				return
+			}
+			same := decodeClient(t, w).UUID == created.UUID
+			if same != test.replayed {
+				t.Errorf("got the first client %t, want %t", same, test.replayed)
+			}
+			if test.replayed && (w.Header().Get("Location") != first.Header().Get("Location") || w.Header().Get("ETag") != first.Header().Get("ETag")) {
+				t.Errorf("replayed headers %v, want those of %v", w.Header(), first.Header())
+			}
+		})
+	}
+}
+
+func TestConditionalRequests(t *testing.T) {
+	handler := newTestAPI(t)
+	created := call(handler, "POST", "/api/v2/clients", `{"duration":"30d"}`)
+	path := created.Header().Get("Location")
+	etag := created.Header().Get("ETag")
+	if path == "" || etag != `"1"` {
+		t.Fatalf("created client at %q with ETag %q", path, etag)
+	}
+
+	for _, test := range []struct {
+		name, method, body string
+		headers            []string
+		status             int
+		etag               string // the ETag of the response, if checked
+	}{
+		{"unchanged", "GET", "", []string{"If-None-Match", `"1"`}, http.StatusNotModified, `"1"`},
+		{"weak and listed", "GET", "", []string{"If-None-Match", `"7", W/"1"`}, http.StatusNotModified, `"1"`},
+		{"changed", "GET", "", []string{"If-None-Match", `"7"`}, http.StatusOK, `"1"`},
+		{"malformed If-Match", "PATCH", `{"name":"a"}`, []string{"If-Match", "1"}, http.StatusBadRequest, ""},
+		{"stale If-Match", "PATCH", `{"name":"a"}`, []string{"If-Match", `"7"`}, http.StatusPreconditionFailed, ""},
+		{"current If-Match", "PATCH", `{"name":"a"}`, []string{"If-Match", `"1"`}, http.StatusOK, `"2"`},
+		{"reused If-Match", "PATCH", `{"name":"b"}`, []string{"If-Match", `"1"`}, http.StatusPreconditionFailed, ""},
+		{"unconditional", "PATCH", `{"name":"b"}`, nil, http.StatusOK, `"3"`},
+		{"stale delete", "DELETE", "", []string{"If-Match", `"2"`}, http.StatusPreconditionFailed, ""},
+		{"delete", "DELETE", "", []string{"If-Match", `"3"`}, http.StatusNoContent, ""},
+		{"gone", "GET", "", nil, http.StatusNotFound, ""},
+	} {
+		w := call(handler, test.method, path, test.body, test.headers...)
+		if w.Code != test.status {
+			t.Fatalf("%s: status %d, want %d: %s", test.name, w.Code, test.status, w.Body)
+		}
+		if test.etag != "" && w.Header().Get("ETag") != test.etag {
+			t.Errorf("%s: ETag %q, want %q", test.name, w.Header().Get("ETag"), test.etag)
+		}
+		if w.Code == http.StatusPreconditionFailed {
+			var resp ErrorResponse
+			json.Unmarshal(w.Body.Bytes(), &resp)
+			if resp.Error.Code != CodePreconditionFailed || resp.Error.Details["etag"] == nil {
+				t.Errorf("%s: error %s, want the current ETag", test.name, w.Body)
+			}
+		}
+	}
+}
+
+// TestReadOnlyClientResponse checks that keys that may not see credentials
+// get neither the secret nor the share link.
+func TestReadOnlyClientResponse(t *testing.T) {
+	handler := newTestAPI(t)
+	path := call(handler, "POST", "/api/v2/clients", `{"duration":"30d"}`).Header().Get("Location")
+
+	r := httptest.NewRequest("GET", path, nil)
+	r.Header.Set("X-API-Key", "ro")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if resp := decodeClient(t, w); w.Code != http.StatusOK || resp.Secret != "" || resp.ShareURI != "" {
+		t.Errorf("read-only key got %d %s", w.Code, w.Body)
+	}
+	if resp := decodeClient(t, call(handler, "GET", path, "")); resp.Secret == "" || resp.ShareURI == "" {
+		t.Error("admin key got no credentials")
+	}
+}
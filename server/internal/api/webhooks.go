@@ -0,0 +1,254 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"yagnoetik-vpn/internal/webhooks"
+
+	"github.com/gorilla/mux"
+)
+
+type CreateWebhookRequest struct {
+	URL string `json:"url"`
+	// Events holds event types or groups such as "quota"; empty sends
+	// every event.
+	Events      []string `json:"events,omitempty"`
+	Description string   `json:"description,omitempty"`
+	// Secret signs the deliveries; empty generates one.
+	Secret string `json:"secret,omitempty"`
+}
+
+// UpdateWebhookRequest is a partial update; omitted fields are unchanged.
+type UpdateWebhookRequest struct {
+	URL         *string   `json:"url,omitempty"`
+	Events      *[]string `json:"events,omitempty"`
+	Description *string   `json:"description,omitempty"`
+	Enabled     *bool     `json:"enabled,omitempty"`
+}
+
+// WebhookResponse carries the signing secret of a created webhook or one
+// whose secret was rotated. It is shown only once.
+type WebhookResponse struct {
+	webhooks.Subscription
+	Secret string `json:"secret"`
+}
+
+// DeliveryResponse is a delivery with its payload, returned when a single
+// delivery is requested.
+type DeliveryResponse struct {
+	webhooks.Delivery
+	Payload json.RawMessage `json:"payload"`
+}
+
+func (a *AdminAPI) listWebhooks(w http.ResponseWriter, r *http.Request) {
+	if a.webhooks == nil {
+		http.Error(w, "Webhooks are disabled", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.webhooks.List())
+}
+
+func (a *AdminAPI) createWebhook(w http.ResponseWriter, r *http.Request) {
+	if a.webhooks == nil {
+		http.Error(w, "Webhooks are disabled", http.StatusNotFound)
+		return
+	}
+	var req CreateWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	sub, secret, err := a.webhooks.Create(req.URL, req.Events, req.Description, req.Secret)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(WebhookResponse{Subscription: sub, Secret: secret})
+}
+
+func (a *AdminAPI) getWebhook(w http.ResponseWriter, r *http.Request) {
+	if a.webhooks == nil {
+		http.Error(w, "Webhooks are disabled", http.StatusNotFound)
+		return
+	}
+	sub, err := a.webhooks.Get(mux.Vars(r)["id"])
+	if err != nil {
+		webhookError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sub)
+}
+
+func (a *AdminAPI) updateWebhook(w http.ResponseWriter, r *http.Request) {
+	if a.webhooks == nil {
+		http.Error(w, "Webhooks are disabled", http.StatusNotFound)
+		return
+	}
+	var req UpdateWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	sub, err := a.webhooks.Update(mux.Vars(r)["id"], webhooks.SubscriptionUpdate{
+		URL:         req.URL,
+		Events:      req.Events,
+		Description: req.Description,
+		Enabled:     req.Enabled,
+	})
+	if err != nil {
+		webhookError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sub)
+}
+
+func (a *AdminAPI) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	if a.webhooks == nil {
+		http.Error(w, "Webhooks are disabled", http.StatusNotFound)
+		return
+	}
+	if err := a.webhooks.Delete(mux.Vars(r)["id"]); err != nil {
+		webhookError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *AdminAPI) rotateWebhookSecret(w http.ResponseWriter, r *http.Request) {
+	if a.webhooks == nil {
+		http.Error(w, "Webhooks are disabled", http.StatusNotFound)
+		return
+	}
+	sub, secret, err := a.webhooks.RotateSecret(mux.Vars(r)["id"])
+	if err != nil {
+		webhookError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(WebhookResponse{Subscription: sub, Secret: secret})
+}
+
+// testWebhook queues a webhook.ping delivery, which the receiver can use to
+// check the signature. The delivery shows up in the log like any other.
+func (a *AdminAPI) testWebhook(w http.ResponseWriter, r *http.Request) {
+	if a.webhooks == nil {
+		http.Error(w, "Webhooks are disabled", http.StatusNotFound)
+		return
+	}
+	delivery, err := a.webhooks.Test(mux.Vars(r)["id"])
+	if err != nil {
+		webhookError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(delivery)
+}
+
+// listDeliveries returns the delivery log, newest first. Filters:
+// ?subscription=<id>, ?status=pending|delivered|dead (dead is the
+// dead-letter queue), ?event=<type or group> and ?limit= (default 100).
+func (a *AdminAPI) listDeliveries(w http.ResponseWriter, r *http.Request) {
+	if a.webhooks == nil {
+		http.Error(w, "Webhooks are disabled", http.StatusNotFound)
+		return
+	}
+	query := r.URL.Query()
+	q := webhooks.Query{
+		SubscriptionID: query.Get("subscription"),
+		Status:         webhooks.DeliveryStatus(query.Get("status")),
+		EventType:      query.Get("event"),
+		Limit:          100,
+	}
+	switch q.Status {
+	case "", webhooks.StatusPending, webhooks.StatusDelivered, webhooks.StatusDead:
+	default:
+		http.Error(w, "Invalid status", http.StatusBadRequest)
+		return
+	}
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 || n > maxPageSize {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		q.Limit = n
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.webhooks.Deliveries(q))
+}
+
+func (a *AdminAPI) getDelivery(w http.ResponseWriter, r *http.Request) {
+	if a.webhooks == nil {
+		http.Error(w, "Webhooks are disabled", http.StatusNotFound)
+		return
+	}
+	delivery, err := a.webhooks.Delivery(mux.Vars(r)["id"])
+	if err != nil {
+		webhookError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(DeliveryResponse{Delivery: delivery, Payload: delivery.Payload})
+}
+
+// redeliverDelivery moves a delivery from the dead-letter queue back to the
+// queue with a fresh set of attempts.
+func (a *AdminAPI) redeliverDelivery(w http.ResponseWriter, r *http.Request) {
+	if a.webhooks == nil {
+		http.Error(w, "Webhooks are disabled", http.StatusNotFound)
+		return
+	}
+	delivery, err := a.webhooks.Redeliver(mux.Vars(r)["id"])
+	if err != nil {
+		webhookError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(delivery)
+}
+
+// discardDelivery drops a delivery from the dead-letter queue.
+func (a *AdminAPI) discardDelivery(w http.ResponseWriter, r *http.Request) {
+	if a.webhooks == nil {
+		http.Error(w, "Webhooks are disabled", http.StatusNotFound)
+		return
+	}
+	if err := a.webhooks.Discard(mux.Vars(r)["id"]); err != nil {
+		webhookError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func webhookError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, webhooks.ErrNotFound):
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+	case errors.Is(err, webhooks.ErrDeliveryNotFound):
+		http.Error(w, "Delivery not found", http.StatusNotFound)
+	case errors.Is(err, webhooks.ErrNotDead):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
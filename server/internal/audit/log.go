@@ -0,0 +1,313 @@
+package audit
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"yagnoetik-vpn/internal/storage"
+)
+
+// maxEntries caps the log regardless of retention.
+const maxEntries = 100000
+
+// Entry records one admin API mutation. Entries are chained: Hash is an
+// HMAC over every other field, including PrevHash, the hash of the entry
+// before, so the chain cannot be recomputed without the server's key.
+type Entry struct {
+	ID     uint64    `json:"id"`
+	Time   time.Time `json:"time"`
+	Actor  string    `json:"actor"`
+	Action string    `json:"action"` // method and route, e.g. "POST /api/clients/{uuid}/block"
+	Target string    `json:"target,omitempty"`
+	// Params holds the query string and request body with secrets
+	// redacted.
+	Params       json.RawMessage `json:"params,omitempty"`
+	SourceIP     string          `json:"source_ip"`
+	ForwardedFor string          `json:"forwarded_for,omitempty"`
+	Status       int             `json:"status"`
+	PrevHash     string          `json:"prev_hash"`
+	Hash         string          `json:"hash"`
+}
+
+// Query selects entries; zero fields are not filtered on. Action matches
+// as a substring so that "block" finds both block and unblock.
+type Query struct {
+	Actor  string
+	Action string
+	Target string
+	From   time.Time // entries at or after From
+	To     time.Time // entries before To
+	Limit  int
+}
+
+func (q Query) matches(e Entry) bool {
+	switch {
+	case q.Actor != "" && e.Actor != q.Actor:
+		return false
+	case q.Action != "" && !strings.Contains(e.Action, q.Action):
+		return false
+	case q.Target != "" && e.Target != q.Target:
+		return false
+	case e.Time.Before(q.From):
+		return false
+	case !q.To.IsZero() && !e.Time.Before(q.To):
+		return false
+	}
+	return true
+}
+
+// Log is an append-only, hash-chained audit log. Entries can only leave
+// it by expiring, oldest first, so the retained entries always form an
+// unbroken chain starting at the first one's PrevHash.
+type Log struct {
+	entries   []Entry
+	nextID    uint64
+	lastHash  string
+	key       []byte
+	retention time.Duration
+	// brokenAt is the first entry found not to match the chain when the
+	// log was loaded. It is reported until the server restarts, even once
+	// the entry has expired.
+	brokenAt uint64
+	// journal, if set, holds the entries on disk. stale counts the entries
+	// expired from memory but still in the journal.
+	journal *storage.Journal
+	stale   int
+	mutex   sync.RWMutex
+}
+
+// NewLog creates a log that is kept in memory only and chained with key. A
+// zero retention keeps entries until maxEntries is reached.
+func NewLog(key []byte, retention time.Duration) *Log {
+	return &Log{nextID: 1, key: key, retention: retention}
+}
+
+// Open creates a log persisted to the journal at path, which new entries
+// are appended to. Saved entries that do not form an unbroken chain, as
+// they would after being edited, are kept and the break is logged and
+// reported by Verify.
+func Open(path string, key []byte, retention time.Duration) (*Log, error) {
+	l := NewLog(key, retention)
+	journal, err := storage.OpenJournal(path, func(data json.RawMessage) error {
+		var e Entry
+		if err := json.Unmarshal(data, &e); err != nil {
+			return err
+		}
+		l.entries = append(l.entries, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if v := Verify(key, l.entries); !v.Valid {
+		slog.Error("Audit log hash chain is broken", "path", path, "entry", v.BrokenAt)
+		l.brokenAt = v.BrokenAt
+	}
+	l.journal = journal
+
+	if n := len(l.entries); n > 0 {
+		l.nextID = l.entries[n-1].ID + 1
+		l.lastHash = l.entries[n-1].Hash
+	}
+	l.stale = l.prune(time.Now())
+	if err := l.compact(); err != nil {
+		journal.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// Append assigns the entry its ID, time and hashes and adds it to the log.
+func (l *Log) Append(e Entry) Entry {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	e.ID = l.nextID
+	e.Time = time.Now().UTC()
+	e.PrevHash = l.lastHash
+	e.Hash = e.computeHash(l.key)
+
+	l.nextID++
+	l.lastHash = e.Hash
+	l.entries = append(l.entries, e)
+	// Expired entries leave the journal on the next sweep
+	l.stale += l.prune(e.Time)
+	if l.journal != nil {
+		if err := l.journal.Append(e); err != nil {
+			slog.Error("Failed to save audit entry", "id", e.ID, "action", e.Action, "error", err)
+		}
+	}
+	return e
+}
+
+// Prune drops expired entries and rewrites the journal without them.
+func (l *Log) Prune(now time.Time) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.stale += l.prune(now)
+	if err := l.compact(); err != nil {
+		slog.Error("Failed to compact the audit log", "error", err)
+	}
+}
+
+// compact rewrites the journal if it holds expired entries. The retained
+// entries still chain from the first one's PrevHash. The caller must hold
+// l.mutex.
+func (l *Log) compact() error {
+	if l.journal == nil || l.stale == 0 {
+		return nil
+	}
+	err := l.journal.Rewrite(func(enc *json.Encoder) error {
+		for _, e := range l.entries {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	l.stale = 0
+	return nil
+}
+
+// prune drops expired and excess entries and returns how many it dropped.
+// The caller must hold l.mutex.
+func (l *Log) prune(now time.Time) int {
+	drop := 0
+	if len(l.entries) > maxEntries {
+		drop = len(l.entries) - maxEntries
+	}
+	if l.retention > 0 {
+		cutoff := now.Add(-l.retention)
+		for drop < len(l.entries) && l.entries[drop].Time.Before(cutoff) {
+			drop++
+		}
+	}
+	if drop > 0 {
+		l.entries = append([]Entry(nil), l.entries[drop:]...)
+	}
+	return drop
+}
+
+// Query returns matching entries, newest first.
+func (l *Log) Query(q Query) []Entry {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	entries := make([]Entry, 0)
+	for i := len(l.entries) - 1; i >= 0; i-- {
+		e := l.entries[i]
+		if e.Time.Before(q.From) {
+			break
+		}
+		if !q.matches(e) {
+			continue
+		}
+		entries = append(entries, e)
+		if q.Limit > 0 && len(entries) >= q.Limit {
+			break
+		}
+	}
+	return entries
+}
+
+// Export returns matching entries oldest first, the order in which an
+// unfiltered export can be verified. The query's limit is ignored.
+func (l *Log) Export(q Query) []Entry {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	entries := make([]Entry, 0)
+	for _, e := range l.entries {
+		if q.matches(e) {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// Verification is the result of checking the hash chain.
+type Verification struct {
+	Valid   bool `json:"valid"`
+	Entries int  `json:"entries"`
+	// BrokenAt is the ID of the first entry whose hash or link does not
+	// match; zero when the chain is valid.
+	BrokenAt uint64 `json:"broken_at,omitempty"`
+}
+
+// Verify checks the retained entries, and reports a break found when the
+// log was loaded even if the entries after it are intact.
+func (l *Log) Verify() Verification {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	if l.brokenAt != 0 {
+		return Verification{Entries: len(l.entries), BrokenAt: l.brokenAt}
+	}
+	return Verify(l.key, l.entries)
+}
+
+// Verify checks that each entry's hash matches its contents under key and
+// that each entry links to the one before it. Entries must be in log
+// order.
+func Verify(key []byte, entries []Entry) Verification {
+	for i, e := range entries {
+		if !hmac.Equal([]byte(e.Hash), []byte(e.computeHash(key))) || (i > 0 && e.PrevHash != entries[i-1].Hash) {
+			return Verification{Entries: len(entries), BrokenAt: e.ID}
+		}
+	}
+	return Verification{Valid: true, Entries: len(entries)}
+}
+
+// computeHash is the HMAC-SHA256 of the entry's JSON encoding with Hash
+// empty.
+func (e Entry) computeHash(key []byte) string {
+	e.Hash = ""
+	data, _ := json.Marshal(e)
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// LoadKey reads the chain key from the file at path, creating the file with
+// a random key if it does not exist yet.
+func LoadKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(key) < 32 {
+			return nil, fmt.Errorf("%s: want at least 32 hex-encoded bytes", path)
+		}
+		return key, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.WriteString(hex.EncodeToString(key) + "\n"); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return key, f.Close()
+}
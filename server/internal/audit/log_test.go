@@ -0,0 +1,193 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testKey = []byte("0123456789abcdef0123456789abcdef")
+
+// writeLog appends n entries to a log at path and closes it.
+func writeLog(t *testing.T, path string, n int) {
+	t.Helper()
+	l, err := Open(path, testKey, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range n {
+		l.Append(Entry{Actor: "admin", Action: "POST /api/clients", Target: string(rune('a' + i)), Status: 201})
+	}
+	l.journal.Close()
+}
+
+// editLog rewrites the saved entries with edit.
+func editLog(t *testing.T, path string, edit func([]Entry) []Entry) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []Entry
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var e Entry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, e)
+	}
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	for _, e := range edit(entries) {
+		enc.Encode(e)
+	}
+	if err := os.WriteFile(path, out.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// unkeyedHash is the plain SHA-256 an attacker without the key could
+// compute.
+func unkeyedHash(e Entry) string {
+	e.Hash = ""
+	data, _ := json.Marshal(e)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestOpenDetectsTampering(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		key      []byte
+		edit     func([]Entry) []Entry
+		brokenAt uint64
+	}{
+		{"intact", testKey, func(es []Entry) []Entry { return es }, 0},
+		{"edited", testKey, func(es []Entry) []Entry {
+			es[2].Actor = "someone else"
+			return es
+		}, 3},
+		{"removed", testKey, func(es []Entry) []Entry {
+			return append(es[:1], es[2:]...)
+		}, 3},
+		{"rehashed without the key", testKey, func(es []Entry) []Entry {
+			es[1].Status = 500
+			for i := 1; i < len(es); i++ {
+				es[i].PrevHash = es[i-1].Hash
+				es[i].Hash = unkeyedHash(es[i])
+			}
+			return es
+		}, 2},
+		{"other key", []byte("another key entirely, 32 bytes.."), func(es []Entry) []Entry { return es }, 1},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "audit.jsonl")
+			writeLog(t, path, 4)
+			editLog(t, path, test.edit)
+
+			l, err := Open(path, test.key, 0)
+			if err != nil {
+				t.Fatalf("Open refused the log: %v", err)
+			}
+			defer l.journal.Close()
+
+			v := l.Verify()
+			if v.BrokenAt != test.brokenAt || v.Valid != (test.brokenAt == 0) {
+				t.Errorf("verification %+v, want broken at %d", v, test.brokenAt)
+			}
+			// New entries are still recorded after a break
+			e := l.Append(Entry{Actor: "admin", Action: "DELETE /api/clients/{uuid}"})
+			if e.ID != 5 {
+				t.Errorf("appended entry %d, want 5", e.ID)
+			}
+			if v := l.Verify(); v.BrokenAt != test.brokenAt {
+				t.Errorf("after appending: %+v, want broken at %d", v, test.brokenAt)
+			}
+		})
+	}
+}
+
+func TestReopenContinuesChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	writeLog(t, path, 2)
+	writeLog(t, path, 2)
+
+	l, err := Open(path, testKey, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.journal.Close()
+	if v := l.Verify(); !v.Valid || v.Entries != 4 {
+		t.Errorf("verification %+v, want 4 valid entries", v)
+	}
+	entries := l.Export(Query{})
+	if entries[3].ID != 4 || entries[2].PrevHash != entries[1].Hash {
+		t.Errorf("entries %+v do not continue the chain", entries)
+	}
+}
+
+// TestPrune expires entries from memory and the journal; what is left
+// still verifies.
+func TestPrune(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	l, err := Open(path, testKey, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		l.Append(Entry{Actor: "admin", Action: "POST /api/clients"})
+	}
+	// Two entries are past retention an hour from now, the third is not
+	l.mutex.Lock()
+	l.entries[0].Time = l.entries[0].Time.Add(-2 * time.Hour)
+	l.entries[1].Time = l.entries[1].Time.Add(-2 * time.Hour)
+	l.mutex.Unlock()
+
+	l.Prune(time.Now())
+	l.journal.Close()
+	if v := l.Verify(); !v.Valid || v.Entries != 1 {
+		t.Errorf("after pruning: %+v, want 1 valid entry", v)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 1 {
+		t.Errorf("journal has %d entries after pruning, want 1", lines)
+	}
+}
+
+func TestLoadKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.key")
+	key, err := LoadKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(key) != 32 {
+		t.Errorf("generated a %d-byte key", len(key))
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("key file mode %v, want 0600", info.Mode().Perm())
+	}
+
+	again, err := LoadKey(path)
+	if err != nil || !bytes.Equal(again, key) {
+		t.Errorf("reloaded key %x, error %v; want %x", again, err, key)
+	}
+
+	os.WriteFile(path, []byte("too short\n"), 0600)
+	if _, err := LoadKey(path); err == nil {
+		t.Error("loaded a malformed key")
+	}
+}
@@ -3,33 +3,101 @@ package auth
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Client struct {
-	UUID      string    `json:"uuid"`
-	Secret    string    `json:"secret"`
-	Key       []byte    `json:"-"`
-	CreatedAt time.Time `json:"created_at"`
-	ExpiresAt time.Time `json:"expires_at"`
-	Blocked   bool      `json:"blocked"`
-	BytesUp   int64     `json:"bytes_up"`
-	BytesDown int64     `json:"bytes_down"`
+	UUID          string        `json:"uuid"`
+	Secret        string        `json:"secret,omitempty"`
+	Key           []byte        `json:"-"`
+	State         ClientState   `json:"state"`
+	CreatedAt     time.Time     `json:"created_at"`
+	ActivatedAt   time.Time     `json:"activated_at"`
+	ExpiresAt     time.Time     `json:"expires_at"`
+	StateSince    time.Time     `json:"state_since"`
+	Blocked       bool          `json:"blocked"`
+	Name          string        `json:"name"`
+	Email         string        `json:"email"`
+	Contact       string        `json:"contact"`
+	Tags          []string      `json:"tags"`
+	Notes         string        `json:"notes"`
+	QuotaBytes    int64         `json:"quota_bytes"`
+	QuotaReset    QuotaReset    `json:"quota_reset"`
+	PeriodStart   time.Time     `json:"period_start"`
+	PeriodBytes   int64         `json:"period_bytes"`
+	QuotaWarned   int           `json:"quota_warned"`
+	QuotaExceeded bool          `json:"quota_exceeded"`
+	SpeedTier     string        `json:"speed_tier"`
+	Plan          string        `json:"plan"`
+	MaxSessions   int           `json:"max_sessions"`
+	SessionPolicy SessionPolicy `json:"session_policy"`
+	BytesUp       int64         `json:"bytes_up"`
+	BytesDown     int64         `json:"bytes_down"`
+
+	// Revision counts admin changes to the client. It is the basis of the
+	// admin API's ETags and does not change with traffic or state sweeps.
+	Revision uint64 `json:"revision"`
+}
+
+// ClientUpdate describes a partial change to a client. Nil fields are left
+// untouched. Extend is applied after ExpiresAt.
+type ClientUpdate struct {
+	ExpiresAt  *time.Time
+	Extend     time.Duration
+	Name       *string
+	Email      *string
+	Contact    *string
+	Tags       *[]string
+	Notes      *string
+	QuotaBytes *int64
+	QuotaReset *QuotaReset
+	SpeedTier  *string
+	// Plan names the plan the client was last put on; the plan's settings
+	// come in the other fields.
+	Plan *string
+
+	MaxSessions   *int
+	SessionPolicy *SessionPolicy
 }
 
+var (
+	ErrClientNotFound = errors.New("client not found")
+	// ErrRevisionMismatch is returned by conditional changes when the client
+	// has been modified since the caller read it.
+	ErrRevisionMismatch = errors.New("client has been modified")
+)
+
 type ClientManager struct {
-	clients map[string]*Client
-	mutex   sync.RWMutex
+	clients             map[string]*Client
+	transitions         []Transition
+	transitionRetention time.Duration
+	listeners           []TransitionListener
+	updateListeners     []UpdateListener
+	quotaListeners      []QuotaListener
+	quotaPolicy         QuotaPolicy
+	mutex               sync.RWMutex
+
+	// path is the file the clients are saved to; "" keeps them in memory
+	// only. dirty is set when clients changed since the last save.
+	path      string
+	dirty     atomic.Bool
+	saveMutex sync.Mutex
 }
 
 func NewClientManager() *ClientManager {
 	return &ClientManager{
-		clients: make(map[string]*Client),
+		clients:     make(map[string]*Client),
+		quotaPolicy: DefaultQuotaPolicy(),
 	}
 }
 
-func (cm *ClientManager) CreateClient(duration time.Duration) (*Client, error) {
+// CreateClient issues credentials for a new pending client. Profile fields
+// such as name, tags and quota are taken from the update; its expiry fields
+// are ignored in favour of duration.
+func (cm *ClientManager) CreateClient(duration time.Duration, profile ClientUpdate) (*Client, error) {
 	uuid := generateUUID()
 	secret := generateSecret()
 	key := make([]byte, 32)
@@ -37,88 +105,282 @@ func (cm *ClientManager) CreateClient(duration time.Duration) (*Client, error) {
 		return nil, err
 	}
 
+	now := time.Now()
 	client := &Client{
-		UUID:      uuid,
-		Secret:    secret,
-		Key:       key,
-		CreatedAt: time.Now(),
-		ExpiresAt: time.Now().Add(duration),
-		Blocked:   false,
+		UUID:        uuid,
+		Secret:      secret,
+		Key:         key,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(duration),
+		Blocked:     false,
+		Tags:        []string{},
+		PeriodStart: now,
+		Revision:    1,
 	}
+	profile.ExpiresAt = nil
+	profile.Extend = 0
+	client.apply(profile, now)
 
 	cm.mutex.Lock()
 	cm.clients[uuid] = client
+	t := cm.transition(client, StatePending, "created", now)
+	client = client.snapshot()
 	cm.mutex.Unlock()
 
+	cm.save()
+	cm.notify(t)
+
 	return client, nil
 }
 
+// GetClient returns the client only if it is currently allowed to connect:
+// it is pending or active, not past its expiry and not cut off by its quota.
 func (cm *ClientManager) GetClient(uuid string) (*Client, bool) {
 	cm.mutex.RLock()
 	defer cm.mutex.RUnlock()
-	
+
 	client, exists := cm.clients[uuid]
-	if !exists || client.Blocked || time.Now().After(client.ExpiresAt) {
+	if !exists || !client.State.CanConnect() || time.Now().After(client.ExpiresAt) || cm.quotaBlocks(client) {
 		return nil, false
 	}
-	
-	return client, true
+
+	return client.snapshot(), true
+}
+
+// FindClient returns a copy of the client regardless of its state.
+func (cm *ClientManager) FindClient(uuid string) (*Client, bool) {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+
+	client, exists := cm.clients[uuid]
+	if !exists {
+		return nil, false
+	}
+	return client.snapshot(), true
+}
+
+// ActivateClient moves a pending client to active on its first successful
+// connection. It is a no-op for clients in any other state.
+func (cm *ClientManager) ActivateClient(uuid string) {
+	cm.mutex.Lock()
+	var ts []Transition
+	if client, exists := cm.clients[uuid]; exists && client.State == StatePending {
+		now := time.Now()
+		client.ActivatedAt = now
+		ts = cm.transition(client, StateActive, "first connection", now)
+	}
+	cm.mutex.Unlock()
+
+	if len(ts) > 0 {
+		cm.save()
+	}
+	cm.notify(ts)
 }
 
 func (cm *ClientManager) DeleteClient(uuid string) bool {
+	return cm.DeleteClientRevision(uuid, 0) == nil
+}
+
+// DeleteClientRevision deletes the client only if it is still at the given
+// revision. A revision of 0 deletes it unconditionally.
+func (cm *ClientManager) DeleteClientRevision(uuid string, revision uint64) error {
 	cm.mutex.Lock()
-	defer cm.mutex.Unlock()
-	
-	_, exists := cm.clients[uuid]
-	if exists {
-		delete(cm.clients, uuid)
+	client, exists := cm.clients[uuid]
+	if !exists {
+		cm.mutex.Unlock()
+		return ErrClientNotFound
 	}
-	return exists
+	if revision != 0 && client.Revision != revision {
+		cm.mutex.Unlock()
+		return ErrRevisionMismatch
+	}
+	ts := cm.transition(client, StateDeleted, "deleted by admin", time.Now())
+	delete(cm.clients, uuid)
+	cm.mutex.Unlock()
+
+	cm.save()
+	cm.notify(ts)
+	return nil
 }
 
 func (cm *ClientManager) BlockClient(uuid string) bool {
 	cm.mutex.Lock()
-	defer cm.mutex.Unlock()
-	
 	client, exists := cm.clients[uuid]
+	var ts []Transition
 	if exists {
 		client.Blocked = true
-		return true
+		client.Revision++
+		if client.State.CanConnect() {
+			ts = cm.transition(client, StateSuspended, "blocked by admin", time.Now())
+		}
+	}
+	cm.mutex.Unlock()
+
+	if exists {
+		cm.save()
 	}
-	return false
+	cm.notify(ts)
+	return exists
 }
 
 func (cm *ClientManager) UnblockClient(uuid string) bool {
 	cm.mutex.Lock()
-	defer cm.mutex.Unlock()
-	
 	client, exists := cm.clients[uuid]
+	var ts []Transition
 	if exists {
 		client.Blocked = false
-		return true
+		client.Revision++
+		if client.State == StateSuspended {
+			now := time.Now()
+			ts = cm.transition(client, client.resumeState(now), "unblocked by admin", now)
+		}
 	}
-	return false
+	cm.mutex.Unlock()
+
+	if exists {
+		cm.save()
+	}
+	cm.notify(ts)
+	return exists
+}
+
+// UpdateClient applies the update and re-evaluates expiry, so extending an
+// expired client restores its access.
+func (cm *ClientManager) UpdateClient(uuid string, update ClientUpdate) (*Client, bool) {
+	client, err := cm.UpdateClientRevision(uuid, 0, update)
+	return client, err == nil
+}
+
+// UpdateClientRevision is UpdateClient that only applies the update if the
+// client is still at the given revision. A revision of 0 applies it
+// unconditionally.
+func (cm *ClientManager) UpdateClientRevision(uuid string, revision uint64, update ClientUpdate) (*Client, error) {
+	cm.mutex.Lock()
+	client, exists := cm.clients[uuid]
+	if !exists {
+		cm.mutex.Unlock()
+		return nil, ErrClientNotFound
+	}
+	if revision != 0 && client.Revision != revision {
+		client = client.snapshot()
+		cm.mutex.Unlock()
+		return client, ErrRevisionMismatch
+	}
+
+	now := time.Now()
+	client.apply(update, now)
+	client.Revision++
+	events := cm.checkQuota(client, now)
+
+	var ts []Transition
+	switch {
+	case client.State.CanConnect() && now.After(client.ExpiresAt):
+		ts = cm.transition(client, StateExpired, "expiry moved to the past", now)
+	case client.State == StateExpired && !now.After(client.ExpiresAt):
+		ts = cm.transition(client, client.renewState(), "subscription renewed", now)
+	}
+	client = client.snapshot()
+	cm.mutex.Unlock()
+
+	cm.save()
+	cm.notify(ts)
+	cm.notifyQuota(events)
+	cm.notifyUpdate(client)
+	return client, nil
+}
+
+func (c *Client) apply(update ClientUpdate, now time.Time) {
+	if update.ExpiresAt != nil {
+		c.ExpiresAt = *update.ExpiresAt
+	}
+	if update.Extend > 0 {
+		if c.ExpiresAt.Before(now) {
+			c.ExpiresAt = now
+		}
+		c.ExpiresAt = c.ExpiresAt.Add(update.Extend)
+	}
+	if update.Name != nil {
+		c.Name = *update.Name
+	}
+	if update.Email != nil {
+		c.Email = *update.Email
+	}
+	if update.Contact != nil {
+		c.Contact = *update.Contact
+	}
+	if update.Tags != nil {
+		c.Tags = NormalizeTags(*update.Tags)
+	}
+	if update.Notes != nil {
+		c.Notes = *update.Notes
+	}
+	if update.QuotaBytes != nil {
+		c.QuotaBytes = *update.QuotaBytes
+	}
+	if update.QuotaReset != nil && *update.QuotaReset != c.QuotaReset {
+		// The new schedule counts from now; usage so far is kept
+		c.QuotaReset = *update.QuotaReset
+		c.PeriodStart = now
+	}
+	if update.SpeedTier != nil {
+		c.SpeedTier = *update.SpeedTier
+	}
+	if update.Plan != nil {
+		c.Plan = *update.Plan
+	}
+	if update.MaxSessions != nil {
+		c.MaxSessions = *update.MaxSessions
+	}
+	if update.SessionPolicy != nil {
+		c.SessionPolicy = *update.SessionPolicy
+	}
+}
+
+// RotateCredentials issues a new secret and key for the client while keeping
+// its UUID, state and usage counters.
+func (cm *ClientManager) RotateCredentials(uuid string) (*Client, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	secret := generateSecret()
+
+	cm.mutex.Lock()
+	client, exists := cm.clients[uuid]
+	if !exists {
+		cm.mutex.Unlock()
+		return nil, ErrClientNotFound
+	}
+	client.Secret = secret
+	client.Key = key
+	client.Revision++
+	client = client.snapshot()
+	cm.mutex.Unlock()
+
+	cm.save()
+	cm.notifyUpdate(client)
+	return client, nil
 }
 
 func (cm *ClientManager) ListClients() []*Client {
 	cm.mutex.RLock()
 	defer cm.mutex.RUnlock()
-	
+
 	clients := make([]*Client, 0, len(cm.clients))
 	for _, client := range cm.clients {
-		clients = append(clients, client)
+		clients = append(clients, client.snapshot())
 	}
 	return clients
 }
 
-func (cm *ClientManager) UpdateTraffic(uuid string, bytesUp, bytesDown int64) {
-	cm.mutex.Lock()
-	defer cm.mutex.Unlock()
-	
-	if client, exists := cm.clients[uuid]; exists {
-		client.BytesUp += bytesUp
-		client.BytesDown += bytesDown
-	}
+// snapshot copies the client, so that it can be read after the manager lock
+// is released while traffic and sweeps keep changing the original. The
+// caller must hold cm.mutex.
+func (c *Client) snapshot() *Client {
+	s := *c
+	s.Key = append([]byte(nil), c.Key...)
+	s.Tags = append(make([]string, 0, len(c.Tags)), c.Tags...)
+	return &s
 }
 
 func generateUUID() string {
@@ -0,0 +1,249 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+type ConflictMode string
+
+const (
+	ConflictSkip      ConflictMode = "skip"
+	ConflictOverwrite ConflictMode = "overwrite"
+	ConflictFail      ConflictMode = "fail"
+)
+
+var ErrImportRejected = errors.New("import rejected: invalid rows or conflicts")
+
+type ImportAction string
+
+const (
+	ImportCreated     ImportAction = "created"
+	ImportOverwritten ImportAction = "overwritten"
+	ImportSkipped     ImportAction = "skipped"
+	ImportInvalid     ImportAction = "invalid"
+	ImportConflict    ImportAction = "conflict"
+)
+
+type ImportResult struct {
+	Index  int          `json:"index"`
+	UUID   string       `json:"uuid"`
+	Action ImportAction `json:"action"`
+	Error  string       `json:"error,omitempty"`
+}
+
+type ImportReport struct {
+	DryRun      bool           `json:"dry_run"`
+	Created     int            `json:"created"`
+	Overwritten int            `json:"overwritten"`
+	Skipped     int            `json:"skipped"`
+	Invalid     int            `json:"invalid"`
+	Conflicts   int            `json:"conflicts"`
+	Results     []ImportResult `json:"results"`
+}
+
+// ImportClients validates the given clients and, unless dryRun is set or a
+// problem aborts the import, stores them. Invalid rows and, in ConflictFail
+// mode, any UUID that already exists abort the whole import so that nothing
+// is applied partially. A row that overwrites a client keeps the client's
+// credentials, activation time and usage where it leaves them out; missing
+// credentials are only generated for new clients.
+func (cm *ClientManager) ImportClients(clients []*Client, mode ConflictMode, dryRun bool) (*ImportReport, error) {
+	switch mode {
+	case ConflictSkip, ConflictOverwrite, ConflictFail:
+	default:
+		return nil, fmt.Errorf("unknown conflict mode %q", mode)
+	}
+
+	now := time.Now()
+	fresh := make([]credentials, len(clients))
+	for i, c := range clients {
+		c.prepareImport(now)
+		if c.Secret == "" || len(c.Key) == 0 {
+			var err error
+			if fresh[i], err = newCredentials(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	cm.mutex.Lock()
+	report := &ImportReport{DryRun: dryRun, Results: make([]ImportResult, 0, len(clients))}
+	seen := make(map[string]bool)
+	for i, c := range clients {
+		result := ImportResult{Index: i, UUID: c.UUID}
+		_, exists := cm.clients[c.UUID]
+		invalid := c.validateImport()
+		switch {
+		case invalid != nil:
+			result.Action, result.Error = ImportInvalid, invalid.Error()
+			report.Invalid++
+		case seen[c.UUID]:
+			result.Action, result.Error = ImportInvalid, "duplicate uuid in import"
+			report.Invalid++
+		case exists && mode == ConflictFail:
+			result.Action, result.Error = ImportConflict, "client already exists"
+			report.Conflicts++
+		case exists && mode == ConflictSkip:
+			result.Action = ImportSkipped
+			report.Skipped++
+		case exists:
+			result.Action = ImportOverwritten
+			report.Overwritten++
+		default:
+			result.Action = ImportCreated
+			report.Created++
+		}
+		seen[c.UUID] = true
+		report.Results = append(report.Results, result)
+	}
+
+	if dryRun || report.Invalid > 0 || report.Conflicts > 0 {
+		cm.mutex.Unlock()
+		if !dryRun {
+			return report, ErrImportRejected
+		}
+		return report, nil
+	}
+
+	var ts []Transition
+	var events []QuotaEvent
+	var updated []*Client
+	for i, c := range clients {
+		action := report.Results[i].Action
+		if action != ImportCreated && action != ImportOverwritten {
+			continue
+		}
+		state := c.State
+		if previous, exists := cm.clients[c.UUID]; exists {
+			c.inherit(previous)
+			c.State = previous.State
+			c.StateSince = previous.StateSince
+			c.Revision = previous.Revision + 1
+		} else {
+			c.State = ""
+			c.Revision = 1
+		}
+		if c.Secret == "" {
+			c.Secret = fresh[i].secret
+		}
+		if len(c.Key) == 0 {
+			c.Key = fresh[i].key
+		}
+		if c.PeriodStart.IsZero() {
+			c.PeriodStart = now
+		}
+		if state == StateActive && c.ActivatedAt.IsZero() {
+			c.ActivatedAt = now
+		}
+		// Keep a copy, so that the caller's clients are not shared
+		stored := c.snapshot()
+		cm.clients[c.UUID] = stored
+		ts = append(ts, cm.transition(stored, state, "imported", now)...)
+		events = append(events, cm.checkQuota(stored, now)...)
+		if action == ImportOverwritten {
+			updated = append(updated, stored.snapshot())
+		}
+	}
+	cm.mutex.Unlock()
+
+	cm.save()
+	cm.notify(ts)
+	cm.notifyQuota(events)
+	cm.notifyUpdate(updated...)
+	return report, nil
+}
+
+type credentials struct {
+	secret string
+	key    []byte
+}
+
+func newCredentials() (credentials, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return credentials{}, err
+	}
+	return credentials{secret: generateSecret(), key: key}, nil
+}
+
+// prepareImport fills defaults and derives the state the client should be
+// imported in. Credentials and usage are filled once it is known whether
+// the client already exists.
+func (c *Client) prepareImport(now time.Time) {
+	if c.UUID == "" {
+		c.UUID = generateUUID()
+	}
+	if c.CreatedAt.IsZero() {
+		c.CreatedAt = now
+	}
+	if c.Tags == nil {
+		c.Tags = []string{}
+	}
+	c.Tags = NormalizeTags(c.Tags)
+
+	switch {
+	case now.After(c.ExpiresAt):
+		c.State = StateExpired
+	case c.Blocked:
+		c.State = StateSuspended
+	case c.State != StateActive:
+		c.State = StatePending
+	}
+}
+
+// inherit takes what an overwriting row leaves out from the client it
+// replaces, so that re-importing an export without secrets neither rotates
+// the credentials nor resets activation and usage.
+func (c *Client) inherit(previous *Client) {
+	if c.Secret == "" {
+		c.Secret = previous.Secret
+	}
+	if len(c.Key) == 0 {
+		c.Key = previous.Key
+	}
+	if c.ActivatedAt.IsZero() {
+		c.ActivatedAt = previous.ActivatedAt
+	}
+	if c.BytesUp == 0 && c.BytesDown == 0 {
+		c.BytesUp, c.BytesDown = previous.BytesUp, previous.BytesDown
+	}
+	if c.PeriodBytes == 0 {
+		c.PeriodStart, c.PeriodBytes = previous.PeriodStart, previous.PeriodBytes
+	}
+	// Warnings already sent are not part of the import format
+	c.QuotaWarned, c.QuotaExceeded = previous.QuotaWarned, previous.QuotaExceeded
+}
+
+func (c *Client) validateImport() error {
+	if b, err := hex.DecodeString(c.UUID); err != nil || len(b) != 16 {
+		return errors.New("uuid must be 32 hex characters")
+	}
+	if len(c.Key) != 0 && len(c.Key) != 32 {
+		return errors.New("key must be 32 bytes")
+	}
+	if c.ExpiresAt.IsZero() {
+		return errors.New("expires_at is required")
+	}
+	if c.QuotaBytes < 0 {
+		return errors.New("quota_bytes must not be negative")
+	}
+	if c.PeriodBytes < 0 {
+		return errors.New("period_bytes must not be negative")
+	}
+	if _, err := ParseQuotaReset(string(c.QuotaReset)); err != nil {
+		return err
+	}
+	if c.MaxSessions < -1 {
+		return errors.New("max_sessions must be -1 or greater")
+	}
+	if c.SessionPolicy != "" {
+		if _, err := ParseSessionPolicy(string(c.SessionPolicy)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// exported copies a client the way an export without secrets carries it:
+// no credentials, activation time or quota warnings.
+func exported(c *Client) *Client {
+	return &Client{
+		UUID:        c.UUID,
+		State:       c.State,
+		CreatedAt:   c.CreatedAt,
+		ExpiresAt:   c.ExpiresAt,
+		Blocked:     c.Blocked,
+		Name:        c.Name,
+		Tags:        c.Tags,
+		QuotaBytes:  c.QuotaBytes,
+		QuotaReset:  c.QuotaReset,
+		PeriodStart: c.PeriodStart,
+		PeriodBytes: c.PeriodBytes,
+		BytesUp:     c.BytesUp,
+		BytesDown:   c.BytesDown,
+	}
+}
+
+// newUsedClient creates an active client that has used 90 of its 100 MiB.
+func newUsedClient(t *testing.T, cm *ClientManager) *Client {
+	t.Helper()
+	quota := int64(100 << 20)
+	client, err := cm.CreateClient(30*day, ClientUpdate{QuotaBytes: &quota})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm.ActivateClient(client.UUID)
+	cm.AddUsage(client.UUID, 10<<20, 80<<20)
+	client, _ = cm.FindClient(client.UUID)
+	return client
+}
+
+// TestImportOverwriteKeepsCredentials re-imports an export without secrets
+// over the clients it came from.
+func TestImportOverwriteKeepsCredentials(t *testing.T) {
+	cm := NewClientManager()
+	before := newUsedClient(t, cm)
+
+	row := exported(before)
+	row.Name = "renamed"
+	row.BytesUp, row.BytesDown, row.PeriodBytes = 0, 0, 0
+	report, err := cm.ImportClients([]*Client{row}, ConflictOverwrite, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Overwritten != 1 {
+		t.Fatalf("report %+v, want one overwritten", report)
+	}
+
+	after, _ := cm.FindClient(before.UUID)
+	if after.Secret != before.Secret || !bytes.Equal(after.Key, before.Key) {
+		t.Error("credentials changed")
+	}
+	if !after.ActivatedAt.Equal(before.ActivatedAt) || after.State != StateActive {
+		t.Errorf("activated %v in state %s, want %v in active", after.ActivatedAt, after.State, before.ActivatedAt)
+	}
+	if after.BytesUp != before.BytesUp || after.BytesDown != before.BytesDown || after.PeriodBytes != before.PeriodBytes {
+		t.Errorf("usage %d/%d/%d, want %d/%d/%d", after.BytesUp, after.BytesDown, after.PeriodBytes,
+			before.BytesUp, before.BytesDown, before.PeriodBytes)
+	}
+	if after.QuotaWarned != before.QuotaWarned {
+		t.Errorf("quota warned at %d, want %d", after.QuotaWarned, before.QuotaWarned)
+	}
+	if after.Name != "renamed" || after.Revision != before.Revision+1 {
+		t.Errorf("name %q revision %d", after.Name, after.Revision)
+	}
+}
+
+func TestImport(t *testing.T) {
+	newKey := bytes.Repeat([]byte{7}, 32)
+	for _, test := range []struct {
+		name string
+		mode ConflictMode
+		// row builds the imported row from an existing client
+		row     func(existing *Client) *Client
+		want    ImportAction
+		err     error
+		changed bool // the existing client's secret was replaced
+	}{
+		{"new without credentials", ConflictFail, func(*Client) *Client {
+			return &Client{ExpiresAt: time.Now().Add(day)}
+		}, ImportCreated, nil, false},
+		{"overwrite with credentials", ConflictOverwrite, func(c *Client) *Client {
+			row := exported(c)
+			row.Secret, row.Key = "new-secret", newKey
+			return row
+		}, ImportOverwritten, nil, true},
+		{"skip", ConflictSkip, exported, ImportSkipped, nil, false},
+		{"conflict", ConflictFail, exported, ImportConflict, ErrImportRejected, false},
+		{"short key", ConflictOverwrite, func(c *Client) *Client {
+			row := exported(c)
+			row.Key = []byte("short")
+			return row
+		}, ImportInvalid, ErrImportRejected, false},
+		{"bad uuid", ConflictOverwrite, func(*Client) *Client {
+			return &Client{UUID: "nope", ExpiresAt: time.Now().Add(day)}
+		}, ImportInvalid, ErrImportRejected, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cm := NewClientManager()
+			existing := newUsedClient(t, cm)
+			row := test.row(existing)
+
+			report, err := cm.ImportClients([]*Client{row}, test.mode, false)
+			if err != test.err {
+				t.Fatalf("error %v, want %v", err, test.err)
+			}
+			if got := report.Results[0].Action; got != test.want {
+				t.Errorf("action %s, want %s", got, test.want)
+			}
+
+			after, _ := cm.FindClient(existing.UUID)
+			if changed := after.Secret != existing.Secret; changed != test.changed {
+				t.Errorf("secret changed %t, want %t", changed, test.changed)
+			}
+			if test.want == ImportCreated {
+				created, exists := cm.FindClient(row.UUID)
+				if !exists || created.Secret == "" || len(created.Key) != 32 || created.State != StatePending {
+					t.Errorf("created %+v", created)
+				}
+			}
+		})
+	}
+}
+
+func TestImportDryRunAndDuplicates(t *testing.T) {
+	cm := NewClientManager()
+	existing := newUsedClient(t, cm)
+
+	row := exported(existing)
+	row.Name = "renamed"
+	report, err := cm.ImportClients([]*Client{row}, ConflictOverwrite, true)
+	if err != nil || !report.DryRun || report.Overwritten != 1 {
+		t.Fatalf("dry run: report %+v, error %v", report, err)
+	}
+	if c, _ := cm.FindClient(existing.UUID); c.Name != "" || c.Revision != existing.Revision {
+		t.Errorf("dry run changed the client: %+v", c)
+	}
+
+	report, err = cm.ImportClients([]*Client{exported(existing), exported(existing)}, ConflictOverwrite, false)
+	if err != ErrImportRejected || report.Invalid != 1 {
+		t.Errorf("duplicate rows: report %+v, error %v", report, err)
+	}
+}
@@ -0,0 +1,251 @@
+package auth
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+type ClientState string
+
+const (
+	StatePending   ClientState = "pending"
+	StateActive    ClientState = "active"
+	StateSuspended ClientState = "suspended"
+	StateExpired   ClientState = "expired"
+	StateDeleted   ClientState = "deleted"
+)
+
+// CanConnect reports whether a client in this state may open a tunnel.
+func (s ClientState) CanConnect() bool {
+	return s == StatePending || s == StateActive
+}
+
+// maxTransitions bounds the in-memory transition log.
+const maxTransitions = 10000
+
+type Transition struct {
+	UUID   string      `json:"uuid"`
+	From   ClientState `json:"from"`
+	To     ClientState `json:"to"`
+	Reason string      `json:"reason"`
+	At     time.Time   `json:"at"`
+}
+
+// TransitionListener is called after a client changes state. Listeners run
+// outside the manager lock and may call back into the ClientManager.
+type TransitionListener func(Transition)
+
+// UpdateListener is called after a client's settings were changed through
+// UpdateClient, RotateCredentials or an overwriting import. Like
+// TransitionListener it runs outside the manager lock.
+type UpdateListener func(*Client)
+
+// OnUpdate registers a listener for client setting changes.
+func (cm *ClientManager) OnUpdate(fn UpdateListener) {
+	cm.mutex.Lock()
+	cm.updateListeners = append(cm.updateListeners, fn)
+	cm.mutex.Unlock()
+}
+
+func (cm *ClientManager) notifyUpdate(clients ...*Client) {
+	cm.mutex.RLock()
+	listeners := cm.updateListeners
+	cm.mutex.RUnlock()
+
+	for _, c := range clients {
+		for _, fn := range listeners {
+			fn(c)
+		}
+	}
+}
+
+// OnTransition registers a listener for client state changes.
+func (cm *ClientManager) OnTransition(fn TransitionListener) {
+	cm.mutex.Lock()
+	cm.listeners = append(cm.listeners, fn)
+	cm.mutex.Unlock()
+}
+
+// SetTransitionRetention sets how long transitions are kept. Zero keeps them
+// until maxTransitions is reached.
+func (cm *ClientManager) SetTransitionRetention(d time.Duration) {
+	cm.mutex.Lock()
+	cm.transitionRetention = d
+	cm.mutex.Unlock()
+}
+
+// EraseTransitions drops every recorded transition of a client.
+func (cm *ClientManager) EraseTransitions(uuid string) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
+	kept := make([]Transition, 0, len(cm.transitions))
+	for _, t := range cm.transitions {
+		if t.UUID != uuid {
+			kept = append(kept, t)
+		}
+	}
+	cm.transitions = kept
+}
+
+// Transitions returns the recorded state changes of a client, oldest first.
+// An empty uuid returns the transitions of all clients.
+func (cm *ClientManager) Transitions(uuid string) []Transition {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+
+	result := make([]Transition, 0)
+	for _, t := range cm.transitions {
+		if uuid == "" || t.UUID == uuid {
+			result = append(result, t)
+		}
+	}
+	return result
+}
+
+// transition moves the client to a new state and records it. The caller must
+// hold cm.mutex and pass the result to notify once the lock is released.
+func (cm *ClientManager) transition(client *Client, to ClientState, reason string, at time.Time) []Transition {
+	if client.State == to {
+		return nil
+	}
+
+	t := Transition{
+		UUID:   client.UUID,
+		From:   client.State,
+		To:     to,
+		Reason: reason,
+		At:     at,
+	}
+	client.State = to
+	client.StateSince = at
+
+	cm.transitions = append(cm.transitions, t)
+	drop := 0
+	if len(cm.transitions) > maxTransitions {
+		drop = len(cm.transitions) - maxTransitions
+	}
+	if cm.transitionRetention > 0 {
+		cutoff := at.Add(-cm.transitionRetention)
+		for drop < len(cm.transitions) && cm.transitions[drop].At.Before(cutoff) {
+			drop++
+		}
+	}
+	cm.transitions = cm.transitions[drop:]
+
+	return []Transition{t}
+}
+
+func (cm *ClientManager) notify(ts []Transition) {
+	if len(ts) == 0 {
+		return
+	}
+
+	cm.mutex.RLock()
+	listeners := cm.listeners
+	cm.mutex.RUnlock()
+
+	for _, t := range ts {
+		for _, fn := range listeners {
+			fn(t)
+		}
+	}
+}
+
+// resumeState is the state a suspended client returns to when unblocked.
+func (c *Client) resumeState(now time.Time) ClientState {
+	switch {
+	case now.After(c.ExpiresAt):
+		return StateExpired
+	case c.ActivatedAt.IsZero():
+		return StatePending
+	default:
+		return StateActive
+	}
+}
+
+// renewState is the state an expired client returns to when its
+// subscription is extended.
+func (c *Client) renewState() ClientState {
+	switch {
+	case c.Blocked:
+		return StateSuspended
+	case c.ActivatedAt.IsZero():
+		return StatePending
+	default:
+		return StateActive
+	}
+}
+
+// Sweeper periodically expires clients whose access has ended, purges
+// expired clients once the grace period has passed and rolls over quota
+// periods.
+type Sweeper struct {
+	clientManager *ClientManager
+	interval      time.Duration
+	grace         time.Duration
+}
+
+func NewSweeper(clientManager *ClientManager, interval, grace time.Duration) *Sweeper {
+	return &Sweeper{
+		clientManager: clientManager,
+		interval:      interval,
+		grace:         grace,
+	}
+}
+
+// Run sweeps until the context is cancelled.
+func (s *Sweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.Sweep(time.Now())
+		}
+	}
+}
+
+// Sweep applies time-based transitions as of now.
+func (s *Sweeper) Sweep(now time.Time) {
+	cm := s.clientManager
+
+	cm.mutex.Lock()
+	var ts []Transition
+	var events []QuotaEvent
+	rolledOver := false
+	for uuid, client := range cm.clients {
+		start := client.PeriodStart
+		events = append(events, cm.sweepQuota(client, now)...)
+		rolledOver = rolledOver || !client.PeriodStart.Equal(start)
+
+		switch client.State {
+		case StatePending, StateActive, StateSuspended:
+			if now.After(client.ExpiresAt) {
+				ts = append(ts, cm.transition(client, StateExpired, "subscription ended", now)...)
+			}
+		case StateExpired:
+			if now.Sub(client.StateSince) > s.grace {
+				ts = append(ts, cm.transition(client, StateDeleted, "grace period elapsed", now)...)
+				delete(cm.clients, uuid)
+			}
+		}
+	}
+	cm.mutex.Unlock()
+
+	if len(ts) > 0 || rolledOver {
+		cm.dirty.Store(true)
+	}
+	// This also saves the traffic reported since the last sweep
+	cm.Save()
+
+	if len(ts) > 0 {
+		slog.Info("Sweeper applied client transitions", "count", len(ts))
+	}
+	cm.notify(ts)
+	cm.notifyQuota(events)
+}
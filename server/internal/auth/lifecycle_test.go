@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+const (
+	day   = 24 * time.Hour
+	grace = 7 * day
+)
+
+// newTestClient creates a client that expires in 30 days, optionally
+// activating and then blocking it.
+func newTestClient(t *testing.T, cm *ClientManager, activate, block bool) *Client {
+	t.Helper()
+	client, err := cm.CreateClient(30*day, ClientUpdate{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if activate {
+		cm.ActivateClient(client.UUID)
+	}
+	if block {
+		cm.BlockClient(client.UUID)
+	}
+	return client
+}
+
+func TestSweep(t *testing.T) {
+	for _, test := range []struct {
+		name            string
+		activate, block bool
+		// sweeps are days after creation; the client is swept at each
+		sweeps []time.Duration
+		want   ClientState
+		reason string
+	}{
+		{"pending before expiry", false, false, []time.Duration{29 * day}, StatePending, "created"},
+		{"active before expiry", true, false, []time.Duration{29 * day}, StateActive, "first connection"},
+		{"pending past expiry", false, false, []time.Duration{31 * day}, StateExpired, "subscription ended"},
+		{"active past expiry", true, false, []time.Duration{31 * day}, StateExpired, "subscription ended"},
+		{"suspended past expiry", true, true, []time.Duration{31 * day}, StateExpired, "subscription ended"},
+		{"within grace", true, false, []time.Duration{31 * day, 31*day + grace}, StateExpired, "subscription ended"},
+		{"past grace", true, false, []time.Duration{31 * day, 31*day + grace + time.Second}, StateDeleted, "grace period elapsed"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cm := NewClientManager()
+			client := newTestClient(t, cm, test.activate, test.block)
+			sweeper := NewSweeper(cm, time.Minute, grace)
+			for _, after := range test.sweeps {
+				sweeper.Sweep(client.CreatedAt.Add(after))
+			}
+
+			ts := cm.Transitions(client.UUID)
+			last := ts[len(ts)-1]
+			if last.To != test.want || last.Reason != test.reason {
+				t.Errorf("last transition to %s (%s), want %s (%s)", last.To, last.Reason, test.want, test.reason)
+			}
+			got, exists := cm.FindClient(client.UUID)
+			if test.want == StateDeleted {
+				if exists {
+					t.Errorf("client still present in state %s", got.State)
+				}
+				return
+			}
+			if !exists || got.State != test.want {
+				t.Errorf("client %+v, want state %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSweepNotifies(t *testing.T) {
+	cm := NewClientManager()
+	client := newTestClient(t, cm, true, false)
+	var got []Transition
+	cm.OnTransition(func(t Transition) {
+		got = append(got, t)
+	})
+
+	NewSweeper(cm, time.Minute, grace).Sweep(client.ExpiresAt.Add(time.Second))
+	if len(got) != 1 || got[0].From != StateActive || got[0].To != StateExpired {
+		t.Errorf("notified %+v, want one active -> expired", got)
+	}
+}
+
+func TestRenewAndResume(t *testing.T) {
+	for _, test := range []struct {
+		name            string
+		activate, block bool
+		// unblock lifts the block after the client was renewed
+		unblock bool
+		want    ClientState
+	}{
+		{"pending", false, false, false, StatePending},
+		{"active", true, false, false, StateActive},
+		{"blocked", true, true, false, StateSuspended},
+		{"unblocked", true, true, true, StateActive},
+		{"unblocked before activation", false, true, true, StatePending},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cm := NewClientManager()
+			client := newTestClient(t, cm, test.activate, test.block)
+			NewSweeper(cm, time.Minute, grace).Sweep(client.ExpiresAt.Add(time.Second))
+			if c, _ := cm.FindClient(client.UUID); c.State != StateExpired {
+				t.Fatalf("state %s after the sweep, want expired", c.State)
+			}
+
+			renewed, _ := cm.UpdateClient(client.UUID, ClientUpdate{Extend: 30 * day})
+			if test.unblock {
+				cm.UnblockClient(client.UUID)
+				renewed, _ = cm.FindClient(client.UUID)
+			}
+			if renewed.State != test.want {
+				t.Errorf("state %s, want %s", renewed.State, test.want)
+			}
+		})
+	}
+}
+
+func TestTransitionRetention(t *testing.T) {
+	cm := NewClientManager()
+	old := newTestClient(t, cm, false, false)
+	cm.SetTransitionRetention(time.Hour)
+
+	// The sweep is far enough ahead that the creation falls out of retention
+	NewSweeper(cm, time.Minute, grace).Sweep(old.ExpiresAt.Add(time.Second))
+	ts := cm.Transitions(old.UUID)
+	if len(ts) != 1 || ts[0].To != StateExpired {
+		t.Errorf("transitions %+v, want only the expiry", ts)
+	}
+
+	cm.EraseTransitions(old.UUID)
+	if ts := cm.Transitions(""); len(ts) != 0 {
+		t.Errorf("transitions %+v after erasing", ts)
+	}
+}
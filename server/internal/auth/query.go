@@ -0,0 +1,221 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Sort fields accepted by ClientQuery.Sort. A leading "-" sorts descending.
+const (
+	SortCreatedAt = "created_at"
+	SortExpiresAt = "expires_at"
+	SortName      = "name"
+	SortTraffic   = "traffic"
+)
+
+// ClientQuery filters, sorts and paginates the client list. Zero values
+// disable the corresponding filter; a zero Limit returns every match.
+type ClientQuery struct {
+	States        []ClientState
+	Tag           string
+	Text          string
+	ExpiresAfter  time.Time
+	ExpiresBefore time.Time
+	Sort          string
+	Limit         int
+	Cursor        string
+}
+
+type ClientPage struct {
+	Clients    []*Client
+	Total      int
+	NextCursor string
+}
+
+// cursor identifies the last client of a page by its sort key and UUID, so
+// pages stay stable while clients are added or removed.
+type cursor struct {
+	Sort string `json:"s"`
+	Str  string `json:"v,omitempty"`
+	Num  int64  `json:"n,omitempty"`
+	UUID string `json:"u"`
+}
+
+func (cm *ClientManager) QueryClients(q ClientQuery) (*ClientPage, error) {
+	sortField, desc := strings.TrimPrefix(q.Sort, "-"), strings.HasPrefix(q.Sort, "-")
+	if sortField == "" {
+		sortField = SortCreatedAt
+	}
+	switch sortField {
+	case SortCreatedAt, SortExpiresAt, SortName, SortTraffic:
+	default:
+		return nil, errors.New("unknown sort field: " + sortField)
+	}
+
+	var after *cursor
+	if q.Cursor != "" {
+		c, err := decodeCursor(q.Cursor)
+		if err != nil || c.Sort != q.Sort {
+			return nil, ErrInvalidCursor
+		}
+		after = c
+	}
+
+	text := strings.ToLower(strings.TrimSpace(q.Text))
+	tag := normalizeTag(q.Tag)
+
+	cm.mutex.RLock()
+	matched := make([]*Client, 0)
+	for _, client := range cm.clients {
+		if client.matches(q, tag, text) {
+			matched = append(matched, client.snapshot())
+		}
+	}
+	cm.mutex.RUnlock()
+
+	less := func(a, b *Client) bool {
+		ka, kb := sortKey(a, sortField), sortKey(b, sortField)
+		if c := ka.compare(kb); c != 0 {
+			return (c < 0) != desc
+		}
+		return a.UUID < b.UUID
+	}
+	sort.Slice(matched, func(i, j int) bool { return less(matched[i], matched[j]) })
+
+	start := 0
+	if after != nil {
+		start = sort.Search(len(matched), func(i int) bool {
+			k := sortKey(matched[i], sortField)
+			c := k.compare(*after)
+			if c == 0 {
+				return matched[i].UUID > after.UUID
+			}
+			return (c > 0) != desc
+		})
+	}
+
+	page := &ClientPage{Total: len(matched)}
+	end := len(matched)
+	if q.Limit > 0 && start+q.Limit < end {
+		end = start + q.Limit
+		last := sortKey(matched[end-1], sortField)
+		last.Sort = q.Sort
+		last.UUID = matched[end-1].UUID
+		page.NextCursor = encodeCursor(last)
+	}
+	page.Clients = matched[start:end]
+
+	return page, nil
+}
+
+func (c *Client) matches(q ClientQuery, tag, text string) bool {
+	if len(q.States) > 0 {
+		found := false
+		for _, state := range q.States {
+			if c.State == state {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if tag != "" && !c.HasTag(tag) {
+		return false
+	}
+	if !q.ExpiresAfter.IsZero() && c.ExpiresAt.Before(q.ExpiresAfter) {
+		return false
+	}
+	if !q.ExpiresBefore.IsZero() && !c.ExpiresAt.Before(q.ExpiresBefore) {
+		return false
+	}
+	if text != "" {
+		fields := []string{c.UUID, c.Name, c.Email, c.Contact, c.Notes, strings.Join(c.Tags, " ")}
+		for _, f := range fields {
+			if strings.Contains(strings.ToLower(f), text) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// HasTag reports whether the client carries the given normalized tag.
+func (c *Client) HasTag(tag string) bool {
+	for _, t := range c.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func sortKey(c *Client, field string) cursor {
+	switch field {
+	case SortExpiresAt:
+		return cursor{Num: c.ExpiresAt.UnixNano()}
+	case SortName:
+		return cursor{Str: strings.ToLower(c.Name)}
+	case SortTraffic:
+		return cursor{Num: c.BytesUp + c.BytesDown}
+	default:
+		return cursor{Num: c.CreatedAt.UnixNano()}
+	}
+}
+
+func (k cursor) compare(o cursor) int {
+	if k.Str != o.Str {
+		return strings.Compare(k.Str, o.Str)
+	}
+	switch {
+	case k.Num < o.Num:
+		return -1
+	case k.Num > o.Num:
+		return 1
+	}
+	return 0
+}
+
+func encodeCursor(c cursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// NormalizeTags lowercases, trims and de-duplicates tags, dropping empty ones.
+func NormalizeTags(tags []string) []string {
+	result := make([]string, 0, len(tags))
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		tag = normalizeTag(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		result = append(result, tag)
+	}
+	return result
+}
+
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// newQueryClients creates clients with repeated names and traffic so that
+// every sort has ties for the UUID to break.
+func newQueryClients(t *testing.T) *ClientManager {
+	t.Helper()
+	cm := NewClientManager()
+	for i, name := range []string{"Boris", "anna", "Anna", "Vera", "boris", "Gleb", "Dina"} {
+		tags := []string{"Team-" + name[:1]}
+		client, err := cm.CreateClient(time.Duration(i%3+1)*day, ClientUpdate{Name: &name, Tags: &tags})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cm.AddUsage(client.UUID, int64(i%2)<<20, 0)
+	}
+	return cm
+}
+
+func uuids(clients []*Client) []string {
+	result := make([]string, len(clients))
+	for i, c := range clients {
+		result[i] = c.UUID
+	}
+	return result
+}
+
+// TestQueryPages pages through every sort two clients at a time and checks
+// that the pages add up to the unpaginated list.
+func TestQueryPages(t *testing.T) {
+	cm := newQueryClients(t)
+	for _, sort := range []string{"", SortCreatedAt, "-" + SortCreatedAt, SortExpiresAt, "-" + SortExpiresAt, SortName, "-" + SortName, SortTraffic, "-" + SortTraffic} {
+		t.Run(sort, func(t *testing.T) {
+			all, err := cm.QueryClients(ClientQuery{Sort: sort})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if all.Total != 7 || len(all.Clients) != 7 || all.NextCursor != "" {
+				t.Fatalf("unpaginated: total %d, %d clients, cursor %q", all.Total, len(all.Clients), all.NextCursor)
+			}
+
+			var paged []*Client
+			q := ClientQuery{Sort: sort, Limit: 2}
+			for range 10 {
+				page, err := cm.QueryClients(q)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if page.Total != 7 {
+					t.Errorf("total %d on a page", page.Total)
+				}
+				paged = append(paged, page.Clients...)
+				if page.NextCursor == "" {
+					break
+				}
+				q.Cursor = page.NextCursor
+			}
+			if got, want := uuids(paged), uuids(all.Clients); !slices.Equal(got, want) {
+				t.Errorf("pages %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+// TestQueryCursorSurvivesDeletion deletes the client a cursor points at; the
+// next page continues after it rather than starting over.
+func TestQueryCursorSurvivesDeletion(t *testing.T) {
+	cm := newQueryClients(t)
+	all, _ := cm.QueryClients(ClientQuery{Sort: SortName})
+	first, err := cm.QueryClients(ClientQuery{Sort: SortName, Limit: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm.DeleteClient(first.Clients[2].UUID)
+
+	next, err := cm.QueryClients(ClientQuery{Sort: SortName, Limit: 3, Cursor: first.NextCursor})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := uuids(next.Clients), uuids(all.Clients[3:6]); !slices.Equal(got, want) {
+		t.Errorf("next page %v, want %v", got, want)
+	}
+}
+
+func TestQueryInvalid(t *testing.T) {
+	cm := newQueryClients(t)
+	page, _ := cm.QueryClients(ClientQuery{Sort: SortName, Limit: 2})
+
+	for _, test := range []struct {
+		name string
+		q    ClientQuery
+	}{
+		{"not base64", ClientQuery{Cursor: "!!"}},
+		{"not JSON", ClientQuery{Cursor: "bm90IGpzb24"}},
+		{"other sort", ClientQuery{Sort: "-" + SortName, Cursor: page.NextCursor}},
+		{"default sort", ClientQuery{Cursor: page.NextCursor}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := cm.QueryClients(test.q); err != ErrInvalidCursor {
+				t.Errorf("error %v, want ErrInvalidCursor", err)
+			}
+		})
+	}
+	if _, err := cm.QueryClients(ClientQuery{Sort: "email"}); err == nil || err == ErrInvalidCursor {
+		t.Errorf("unknown sort field: error %v", err)
+	}
+}
+
+func TestQueryFilters(t *testing.T) {
+	cm := newQueryClients(t)
+	boris, _ := cm.QueryClients(ClientQuery{Text: "bor", Sort: SortName})
+	cm.ActivateClient(boris.Clients[0].UUID)
+
+	for _, test := range []struct {
+		name string
+		q    ClientQuery
+		want int
+	}{
+		{"text is case-insensitive", ClientQuery{Text: " ANNA "}, 2},
+		{"tag is normalized", ClientQuery{Tag: " team-b"}, 2},
+		{"state", ClientQuery{States: []ClientState{StateActive}}, 1},
+		{"states", ClientQuery{States: []ClientState{StatePending, StateActive}}, 7},
+		{"expires after", ClientQuery{ExpiresAfter: time.Now().Add(2*day + time.Hour)}, 2},
+		{"expires before", ClientQuery{ExpiresBefore: time.Now().Add(day + time.Hour)}, 3},
+		{"combined", ClientQuery{Tag: "team-b", States: []ClientState{StatePending}}, 1},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			page, err := cm.QueryClients(test.q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if page.Total != test.want || len(page.Clients) != test.want {
+				t.Errorf("total %d, %d clients, want %d", page.Total, len(page.Clients), test.want)
+			}
+		})
+	}
+}
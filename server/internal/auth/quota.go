@@ -0,0 +1,284 @@
+package auth
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// QuotaReset decides when a client's period usage starts over.
+type QuotaReset string
+
+const (
+	// QuotaResetNever makes the quota a total for the client's lifetime.
+	QuotaResetNever QuotaReset = ""
+	// QuotaResetMonthly resets on the first day of every calendar month (UTC).
+	QuotaResetMonthly QuotaReset = "monthly"
+	// QuotaResetRolling resets every QuotaPolicy.RollingPeriod after the
+	// period start.
+	QuotaResetRolling QuotaReset = "rolling"
+)
+
+func ParseQuotaReset(s string) (QuotaReset, error) {
+	switch r := QuotaReset(s); r {
+	case QuotaResetNever, QuotaResetMonthly, QuotaResetRolling:
+		return r, nil
+	}
+	return "", fmt.Errorf("unknown quota reset %q", s)
+}
+
+// QuotaAction is what happens to a client that exhausts its quota.
+type QuotaAction string
+
+const (
+	// QuotaActionDisconnect closes live sessions and refuses new ones until
+	// the quota is reset or raised.
+	QuotaActionDisconnect QuotaAction = "disconnect"
+	// QuotaActionThrottle keeps the client connected at a reduced speed.
+	QuotaActionThrottle QuotaAction = "throttle"
+)
+
+func ParseQuotaAction(s string) (QuotaAction, error) {
+	switch a := QuotaAction(s); a {
+	case QuotaActionDisconnect, QuotaActionThrottle:
+		return a, nil
+	}
+	return "", fmt.Errorf("unknown quota action %q", s)
+}
+
+// QuotaPolicy holds the server-wide quota settings.
+type QuotaPolicy struct {
+	// Thresholds are usage percentages at which the client is warned.
+	Thresholds    []int
+	Action        QuotaAction
+	RollingPeriod time.Duration
+}
+
+func DefaultQuotaPolicy() QuotaPolicy {
+	return QuotaPolicy{
+		Thresholds:    []int{80, 90},
+		Action:        QuotaActionDisconnect,
+		RollingPeriod: 30 * 24 * time.Hour,
+	}
+}
+
+type QuotaEventKind string
+
+const (
+	QuotaWarning  QuotaEventKind = "warning"
+	QuotaExceeded QuotaEventKind = "exceeded"
+	// QuotaRestored is sent when an exhausted quota is reset or raised.
+	QuotaRestored QuotaEventKind = "restored"
+)
+
+type QuotaEvent struct {
+	UUID    string         `json:"uuid"`
+	Kind    QuotaEventKind `json:"kind"`
+	Percent int            `json:"percent"`
+	Used    int64          `json:"used"`
+	Quota   int64          `json:"quota"`
+	Action  QuotaAction    `json:"action,omitempty"`
+	At      time.Time      `json:"at"`
+}
+
+// QuotaListener is called outside the manager lock for every quota event.
+type QuotaListener func(QuotaEvent)
+
+// SetQuotaPolicy replaces the server-wide quota settings.
+func (cm *ClientManager) SetQuotaPolicy(policy QuotaPolicy) {
+	thresholds := append([]int(nil), policy.Thresholds...)
+	sort.Ints(thresholds)
+	policy.Thresholds = thresholds
+
+	cm.mutex.Lock()
+	cm.quotaPolicy = policy
+	cm.mutex.Unlock()
+}
+
+func (cm *ClientManager) QuotaPolicy() QuotaPolicy {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+	return cm.quotaPolicy
+}
+
+// OnQuota registers a listener for quota warnings, exhaustion and restores.
+func (cm *ClientManager) OnQuota(fn QuotaListener) {
+	cm.mutex.Lock()
+	cm.quotaListeners = append(cm.quotaListeners, fn)
+	cm.mutex.Unlock()
+}
+
+func (cm *ClientManager) notifyQuota(events []QuotaEvent) {
+	if len(events) == 0 {
+		return
+	}
+
+	cm.mutex.RLock()
+	listeners := cm.quotaListeners
+	cm.mutex.RUnlock()
+
+	for _, e := range events {
+		for _, fn := range listeners {
+			fn(e)
+		}
+	}
+}
+
+// AddUsage adds live traffic to the client's lifetime and period counters
+// and reports whether the quota is exhausted.
+func (cm *ClientManager) AddUsage(uuid string, bytesUp, bytesDown int64) bool {
+	cm.mutex.Lock()
+	client, exists := cm.clients[uuid]
+	var events []QuotaEvent
+	exceeded := false
+	if exists {
+		client.BytesUp += bytesUp
+		client.BytesDown += bytesDown
+		client.PeriodBytes += bytesUp + bytesDown
+		events = cm.checkQuota(client, time.Now())
+		exceeded = client.QuotaExceeded
+	}
+	cm.mutex.Unlock()
+
+	// Traffic is saved by the next sweep rather than on every report
+	if exists {
+		cm.dirty.Store(true)
+	}
+
+	cm.notifyQuota(events)
+	return exceeded
+}
+
+// ResetQuota starts a new quota period for the client immediately.
+func (cm *ClientManager) ResetQuota(uuid string) (*Client, bool) {
+	cm.mutex.Lock()
+	client, exists := cm.clients[uuid]
+	var events []QuotaEvent
+	if exists {
+		now := time.Now()
+		events = cm.resetPeriod(client, now, now)
+		client.Revision++
+		client = client.snapshot()
+	}
+	cm.mutex.Unlock()
+
+	if exists {
+		cm.save()
+	}
+	cm.notifyQuota(events)
+	return client, exists
+}
+
+// quotaBlocks reports whether exhaustion should keep the client offline.
+// The caller must hold cm.mutex.
+func (cm *ClientManager) quotaBlocks(client *Client) bool {
+	return client.QuotaExceeded && cm.quotaPolicy.Action == QuotaActionDisconnect
+}
+
+// checkQuota raises warnings and exhaustion for the current period usage,
+// and clears exhaustion and re-arms warnings when the quota was raised or
+// removed. The caller must hold cm.mutex.
+func (cm *ClientManager) checkQuota(client *Client, now time.Time) []QuotaEvent {
+	event := QuotaEvent{
+		UUID:   client.UUID,
+		Used:   client.PeriodBytes,
+		Quota:  client.QuotaBytes,
+		Action: cm.quotaPolicy.Action,
+		At:     now,
+	}
+
+	if client.QuotaBytes <= 0 || client.PeriodBytes < client.QuotaBytes {
+		client.QuotaWarned = min(client.QuotaWarned, cm.warnedLevel(client))
+		if client.QuotaExceeded {
+			client.QuotaExceeded = false
+			event.Kind = QuotaRestored
+			return []QuotaEvent{event}
+		}
+	}
+	if client.QuotaBytes <= 0 {
+		return nil
+	}
+
+	percent := int(client.PeriodBytes * 100 / client.QuotaBytes)
+	event.Percent = percent
+
+	if client.PeriodBytes >= client.QuotaBytes {
+		if client.QuotaExceeded {
+			return nil
+		}
+		client.QuotaExceeded = true
+		client.QuotaWarned = 100
+		event.Kind = QuotaExceeded
+		return []QuotaEvent{event}
+	}
+
+	crossed := 0
+	for _, threshold := range cm.quotaPolicy.Thresholds {
+		if threshold > client.QuotaWarned && threshold <= percent {
+			crossed = threshold
+		}
+	}
+	if crossed == 0 {
+		return nil
+	}
+	client.QuotaWarned = crossed
+	event.Kind = QuotaWarning
+	return []QuotaEvent{event}
+}
+
+// warnedLevel is the highest warning threshold the client's usage has
+// reached. The caller must hold cm.mutex.
+func (cm *ClientManager) warnedLevel(client *Client) int {
+	if client.QuotaBytes <= 0 {
+		return 0
+	}
+	percent := int(client.PeriodBytes * 100 / client.QuotaBytes)
+	level := 0
+	for _, threshold := range cm.quotaPolicy.Thresholds {
+		if threshold <= percent {
+			level = threshold
+		}
+	}
+	return level
+}
+
+// resetPeriod starts a new quota period at start. The caller must hold
+// cm.mutex.
+func (cm *ClientManager) resetPeriod(client *Client, start, now time.Time) []QuotaEvent {
+	client.PeriodStart = start
+	client.PeriodBytes = 0
+	client.QuotaWarned = 0
+	return cm.checkQuota(client, now)
+}
+
+// nextReset returns when the client's current quota period ends, or the
+// zero time if it never does.
+func (c *Client) nextReset(policy QuotaPolicy) time.Time {
+	switch c.QuotaReset {
+	case QuotaResetMonthly:
+		start := c.PeriodStart.UTC()
+		return time.Date(start.Year(), start.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+	case QuotaResetRolling:
+		return c.PeriodStart.Add(policy.RollingPeriod)
+	}
+	return time.Time{}
+}
+
+// sweepQuota rolls over the quota periods that ended before now. The caller
+// must hold cm.mutex.
+func (cm *ClientManager) sweepQuota(client *Client, now time.Time) []QuotaEvent {
+	next := client.nextReset(cm.quotaPolicy)
+	if next.IsZero() || now.Before(next) {
+		return nil
+	}
+
+	// Skip whole periods the server was not running for
+	for {
+		following := (&Client{QuotaReset: client.QuotaReset, PeriodStart: next}).nextReset(cm.quotaPolicy)
+		if now.Before(following) || !following.After(next) {
+			break
+		}
+		next = following
+	}
+	return cm.resetPeriod(client, next, now)
+}
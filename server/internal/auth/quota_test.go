@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+const mib = 1 << 20
+
+// quotaStep changes a client with a 100 MiB quota.
+type quotaStep func(cm *ClientManager, uuid string)
+
+func use(n int64) quotaStep {
+	return func(cm *ClientManager, uuid string) { cm.AddUsage(uuid, 0, n*mib) }
+}
+
+func setQuota(n int64) quotaStep {
+	return func(cm *ClientManager, uuid string) {
+		quota := n * mib
+		cm.UpdateClient(uuid, ClientUpdate{QuotaBytes: &quota})
+	}
+}
+
+func resetQuota(cm *ClientManager, uuid string) { cm.ResetQuota(uuid) }
+
+// TestQuota runs each client through usage and quota changes and checks the
+// events raised, written as kind:percent.
+func TestQuota(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		steps  []quotaStep
+		events string
+		warned int
+	}{
+		{"below thresholds", []quotaStep{use(79)}, "", 0},
+		{"thresholds once each", []quotaStep{use(85), use(1), use(5)}, "warning:85 warning:91", 90},
+		{"skipped threshold", []quotaStep{use(95)}, "warning:95", 90},
+		{"exhausted", []quotaStep{use(85), use(20), use(10)}, "warning:85 exceeded:105", 100},
+		{"raised quota re-arms warnings", []quotaStep{use(100), setQuota(200), use(70)},
+			"exceeded:100 restored:0 warning:85", 80},
+		{"raised quota keeps reached warnings", []quotaStep{use(100), setQuota(120), use(10)},
+			"exceeded:100 restored:0 warning:91", 90},
+		{"raised quota without exhaustion", []quotaStep{use(95), setQuota(1000), use(705)},
+			"warning:95 warning:80", 80},
+		{"removed quota", []quotaStep{use(100), setQuota(0), use(100)}, "exceeded:100 restored:0", 0},
+		{"reset", []quotaStep{use(100), resetQuota, use(80)}, "exceeded:100 restored:0 warning:80", 80},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cm := NewClientManager()
+			var events []string
+			cm.OnQuota(func(e QuotaEvent) {
+				events = append(events, fmt.Sprintf("%s:%d", e.Kind, e.Percent))
+			})
+			quota := int64(100 * mib)
+			client, err := cm.CreateClient(30*day, ClientUpdate{QuotaBytes: &quota})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, step := range test.steps {
+				step(cm, client.UUID)
+			}
+			if got := strings.Join(events, " "); got != test.events {
+				t.Errorf("events %q, want %q", got, test.events)
+			}
+			if c, _ := cm.FindClient(client.UUID); c.QuotaWarned != test.warned {
+				t.Errorf("warned at %d, want %d", c.QuotaWarned, test.warned)
+			}
+		})
+	}
+}
+
+// TestQuotaBlocks checks that an exhausted client only loses access under
+// the disconnect action.
+func TestQuotaBlocks(t *testing.T) {
+	for _, action := range []QuotaAction{QuotaActionDisconnect, QuotaActionThrottle} {
+		cm := NewClientManager()
+		policy := DefaultQuotaPolicy()
+		policy.Action = action
+		cm.SetQuotaPolicy(policy)
+		quota := int64(mib)
+		client, _ := cm.CreateClient(30*day, ClientUpdate{QuotaBytes: &quota})
+
+		if exceeded := cm.AddUsage(client.UUID, mib, 0); !exceeded {
+			t.Errorf("%s: AddUsage did not report exhaustion", action)
+		}
+		_, allowed := cm.GetClient(client.UUID)
+		if want := action == QuotaActionThrottle; allowed != want {
+			t.Errorf("%s: allowed to connect %t, want %t", action, allowed, want)
+		}
+	}
+}
+
+func TestQuotaSweep(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		reset QuotaReset
+		start time.Time
+		now   time.Time
+		want  time.Time // the new period start; zero if no reset is due
+	}{
+		{"never", QuotaResetNever,
+			time.Date(2026, 1, 10, 0, 0, 0, 0, time.UTC), time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC), time.Time{}},
+		{"monthly not due", QuotaResetMonthly,
+			time.Date(2026, 1, 10, 0, 0, 0, 0, time.UTC), time.Date(2026, 1, 31, 23, 0, 0, 0, time.UTC), time.Time{}},
+		{"monthly", QuotaResetMonthly,
+			time.Date(2026, 1, 10, 0, 0, 0, 0, time.UTC), time.Date(2026, 2, 3, 0, 0, 0, 0, time.UTC), time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"monthly after downtime", QuotaResetMonthly,
+			time.Date(2026, 1, 10, 0, 0, 0, 0, time.UTC), time.Date(2026, 4, 15, 0, 0, 0, 0, time.UTC), time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"rolling", QuotaResetRolling,
+			time.Date(2026, 1, 10, 0, 0, 0, 0, time.UTC), time.Date(2026, 2, 10, 0, 0, 0, 0, time.UTC), time.Date(2026, 2, 9, 0, 0, 0, 0, time.UTC)},
+		{"rolling after downtime", QuotaResetRolling,
+			time.Date(2026, 1, 10, 0, 0, 0, 0, time.UTC), time.Date(2026, 3, 12, 0, 0, 0, 0, time.UTC), time.Date(2026, 3, 11, 0, 0, 0, 0, time.UTC)},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cm := NewClientManager()
+			client := &Client{UUID: "c", QuotaBytes: 100 * mib, QuotaReset: test.reset,
+				PeriodStart: test.start, PeriodBytes: 90 * mib, QuotaWarned: 90}
+
+			cm.sweepQuota(client, test.now)
+			if test.want.IsZero() {
+				if !client.PeriodStart.Equal(test.start) || client.PeriodBytes != 90*mib {
+					t.Errorf("period reset to %v", client.PeriodStart)
+				}
+				return
+			}
+			if !client.PeriodStart.Equal(test.want) || client.PeriodBytes != 0 || client.QuotaWarned != 0 {
+				t.Errorf("period from %v with %d bytes, warned at %d; want a new period from %v",
+					client.PeriodStart, client.PeriodBytes, client.QuotaWarned, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package auth
+
+import "fmt"
+
+// SessionPolicy decides what happens when a client that already has the
+// maximum number of live sessions connects again.
+type SessionPolicy string
+
+const (
+	// SessionPolicyReject refuses the new connection.
+	SessionPolicyReject SessionPolicy = "reject"
+	// SessionPolicyReplace disconnects the oldest session to make room.
+	SessionPolicyReplace SessionPolicy = "replace"
+)
+
+func ParseSessionPolicy(s string) (SessionPolicy, error) {
+	switch p := SessionPolicy(s); p {
+	case SessionPolicyReject, SessionPolicyReplace:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown session policy %q", s)
+}
+
+// SessionLimit returns the client's concurrent session limit and policy,
+// falling back to the given server defaults for unset fields. A client
+// MaxSessions of -1 lifts the default limit. A returned limit of 0 means
+// unlimited.
+func (c *Client) SessionLimit(defaultMax int, defaultPolicy SessionPolicy) (int, SessionPolicy) {
+	max, policy := c.MaxSessions, c.SessionPolicy
+	switch {
+	case max == 0:
+		max = defaultMax
+	case max < 0:
+		max = 0
+	}
+	if policy == "" {
+		policy = defaultPolicy
+	}
+	return max, policy
+}
@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+
+	"yagnoetik-vpn/internal/storage"
+)
+
+// savedClients is the file format of a saved ClientManager.
+type savedClients struct {
+	Clients []savedClient `json:"clients"` // oldest first
+}
+
+// savedClient adds the key, which the API never shows.
+type savedClient struct {
+	Client
+	Key []byte `json:"key"`
+}
+
+// Open creates a ClientManager that saves its clients to the file at path
+// and loads the clients already there. Admin changes are saved before the
+// call that made them returns; traffic counters are saved by each sweep
+// and by Save.
+func Open(path string) (*ClientManager, error) {
+	var saved savedClients
+	if err := storage.ReadJSON(path, &saved); err != nil {
+		return nil, err
+	}
+
+	cm := NewClientManager()
+	cm.path = path
+	for _, sc := range saved.Clients {
+		c := sc.Client
+		c.Key = sc.Key
+		if err := c.validateImport(); err != nil {
+			return nil, fmt.Errorf("%s: client %s: %w", path, c.UUID, err)
+		}
+		if len(c.Key) == 0 || c.Secret == "" {
+			return nil, fmt.Errorf("%s: client %s has no credentials", path, c.UUID)
+		}
+		if c.Tags == nil {
+			c.Tags = []string{}
+		}
+		cm.clients[c.UUID] = &c
+	}
+	return cm, nil
+}
+
+// save records that the clients changed and writes them to the manager's
+// file. The caller must not hold cm.mutex.
+func (cm *ClientManager) save() {
+	cm.dirty.Store(true)
+	cm.Save()
+}
+
+// Save writes the clients to the manager's file if they changed since the
+// last save. A failure is logged and retried by the next save: the changes
+// it records have already been made.
+func (cm *ClientManager) Save() {
+	if cm.path == "" {
+		return
+	}
+
+	// Saves are serialized, so that an older snapshot never replaces a
+	// newer one
+	cm.saveMutex.Lock()
+	defer cm.saveMutex.Unlock()
+
+	if !cm.dirty.Swap(false) {
+		return
+	}
+	cm.mutex.RLock()
+	saved := savedClients{Clients: make([]savedClient, 0, len(cm.clients))}
+	for _, c := range cm.clients {
+		s := c.snapshot()
+		saved.Clients = append(saved.Clients, savedClient{Client: *s, Key: s.Key})
+	}
+	cm.mutex.RUnlock()
+
+	sort.Slice(saved.Clients, func(i, j int) bool {
+		a, b := saved.Clients[i], saved.Clients[j]
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.UUID < b.UUID
+	})
+	if err := storage.WriteJSON(cm.path, saved); err != nil {
+		cm.dirty.Store(true)
+		slog.Error("Failed to save clients", "error", err)
+	}
+}
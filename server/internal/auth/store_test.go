@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOpenReloadsClients(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clients.json")
+	cm, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := "Ivan"
+	created, err := cm.CreateClient(30*day, ClientUpdate{Name: &name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm.ActivateClient(created.UUID)
+	blocked, _ := cm.CreateClient(30*day, ClientUpdate{})
+	cm.BlockClient(blocked.UUID)
+	deleted, _ := cm.CreateClient(30*day, ClientUpdate{})
+	cm.DeleteClient(deleted.UUID)
+
+	// Traffic is only saved by a sweep
+	cm.AddUsage(created.UUID, 100, 200)
+	if reopened, _ := Open(path); reopened.clients[created.UUID].BytesUp != 0 {
+		t.Error("traffic was saved before the sweep")
+	}
+	NewSweeper(cm, time.Minute, grace).Sweep(time.Now())
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reopened.clients) != 2 {
+		t.Fatalf("%d clients after reopening, want 2", len(reopened.clients))
+	}
+	c := reopened.clients[created.UUID]
+	switch {
+	case c.Secret != created.Secret || !bytes.Equal(c.Key, created.Key):
+		t.Error("credentials changed")
+	case c.Name != name || c.State != StateActive || c.ActivatedAt.IsZero():
+		t.Errorf("got %+v, want the active client %q", c, name)
+	case c.BytesUp != 100 || c.BytesDown != 200 || c.PeriodBytes != 300:
+		t.Errorf("usage %d/%d/%d, want 100/200/300", c.BytesUp, c.BytesDown, c.PeriodBytes)
+	}
+	if b := reopened.clients[blocked.UUID]; !b.Blocked || b.State != StateSuspended {
+		t.Errorf("blocked client came back %s, blocked %t", b.State, b.Blocked)
+	}
+	if _, ok := reopened.GetClient(created.UUID); !ok {
+		t.Error("reloaded client cannot connect")
+	}
+}
+
+func TestOpenRejectsBrokenFile(t *testing.T) {
+	for _, test := range []struct {
+		name, contents string
+	}{
+		{"not JSON", "{"},
+		{"bad UUID", `{"clients":[{"uuid":"x","secret":"s","key":"` + string(bytes.Repeat([]byte("A"), 44)) + `","expires_at":"2030-01-01T00:00:00Z"}]}`},
+		{"no key", `{"clients":[{"uuid":"00112233445566778899aabbccddeeff","secret":"s","expires_at":"2030-01-01T00:00:00Z"}]}`},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "clients.json")
+			if err := os.WriteFile(path, []byte(test.contents), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Open(path); err == nil {
+				t.Error("opened a broken file")
+			}
+		})
+	}
+}
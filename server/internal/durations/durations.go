@@ -0,0 +1,33 @@
+// Package durations reads and writes durations the way the admin API and
+// the configuration accept them: Go durations plus a "d" suffix for days.
+package durations
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const day = 24 * time.Hour
+
+// Parse parses a Go duration such as "12h", or a number of days such as
+// "30d" or "1.5d".
+func Parse(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		d, err := time.ParseDuration(days + "h")
+		if err != nil {
+			return 0, err
+		}
+		return d * 24, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// Format writes whole days as "30d" and other durations like time.Duration
+// does.
+func Format(d time.Duration) string {
+	if d > 0 && d%day == 0 {
+		return fmt.Sprintf("%dd", d/day)
+	}
+	return d.String()
+}
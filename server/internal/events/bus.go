@@ -0,0 +1,198 @@
+package events
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event types published on the bus.
+const (
+	ClientCreated      = "client.created"
+	ClientUpdated      = "client.updated"
+	ClientDeleted      = "client.deleted"
+	ClientExpired      = "client.expired"
+	ClientStateChanged = "client.state_changed"
+	ClientExpiring     = "client.expiring"
+	SessionStarted     = "session.started"
+	SessionEnded       = "session.ended"
+	QuotaWarning       = "quota.warning"
+	QuotaExceeded      = "quota.exceeded"
+	QuotaRestored      = "quota.restored"
+	AuthFailureBurst   = "auth.failure_burst"
+	CertExpiring       = "server.cert_expiring"
+	VoucherRedeemed    = "voucher.redeemed"
+)
+
+// Types lists every event type, for validating filters.
+var Types = []string{
+	ClientCreated, ClientUpdated, ClientDeleted, ClientExpired, ClientStateChanged, ClientExpiring,
+	SessionStarted, SessionEnded,
+	QuotaWarning, QuotaExceeded, QuotaRestored,
+	AuthFailureBurst, CertExpiring,
+	VoucherRedeemed,
+}
+
+// Match reports whether an event type is selected by patterns, which hold
+// event types or groups such as "session". Empty patterns select every
+// type.
+func Match(patterns []string, eventType string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if eventType == p || strings.HasPrefix(eventType, p+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// subscriberBuffer is how many events a subscriber may fall behind before
+// it is dropped.
+const subscriberBuffer = 256
+
+// Event is one operational event. IDs increase by one per event and start
+// from the boot time in microseconds, so IDs handed out before a restart
+// are always lower than the ones after it.
+type Event struct {
+	ID     uint64    `json:"id"`
+	Type   string    `json:"type"`
+	Time   time.Time `json:"time"`
+	Client string    `json:"client,omitempty"`
+	Data   any       `json:"data,omitempty"`
+}
+
+// Bus fans events out to subscribers and keeps the most recent ones so that
+// a subscriber can resume after a disconnect.
+type Bus struct {
+	buffer      []Event // ring of the last len(buffer) events
+	next        int     // index in buffer of the next event
+	count       int
+	lastID      uint64
+	subscribers map[*Subscription]struct{}
+	mutex       sync.Mutex
+}
+
+// Subscription receives the events published after it was created. C is
+// closed when the subscriber falls too far behind or Close is called.
+type Subscription struct {
+	C   <-chan Event
+	ch  chan Event
+	bus *Bus
+}
+
+// NewBus creates a bus that keeps the last size events for resuming.
+func NewBus(size int) *Bus {
+	if size <= 0 {
+		size = 1
+	}
+	return &Bus{
+		buffer:      make([]Event, size),
+		lastID:      uint64(time.Now().UnixMicro()),
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// Publish records an event and sends it to every subscriber. client is the
+// UUID of the client the event is about, if any.
+func (b *Bus) Publish(eventType, client string, data any) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.lastID++
+	e := Event{
+		ID:     b.lastID,
+		Type:   eventType,
+		Time:   time.Now().UTC(),
+		Client: client,
+		Data:   data,
+	}
+	b.buffer[b.next] = e
+	b.next = (b.next + 1) % len(b.buffer)
+	if b.count < len(b.buffer) {
+		b.count++
+	}
+
+	for s := range b.subscribers {
+		select {
+		case s.ch <- e:
+		default:
+			// Too slow; it can resume from its last event ID
+			delete(b.subscribers, s)
+			close(s.ch)
+		}
+	}
+}
+
+// Subscribe starts a subscription. When after is non-zero the buffered
+// events with a higher ID are returned as the backlog, and complete reports
+// whether the buffer still held every one of them. Otherwise the backlog is
+// the last replay events.
+func (b *Bus) Subscribe(after uint64, replay int) (s *Subscription, backlog []Event, complete bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch {
+	case after > 0:
+		backlog, complete = b.since(after)
+	case replay > 0:
+		backlog, complete = b.recent(replay), true
+	default:
+		complete = true
+	}
+	ch := make(chan Event, subscriberBuffer)
+	s = &Subscription{C: ch, ch: ch, bus: b}
+	b.subscribers[s] = struct{}{}
+	return s, backlog, complete
+}
+
+// Close ends the subscription.
+func (s *Subscription) Close() {
+	b := s.bus
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if _, ok := b.subscribers[s]; ok {
+		delete(b.subscribers, s)
+		close(s.ch)
+	}
+}
+
+// Recent returns the buffered events, oldest first. When after is non-zero
+// only the events with a higher ID are returned, and complete reports
+// whether the buffer still held every one of them.
+func (b *Bus) Recent(after uint64) (events []Event, complete bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if after > 0 {
+		return b.since(after)
+	}
+	return b.recent(b.count), true
+}
+
+// recent returns the last n buffered events. The caller must hold b.mutex.
+func (b *Bus) recent(n int) []Event {
+	if n > b.count {
+		n = b.count
+	}
+	result := make([]Event, 0, n)
+	for i := n; i > 0; i-- {
+		result = append(result, b.buffer[(b.next-i+len(b.buffer))%len(b.buffer)])
+	}
+	return result
+}
+
+// since returns the buffered events with an ID above after. The caller must
+// hold b.mutex.
+func (b *Bus) since(after uint64) ([]Event, bool) {
+	if after >= b.lastID {
+		return nil, after == b.lastID
+	}
+	missing := b.lastID - after
+	if missing > uint64(b.count) {
+		return b.recent(b.count), false
+	}
+	return b.recent(int(missing)), true
+}
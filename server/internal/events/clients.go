@@ -0,0 +1,76 @@
+package events
+
+import (
+	"time"
+
+	"yagnoetik-vpn/internal/auth"
+)
+
+// ClientSummary describes a client in client events. It leaves out the
+// secret and key.
+type ClientSummary struct {
+	UUID      string           `json:"uuid"`
+	Name      string           `json:"name,omitempty"`
+	State     auth.ClientState `json:"state"`
+	ExpiresAt time.Time        `json:"expires_at"`
+	Tags      []string         `json:"tags,omitempty"`
+	Revision  uint64           `json:"revision"`
+}
+
+func summarize(c *auth.Client) ClientSummary {
+	return ClientSummary{
+		UUID:      c.UUID,
+		Name:      c.Name,
+		State:     c.State,
+		ExpiresAt: c.ExpiresAt,
+		Tags:      c.Tags,
+		Revision:  c.Revision,
+	}
+}
+
+// StateChange is the data of client.created, client.expired, client.deleted
+// and client.state_changed events.
+type StateChange struct {
+	From   auth.ClientState `json:"from,omitempty"`
+	To     auth.ClientState `json:"to"`
+	Reason string           `json:"reason,omitempty"`
+	Client *ClientSummary   `json:"client,omitempty"`
+}
+
+// WatchClients publishes the lifecycle, settings and quota events of the
+// manager's clients.
+func (b *Bus) WatchClients(cm *auth.ClientManager) {
+	cm.OnTransition(func(t auth.Transition) {
+		change := StateChange{From: t.From, To: t.To, Reason: t.Reason}
+		if t.To != auth.StateDeleted {
+			if c, exists := cm.FindClient(t.UUID); exists {
+				summary := summarize(c)
+				change.Client = &summary
+			}
+		}
+
+		eventType := ClientStateChanged
+		switch {
+		case t.From == "":
+			eventType = ClientCreated
+		case t.To == auth.StateExpired:
+			eventType = ClientExpired
+		case t.To == auth.StateDeleted:
+			eventType = ClientDeleted
+		}
+		b.Publish(eventType, t.UUID, change)
+	})
+	cm.OnUpdate(func(c *auth.Client) {
+		b.Publish(ClientUpdated, c.UUID, summarize(c))
+	})
+	cm.OnQuota(func(e auth.QuotaEvent) {
+		eventType := QuotaWarning
+		switch e.Kind {
+		case auth.QuotaExceeded:
+			eventType = QuotaExceeded
+		case auth.QuotaRestored:
+			eventType = QuotaRestored
+		}
+		b.Publish(eventType, e.UUID, e)
+	})
+}
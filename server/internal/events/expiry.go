@@ -0,0 +1,166 @@
+package events
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"yagnoetik-vpn/internal/auth"
+)
+
+// certWarningRepeat is how often server.cert_expiring is repeated while the
+// certificate stays within the warning period.
+const certWarningRepeat = 24 * time.Hour
+
+// Expiring is the data of client.expiring events.
+type Expiring struct {
+	ExpiresAt time.Time      `json:"expires_at"`
+	Client    *ClientSummary `json:"client"`
+}
+
+// CertExpiry is the data of server.cert_expiring events.
+type CertExpiry struct {
+	File     string    `json:"file"`
+	Subject  string    `json:"subject"`
+	NotAfter time.Time `json:"not_after"`
+}
+
+// ExpiryOptions tunes the ExpiryWatcher. Zero fields use the defaults.
+type ExpiryOptions struct {
+	// ClientWarning is how long before a subscription ends that
+	// client.expiring is published; default 3 days, negative disables it.
+	ClientWarning time.Duration
+	// CertFile is the PEM certificate checked for server.cert_expiring;
+	// empty disables the check.
+	CertFile string
+	// CertWarning is how long before the certificate expires that the
+	// warning starts; default 14 days.
+	CertWarning time.Duration
+	// Interval is how often both are checked; default 1h.
+	Interval time.Duration
+	// Logger defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// ExpiryWatcher publishes advance warnings that nothing else triggers: a
+// client whose subscription is about to end and a server certificate that
+// is about to expire.
+type ExpiryWatcher struct {
+	bus           *Bus
+	clientManager *auth.ClientManager
+	options       ExpiryOptions
+	// warned holds the expiry each client was last warned about, so that
+	// an extended subscription is warned about again
+	warned     map[string]time.Time
+	certWarned time.Time
+	mutex      sync.Mutex
+}
+
+func NewExpiryWatcher(bus *Bus, clientManager *auth.ClientManager, options ExpiryOptions) *ExpiryWatcher {
+	if options.ClientWarning == 0 {
+		options.ClientWarning = 3 * 24 * time.Hour
+	}
+	if options.CertWarning <= 0 {
+		options.CertWarning = 14 * 24 * time.Hour
+	}
+	if options.Interval <= 0 {
+		options.Interval = time.Hour
+	}
+	if options.Logger == nil {
+		options.Logger = slog.Default()
+	}
+	return &ExpiryWatcher{
+		bus:           bus,
+		clientManager: clientManager,
+		options:       options,
+		warned:        make(map[string]time.Time),
+	}
+}
+
+// Run checks once at start and then every Interval until ctx is cancelled.
+func (w *ExpiryWatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.options.Interval)
+	defer ticker.Stop()
+
+	for {
+		w.Check(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check publishes the warnings due as of now.
+func (w *ExpiryWatcher) Check(now time.Time) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.options.ClientWarning > 0 {
+		w.checkClients(now)
+	}
+	if w.options.CertFile != "" {
+		w.checkCert(now)
+	}
+}
+
+func (w *ExpiryWatcher) checkClients(now time.Time) {
+	seen := make(map[string]bool)
+	for _, c := range w.clientManager.ListClients() {
+		seen[c.UUID] = true
+		if c.State != auth.StatePending && c.State != auth.StateActive {
+			continue
+		}
+		if c.ExpiresAt.Sub(now) > w.options.ClientWarning || !c.ExpiresAt.After(now) {
+			continue
+		}
+		if w.warned[c.UUID].Equal(c.ExpiresAt) {
+			continue
+		}
+		w.warned[c.UUID] = c.ExpiresAt
+		summary := summarize(c)
+		w.bus.Publish(ClientExpiring, c.UUID, Expiring{ExpiresAt: c.ExpiresAt, Client: &summary})
+	}
+	for uuid := range w.warned {
+		if !seen[uuid] {
+			delete(w.warned, uuid)
+		}
+	}
+}
+
+func (w *ExpiryWatcher) checkCert(now time.Time) {
+	cert, err := readCert(w.options.CertFile)
+	if err != nil {
+		w.options.Logger.Warn("Failed to read the server certificate", "file", w.options.CertFile, "error", err)
+		return
+	}
+	if cert.NotAfter.Sub(now) > w.options.CertWarning || now.Sub(w.certWarned) < certWarningRepeat {
+		return
+	}
+	w.certWarned = now
+	w.bus.Publish(CertExpiring, "", CertExpiry{
+		File:     w.options.CertFile,
+		Subject:  cert.Subject.String(),
+		NotAfter: cert.NotAfter,
+	})
+}
+
+// readCert parses the first certificate of a PEM file. It is read on each
+// check so that a renewed certificate is picked up.
+func readCert(path string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("no PEM certificate found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
@@ -0,0 +1,194 @@
+package history
+
+import (
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+
+	"yagnoetik-vpn/internal/storage"
+)
+
+// maxRecords caps the log regardless of retention.
+const maxRecords = 100000
+
+// Record describes one finished tunnel session.
+type Record struct {
+	ID            string    `json:"id"`
+	ClientUUID    string    `json:"client_uuid"`
+	RemoteAddr    string    `json:"remote_addr"`
+	AssignedIP    string    `json:"assigned_ip,omitempty"`
+	ClientVersion string    `json:"client_version,omitempty"`
+	StartedAt     time.Time `json:"started_at"`
+	EndedAt       time.Time `json:"ended_at"`
+	BytesUp       int64     `json:"bytes_up"`
+	BytesDown     int64     `json:"bytes_down"`
+	// Reason is why the session ended, such as a server-side close reason
+	// or "client disconnected". Error holds the underlying error, if any.
+	Reason string `json:"reason"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Query selects records; zero fields are not filtered on. Results are
+// newest first.
+type Query struct {
+	ClientUUID string
+	From       time.Time // sessions that ended at or after From
+	To         time.Time // sessions that ended before To
+	Limit      int
+}
+
+// Store keeps finished sessions in the order they ended and drops them once
+// they are older than the retention period.
+type Store struct {
+	records   []Record
+	retention time.Duration
+	// journal, if set, holds the records on disk. stale counts the records
+	// dropped from memory but still in the journal.
+	journal *storage.Journal
+	stale   int
+	mutex   sync.RWMutex
+}
+
+// NewStore creates a store that is kept in memory only. A zero retention
+// keeps records until maxRecords is reached.
+func NewStore(retention time.Duration) *Store {
+	return &Store{retention: retention}
+}
+
+// Open creates a store persisted to the journal at path. Records already
+// past the retention period are dropped while loading.
+func Open(path string, retention time.Duration) (*Store, error) {
+	s := NewStore(retention)
+	journal, err := storage.OpenJournal(path, func(data json.RawMessage) error {
+		var r Record
+		if err := json.Unmarshal(data, &r); err != nil {
+			return err
+		}
+		s.records = append(s.records, r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.journal = journal
+
+	s.stale = s.prune(time.Now())
+	if err := s.compact(); err != nil {
+		journal.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Store) Add(r Record) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.records = append(s.records, r)
+	// Expired records leave the journal on the next sweep
+	s.stale += s.prune(r.EndedAt)
+	if s.journal != nil {
+		if err := s.journal.Append(r); err != nil {
+			slog.Error("Failed to save session record", "session", r.ID, "error", err)
+		}
+	}
+}
+
+// Prune drops expired records and rewrites the journal without them.
+func (s *Store) Prune(now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.stale += s.prune(now)
+	if err := s.compact(); err != nil {
+		slog.Error("Failed to compact session history", "error", err)
+	}
+}
+
+// compact rewrites the journal if it holds dropped records. The caller must
+// hold s.mutex.
+func (s *Store) compact() error {
+	if s.journal == nil || s.stale == 0 {
+		return nil
+	}
+	err := s.journal.Rewrite(func(enc *json.Encoder) error {
+		for _, r := range s.records {
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.stale = 0
+	return nil
+}
+
+// prune drops expired and excess records and returns how many it dropped.
+// The caller must hold s.mutex.
+func (s *Store) prune(now time.Time) int {
+	drop := 0
+	if len(s.records) > maxRecords {
+		drop = len(s.records) - maxRecords
+	}
+	if s.retention > 0 {
+		cutoff := now.Add(-s.retention)
+		for drop < len(s.records) && s.records[drop].EndedAt.Before(cutoff) {
+			drop++
+		}
+	}
+	if drop > 0 {
+		s.records = append([]Record(nil), s.records[drop:]...)
+	}
+	return drop
+}
+
+// DeleteClient drops the records of a client.
+func (s *Store) DeleteClient(uuid string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	kept := make([]Record, 0, len(s.records))
+	for _, r := range s.records {
+		if r.ClientUUID != uuid {
+			kept = append(kept, r)
+		}
+	}
+	s.stale += len(s.records) - len(kept)
+	s.records = kept
+	if err := s.compact(); err != nil {
+		slog.Error("Failed to delete session history", "client", uuid, "error", err)
+	}
+}
+
+func (s *Store) Query(q Query) []Record {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var cutoff time.Time
+	if s.retention > 0 {
+		cutoff = time.Now().Add(-s.retention)
+	}
+
+	records := make([]Record, 0)
+	for i := len(s.records) - 1; i >= 0; i-- {
+		r := s.records[i]
+		if r.EndedAt.Before(cutoff) || r.EndedAt.Before(q.From) {
+			break
+		}
+		if !q.To.IsZero() && !r.EndedAt.Before(q.To) {
+			continue
+		}
+		if q.ClientUUID != "" && r.ClientUUID != q.ClientUUID {
+			continue
+		}
+		records = append(records, r)
+		if q.Limit > 0 && len(records) >= q.Limit {
+			break
+		}
+	}
+	return records
+}
@@ -0,0 +1,125 @@
+package idempotency
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxEntries bounds memory use; the oldest responses are dropped first.
+const maxEntries = 10000
+
+var (
+	// ErrInProgress is returned while the first request with a key is still
+	// being handled.
+	ErrInProgress = errors.New("a request with this idempotency key is in progress")
+	// ErrMismatch is returned when a key is reused for a different request.
+	ErrMismatch = errors.New("idempotency key was used for a different request")
+)
+
+// Response is a stored result, replayed for retries of the same request.
+type Response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+type entry struct {
+	fingerprint string
+	created     time.Time
+	response    *Response // nil while in progress
+}
+
+// Store remembers the responses to requests that carried an idempotency
+// key, so that a retried request returns the original result instead of
+// being applied twice.
+type Store struct {
+	ttl     time.Duration
+	entries map[string]*entry
+	order   []string
+	mutex   sync.Mutex
+}
+
+func NewStore(ttl time.Duration) *Store {
+	return &Store{
+		ttl:     ttl,
+		entries: make(map[string]*entry),
+	}
+}
+
+// Begin claims key for a request. It returns the stored response when the
+// same request has already completed, and nil when the caller should handle
+// the request and then call Finish or Abort. fingerprint identifies the
+// request, so that reusing a key for different content is rejected.
+func (s *Store) Begin(key, fingerprint string) (*Response, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	s.expire(now)
+
+	if e, exists := s.entries[key]; exists {
+		switch {
+		case e.fingerprint != fingerprint:
+			return nil, ErrMismatch
+		case e.response == nil:
+			return nil, ErrInProgress
+		}
+		return e.response, nil
+	}
+
+	s.entries[key] = &entry{fingerprint: fingerprint, created: now}
+	s.order = append(s.order, key)
+	for len(s.order) > maxEntries {
+		delete(s.entries, s.order[0])
+		s.order = s.order[1:]
+	}
+	return nil, nil
+}
+
+// Finish stores the response to a request claimed with Begin.
+func (s *Store) Finish(key string, response *Response) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if e, exists := s.entries[key]; exists {
+		e.response = response
+	}
+}
+
+// Abort releases a key without storing a response, so that the request can
+// be retried.
+func (s *Store) Abort(key string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.entries, key)
+	// Drop the key from the order too, or claiming it again would leave a
+	// stale position through which the new claim could be evicted early.
+	for i, k := range s.order {
+		if k == key {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// expire drops entries older than the TTL. Keys are kept in the order
+// they were claimed, so it stops at the first live one instead of scanning
+// every entry on each request. The caller must hold s.mutex.
+func (s *Store) expire(now time.Time) {
+	cutoff := now.Add(-s.ttl)
+	n := 0
+	for ; n < len(s.order); n++ {
+		e, exists := s.entries[s.order[n]]
+		if !exists {
+			continue
+		}
+		if !e.created.Before(cutoff) {
+			break
+		}
+		delete(s.entries, s.order[n])
+	}
+	s.order = s.order[n:]
+}
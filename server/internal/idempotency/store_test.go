@@ -0,0 +1,96 @@
+package idempotency
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestBegin(t *testing.T) {
+	s := NewStore(time.Hour)
+	done := &Response{Status: 201, Body: []byte(`{"uuid":"a"}`)}
+
+	for _, step := range []struct {
+		name       string
+		key, print string
+		finish     bool // finish the request the step began
+		abort      bool
+		wantStored bool
+		wantErr    error
+	}{
+		{"first use", "k1", "POST /a 1", false, false, false, nil},
+		{"retry in progress", "k1", "POST /a 1", false, false, false, ErrInProgress},
+		{"other content in progress", "k1", "POST /a 2", false, false, false, ErrMismatch},
+		{"other key", "k2", "POST /a 1", true, false, false, nil},
+		{"retry after finishing", "k2", "POST /a 1", false, false, true, nil},
+		{"other content after finishing", "k2", "POST /b 1", false, false, false, ErrMismatch},
+		{"claim to abort", "k3", "POST /a 1", false, true, false, nil},
+		{"retry after aborting", "k3", "POST /a 1", false, false, false, nil},
+	} {
+		stored, err := s.Begin(step.key, step.print)
+		if err != step.wantErr {
+			t.Fatalf("%s: error %v, want %v", step.name, err, step.wantErr)
+		}
+		if (stored != nil) != step.wantStored {
+			t.Fatalf("%s: stored response %+v", step.name, stored)
+		}
+		if step.wantStored && stored != done {
+			t.Errorf("%s: replayed %+v, want the finished response", step.name, stored)
+		}
+		if step.finish {
+			s.Finish(step.key, done)
+		}
+		if step.abort {
+			s.Abort(step.key)
+		}
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	s := NewStore(10 * time.Millisecond)
+	s.Begin("k", "POST /a 1")
+	s.Finish("k", &Response{Status: 200})
+
+	time.Sleep(20 * time.Millisecond)
+	stored, err := s.Begin("k", "POST /b 2")
+	if err != nil || stored != nil {
+		t.Errorf("expired key: stored %+v, error %v; want a fresh claim", stored, err)
+	}
+}
+
+func TestMaxEntries(t *testing.T) {
+	s := NewStore(time.Hour)
+	for i := range maxEntries + 1 {
+		key := strconv.Itoa(i)
+		s.Begin(key, "POST /a "+key)
+		s.Finish(key, &Response{Status: 200})
+	}
+	if len(s.entries) != maxEntries {
+		t.Errorf("%d entries, want %d", len(s.entries), maxEntries)
+	}
+	if stored, _ := s.Begin("1", "POST /a 1"); stored == nil {
+		t.Error("second oldest key was dropped too")
+	}
+	// The oldest key was dropped and can be claimed for anything
+	if stored, err := s.Begin("0", "POST /other"); err != nil || stored != nil {
+		t.Errorf("oldest key: stored %+v, error %v", stored, err)
+	}
+}
+
+// TestAbortThenEvict checks that a key claimed again after an abort is
+// evicted by its new claim time, not the first one.
+func TestAbortThenEvict(t *testing.T) {
+	s := NewStore(time.Hour)
+	s.Begin("retried", "POST /a")
+	s.Abort("retried")
+	for i := range maxEntries - 1 {
+		s.Begin(strconv.Itoa(i), "POST /a")
+	}
+	s.Begin("retried", "POST /a")
+	s.Finish("retried", &Response{Status: 200})
+	s.Begin("last", "POST /a")
+
+	if stored, _ := s.Begin("retried", "POST /a"); stored == nil {
+		t.Error("key claimed again after an abort was evicted first")
+	}
+}
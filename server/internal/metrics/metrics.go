@@ -0,0 +1,201 @@
+package metrics
+
+import (
+	"net/http"
+
+	"yagnoetik-vpn/internal/auth"
+	"yagnoetik-vpn/internal/protocol"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "yagnoetik"
+
+// Directions are from the server's point of view: "in" is received from
+// clients and "out" is sent to them.
+const (
+	DirectionIn  = "in"
+	DirectionOut = "out"
+)
+
+// Authentication results and failure reasons. A connect attempt counts as a
+// success once its credentials check out.
+const (
+	AuthSuccess = "success"
+	AuthFailure = "failure"
+
+	ReasonNoMetadata         = "no_metadata"
+	ReasonMissingCredentials = "missing_credentials"
+	ReasonInvalidCredentials = "invalid_credentials"
+)
+
+// Reasons an authenticated connect attempt was turned away.
+const (
+	RejectSessionLimit = "session_limit"
+	RejectInternal     = "internal"
+)
+
+// Webhook delivery attempt outcomes.
+const (
+	WebhookDelivered = "delivered"
+	WebhookRetry     = "retry"
+	WebhookDead      = "dead"
+)
+
+// Notification outcomes.
+const (
+	NotificationSent   = "sent"
+	NotificationFailed = "failed"
+)
+
+// Voucher redemption outcomes. Rejected covers unknown, used, expired and
+// revoked codes and wrong client credentials.
+const (
+	RedemptionCreated     = "created"
+	RedemptionExtended    = "extended"
+	RedemptionRejected    = "rejected"
+	RedemptionRateLimited = "rate_limited"
+)
+
+var (
+	SessionsActive = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "sessions_active",
+		Help:      "Number of live tunnel sessions.",
+	})
+
+	AuthAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "auth_attempts_total",
+		Help:      "Tunnel connect attempts by result and failure reason.",
+	}, []string{"result", "reason"})
+
+	ConnectRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "connect_rejections_total",
+		Help:      "Authenticated tunnel connect attempts turned away, by reason.",
+	}, []string{"reason"})
+
+	Frames = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "frames_total",
+		Help:      "Tunnel frames by direction and frame type.",
+	}, []string{"direction", "type"})
+
+	Bytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "bytes_total",
+		Help:      "Encrypted tunnel bytes by direction.",
+	}, []string{"direction"})
+
+	DecryptFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "decrypt_failures_total",
+		Help:      "Frames from clients that failed to decrypt.",
+	})
+
+	SendQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "send_queue_depth",
+		Help:      "Frames waiting for their session's stream to become free.",
+	})
+
+	SendDrops = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "send_drops_total",
+		Help:      "Frames that could not be sent to a client.",
+	})
+
+	KeepaliveTimeouts = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "keepalive_timeouts_total",
+		Help:      "Sessions closed because the client stopped answering pings.",
+	})
+
+	HandshakeDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "handshake_duration_seconds",
+		Help:      "Time from a connect request to the session being established.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	})
+	WebhookAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_attempts_total",
+		Help:      "Webhook delivery attempts by outcome; dead attempts moved the delivery to the dead-letter queue.",
+	}, []string{"result"})
+	Notifications = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "notifications_total",
+		Help:      "Notifications sent to operators and users by channel and outcome.",
+	}, []string{"channel", "result"})
+	VoucherRedemptions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "voucher_redemptions_total",
+		Help:      "Voucher redemption attempts on the public endpoint by outcome.",
+	}, []string{"result"})
+)
+
+// Registry holds the server metrics and the Go runtime and process
+// collectors.
+var Registry = prometheus.NewRegistry()
+
+func init() {
+	Registry.MustRegister(
+		SessionsActive, AuthAttempts, ConnectRejections, Frames, Bytes, DecryptFailures,
+		SendQueueDepth, SendDrops, KeepaliveTimeouts, HandshakeDuration,
+		WebhookAttempts, Notifications, VoucherRedemptions,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// FrameType names a protocol frame type for the type label.
+func FrameType(t byte) string {
+	switch t {
+	case protocol.FrameTypeData:
+		return "data"
+	case protocol.FrameTypePing:
+		return "ping"
+	case protocol.FrameTypePong:
+		return "pong"
+	case protocol.FrameTypeNotice:
+		return "notice"
+	}
+	return "unknown"
+}
+
+// clientCollector exports per-client traffic counters. It adds one series
+// per client and direction, so it is only registered on request.
+type clientCollector struct {
+	clientManager *auth.ClientManager
+	bytes         *prometheus.Desc
+}
+
+// RegisterPerClient adds per-client byte counters labelled by UUID.
+func RegisterPerClient(clientManager *auth.ClientManager) {
+	Registry.MustRegister(&clientCollector{
+		clientManager: clientManager,
+		bytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "client", "bytes_total"),
+			"Tunnel payload bytes per client; out is sent to the client.",
+			[]string{"uuid", "direction"}, nil,
+		),
+	})
+}
+
+func (c *clientCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.bytes
+}
+
+func (c *clientCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, client := range c.clientManager.ListClients() {
+		ch <- prometheus.MustNewConstMetric(c.bytes, prometheus.CounterValue, float64(client.BytesUp), client.UUID, DirectionOut)
+		ch <- prometheus.MustNewConstMetric(c.bytes, prometheus.CounterValue, float64(client.BytesDown), client.UUID, DirectionIn)
+	}
+}
@@ -0,0 +1,250 @@
+// Package notify sends human-readable notifications about operational
+// events to operators and to the clients they concern, over channels such
+// as email and Telegram.
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"yagnoetik-vpn/internal/auth"
+	"yagnoetik-vpn/internal/events"
+	"yagnoetik-vpn/internal/metrics"
+)
+
+// Audiences of a notification.
+const (
+	Operator = "operator"
+	User     = "user"
+)
+
+// Events are the event types that have operator notifications. Those about
+// a client, client.expiring and the quota events, also have one written for
+// the client.
+var Events = []string{events.ClientExpiring, events.QuotaWarning, events.QuotaExceeded, events.CertExpiring, events.AuthFailureBurst}
+
+// telegramContact marks a client contact that is a Telegram chat ID.
+const telegramContact = "telegram:"
+
+// queueSize is how many notifications may wait to be sent before new ones
+// are dropped.
+const queueSize = 256
+
+// ErrNoAddress is returned by a Notifier when the recipient has no address
+// on its channel.
+var ErrNoAddress = errors.New("recipient has no address on this channel")
+
+// Recipient holds someone's addresses; empty ones are skipped.
+type Recipient struct {
+	Email string
+	// Telegram is a chat ID the bot may write to.
+	Telegram string
+}
+
+func (r Recipient) String() string {
+	var parts []string
+	if r.Email != "" {
+		parts = append(parts, r.Email)
+	}
+	if r.Telegram != "" {
+		parts = append(parts, "telegram:"+r.Telegram)
+	}
+	return strings.Join(parts, ",")
+}
+
+type Message struct {
+	Subject string
+	Body    string
+}
+
+// Notifier delivers messages over one channel.
+type Notifier interface {
+	// Name identifies the channel in logs and metrics, such as "smtp".
+	Name() string
+	// Send delivers the message, or returns ErrNoAddress if the recipient
+	// has no address on this channel.
+	Send(ctx context.Context, to Recipient, msg Message) error
+}
+
+// Options selects who is notified of what. Zero fields use the defaults.
+type Options struct {
+	// Language is "ru" (the default) or "en".
+	Language string
+	// Operators receive the OperatorEvents.
+	Operators []Recipient
+	// OperatorEvents defaults to Events.
+	OperatorEvents []string
+	// UserEvents are sent to the email of the client the event is about,
+	// and to its Telegram chat if its contact is "telegram:<chat ID>".
+	// Default none.
+	UserEvents []string
+	// QuotaPercent skips quota.warning events below this percentage;
+	// default 90.
+	QuotaPercent int
+	// Server names the server in messages.
+	Server string
+	// Timeout bounds one send; default 30s.
+	Timeout time.Duration
+	// Logger defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// Service sends notifications for the events of a bus.
+type Service struct {
+	bus           *events.Bus
+	clientManager *auth.ClientManager
+	notifiers     []Notifier
+	templates     *templates
+	options       Options
+	queue         chan delivery
+	// sub is taken in NewService so that no event published after it
+	// returns is missed
+	sub *events.Subscription
+}
+
+type delivery struct {
+	to  Recipient
+	msg Message
+	// eventType labels log lines
+	eventType string
+}
+
+func NewService(bus *events.Bus, clientManager *auth.ClientManager, notifiers []Notifier, options Options) (*Service, error) {
+	if options.Language == "" {
+		options.Language = "ru"
+	}
+	if options.OperatorEvents == nil {
+		options.OperatorEvents = Events
+	}
+	if options.QuotaPercent <= 0 {
+		options.QuotaPercent = 90
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = 30 * time.Second
+	}
+	if options.Logger == nil {
+		options.Logger = slog.Default()
+	}
+	t, err := loadTemplates(options.Language)
+	if err != nil {
+		return nil, err
+	}
+	for _, check := range []struct {
+		audience string
+		types    []string
+	}{{Operator, options.OperatorEvents}, {User, options.UserEvents}} {
+		for _, eventType := range check.types {
+			if !t.has(check.audience, eventType) {
+				return nil, fmt.Errorf("no %s notification for event type %q", check.audience, eventType)
+			}
+		}
+	}
+	sub, _, _ := bus.Subscribe(0, 0)
+	return &Service{
+		bus:           bus,
+		clientManager: clientManager,
+		notifiers:     notifiers,
+		templates:     t,
+		options:       options,
+		queue:         make(chan delivery, queueSize),
+		sub:           sub,
+	}, nil
+}
+
+// Run sends notifications until ctx is cancelled.
+func (s *Service) Run(ctx context.Context) {
+	go s.send(ctx)
+
+	defer func() { s.sub.Close() }()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-s.sub.C:
+			if !ok {
+				// Dropped for falling behind; notifications are best
+				// effort, so the missed events are not replayed
+				s.options.Logger.Warn("Notifications fell behind the event bus, some were skipped")
+				s.sub, _, _ = s.bus.Subscribe(0, 0)
+				continue
+			}
+			s.handle(e)
+		}
+	}
+}
+
+// handle renders the notifications of an event and queues them.
+func (s *Service) handle(e events.Event) {
+	toOperators := slices.Contains(s.options.OperatorEvents, e.Type) && len(s.options.Operators) > 0
+	toUser := slices.Contains(s.options.UserEvents, e.Type)
+	if !toOperators && !toUser {
+		return
+	}
+	data := newTemplateData(e, s.options.Server, time.Now())
+	if e.Type == events.QuotaWarning && data.Percent < s.options.QuotaPercent {
+		return
+	}
+
+	var user Recipient
+	if c, exists := s.clientManager.FindClient(e.Client); exists {
+		data.Name = c.Name
+		user.Email = c.Email
+		if chat, ok := strings.CutPrefix(c.Contact, telegramContact); ok {
+			user.Telegram = strings.TrimSpace(chat)
+		}
+	}
+
+	if toOperators {
+		s.queueMessage(Operator, data, s.options.Operators...)
+	}
+	if toUser && user != (Recipient{}) {
+		s.queueMessage(User, data, user)
+	}
+}
+
+func (s *Service) queueMessage(audience string, data templateData, recipients ...Recipient) {
+	msg, err := s.templates.render(audience, data)
+	if err != nil {
+		s.options.Logger.Error("Failed to render notification", "event_type", data.Event.Type, "audience", audience, "error", err)
+		return
+	}
+	for _, to := range recipients {
+		select {
+		case s.queue <- delivery{to: to, msg: msg, eventType: data.Event.Type}:
+		default:
+			s.options.Logger.Warn("Notification queue is full, dropping notification", "event_type", data.Event.Type, "to", to.String())
+		}
+	}
+}
+
+// send delivers queued notifications over every channel the recipient has
+// an address on. Failures are logged and not retried.
+func (s *Service) send(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case d := <-s.queue:
+			for _, n := range s.notifiers {
+				sendCtx, cancel := context.WithTimeout(ctx, s.options.Timeout)
+				err := n.Send(sendCtx, d.to, d.msg)
+				cancel()
+				switch {
+				case errors.Is(err, ErrNoAddress):
+					continue
+				case err != nil:
+					metrics.Notifications.WithLabelValues(n.Name(), metrics.NotificationFailed).Inc()
+					s.options.Logger.Warn("Failed to send notification", "channel", n.Name(), "event_type", d.eventType, "to", d.to.String(), "error", err)
+				default:
+					metrics.Notifications.WithLabelValues(n.Name(), metrics.NotificationSent).Inc()
+					s.options.Logger.Debug("Notification sent", "channel", n.Name(), "event_type", d.eventType, "to", d.to.String())
+				}
+			}
+		}
+	}
+}
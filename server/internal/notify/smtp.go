@@ -0,0 +1,137 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"time"
+)
+
+type SMTPOptions struct {
+	// Addr is the server's host:port.
+	Addr string
+	// Username and Password authenticate with PLAIN auth, which is only
+	// used over TLS or to localhost. Empty Username skips authentication.
+	Username string
+	Password string
+	// From is the sender address, such as "VPN <vpn@example.com>".
+	From string
+	// ImplicitTLS connects over TLS, usually on port 465. Otherwise the
+	// connection is upgraded with STARTTLS when the server offers it.
+	ImplicitTLS bool
+	// RootCAs verifies the server's certificate, for a relay with a
+	// private CA; nil uses the system roots.
+	RootCAs *x509.CertPool
+}
+
+// SMTP sends notifications as plain-text email.
+type SMTP struct {
+	options SMTPOptions
+	host    string
+	from    *mail.Address
+}
+
+func NewSMTP(options SMTPOptions) (*SMTP, error) {
+	host, _, err := net.SplitHostPort(options.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTP address %q: %v", options.Addr, err)
+	}
+	from, err := mail.ParseAddress(options.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender %q: %v", options.From, err)
+	}
+	return &SMTP{options: options, host: host, from: from}, nil
+}
+
+func (s *SMTP) Name() string {
+	return "smtp"
+}
+
+func (s *SMTP) Send(ctx context.Context, to Recipient, msg Message) error {
+	if to.Email == "" {
+		return ErrNoAddress
+	}
+	rcpt, err := mail.ParseAddress(to.Email)
+	if err != nil {
+		return fmt.Errorf("invalid email %q: %v", to.Email, err)
+	}
+
+	var conn net.Conn
+	if s.options.ImplicitTLS {
+		dialer := &tls.Dialer{Config: s.tlsConfig()}
+		conn, err = dialer.DialContext(ctx, "tcp", s.options.Addr)
+	} else {
+		var dialer net.Dialer
+		conn, err = dialer.DialContext(ctx, "tcp", s.options.Addr)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	c, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok && !s.options.ImplicitTLS {
+		if err := c.StartTLS(s.tlsConfig()); err != nil {
+			return err
+		}
+	}
+	if s.options.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.options.Username, s.options.Password, s.host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(s.from.Address); err != nil {
+		return err
+	}
+	if err := c.Rcpt(rcpt.Address); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(s.compose(rcpt, msg)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func (s *SMTP) tlsConfig() *tls.Config {
+	return &tls.Config{ServerName: s.host, RootCAs: s.options.RootCAs}
+}
+
+// compose writes the message with a UTF-8 subject and a quoted-printable
+// body, so that it passes servers without 8BITMIME.
+func (s *SMTP) compose(to *mail.Address, msg Message) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", s.from)
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	qp := quotedprintable.NewWriter(&b)
+	qp.Write(bytes.ReplaceAll([]byte(msg.Body), []byte("\n"), []byte("\r\n")))
+	qp.Close()
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
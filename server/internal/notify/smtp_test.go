@@ -0,0 +1,344 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"io"
+	"math/big"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"yagnoetik-vpn/internal/events"
+)
+
+// testCertificate returns a self-signed certificate for 127.0.0.1 and a
+// pool that trusts it.
+func testCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "smtp sink"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+// sinkMessage is what the sink received in one session.
+type sinkMessage struct {
+	tls        bool // the session was encrypted when the message was sent
+	auth       string
+	from, rcpt string
+	data       string
+}
+
+// smtpSink is a minimal SMTP server that records the messages it is sent.
+type smtpSink struct {
+	listener net.Listener
+	// startTLS offers STARTTLS with this configuration when set
+	startTLS *tls.Config
+	messages chan sinkMessage
+	wg       sync.WaitGroup
+}
+
+// newSMTPSink listens on 127.0.0.1. With implicit set the listener speaks
+// TLS from the start.
+func newSMTPSink(t *testing.T, implicit, startTLS *tls.Config) *smtpSink {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if implicit != nil {
+		listener = tls.NewListener(listener, implicit)
+	}
+	s := &smtpSink{listener: listener, startTLS: startTLS, messages: make(chan sinkMessage, 10)}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				s.serve(conn, implicit != nil)
+			}()
+		}
+	}()
+	t.Cleanup(func() {
+		listener.Close()
+		s.wg.Wait()
+	})
+	return s
+}
+
+func (s *smtpSink) addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *smtpSink) serve(conn net.Conn, encrypted bool) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	r := bufio.NewReader(conn)
+	reply := func(lines ...string) {
+		io.WriteString(conn, strings.Join(lines, "\r\n")+"\r\n")
+	}
+
+	var msg sinkMessage
+	reply("220 sink ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			ext := []string{"250-sink", "250-AUTH PLAIN"}
+			if s.startTLS != nil && !encrypted {
+				ext = append(ext, "250-STARTTLS")
+			}
+			reply(append(ext, "250 8BITMIME")...)
+		case "STARTTLS":
+			reply("220 go ahead")
+			tlsConn := tls.Server(conn, s.startTLS)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, r, encrypted = tlsConn, bufio.NewReader(tlsConn), true
+		case "AUTH":
+			mech, resp, _ := strings.Cut(arg, " ")
+			decoded, err := base64.StdEncoding.DecodeString(resp)
+			if mech != "PLAIN" || err != nil {
+				reply("504 unsupported")
+				continue
+			}
+			msg.auth = string(decoded)
+			reply("235 authenticated")
+		case "MAIL":
+			msg.from = arg
+			reply("250 ok")
+		case "RCPT":
+			msg.rcpt = arg
+			reply("250 ok")
+		case "DATA":
+			reply("354 send it")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(line, "."))
+			}
+			msg.data, msg.tls = data.String(), encrypted
+			s.messages <- msg
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 unknown command")
+		}
+	}
+}
+
+// receive returns the next message the sink got.
+func (s *smtpSink) receive(t *testing.T) sinkMessage {
+	t.Helper()
+	select {
+	case msg := <-s.messages:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("the sink received no message")
+		return sinkMessage{}
+	}
+}
+
+// parseMessage decodes the subject and quoted-printable body of a message
+// written by compose.
+func parseMessage(t *testing.T, data string) (*mail.Message, string, string) {
+	t.Helper()
+	m, err := mail.ReadMessage(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(m.Header.Get("Subject"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(quotedprintable.NewReader(m.Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, subject, strings.ReplaceAll(string(body), "\r\n", "\n")
+}
+
+func newTestSMTP(t *testing.T, options SMTPOptions) *SMTP {
+	t.Helper()
+	if options.From == "" {
+		options.From = "VPN <vpn@example.com>"
+	}
+	s, err := NewSMTP(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+var testMessage = Message{Subject: "Test", Body: "Line one\nLine two"}
+
+func TestSMTPStartTLSAndPlainAuth(t *testing.T) {
+	cert, pool := testCertificate(t)
+	sink := newSMTPSink(t, nil, &tls.Config{Certificates: []tls.Certificate{cert}})
+	s := newTestSMTP(t, SMTPOptions{Addr: sink.addr(), Username: "vpn", Password: "hunter2", RootCAs: pool})
+
+	if err := s.Send(context.Background(), Recipient{Email: "Ops <ops@example.com>"}, testMessage); err != nil {
+		t.Fatal(err)
+	}
+	msg := sink.receive(t)
+	if !msg.tls {
+		t.Error("the message was sent before STARTTLS")
+	}
+	if msg.auth != "\x00vpn\x00hunter2" {
+		t.Errorf("AUTH PLAIN sent %q", msg.auth)
+	}
+	if !strings.HasPrefix(msg.from, "FROM:<vpn@example.com>") || !strings.HasPrefix(msg.rcpt, "TO:<ops@example.com>") {
+		t.Errorf("envelope %s %s", msg.from, msg.rcpt)
+	}
+	m, subject, body := parseMessage(t, msg.data)
+	if subject != "Test" || body != "Line one\nLine two\n" {
+		t.Errorf("message %q: %q", subject, body)
+	}
+	if m.Header.Get("To") != `"Ops" <ops@example.com>` {
+		t.Errorf("To: %s", m.Header.Get("To"))
+	}
+}
+
+func TestSMTPImplicitTLS(t *testing.T) {
+	cert, pool := testCertificate(t)
+	sink := newSMTPSink(t, &tls.Config{Certificates: []tls.Certificate{cert}}, nil)
+	s := newTestSMTP(t, SMTPOptions{Addr: sink.addr(), ImplicitTLS: true, Username: "vpn", Password: "hunter2", RootCAs: pool})
+
+	if err := s.Send(context.Background(), Recipient{Email: "ops@example.com"}, testMessage); err != nil {
+		t.Fatal(err)
+	}
+	if msg := sink.receive(t); !msg.tls || msg.auth == "" {
+		t.Errorf("implicit TLS session: tls %t, auth %q", msg.tls, msg.auth)
+	}
+}
+
+func TestSMTPUntrustedCertificate(t *testing.T) {
+	cert, _ := testCertificate(t)
+	sink := newSMTPSink(t, nil, &tls.Config{Certificates: []tls.Certificate{cert}})
+	s := newTestSMTP(t, SMTPOptions{Addr: sink.addr(), Username: "vpn", Password: "hunter2"})
+
+	if err := s.Send(context.Background(), Recipient{Email: "ops@example.com"}, testMessage); err == nil {
+		t.Fatal("sent over STARTTLS with a certificate the system does not trust")
+	}
+	select {
+	case msg := <-sink.messages:
+		t.Fatalf("the sink received %+v", msg)
+	default:
+	}
+}
+
+// TestSMTPWithoutTLS sends to a local relay that offers no STARTTLS, such
+// as a sidecar; PLAIN auth is only allowed because it is on localhost.
+func TestSMTPWithoutTLS(t *testing.T) {
+	sink := newSMTPSink(t, nil, nil)
+	s := newTestSMTP(t, SMTPOptions{Addr: sink.addr()})
+
+	if err := s.Send(context.Background(), Recipient{Email: "ops@example.com"}, testMessage); err != nil {
+		t.Fatal(err)
+	}
+	if msg := sink.receive(t); msg.tls || msg.auth != "" {
+		t.Errorf("session without TLS or credentials: tls %t, auth %q", msg.tls, msg.auth)
+	}
+	if err := s.Send(context.Background(), Recipient{Telegram: "42"}, testMessage); err != ErrNoAddress {
+		t.Errorf("Send to a recipient without email: %v, want ErrNoAddress", err)
+	}
+}
+
+// TestSMTPTemplates renders a notification in each language and checks
+// that it survives the trip through the mail encoding.
+func TestSMTPTemplates(t *testing.T) {
+	sink := newSMTPSink(t, nil, nil)
+	s := newTestSMTP(t, SMTPOptions{Addr: sink.addr()})
+	now := time.Now()
+	expires := now.Add(50 * time.Hour)
+
+	for lang, want := range map[string][]string{
+		"ru": {"Ваша подписка на VPN истекает через 2 дн. 2 ч.", "Здравствуйте, Иван!"},
+		"en": {"Your VPN subscription expires in 2d 2h", "Hello Иван,"},
+	} {
+		templates, err := loadTemplates(lang)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := templateData{
+			Event:     events.Event{Type: events.ClientExpiring},
+			UUID:      "uuid-1",
+			Name:      "Иван",
+			ExpiresAt: expires,
+			now:       now,
+		}
+		msg, err := templates.render(User, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Send(context.Background(), Recipient{Email: "ivan@example.com"}, msg); err != nil {
+			t.Fatal(err)
+		}
+
+		m, subject, body := parseMessage(t, sink.receive(t).data)
+		if !strings.Contains(m.Header.Get("Content-Type"), "charset=utf-8") {
+			t.Errorf("%s: Content-Type %s", lang, m.Header.Get("Content-Type"))
+		}
+		if subject != want[0] {
+			t.Errorf("%s subject %q, want %q", lang, subject, want[0])
+		}
+		if !strings.Contains(body, want[1]) || !strings.Contains(body, templates.date(expires)) {
+			t.Errorf("%s body %q, want the greeting and the expiry date", lang, body)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// telegramAPI is the Bot API's base URL.
+const telegramAPI = "https://api.telegram.org"
+
+type TelegramOptions struct {
+	// Token is the bot token from @BotFather.
+	Token string
+	// APIURL replaces the Bot API's URL, for a local Bot API server.
+	APIURL string
+	// Client defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// Telegram sends notifications as bot messages.
+type Telegram struct {
+	options TelegramOptions
+}
+
+func NewTelegram(options TelegramOptions) *Telegram {
+	if options.APIURL == "" {
+		options.APIURL = telegramAPI
+	}
+	options.APIURL = strings.TrimRight(options.APIURL, "/")
+	if options.Client == nil {
+		options.Client = http.DefaultClient
+	}
+	return &Telegram{options: options}
+}
+
+func (t *Telegram) Name() string {
+	return "telegram"
+}
+
+func (t *Telegram) Send(ctx context.Context, to Recipient, msg Message) error {
+	if to.Telegram == "" {
+		return ErrNoAddress
+	}
+	body, err := json.Marshal(map[string]any{
+		"chat_id":                  to.Telegram,
+		"text":                     msg.Subject + "\n\n" + msg.Body,
+		"disable_web_page_preview": true,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.options.APIURL+"/bot"+t.options.Token+"/sendMessage", bytes.NewReader(body))
+	if err != nil {
+		return errors.New("invalid Telegram API URL")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.options.Client.Do(req)
+	if err != nil {
+		// The URL carries the token; keep it out of the logs
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("telegram: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&result); err != nil || !result.OK {
+		if result.Description == "" {
+			result.Description = resp.Status
+		}
+		return fmt.Errorf("telegram: %s", result.Description)
+	}
+	return nil
+}
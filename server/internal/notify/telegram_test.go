@@ -0,0 +1,160 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"yagnoetik-vpn/internal/auth"
+	"yagnoetik-vpn/internal/events"
+)
+
+const testToken = "123456:secret-token"
+
+// sentMessage is a sendMessage request the fake Bot API received.
+type sentMessage struct {
+	ChatID          string `json:"chat_id"`
+	Text            string `json:"text"`
+	DisablePreviews bool   `json:"disable_web_page_preview"`
+}
+
+// newBotAPI starts a fake Bot API that answers sendMessage with reply and
+// passes what it was sent to messages.
+func newBotAPI(t *testing.T, status int, reply string) (*httptest.Server, chan sentMessage) {
+	t.Helper()
+	messages := make(chan sentMessage, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/bot"+testToken+"/sendMessage" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type %q", ct)
+		}
+		var msg sentMessage
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			t.Errorf("decoding the request: %v", err)
+		}
+		messages <- msg
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(reply))
+	}))
+	t.Cleanup(server.Close)
+	return server, messages
+}
+
+func TestTelegramSend(t *testing.T) {
+	server, messages := newBotAPI(t, http.StatusOK, `{"ok":true,"result":{"message_id":1}}`)
+	tg := NewTelegram(TelegramOptions{Token: testToken, APIURL: server.URL + "/"})
+
+	if err := tg.Send(context.Background(), Recipient{Telegram: "42"}, testMessage); err != nil {
+		t.Fatal(err)
+	}
+	msg := <-messages
+	if msg.ChatID != "42" || msg.Text != "Test\n\nLine one\nLine two" || !msg.DisablePreviews {
+		t.Errorf("sent %+v", msg)
+	}
+
+	if err := tg.Send(context.Background(), Recipient{Email: "ops@example.com"}, testMessage); err != ErrNoAddress {
+		t.Errorf("Send to a recipient without a chat: %v, want ErrNoAddress", err)
+	}
+}
+
+func TestTelegramErrors(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		status int
+		reply  string
+		want   string
+	}{
+		{"described", http.StatusBadRequest, `{"ok":false,"error_code":400,"description":"Bad Request: chat not found"}`, "telegram: Bad Request: chat not found"},
+		{"not JSON", http.StatusBadGateway, `<html>Bad Gateway</html>`, "telegram: 502 Bad Gateway"},
+		{"not ok", http.StatusOK, `{"ok":false}`, "telegram: 200 OK"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			server, _ := newBotAPI(t, test.status, test.reply)
+			tg := NewTelegram(TelegramOptions{Token: testToken, APIURL: server.URL})
+
+			err := tg.Send(context.Background(), Recipient{Telegram: "42"}, testMessage)
+			if err == nil || err.Error() != test.want {
+				t.Errorf("error %v, want %q", err, test.want)
+			}
+		})
+	}
+}
+
+// TestTelegramErrorHidesToken checks that a failed request does not put the
+// URL, which carries the token, in the error.
+func TestTelegramErrorHidesToken(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	tg := NewTelegram(TelegramOptions{Token: testToken, APIURL: server.URL})
+
+	err := tg.Send(context.Background(), Recipient{Telegram: "42"}, testMessage)
+	if err == nil {
+		t.Fatal("Send succeeded with the Bot API down")
+	}
+	if strings.Contains(err.Error(), "secret-token") {
+		t.Errorf("error %q contains the token", err)
+	}
+}
+
+// TestServiceTelegram sends a quota warning through the Service in each
+// language, to the operators and to the client's Telegram chat.
+func TestServiceTelegram(t *testing.T) {
+	for lang, want := range map[string][]string{
+		"ru": {"Клиент Иван израсходовал 95% трафика", "Израсходовано 95% трафика"},
+		"en": {"Иван has used 95% of their traffic", "You have used 95% of your traffic"},
+	} {
+		t.Run(lang, func(t *testing.T) {
+			server, messages := newBotAPI(t, http.StatusOK, `{"ok":true}`)
+			clientManager := auth.NewClientManager()
+			name, contact := "Иван", "telegram: 1001"
+			client, err := clientManager.CreateClient(30*24*time.Hour, auth.ClientUpdate{Name: &name, Contact: &contact})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			bus := events.NewBus(16)
+			service, err := NewService(bus, clientManager,
+				[]Notifier{NewTelegram(TelegramOptions{Token: testToken, APIURL: server.URL})},
+				Options{
+					Language:   lang,
+					Operators:  []Recipient{{Telegram: "7"}},
+					UserEvents: []string{events.QuotaWarning},
+				})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go service.Run(ctx)
+
+			bus.Publish(events.QuotaWarning, client.UUID, auth.QuotaEvent{
+				UUID: client.UUID, Kind: auth.QuotaWarning, Percent: 95, Used: 95 << 20, Quota: 100 << 20,
+			})
+
+			got := map[string]string{}
+			for range 2 {
+				select {
+				case msg := <-messages:
+					got[msg.ChatID] = msg.Text
+				case <-time.After(5 * time.Second):
+					t.Fatalf("got %d of 2 messages", len(got))
+				}
+			}
+			if subject, _, _ := strings.Cut(got["7"], "\n\n"); subject != want[0] {
+				t.Errorf("operator message %q, want subject %q", got["7"], want[0])
+			}
+			if subject, _, _ := strings.Cut(got["1001"], "\n\n"); subject != want[1] {
+				t.Errorf("client message %q, want subject %q", got["1001"], want[1])
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package notify
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"yagnoetik-vpn/internal/events"
+)
+
+//go:embed templates/*.tmpl
+var templateFiles embed.FS
+
+// Languages are the languages templates are written in.
+var Languages = []string{"ru", "en"}
+
+// language holds the wording that is not in the templates.
+type language struct {
+	dateLayout string
+	// day and hour follow the number, with a space if there should be one
+	day, hour   string
+	lessThanOne string
+}
+
+var languages = map[string]language{
+	"ru": {dateLayout: "02.01.2006 15:04 MST", day: " дн.", hour: " ч.", lessThanOne: "1 ч."},
+	"en": {dateLayout: "Jan 2, 2006 15:04 MST", day: "d", hour: "h", lessThanOne: "1h"},
+}
+
+// templateData is what templates see: the event and the details of its data
+// that notifications mention.
+type templateData struct {
+	Event  events.Event
+	Server string
+	// UUID and Name identify the client the event is about; Name may be
+	// empty.
+	UUID string
+	Name string
+	// ExpiresAt is when the subscription or the certificate expires.
+	ExpiresAt   time.Time
+	CertSubject string
+	CertFile    string
+	Percent     int
+	Used        int64
+	Quota       int64
+	Count       int
+	Window      string
+	Reasons     map[string]int
+	now         time.Time
+}
+
+// eventDetails is the union of the event data fields templates use.
+type eventDetails struct {
+	ExpiresAt time.Time             `json:"expires_at"`
+	NotAfter  time.Time             `json:"not_after"`
+	Subject   string                `json:"subject"`
+	File      string                `json:"file"`
+	Percent   int                   `json:"percent"`
+	Used      int64                 `json:"used"`
+	Quota     int64                 `json:"quota"`
+	Count     int                   `json:"count"`
+	Window    string                `json:"window"`
+	Reasons   map[string]int        `json:"reasons"`
+	Client    *events.ClientSummary `json:"client"`
+}
+
+func newTemplateData(e events.Event, server string, now time.Time) templateData {
+	var details eventDetails
+	if raw, err := json.Marshal(e.Data); err == nil {
+		json.Unmarshal(raw, &details)
+	}
+	data := templateData{
+		Event:       e,
+		Server:      server,
+		UUID:        e.Client,
+		ExpiresAt:   details.ExpiresAt,
+		CertSubject: details.Subject,
+		CertFile:    details.File,
+		Percent:     details.Percent,
+		Used:        details.Used,
+		Quota:       details.Quota,
+		Count:       details.Count,
+		Window:      details.Window,
+		Reasons:     details.Reasons,
+		now:         now,
+	}
+	if !details.NotAfter.IsZero() {
+		data.ExpiresAt = details.NotAfter
+	}
+	if details.Client != nil {
+		data.Name = details.Client.Name
+	}
+	return data
+}
+
+// templates renders the notifications of one language.
+type templates struct {
+	set  *template.Template
+	lang language
+}
+
+func loadTemplates(lang string) (*templates, error) {
+	l, ok := languages[lang]
+	if !ok {
+		return nil, fmt.Errorf("unsupported language %q, want one of %s", lang, strings.Join(Languages, ", "))
+	}
+	t := &templates{lang: l}
+	set, err := template.New(lang).Funcs(template.FuncMap{
+		"date":  t.date,
+		"bytes": formatBytes,
+		// left is filled in per render, as it depends on the time
+		"left": func(time.Time) string { return "" },
+	}).ParseFS(templateFiles, "templates/"+lang+".tmpl")
+	if err != nil {
+		return nil, err
+	}
+	t.set = set
+	return t, nil
+}
+
+func templateName(audience, eventType, part string) string {
+	return audience + ":" + eventType + ":" + part
+}
+
+// has reports whether there is a notification for the event type and
+// audience.
+func (t *templates) has(audience, eventType string) bool {
+	return t.set.Lookup(templateName(audience, eventType, "subject")) != nil &&
+		t.set.Lookup(templateName(audience, eventType, "body")) != nil
+}
+
+func (t *templates) render(audience string, data templateData) (Message, error) {
+	set, err := t.set.Clone()
+	if err != nil {
+		return Message{}, err
+	}
+	set.Funcs(template.FuncMap{"left": func(at time.Time) string { return t.left(at, data.now) }})
+
+	var msg Message
+	for _, part := range []struct {
+		name string
+		out  *string
+	}{{"subject", &msg.Subject}, {"body", &msg.Body}} {
+		var b strings.Builder
+		if err := set.ExecuteTemplate(&b, templateName(audience, data.Event.Type, part.name), data); err != nil {
+			return Message{}, err
+		}
+		*part.out = strings.TrimSpace(b.String())
+	}
+	return msg, nil
+}
+
+func (t *templates) date(at time.Time) string {
+	return at.Local().Format(t.lang.dateLayout)
+}
+
+// left describes the time until at in days and hours, rounding the last
+// hour up, or returns "" if it has passed.
+func (t *templates) left(at, now time.Time) string {
+	d := at.Sub(now)
+	switch {
+	case d <= 0:
+		return ""
+	case d < time.Hour:
+		return t.lang.lessThanOne
+	}
+	days, hours := int(d/(24*time.Hour)), int(d%(24*time.Hour)/time.Hour)
+	switch {
+	case days == 0:
+		return fmt.Sprintf("%d%s", hours, t.lang.hour)
+	case hours == 0:
+		return fmt.Sprintf("%d%s", days, t.lang.day)
+	}
+	return fmt.Sprintf("%d%s %d%s", days, t.lang.day, hours, t.lang.hour)
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,241 @@
+// Package plans keeps the catalogue of subscription plans and the voucher
+// codes that create or extend clients on them.
+package plans
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"yagnoetik-vpn/internal/auth"
+	"yagnoetik-vpn/internal/durations"
+	"yagnoetik-vpn/internal/storage"
+)
+
+var (
+	ErrPlanNotFound = errors.New("plan not found")
+	// ErrPlanInUse is returned when deleting a plan that unredeemed
+	// vouchers still grant.
+	ErrPlanInUse = errors.New("plan has unredeemed vouchers")
+)
+
+// Plan is what a client gets for one purchase: a subscription length and
+// the limits that come with it. Zero limits mean unlimited, as on clients.
+type Plan struct {
+	Name        string        `json:"name"`
+	Description string        `json:"description"`
+	Duration    time.Duration `json:"-"`
+	QuotaBytes  int64         `json:"quota_bytes"`
+	// QuotaReset is "" for a quota over the whole subscription, "monthly"
+	// or "rolling".
+	QuotaReset auth.QuotaReset `json:"quota_reset"`
+	SpeedTier  string          `json:"speed_tier"`
+	// MaxSessions is how many devices may be connected at once; 0 uses the
+	// server default and -1 allows any number.
+	MaxSessions int       `json:"max_sessions"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+func (p Plan) Validate() error {
+	switch {
+	case p.Name == "":
+		return errors.New("plan name is required")
+	case p.Duration <= 0:
+		return errors.New("plan duration must be positive")
+	case p.QuotaBytes < 0:
+		return errors.New("plan quota must not be negative")
+	case p.MaxSessions < -1:
+		return errors.New("plan max_sessions must be -1 or more")
+	}
+	if _, err := auth.ParseQuotaReset(string(p.QuotaReset)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Update is the change that puts a client on the plan: its limits, and
+// Extend set to the plan's duration. CreateClient ignores Extend.
+func (p Plan) Update() auth.ClientUpdate {
+	return auth.ClientUpdate{
+		Extend:      p.Duration,
+		Plan:        &p.Name,
+		QuotaBytes:  &p.QuotaBytes,
+		QuotaReset:  &p.QuotaReset,
+		SpeedTier:   &p.SpeedTier,
+		MaxSessions: &p.MaxSessions,
+	}
+}
+
+// Store holds the plans and their vouchers.
+type Store struct {
+	plans    map[string]*Plan
+	vouchers map[string]*Voucher // keyed by normalized code
+	batches  map[string]*Batch
+	order    []*Voucher // oldest first
+	// redemptions is broadcast when a redemption finishes, for revokes
+	// waiting on it
+	redemptions *sync.Cond
+	// path is the file the store is saved to; "" keeps it in memory only
+	path  string
+	mutex sync.Mutex
+}
+
+// savedState is the file format of a saved store.
+type savedState struct {
+	Plans    []savedPlan `json:"plans"`
+	Batches  []Batch     `json:"batches"`
+	Vouchers []Voucher   `json:"vouchers"` // oldest first
+}
+
+// savedPlan adds the duration, which the API writes in its own field.
+type savedPlan struct {
+	Plan
+	Duration string `json:"duration"`
+}
+
+// NewStore creates a store kept in memory only.
+func NewStore() *Store {
+	s := &Store{
+		plans:    make(map[string]*Plan),
+		vouchers: make(map[string]*Voucher),
+		batches:  make(map[string]*Batch),
+	}
+	s.redemptions = sync.NewCond(&s.mutex)
+	return s
+}
+
+// Open creates a store that is saved to the file at path after every
+// change, and loads the plans and vouchers already there.
+func Open(path string) (*Store, error) {
+	var saved savedState
+	if err := storage.ReadJSON(path, &saved); err != nil {
+		return nil, err
+	}
+
+	s := NewStore()
+	s.path = path
+	for _, sp := range saved.Plans {
+		p := sp.Plan
+		d, err := durations.Parse(sp.Duration)
+		if err != nil {
+			return nil, fmt.Errorf("%s: plan %s: %w", path, p.Name, err)
+		}
+		p.Duration = d
+		s.plans[p.Name] = &p
+	}
+	for _, b := range saved.Batches {
+		s.batches[b.ID] = &b
+	}
+	for _, v := range saved.Vouchers {
+		s.vouchers[normalizeCode(v.Code)] = &v
+		s.order = append(s.order, &v)
+	}
+	return s, nil
+}
+
+// save writes the store to its file. A failure is logged: the change it
+// records has already been made. The caller must hold s.mutex.
+func (s *Store) save() {
+	if s.path == "" {
+		return
+	}
+	state := savedState{
+		Plans:    make([]savedPlan, 0, len(s.plans)),
+		Batches:  make([]Batch, 0, len(s.batches)),
+		Vouchers: make([]Voucher, 0, len(s.order)),
+	}
+	for _, p := range s.plans {
+		state.Plans = append(state.Plans, savedPlan{Plan: *p, Duration: durations.Format(p.Duration)})
+	}
+	sort.Slice(state.Plans, func(i, j int) bool {
+		return state.Plans[i].Name < state.Plans[j].Name
+	})
+	for _, b := range s.batches {
+		state.Batches = append(state.Batches, *b)
+	}
+	sort.Slice(state.Batches, func(i, j int) bool {
+		return state.Batches[i].CreatedAt.Before(state.Batches[j].CreatedAt)
+	})
+	for _, v := range s.order {
+		saved := *v
+		// A redemption is only saved once it has finished; if the server
+		// stops first, the voucher can be redeemed again
+		if saved.Status == StatusRedeeming {
+			saved.Status = StatusUnredeemed
+		}
+		state.Vouchers = append(state.Vouchers, saved)
+	}
+	if err := storage.WriteJSON(s.path, state); err != nil {
+		slog.Error("Failed to save plans and vouchers", "error", err)
+	}
+}
+
+// Plans returns the plans sorted by name.
+func (s *Store) Plans() []Plan {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	plans := make([]Plan, 0, len(s.plans))
+	for _, p := range s.plans {
+		plans = append(plans, *p)
+	}
+	sort.Slice(plans, func(i, j int) bool {
+		return plans[i].Name < plans[j].Name
+	})
+	return plans
+}
+
+func (s *Store) Plan(name string) (Plan, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	p, exists := s.plans[name]
+	if !exists {
+		return Plan{}, ErrPlanNotFound
+	}
+	return *p, nil
+}
+
+// PutPlan creates or replaces a plan. Clients already on the plan keep the
+// settings they were given; vouchers grant the new ones.
+func (s *Store) PutPlan(p Plan) (Plan, error) {
+	if err := p.Validate(); err != nil {
+		return Plan{}, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	p.CreatedAt, p.UpdatedAt = now, now
+	if old, exists := s.plans[p.Name]; exists {
+		p.CreatedAt = old.CreatedAt
+	}
+	s.plans[p.Name] = &p
+	s.save()
+	return p, nil
+}
+
+// DeletePlan removes a plan unless vouchers that can still be redeemed
+// grant it.
+func (s *Store) DeletePlan(name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.plans[name]; !exists {
+		return ErrPlanNotFound
+	}
+	now := time.Now()
+	for _, v := range s.order {
+		if status := v.status(now); v.Plan == name && (status == StatusUnredeemed || status == StatusRedeeming) {
+			return fmt.Errorf("%w, revoke them first", ErrPlanInUse)
+		}
+	}
+	delete(s.plans, name)
+	s.save()
+	return nil
+}
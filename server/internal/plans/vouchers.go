@@ -0,0 +1,398 @@
+package plans
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MaxBatchSize bounds how many vouchers one batch may hold.
+const MaxBatchSize = 10000
+
+// codeAlphabet is Crockford's base32, which leaves out I, L, O and U so
+// that codes survive being read aloud or typed from paper.
+const codeAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// codeLength is the number of code characters, 80 random bits, written in
+// groups of codeGroup.
+const (
+	codeLength = 16
+	codeGroup  = 4
+)
+
+var (
+	ErrVoucherNotFound = errors.New("voucher not found")
+	ErrBatchNotFound   = errors.New("voucher batch not found")
+	ErrRedeemed        = errors.New("voucher has already been redeemed")
+	ErrRedeeming       = errors.New("voucher is being redeemed")
+	ErrExpired         = errors.New("voucher has expired")
+	ErrRevoked         = errors.New("voucher has been revoked")
+)
+
+type VoucherStatus string
+
+const (
+	StatusUnredeemed VoucherStatus = "unredeemed"
+	// StatusRedeeming vouchers are being redeemed: their client is being
+	// created or extended. They end up redeemed or, if that fails,
+	// unredeemed again.
+	StatusRedeeming VoucherStatus = "redeeming"
+	StatusRedeemed  VoucherStatus = "redeemed"
+	// StatusExpired vouchers passed their expiry without being redeemed.
+	StatusExpired VoucherStatus = "expired"
+	StatusRevoked VoucherStatus = "revoked"
+)
+
+// Voucher is a single-use code that creates a client on its plan or extends
+// an existing one.
+type Voucher struct {
+	Code   string        `json:"code"`
+	Plan   string        `json:"plan"`
+	Batch  string        `json:"batch"`
+	Status VoucherStatus `json:"status"`
+	// ExpiresAt is zero for vouchers that do not expire.
+	ExpiresAt  time.Time `json:"expires_at"`
+	CreatedAt  time.Time `json:"created_at"`
+	RedeemedAt time.Time `json:"redeemed_at"`
+	RevokedAt  time.Time `json:"revoked_at"`
+	// Client is the UUID of the client the voucher created or, if Extended,
+	// extended.
+	Client   string `json:"client"`
+	Extended bool   `json:"extended"`
+}
+
+// status is the voucher's status as of now; expiry is not stored.
+func (v *Voucher) status(now time.Time) VoucherStatus {
+	if v.Status == StatusUnredeemed && !v.ExpiresAt.IsZero() && now.After(v.ExpiresAt) {
+		return StatusExpired
+	}
+	return v.Status
+}
+
+func (v *Voucher) snapshot(now time.Time) Voucher {
+	c := *v
+	c.Status = v.status(now)
+	return c
+}
+
+// Batch is a set of vouchers generated together, with how many of them are
+// in each status.
+type Batch struct {
+	ID         string    `json:"id"`
+	Plan       string    `json:"plan"`
+	Note       string    `json:"note"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Total      int       `json:"total"`
+	Unredeemed int       `json:"unredeemed"`
+	Redeeming  int       `json:"redeeming"`
+	Redeemed   int       `json:"redeemed"`
+	Expired    int       `json:"expired"`
+	Revoked    int       `json:"revoked"`
+}
+
+// VoucherQuery selects vouchers; zero fields are not filtered on.
+type VoucherQuery struct {
+	Batch  string
+	Plan   string
+	Status VoucherStatus
+}
+
+func ParseVoucherStatus(s string) (VoucherStatus, error) {
+	switch status := VoucherStatus(s); status {
+	case "", StatusUnredeemed, StatusRedeeming, StatusRedeemed, StatusExpired, StatusRevoked:
+		return status, nil
+	}
+	return "", fmt.Errorf("unknown voucher status %q", s)
+}
+
+// Generate creates a batch of count vouchers for the plan. A zero expiresAt
+// makes them valid until redeemed or revoked.
+func (s *Store) Generate(plan string, count int, expiresAt time.Time, note string) (Batch, []Voucher, error) {
+	if count < 1 || count > MaxBatchSize {
+		return Batch{}, nil, fmt.Errorf("count must be between 1 and %d", MaxBatchSize)
+	}
+	now := time.Now()
+	if !expiresAt.IsZero() && !expiresAt.After(now) {
+		return Batch{}, nil, errors.New("expiry must be in the future")
+	}
+	id, err := randomHex(8)
+	if err != nil {
+		return Batch{}, nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.plans[plan]; !exists {
+		return Batch{}, nil, ErrPlanNotFound
+	}
+	batch := &Batch{ID: id, Plan: plan, Note: note, CreatedAt: now, ExpiresAt: expiresAt}
+	vouchers := make([]Voucher, 0, count)
+	for len(vouchers) < count {
+		key, err := randomCode()
+		if err != nil {
+			return Batch{}, nil, err
+		}
+		if _, taken := s.vouchers[key]; taken {
+			continue
+		}
+		v := &Voucher{
+			Code:      formatCode(key),
+			Plan:      plan,
+			Batch:     id,
+			Status:    StatusUnredeemed,
+			ExpiresAt: expiresAt,
+			CreatedAt: now,
+		}
+		s.vouchers[key] = v
+		s.order = append(s.order, v)
+		vouchers = append(vouchers, *v)
+	}
+	s.batches[id] = batch
+	s.save()
+	return s.count(*batch, now), vouchers, nil
+}
+
+// Vouchers returns the matching vouchers, oldest first.
+func (s *Store) Vouchers(q VoucherQuery) []Voucher {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	vouchers := make([]Voucher, 0)
+	for _, v := range s.order {
+		if (q.Batch != "" && v.Batch != q.Batch) || (q.Plan != "" && v.Plan != q.Plan) {
+			continue
+		}
+		if snapshot := v.snapshot(now); q.Status == "" || snapshot.Status == q.Status {
+			vouchers = append(vouchers, snapshot)
+		}
+	}
+	return vouchers
+}
+
+func (s *Store) Voucher(code string) (Voucher, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	v, exists := s.vouchers[normalizeCode(code)]
+	if !exists {
+		return Voucher{}, ErrVoucherNotFound
+	}
+	return v.snapshot(time.Now()), nil
+}
+
+// Batches returns every batch with its current counts, newest first.
+func (s *Store) Batches() []Batch {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	batches := make([]Batch, 0, len(s.batches))
+	for _, b := range s.batches {
+		batches = append(batches, s.count(*b, now))
+	}
+	sort.Slice(batches, func(i, j int) bool {
+		return batches[i].CreatedAt.After(batches[j].CreatedAt)
+	})
+	return batches
+}
+
+// count fills in the status counts of a batch. The caller must hold
+// s.mutex.
+func (s *Store) count(b Batch, now time.Time) Batch {
+	for _, v := range s.order {
+		if v.Batch != b.ID {
+			continue
+		}
+		b.Total++
+		switch v.status(now) {
+		case StatusUnredeemed:
+			b.Unredeemed++
+		case StatusRedeeming:
+			b.Redeeming++
+		case StatusRedeemed:
+			b.Redeemed++
+		case StatusExpired:
+			b.Expired++
+		case StatusRevoked:
+			b.Revoked++
+		}
+	}
+	return b
+}
+
+// Revoke stops an unredeemed voucher from being redeemed. Revoking a
+// revoked voucher is a no-op. A voucher being redeemed is revoked only if
+// the redemption fails; Revoke waits for it to finish.
+func (s *Store) Revoke(code string) (Voucher, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	v, exists := s.vouchers[normalizeCode(code)]
+	if !exists {
+		return Voucher{}, ErrVoucherNotFound
+	}
+	for v.Status == StatusRedeeming {
+		s.redemptions.Wait()
+	}
+	now := time.Now()
+	if v.Status == StatusRedeemed {
+		return v.snapshot(now), ErrRedeemed
+	}
+	if v.Status != StatusRevoked {
+		v.Status = StatusRevoked
+		v.RevokedAt = now
+		s.save()
+	}
+	return v.snapshot(now), nil
+}
+
+// RevokeBatch revokes every voucher of the batch that has not been
+// redeemed, such as after the codes leaked. Like Revoke, it waits for the
+// batch's redemptions in progress to finish.
+func (s *Store) RevokeBatch(id string) (Batch, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	b, exists := s.batches[id]
+	if !exists {
+		return Batch{}, ErrBatchNotFound
+	}
+	for s.redeeming(id) {
+		s.redemptions.Wait()
+	}
+	now := time.Now()
+	for _, v := range s.order {
+		if v.Batch == id && v.Status == StatusUnredeemed {
+			v.Status = StatusRevoked
+			v.RevokedAt = now
+		}
+	}
+	s.save()
+	return s.count(*b, now), nil
+}
+
+// redeeming reports whether a voucher of the batch is being redeemed. The
+// caller must hold s.mutex.
+func (s *Store) redeeming(batch string) bool {
+	for _, v := range s.order {
+		if v.Batch == batch && v.Status == StatusRedeeming {
+			return true
+		}
+	}
+	return false
+}
+
+// Redeem uses up a voucher. apply creates or extends the client on the
+// voucher's plan and returns its UUID; existing is the UUID of the client
+// being extended, or "" when apply creates one. ErrPlanNotFound comes with
+// the voucher, to name the missing plan.
+//
+// apply runs without s.mutex held, so it may take the ClientManager's locks
+// freely. The voucher is redeeming while apply runs, so that a code is never
+// redeemed twice, and goes back to unredeemed if apply fails.
+func (s *Store) Redeem(code, existing string, apply func(Plan) (string, error)) (Voucher, error) {
+	s.mutex.Lock()
+	v, exists := s.vouchers[normalizeCode(code)]
+	if !exists {
+		s.mutex.Unlock()
+		return Voucher{}, ErrVoucherNotFound
+	}
+	now := time.Now()
+	var err error
+	switch v.status(now) {
+	case StatusRedeeming:
+		err = ErrRedeeming
+	case StatusRedeemed:
+		err = ErrRedeemed
+	case StatusExpired:
+		err = ErrExpired
+	case StatusRevoked:
+		err = ErrRevoked
+	}
+	plan, exists := s.plans[v.Plan]
+	if err == nil && !exists {
+		// The plan was deleted after the voucher stopped being
+		// redeemable, or is missing from the saved store
+		s.mutex.Unlock()
+		return v.snapshot(now), ErrPlanNotFound
+	}
+	if err != nil {
+		s.mutex.Unlock()
+		return Voucher{}, err
+	}
+	v.Status = StatusRedeeming
+	p := *plan
+	s.mutex.Unlock()
+
+	client, err := apply(p)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	defer s.redemptions.Broadcast()
+
+	if err != nil {
+		v.Status = StatusUnredeemed
+		return Voucher{}, err
+	}
+	v.Status = StatusRedeemed
+	v.RedeemedAt = time.Now()
+	v.Client = client
+	v.Extended = existing != ""
+	s.save()
+	return *v, nil
+}
+
+// randomCode returns a normalized voucher code.
+func randomCode() (string, error) {
+	bytes := make([]byte, codeLength)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	code := make([]byte, codeLength)
+	for i, b := range bytes {
+		code[i] = codeAlphabet[b%byte(len(codeAlphabet))]
+	}
+	return string(code), nil
+}
+
+// formatCode splits a normalized code into dash-separated groups.
+func formatCode(key string) string {
+	groups := make([]string, 0, len(key)/codeGroup)
+	for i := 0; i < len(key); i += codeGroup {
+		groups = append(groups, key[i:min(i+codeGroup, len(key))])
+	}
+	return strings.Join(groups, "-")
+}
+
+// normalizeCode undoes the ways a code is commonly mistyped: lower case,
+// missing or extra separators and letters that look like digits.
+func normalizeCode(code string) string {
+	var b strings.Builder
+	for _, c := range strings.ToUpper(code) {
+		switch c {
+		case '-', ' ':
+			continue
+		case 'O':
+			c = '0'
+		case 'I', 'L':
+			c = '1'
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+func randomHex(n int) (string, error) {
+	bytes := make([]byte, n)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
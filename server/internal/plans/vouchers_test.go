@@ -0,0 +1,218 @@
+package plans
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestStore returns a store with a 30-day plan "month" and one voucher
+// for it.
+func newTestStore(t *testing.T, path string) (*Store, string) {
+	t.Helper()
+	s := NewStore()
+	if path != "" {
+		var err error
+		if s, err = Open(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.PutPlan(Plan{Name: "month", Duration: 30 * 24 * time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	_, vouchers, err := s.Generate("month", 1, time.Time{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, vouchers[0].Code
+}
+
+// created is an apply function that creates client "c1".
+func created(Plan) (string, error) { return "c1", nil }
+
+func TestRedeem(t *testing.T) {
+	s, code := newTestStore(t, "")
+
+	v, err := s.Redeem(code, "", created)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Status != StatusRedeemed || v.Client != "c1" || v.Extended || v.RedeemedAt.IsZero() {
+		t.Errorf("redeemed voucher %+v", v)
+	}
+	if _, err := s.Redeem(code, "", created); !errors.Is(err, ErrRedeemed) {
+		t.Errorf("second redemption: error %v, want %v", err, ErrRedeemed)
+	}
+	if _, err := s.Revoke(code); !errors.Is(err, ErrRedeemed) {
+		t.Errorf("revoking a redeemed voucher: error %v, want %v", err, ErrRedeemed)
+	}
+	if _, err := s.Redeem("0000-0000-0000-0000", "", created); !errors.Is(err, ErrVoucherNotFound) {
+		t.Errorf("unknown code: error %v, want %v", err, ErrVoucherNotFound)
+	}
+}
+
+func TestNormalizeCode(t *testing.T) {
+	for _, code := range []string{"ABCD-EFGH-JK01", "abcd efgh jk01", "ABCDEFGHJKO1", "abcd-efgh-jkol"} {
+		if got := normalizeCode(code); got != "ABCDEFGHJK01" {
+			t.Errorf("normalizeCode(%q) = %q, want ABCDEFGHJK01", code, got)
+		}
+	}
+}
+
+func TestRedeemFailureRollsBack(t *testing.T) {
+	s, code := newTestStore(t, "")
+	failure := errors.New("no such client")
+
+	_, err := s.Redeem(code, "c9", func(Plan) (string, error) { return "", failure })
+	if err != failure {
+		t.Fatalf("error %v, want %v", err, failure)
+	}
+	if v, _ := s.Voucher(code); v.Status != StatusUnredeemed {
+		t.Errorf("status %s after a failed redemption, want %s", v.Status, StatusUnredeemed)
+	}
+	if v, err := s.Redeem(code, "c2", func(Plan) (string, error) { return "c2", nil }); err != nil || !v.Extended {
+		t.Errorf("retry: %+v, error %v", v, err)
+	}
+}
+
+// redemption starts redeeming code with an apply function that blocks
+// until a result is sent, and waits until the voucher is redeeming.
+func redemption(t *testing.T, s *Store, code string) (result chan<- error, done <-chan error) {
+	t.Helper()
+	results := make(chan error)
+	finished := make(chan error, 1)
+	go func() {
+		_, err := s.Redeem(code, "", func(Plan) (string, error) {
+			if err := <-results; err != nil {
+				return "", err
+			}
+			return "c1", nil
+		})
+		finished <- err
+	}()
+	for {
+		if v, _ := s.Voucher(code); v.Status == StatusRedeeming {
+			return results, finished
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestRevokeDuringRedemption checks that a revoke waits for a redemption in
+// progress, so that a redemption that fails cannot undo it.
+func TestRevokeDuringRedemption(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		applyErr   error
+		wantErr    error
+		wantStatus VoucherStatus
+	}{
+		{"redemption fails", errors.New("failed"), nil, StatusRevoked},
+		{"redemption succeeds", nil, ErrRedeemed, StatusRedeemed},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			s, code := newTestStore(t, "")
+			result, done := redemption(t, s, code)
+
+			if _, err := s.Redeem(code, "", created); !errors.Is(err, ErrRedeeming) {
+				t.Errorf("concurrent redemption: error %v, want %v", err, ErrRedeeming)
+			}
+			if err := s.DeletePlan("month"); !errors.Is(err, ErrPlanInUse) {
+				t.Errorf("deleting the plan: error %v, want %v", err, ErrPlanInUse)
+			}
+			if b := s.Batches()[0]; b.Redeeming != 1 || b.Unredeemed != 0 {
+				t.Errorf("batch counts %+v, want one redeeming", b)
+			}
+
+			revoked := make(chan error, 1)
+			go func() {
+				_, err := s.Revoke(code)
+				revoked <- err
+			}()
+			select {
+			case err := <-revoked:
+				t.Fatalf("revoke returned %v before the redemption finished", err)
+			case <-time.After(20 * time.Millisecond):
+			}
+
+			result <- test.applyErr
+			if err := <-done; err != test.applyErr {
+				t.Errorf("redemption: error %v, want %v", err, test.applyErr)
+			}
+			if err := <-revoked; !errors.Is(err, test.wantErr) {
+				t.Errorf("revoke: error %v, want %v", err, test.wantErr)
+			}
+			if v, _ := s.Voucher(code); v.Status != test.wantStatus {
+				t.Errorf("status %s, want %s", v.Status, test.wantStatus)
+			}
+		})
+	}
+}
+
+func TestRevokeBatchDuringRedemption(t *testing.T) {
+	s, code := newTestStore(t, "")
+	batch, _ := s.Voucher(code)
+	result, done := redemption(t, s, code)
+
+	revoked := make(chan Batch, 1)
+	go func() {
+		b, _ := s.RevokeBatch(batch.Batch)
+		revoked <- b
+	}()
+	select {
+	case <-revoked:
+		t.Fatal("batch revoked before the redemption finished")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	result <- errors.New("failed")
+	<-done
+	if b := <-revoked; b.Revoked != 1 || b.Unredeemed != 0 || b.Redeeming != 0 {
+		t.Errorf("batch counts %+v, want the voucher revoked", b)
+	}
+}
+
+func TestRedeemDeletedPlan(t *testing.T) {
+	s, code := newTestStore(t, "")
+	s.mutex.Lock()
+	delete(s.plans, "month")
+	s.mutex.Unlock()
+
+	v, err := s.Redeem(code, "", created)
+	if !errors.Is(err, ErrPlanNotFound) || v.Plan != "month" {
+		t.Errorf("got %+v, error %v; want %v naming the plan", v, err, ErrPlanNotFound)
+	}
+	if v, _ := s.Voucher(code); v.Status != StatusUnredeemed {
+		t.Errorf("status %s, want %s", v.Status, StatusUnredeemed)
+	}
+}
+
+// TestSaveDuringRedemption checks that a redemption that has not finished
+// is saved as unredeemed, and a finished one as redeemed.
+func TestSaveDuringRedemption(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plans.json")
+	s, code := newTestStore(t, path)
+	result, done := redemption(t, s, code)
+
+	// Another change saves the store while the redemption runs
+	if _, err := s.PutPlan(Plan{Name: "year", Duration: 365 * 24 * time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := reopened.Voucher(code); v.Status != StatusUnredeemed {
+		t.Errorf("saved during the redemption as %s, want %s", v.Status, StatusUnredeemed)
+	}
+
+	result <- nil
+	<-done
+	if reopened, err = Open(path); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := reopened.Voucher(code); v.Status != StatusRedeemed || v.Client != "c1" {
+		t.Errorf("saved after the redemption as %+v", v)
+	}
+}
@@ -0,0 +1,86 @@
+package retention
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"yagnoetik-vpn/internal/usage"
+)
+
+// IPMode controls how much of a client's remote address is kept.
+type IPMode string
+
+const (
+	IPFull IPMode = "full"
+	// IPTruncated keeps the network only: /24 for IPv4 and /48 for IPv6.
+	IPTruncated IPMode = "truncated"
+	IPNone      IPMode = "none"
+)
+
+func ParseIPMode(s string) (IPMode, error) {
+	switch m := IPMode(s); m {
+	case IPFull, IPTruncated, IPNone:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown IP mode %q", s)
+}
+
+// Policy decides which connection metadata is recorded, at what granularity
+// and for how long. Lifetime traffic counters and quota usage are always
+// kept because billing depends on them.
+type Policy struct {
+	// NoLog guarantees that no remote address and no per-session or
+	// per-period record is kept, whatever the other fields say.
+	NoLog    bool
+	RemoteIP IPMode
+	// SessionHistory is how long finished sessions are kept; zero disables
+	// the session log.
+	SessionHistory time.Duration
+	// Usage lists the traffic history resolutions; empty disables history.
+	Usage []usage.Resolution
+	// Audit is how long state transitions and audit entries are kept; zero
+	// keeps them until the size cap.
+	Audit time.Duration
+}
+
+func DefaultPolicy() Policy {
+	return Policy{
+		RemoteIP:       IPFull,
+		SessionHistory: 30 * 24 * time.Hour,
+		Usage:          usage.DefaultResolutions(),
+	}
+}
+
+// Effective returns the policy with NoLog applied.
+func (p Policy) Effective() Policy {
+	if p.NoLog {
+		p.RemoteIP = IPNone
+		p.SessionHistory = 0
+		p.Usage = nil
+	}
+	return p
+}
+
+// RemoteAddr reduces a remote address, with or without a port, to what the
+// policy allows to be kept.
+func (p Policy) RemoteAddr(addr string) string {
+	switch p.Effective().RemoteIP {
+	case IPNone:
+		return ""
+	case IPTruncated:
+		host := addr
+		if h, _, err := net.SplitHostPort(addr); err == nil {
+			host = h
+		}
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return ""
+		}
+		if v4 := ip.To4(); v4 != nil {
+			return (&net.IPNet{IP: v4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+		}
+		return (&net.IPNet{IP: ip.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}).String()
+	}
+	return addr
+}
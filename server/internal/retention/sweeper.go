@@ -0,0 +1,46 @@
+package retention
+
+import (
+	"context"
+	"time"
+)
+
+// Pruner is a store that drops records once they are older than its
+// retention.
+type Pruner interface {
+	Prune(now time.Time)
+}
+
+// Sweeper prunes stores periodically. Stores only prune as new records
+// arrive, so without it the records of idle and deleted clients would be
+// kept past their retention.
+type Sweeper struct {
+	stores   []Pruner
+	interval time.Duration
+}
+
+func NewSweeper(interval time.Duration, stores ...Pruner) *Sweeper {
+	return &Sweeper{stores: stores, interval: interval}
+}
+
+// Run sweeps until the context is cancelled.
+func (s *Sweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.Sweep(time.Now())
+		}
+	}
+}
+
+// Sweep prunes every store as of now.
+func (s *Sweeper) Sweep(now time.Time) {
+	for _, store := range s.stores {
+		store.Prune(now)
+	}
+}
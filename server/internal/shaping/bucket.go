@@ -0,0 +1,98 @@
+package shaping
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Bucket is a token bucket rate limiter measured in bytes. A zero rate
+// disables limiting. The limit can be changed while goroutines are waiting.
+type Bucket struct {
+	rate   float64 // bytes per second
+	burst  float64 // bucket capacity in bytes
+	tokens float64
+	last   time.Time
+	mutex  sync.Mutex
+}
+
+func NewBucket(rate, burst int64) *Bucket {
+	b := &Bucket{last: time.Now()}
+	b.SetLimit(rate, burst)
+	b.tokens = b.burst
+	return b
+}
+
+// SetLimit changes the rate and burst. Accumulated tokens are capped to the
+// new burst so that lowering a limit takes effect immediately.
+func (b *Bucket) SetLimit(rate, burst int64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.refill(time.Now())
+	b.rate = float64(rate)
+	b.burst = float64(burst)
+	if b.burst <= 0 {
+		b.burst = b.rate
+	}
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+// Limit returns the current rate and burst.
+func (b *Bucket) Limit() (rate, burst int64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return int64(b.rate), int64(b.burst)
+}
+
+// Wait blocks until n bytes may pass or the context is done. Requests larger
+// than the burst are allowed and paid back by later callers, so packets are
+// never split or dropped.
+func (b *Bucket) Wait(ctx context.Context, n int) error {
+	delay := b.reserve(n, time.Now())
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// reserve takes n tokens, possibly going into debt, and returns how long the
+// caller has to wait for the debt to be repaid.
+func (b *Bucket) reserve(n int, now time.Time) time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.rate <= 0 {
+		return 0
+	}
+
+	b.refill(now)
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+func (b *Bucket) refill(now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	b.last = now
+	if elapsed <= 0 || b.rate <= 0 {
+		return
+	}
+	b.tokens += elapsed * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
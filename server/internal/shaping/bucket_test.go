@@ -0,0 +1,134 @@
+package shaping
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const packetSize = 1500
+
+// flow has workers goroutines push packets through one bucket until the
+// context is done, counting the bytes that were let through.
+func flow(ctx context.Context, b *Bucket, workers int, passed *atomic.Int64) *sync.WaitGroup {
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b.Wait(ctx, packetSize) == nil {
+				passed.Add(packetSize)
+			}
+		}()
+	}
+	return &wg
+}
+
+// sample lets a flow settle for a moment, then counts the bytes it lets
+// through in about a second.
+func sample(passed *atomic.Int64) (int64, time.Duration) {
+	time.Sleep(300 * time.Millisecond)
+	before, start := passed.Load(), time.Now()
+	time.Sleep(time.Second)
+	return passed.Load() - before, time.Since(start)
+}
+
+// checkRate fails unless bytes over elapsed is within tolerance of rate.
+func checkRate(t *testing.T, what string, bytes int64, elapsed time.Duration, rate int64, tolerance float64) {
+	t.Helper()
+	got := float64(bytes) / elapsed.Seconds()
+	if got < float64(rate)*(1-tolerance) || got > float64(rate)*(1+tolerance) {
+		t.Errorf("%s: %.0f B/s, want %d B/s ±%.0f%%", what, got, rate, tolerance*100)
+	}
+}
+
+func TestReserveRepaysDebt(t *testing.T) {
+	start := time.Now()
+	b := &Bucket{last: start}
+	b.SetLimit(1000, 500)
+	b.tokens = b.burst
+
+	if d := b.reserve(500, start); d != 0 {
+		t.Fatalf("reserve within burst waited %s", d)
+	}
+	// A packet larger than the bucket goes into debt instead of blocking
+	// forever
+	if d := b.reserve(1500, start); d != 1500*time.Millisecond {
+		t.Fatalf("reserve into debt: wait %s, want 1.5s", d)
+	}
+	if d := b.reserve(100, start.Add(time.Second)); d != 600*time.Millisecond {
+		t.Fatalf("reserve after partial repayment: wait %s, want 600ms", d)
+	}
+}
+
+func TestBucketUnlimited(t *testing.T) {
+	b := NewBucket(0, 0)
+	for i := 0; i < 1000; i++ {
+		if d := b.reserve(1<<20, time.Now()); d != 0 {
+			t.Fatalf("unlimited bucket waited %s", d)
+		}
+	}
+}
+
+// TestLimiterThroughputUnderLoad checks that many sessions sharing a
+// client's limiter together get the tier rate, not a multiple of it.
+func TestLimiterThroughputUnderLoad(t *testing.T) {
+	const rate = 2 << 20
+	shaper := NewShaper([]Tier{{Name: "basic", DownloadRate: rate}}, "")
+	limiter := shaper.Acquire("client", "basic")
+	defer shaper.Release("client")
+	if other := shaper.Acquire("client", "basic"); other != limiter {
+		t.Fatal("sessions of one client got different limiters")
+	}
+	defer shaper.Release("client")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var passed atomic.Int64
+	wg := flow(ctx, limiter.Download, 64, &passed)
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	bytes, elapsed := sample(&passed)
+	checkRate(t, "64 goroutines", bytes, elapsed, rate, 0.1)
+}
+
+// TestSetLimitWhileFlowing changes the tier of a client whose sessions are
+// sending and checks that the new rate applies to the running flow.
+func TestSetLimitWhileFlowing(t *testing.T) {
+	const slow, fast = 1 << 20, 4 << 20
+	shaper := NewShaper([]Tier{{Name: "basic", DownloadRate: slow}}, "")
+	limiter := shaper.Acquire("client", "basic")
+	defer shaper.Release("client")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var passed atomic.Int64
+	wg := flow(ctx, limiter.Download, 32, &passed)
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	bytes, elapsed := sample(&passed)
+	checkRate(t, "before the change", bytes, elapsed, slow, 0.1)
+
+	if err := shaper.SetTier(Tier{Name: "basic", DownloadRate: fast}); err != nil {
+		t.Fatal(err)
+	}
+	if rate, _ := limiter.Download.Limit(); rate != fast {
+		t.Fatalf("live limiter rate is %d after SetTier, want %d", rate, fast)
+	}
+	bytes, elapsed = sample(&passed)
+	checkRate(t, "after raising the rate", bytes, elapsed, fast, 0.1)
+
+	// Lowering must take effect at once rather than after spending the
+	// tokens saved up at the old rate
+	if err := shaper.SetTier(Tier{Name: "basic", DownloadRate: slow}); err != nil {
+		t.Fatal(err)
+	}
+	bytes, elapsed = sample(&passed)
+	checkRate(t, "after lowering the rate", bytes, elapsed, slow, 0.1)
+}
@@ -0,0 +1,243 @@
+package shaping
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var ErrTierNotFound = errors.New("speed tier not found")
+
+// Tier is a named speed plan. Rates are in bytes per second from the
+// client's point of view; zero means unlimited. Bursts default to one second
+// of traffic at the tier rate.
+type Tier struct {
+	Name          string `json:"name"`
+	DownloadRate  int64  `json:"download_rate"`
+	UploadRate    int64  `json:"upload_rate"`
+	DownloadBurst int64  `json:"download_burst"`
+	UploadBurst   int64  `json:"upload_burst"`
+}
+
+func (t Tier) Validate() error {
+	if t.Name == "" {
+		return errors.New("tier name is required")
+	}
+	if t.DownloadRate < 0 || t.UploadRate < 0 || t.DownloadBurst < 0 || t.UploadBurst < 0 {
+		return errors.New("rates and bursts must not be negative")
+	}
+	return nil
+}
+
+// Limiter holds the buckets shared by all sessions of one client, so that a
+// client cannot multiply its speed by opening several sessions.
+type Limiter struct {
+	Download *Bucket
+	Upload   *Bucket
+	tier     string
+	refs     int
+}
+
+func (l *Limiter) apply(t Tier) {
+	l.Download.SetLimit(t.DownloadRate, t.DownloadBurst)
+	l.Upload.SetLimit(t.UploadRate, t.UploadBurst)
+}
+
+// Shaper keeps the tier catalogue and the live per-client limiters. Changing
+// a tier or a client's assignment updates running sessions immediately.
+type Shaper struct {
+	tiers       map[string]Tier
+	defaultTier string
+	limiters    map[string]*Limiter // keyed by client UUID
+	mutex       sync.Mutex
+}
+
+// NewShaper creates a shaper. defaultTier applies to clients without an
+// assigned tier; an empty name leaves them unlimited.
+func NewShaper(tiers []Tier, defaultTier string) *Shaper {
+	s := &Shaper{
+		tiers:       make(map[string]Tier),
+		defaultTier: defaultTier,
+		limiters:    make(map[string]*Limiter),
+	}
+	for _, t := range tiers {
+		s.tiers[t.Name] = t
+	}
+	return s
+}
+
+// Acquire returns the client's limiter, creating it for the first session.
+// Every Acquire must be paired with a Release.
+func (s *Shaper) Acquire(uuid, tier string) *Limiter {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	l, exists := s.limiters[uuid]
+	if !exists {
+		l = &Limiter{
+			Download: NewBucket(0, 0),
+			Upload:   NewBucket(0, 0),
+		}
+		s.limiters[uuid] = l
+		l.tier = tier
+		l.apply(s.resolve(tier))
+	}
+	l.refs++
+	return l
+}
+
+func (s *Shaper) Release(uuid string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if l, exists := s.limiters[uuid]; exists {
+		l.refs--
+		if l.refs <= 0 {
+			delete(s.limiters, uuid)
+		}
+	}
+}
+
+// Assign switches a client with live sessions to another tier.
+func (s *Shaper) Assign(uuid, tier string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if l, exists := s.limiters[uuid]; exists && l.tier != tier {
+		l.tier = tier
+		l.apply(s.resolve(tier))
+	}
+}
+
+// HasTier reports whether a tier with the given name exists.
+func (s *Shaper) HasTier(name string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, exists := s.tiers[name]
+	return exists
+}
+
+func (s *Shaper) Tiers() []Tier {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	tiers := make([]Tier, 0, len(s.tiers))
+	for _, t := range s.tiers {
+		tiers = append(tiers, t)
+	}
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].Name < tiers[j].Name })
+	return tiers
+}
+
+// SetTier creates or replaces a tier and re-applies it to live sessions.
+func (s *Shaper) SetTier(t Tier) error {
+	if err := t.Validate(); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.tiers[t.Name] = t
+	s.reapply()
+	return nil
+}
+
+// DeleteTier removes a tier. Clients still assigned to it fall back to the
+// default tier.
+func (s *Shaper) DeleteTier(name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.tiers[name]; !exists {
+		return ErrTierNotFound
+	}
+	delete(s.tiers, name)
+	s.reapply()
+	return nil
+}
+
+func (s *Shaper) reapply() {
+	for _, l := range s.limiters {
+		l.apply(s.resolve(l.tier))
+	}
+}
+
+// resolve maps a tier name to its limits. The caller must hold s.mutex.
+func (s *Shaper) resolve(name string) Tier {
+	if t, exists := s.tiers[name]; exists {
+		return t
+	}
+	if t, exists := s.tiers[s.defaultTier]; exists {
+		return t
+	}
+	return Tier{}
+}
+
+// ParseTiers parses a tier list of the form
+// "basic:10mbit/2mbit,premium:100mbit/20mbit" where each entry is
+// name:download/upload. Bursts are left at their one-second default.
+func ParseTiers(s string) ([]Tier, error) {
+	tiers := make([]Tier, 0)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, rates, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid tier %q", entry)
+		}
+		down, up, ok := strings.Cut(rates, "/")
+		if !ok {
+			return nil, fmt.Errorf("invalid tier %q: expected download/upload", entry)
+		}
+
+		t := Tier{Name: strings.TrimSpace(name)}
+		var err error
+		if t.DownloadRate, err = ParseRate(down); err != nil {
+			return nil, fmt.Errorf("tier %s: %v", t.Name, err)
+		}
+		if t.UploadRate, err = ParseRate(up); err != nil {
+			return nil, fmt.Errorf("tier %s: %v", t.Name, err)
+		}
+		if err := t.Validate(); err != nil {
+			return nil, err
+		}
+		tiers = append(tiers, t)
+	}
+	return tiers, nil
+}
+
+// ParseRate converts a rate such as "10mbit", "512kbit" or "2mb" (bytes) to
+// bytes per second. A plain number is taken as bytes per second.
+func ParseRate(s string) (int64, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	units := []struct {
+		suffix string
+		factor float64
+	}{
+		{"gbit", 1e9 / 8}, {"mbit", 1e6 / 8}, {"kbit", 1e3 / 8},
+		{"gb", 1 << 30}, {"mb", 1 << 20}, {"kb", 1 << 10},
+	}
+
+	factor := 1.0
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			s = strings.TrimSuffix(s, u.suffix)
+			factor = u.factor
+			break
+		}
+	}
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+	return int64(value * factor), nil
+}
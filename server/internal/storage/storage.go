@@ -0,0 +1,162 @@
+// Package storage keeps server state in files under the data directory:
+// JSON snapshots that are replaced atomically, and append-only journals of
+// JSON records, one per line.
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// WriteJSON replaces path with the JSON encoding of v. The file is written
+// next to its destination and renamed into place, so a crash leaves either
+// the old or the new contents.
+func WriteJSON(path string, v any) error {
+	return replace(path, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	})
+}
+
+// ReadJSON decodes the file at path into v. A missing file leaves v
+// untouched and is not an error.
+func ReadJSON(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// replace atomically replaces path with what write produces.
+func replace(path string, write func(io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	if err := write(w); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Journal is an append-only file of JSON records, one per line.
+type Journal struct {
+	path  string
+	file  *os.File
+	mutex sync.Mutex
+}
+
+// OpenJournal opens the journal at path, creating it if needed, and passes
+// every record in it to read in order. A last line cut short by a crash is
+// dropped; any other unreadable line is an error.
+func OpenJournal(path string, read func(json.RawMessage) error) (*Journal, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	var valid int64
+	r := bufio.NewReader(file)
+	for line := 1; ; line++ {
+		data, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			// Anything after the last newline is a torn write
+			break
+		}
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		valid += int64(len(data))
+		if data = bytes.TrimSpace(data); len(data) == 0 {
+			continue
+		}
+		if err := read(data); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+	}
+
+	if err := file.Truncate(valid); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(valid, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &Journal{path: path, file: file}, nil
+}
+
+// Append writes a record to the end of the journal.
+func (j *Journal) Append(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	_, err = j.file.Write(append(data, '\n'))
+	return err
+}
+
+// Rewrite atomically replaces the journal's records with those write
+// encodes, one Encode call per record. It is used to drop expired records.
+func (j *Journal) Rewrite(write func(*json.Encoder) error) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	err := replace(j.path, func(w io.Writer) error {
+		return write(json.NewEncoder(w))
+	})
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	j.file.Close()
+	j.file = file
+	return nil
+}
+
+func (j *Journal) Close() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	return j.file.Close()
+}
@@ -0,0 +1,86 @@
+package tunnel
+
+import (
+	"sync"
+	"time"
+
+	"yagnoetik-vpn/internal/events"
+)
+
+// SessionEnd is the data of session.ended events.
+type SessionEnd struct {
+	SessionInfo
+	EndedAt time.Time `json:"ended_at"`
+	Reason  string    `json:"reason"`
+}
+
+// AuthFailures is the data of auth.failure_burst events.
+type AuthFailures struct {
+	Count   int            `json:"count"`
+	Window  string         `json:"window"`
+	Reasons map[string]int `json:"reasons"`
+}
+
+// failureBurst counts rejected connects in fixed windows. A burst is
+// reported at most once per window.
+type failureBurst struct {
+	start    time.Time
+	count    int
+	reasons  map[string]int
+	reported bool
+	mutex    sync.Mutex
+}
+
+func (s *Server) publishSessionStarted(conn *Connection) {
+	if s.options.Events == nil {
+		return
+	}
+	conn.announced = true
+	s.options.Events.Publish(events.SessionStarted, conn.client.UUID, conn.info())
+}
+
+func (s *Server) publishSessionEnded(conn *Connection, reason string) {
+	if s.options.Events == nil || !conn.announced {
+		return
+	}
+	s.options.Events.Publish(events.SessionEnded, conn.client.UUID, SessionEnd{
+		SessionInfo: conn.info(),
+		EndedAt:     time.Now(),
+		Reason:      reason,
+	})
+}
+
+// recordAuthFailure publishes auth.failure_burst when AuthFailureBurst
+// connects were rejected within one AuthFailureWindow.
+func (s *Server) recordAuthFailure(reason string) {
+	if s.options.Events == nil || s.options.AuthFailureBurst <= 0 {
+		return
+	}
+
+	b := s.authFailures
+	b.mutex.Lock()
+	now := time.Now()
+	if now.Sub(b.start) >= s.options.AuthFailureWindow {
+		b.start, b.count, b.reasons, b.reported = now, 0, make(map[string]int), false
+	}
+	b.count++
+	b.reasons[reason]++
+	var burst *AuthFailures
+	if b.count >= s.options.AuthFailureBurst && !b.reported {
+		b.reported = true
+		burst = &AuthFailures{
+			Count:   b.count,
+			Window:  s.options.AuthFailureWindow.String(),
+			Reasons: make(map[string]int, len(b.reasons)),
+		}
+		for r, n := range b.reasons {
+			burst.Reasons[r] = n
+		}
+	}
+	b.mutex.Unlock()
+
+	if burst != nil {
+		s.logger.Warn("Authentication failure burst", "count", burst.Count, "window", burst.Window)
+		s.options.Events.Publish(events.AuthFailureBurst, "", burst)
+	}
+}
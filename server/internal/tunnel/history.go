@@ -0,0 +1,79 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"yagnoetik-vpn/internal/history"
+)
+
+// Reasons recorded for sessions that were not closed by the server.
+const (
+	ReasonClientClosed = "client disconnected"
+	ReasonStreamError  = "stream error"
+	ReasonRejected     = "rejected"
+)
+
+// endSession logs the end of a session and records it. err is the error
+// Connect returned.
+func (s *Server) endSession(conn *Connection, err error) {
+	reason, detail := endReason(conn, err)
+	conn.logger.Info("Session ended",
+		"reason", reason,
+		"error", detail,
+		"duration", time.Since(conn.startedAt).Round(time.Second),
+		"bytes_up", conn.bytesUp.Load(),
+		"bytes_down", conn.bytesDown.Load(),
+	)
+	s.recordSession(conn, reason, detail)
+	s.publishSessionEnded(conn, reason)
+}
+
+// recordSession adds the finished session to the history log. Sessions of
+// deleted clients are not recorded, so that erasing a client cannot race
+// with its sessions winding down.
+func (s *Server) recordSession(conn *Connection, reason, detail string) {
+	if s.options.History == nil {
+		return
+	}
+	if _, exists := s.clientManager.FindClient(conn.client.UUID); !exists {
+		return
+	}
+
+	record := history.Record{
+		ID:            conn.id,
+		ClientUUID:    conn.client.UUID,
+		RemoteAddr:    conn.remoteAddr,
+		ClientVersion: conn.clientVersion,
+		StartedAt:     conn.startedAt,
+		EndedAt:       time.Now(),
+		BytesUp:       conn.bytesUp.Load(),
+		BytesDown:     conn.bytesDown.Load(),
+	}
+	if conn.assignedIP != nil {
+		record.AssignedIP = conn.assignedIP.String()
+	}
+	record.Reason, record.Error = reason, detail
+
+	s.options.History.Add(record)
+}
+
+// endReason classifies why a session ended. Cancellation of the stream
+// context itself comes from the client side: it hung up or its deadline
+// passed.
+func endReason(conn *Connection, err error) (reason, detail string) {
+	cause := context.Cause(conn.ctx)
+	clientGone := errors.Is(cause, context.Canceled) || errors.Is(cause, context.DeadlineExceeded)
+	switch {
+	case errors.Is(err, ErrSessionLimit):
+		return ReasonRejected, err.Error()
+	case cause != nil && !clientGone:
+		// Closed by the server with a reason
+		return cause.Error(), ""
+	case err == nil, errors.Is(err, io.EOF), clientGone:
+		return ReasonClientClosed, ""
+	}
+	return ReasonStreamError, err.Error()
+}
@@ -0,0 +1,115 @@
+package tunnel
+
+import (
+	"fmt"
+	"time"
+
+	"yagnoetik-vpn/internal/auth"
+	"yagnoetik-vpn/internal/protocol"
+)
+
+const ReasonQuotaExceeded = "traffic quota exceeded"
+
+// effectiveTier is the speed tier a client's sessions should run at, taking
+// quota throttling into account.
+func (s *Server) effectiveTier(client *auth.Client) string {
+	if client.QuotaExceeded && s.clientManager.QuotaPolicy().Action == auth.QuotaActionThrottle {
+		return s.options.QuotaThrottleTier
+	}
+	return client.SpeedTier
+}
+
+// accountUsage periodically adds the session's traffic to the client's
+// counters so that quotas are enforced while the session runs.
+func (s *Server) accountUsage(conn *Connection) {
+	ticker := time.NewTicker(s.options.UsageFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-conn.ctx.Done():
+			return
+		case <-ticker.C:
+			s.flushUsage(conn)
+		}
+	}
+}
+
+func (s *Server) flushUsage(conn *Connection) {
+	conn.accountMutex.Lock()
+	defer conn.accountMutex.Unlock()
+
+	up, down := conn.bytesUp.Load(), conn.bytesDown.Load()
+	deltaUp, deltaDown := up-conn.reportedUp, down-conn.reportedDown
+	if deltaUp == 0 && deltaDown == 0 {
+		return
+	}
+	conn.reportedUp, conn.reportedDown = up, down
+
+	s.clientManager.AddUsage(conn.client.UUID, deltaUp, deltaDown)
+
+	// The history of a deleted client has been dropped and must stay so
+	if _, exists := s.clientManager.FindClient(conn.client.UUID); exists && s.options.Usage != nil {
+		s.options.Usage.Record(conn.client.UUID, deltaUp, deltaDown, time.Now())
+	}
+}
+
+// handleQuota warns users as they approach their quota and applies the
+// quota action once it is exhausted.
+func (s *Server) handleQuota(e auth.QuotaEvent) {
+	switch e.Kind {
+	case auth.QuotaWarning:
+		s.notifyClient(e.UUID, fmt.Sprintf("Использовано %d%% трафика (%s из %s)",
+			e.Percent, formatBytes(e.Used), formatBytes(e.Quota)))
+
+	case auth.QuotaExceeded:
+		s.logger.Info("Client exhausted its quota", "client_id", e.UUID, "action", e.Action)
+		if e.Action == auth.QuotaActionThrottle {
+			s.options.Shaper.Assign(e.UUID, s.options.QuotaThrottleTier)
+			s.notifyClient(e.UUID, "Трафик исчерпан, скорость ограничена")
+			return
+		}
+		s.notifyClient(e.UUID, "Трафик исчерпан")
+		s.CloseClientSessions(e.UUID, ReasonQuotaExceeded)
+
+	case auth.QuotaRestored:
+		if client, exists := s.clientManager.FindClient(e.UUID); exists {
+			s.options.Shaper.Assign(e.UUID, s.effectiveTier(client))
+		}
+	}
+}
+
+// notifyClient sends a notice frame to every live session of the client.
+func (s *Server) notifyClient(uuid, message string) {
+	s.connMutex.RLock()
+	targets := make([]*Connection, 0)
+	for _, conn := range s.connections {
+		if conn.client.UUID == uuid {
+			targets = append(targets, conn)
+		}
+	}
+	s.connMutex.RUnlock()
+
+	frame := &protocol.Frame{
+		Type: protocol.FrameTypeNotice,
+		Data: []byte(message),
+	}
+	for _, conn := range targets {
+		if err := s.sendFrame(conn, frame); err != nil {
+			conn.logger.Warn("Failed to send notice", "error", err)
+		}
+	}
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -4,57 +4,173 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net"
+	"net/netip"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"yagnoetik-vpn/internal/auth"
 	"yagnoetik-vpn/internal/crypto"
+	"yagnoetik-vpn/internal/events"
+	"yagnoetik-vpn/internal/history"
+	"yagnoetik-vpn/internal/metrics"
 	"yagnoetik-vpn/internal/protocol"
+	"yagnoetik-vpn/internal/retention"
+	"yagnoetik-vpn/internal/shaping"
+	"yagnoetik-vpn/internal/usage"
 	pb "yagnoetik-vpn/proto"
 
 	"google.golang.org/grpc/metadata"
+
+	"yagnoetik-sdk/logging"
 )
 
 type Server struct {
 	pb.UnimplementedTunnelServiceServer
 	clientManager *auth.ClientManager
-	connections   map[string]*Connection
+	options       Options
+	connections   map[string]*Connection // keyed by session ID
 	connMutex     sync.RWMutex
+	ipPool        *ipPool
+	authFailures  *failureBurst
+	logger        *slog.Logger
+}
+
+// Options holds server-wide defaults that individual clients may override.
+type Options struct {
+	// DefaultMaxSessions limits concurrent sessions per client; 0 is unlimited.
+	DefaultMaxSessions   int
+	DefaultSessionPolicy auth.SessionPolicy
+	// Shaper applies speed tiers; nil leaves all clients unlimited.
+	Shaper *shaping.Shaper
+	// QuotaThrottleTier is the tier applied to clients that exhausted their
+	// quota when the quota action is throttle.
+	QuotaThrottleTier string
+	// UsageFlushInterval is how often live traffic is added to the client's
+	// counters and checked against its quota.
+	UsageFlushInterval time.Duration
+	// Usage records traffic history; nil keeps only the lifetime counters.
+	Usage *usage.Store
+	// History logs finished sessions; nil disables the log.
+	History *history.Store
+	// Retention decides how much of the remote address is kept.
+	Retention retention.Policy
+	// Events receives session and authentication events; nil disables them.
+	Events *events.Bus
+	// TunnelNetwork is the IPv4 network session addresses are assigned
+	// from; the zero prefix assigns none. Sessions beyond its size start
+	// without an address.
+	TunnelNetwork netip.Prefix
+	// TrustedProxies may set the X-Real-IP metadata; loopback peers always
+	// may.
+	TrustedProxies []netip.Prefix
+	// AuthFailureBurst is how many failed authentications within
+	// AuthFailureWindow raise an auth.failure_burst event; 0 disables it.
+	AuthFailureBurst  int
+	AuthFailureWindow time.Duration
+	// Logger defaults to slog.Default().
+	Logger *slog.Logger
 }
 
 type Connection struct {
-	client     *auth.Client
-	cipher     *crypto.Cipher
-	stream     pb.TunnelService_ConnectServer
-	tunConn    net.Conn
-	lastPing   time.Time
-	bytesUp    int64
-	bytesDown  int64
-	ctx        context.Context
-	cancel     context.CancelFunc
+	id            string
+	client        *auth.Client
+	secret        string
+	cipher        *crypto.Cipher
+	stream        pb.TunnelService_ConnectServer
+	tunConn       net.Conn
+	limiter       *shaping.Limiter
+	remoteAddr    string
+	clientVersion string
+	assignedIP    net.IP
+	startedAt     time.Time
+	lastPing      atomic.Int64 // unix nanoseconds
+	rtt           atomic.Int64 // nanoseconds
+	bytesUp       atomic.Int64
+	bytesDown     atomic.Int64
+	// reportedUp and reportedDown are the byte counts already added to the
+	// client's usage, guarded by accountMutex.
+	reportedUp   int64
+	reportedDown int64
+	accountMutex sync.Mutex
+	sendMutex    sync.Mutex
+	ctx          context.Context
+	cancel       context.CancelCauseFunc
+	// logger carries the session and client IDs; packetLog limits
+	// per-packet error lines.
+	logger    *slog.Logger
+	packetLog *logging.Limiter
+	// announced is set once session.started was published, so that only
+	// sessions that really started get a session.ended event.
+	announced bool
 }
 
-func NewServer(clientManager *auth.ClientManager) *Server {
-	return &Server{
+func NewServer(clientManager *auth.ClientManager, options Options) *Server {
+	if options.Shaper == nil {
+		options.Shaper = shaping.NewShaper(nil, "")
+	}
+	if options.UsageFlushInterval <= 0 {
+		options.UsageFlushInterval = time.Second
+	}
+	if options.Logger == nil {
+		options.Logger = slog.Default()
+	}
+	if options.AuthFailureWindow <= 0 {
+		options.AuthFailureWindow = time.Minute
+	}
+	s := &Server{
 		clientManager: clientManager,
+		options:       options,
 		connections:   make(map[string]*Connection),
+		ipPool:        newIPPool(options.TunnelNetwork),
+		authFailures:  &failureBurst{},
+		logger:        options.Logger,
+	}
+	clientManager.OnTransition(s.handleTransition)
+	clientManager.OnUpdate(func(c *auth.Client) {
+		s.options.Shaper.Assign(c.UUID, s.effectiveTier(c))
+	})
+	clientManager.OnQuota(s.handleQuota)
+	return s
+}
+
+// handleTransition tears down the live sessions of a client that lost access.
+func (s *Server) handleTransition(t auth.Transition) {
+	var reason string
+	switch t.To {
+	case auth.StateSuspended:
+		reason = ReasonSuspended
+	case auth.StateExpired:
+		reason = ReasonExpired
+	case auth.StateDeleted:
+		reason = ReasonDeleted
+	default:
+		return
+	}
+
+	if closed := s.CloseClientSessions(t.UUID, reason); closed > 0 {
+		s.logger.Info("Disconnected client sessions", "client_id", t.UUID, "sessions", closed, "reason", reason)
 	}
 }
 
-func (s *Server) Connect(stream pb.TunnelService_ConnectServer) error {
+func (s *Server) Connect(stream pb.TunnelService_ConnectServer) (err error) {
+	handshakeStart := time.Now()
+
 	// Authenticate client from metadata
 	ctx := stream.Context()
 	md, ok := metadata.FromIncomingContext(ctx)
 	if !ok {
+		s.authFailed("", metrics.ReasonNoMetadata)
 		return fmt.Errorf("no metadata")
 	}
 
 	uuidValues := md.Get("uuid")
 	secretValues := md.Get("secret")
-	
+
 	if len(uuidValues) == 0 || len(secretValues) == 0 {
+		s.authFailed("", metrics.ReasonMissingCredentials)
 		return fmt.Errorf("missing credentials")
 	}
 
@@ -63,61 +179,98 @@ func (s *Server) Connect(stream pb.TunnelService_ConnectServer) error {
 
 	client, exists := s.clientManager.GetClient(uuid)
 	if !exists || client.Secret != secret {
+		s.authFailed(uuid, metrics.ReasonInvalidCredentials)
 		return fmt.Errorf("invalid credentials")
 	}
+	metrics.AuthAttempts.WithLabelValues(metrics.AuthSuccess, "").Inc()
 
 	// Create cipher for this connection
 	cipher, err := crypto.NewCipher(client.Key)
 	if err != nil {
+		s.rejected(uuid, metrics.RejectInternal)
 		return fmt.Errorf("failed to create cipher: %v", err)
 	}
 
-	// Create TUN connection
-	tunConn, err := s.createTunConnection()
-	if err != nil {
-		return fmt.Errorf("failed to create tun connection: %v", err)
-	}
-	defer tunConn.Close()
+	// Assign a tunnel address, if one is free
+	assignedIP := s.ipPool.acquire()
+	defer s.ipPool.release(assignedIP)
 
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
 
+	now := time.Now()
 	conn := &Connection{
-		client:    client,
-		cipher:    cipher,
-		stream:    stream,
-		tunConn:   tunConn,
-		lastPing:  time.Now(),
-		ctx:       ctx,
-		cancel:    cancel,
+		id:            newSessionID(),
+		client:        client,
+		secret:        secret,
+		cipher:        cipher,
+		stream:        stream,
+		remoteAddr:    s.options.Retention.RemoteAddr(s.remoteAddr(ctx)),
+		clientVersion: firstValue(md, "client-version"),
+		assignedIP:    assignedIP,
+		startedAt:     now,
+		ctx:           ctx,
+		cancel:        cancel,
 	}
+	conn.lastPing.Store(now.UnixNano())
+	conn.logger = s.logger.With("session_id", conn.id, "client_id", uuid)
+	conn.packetLog = logging.NewLimiter(5, time.Minute)
+	defer func() { s.endSession(conn, err) }()
 
-	s.connMutex.Lock()
-	s.connections[uuid] = conn
-	s.connMutex.Unlock()
+	conn.limiter = s.options.Shaper.Acquire(uuid, s.effectiveTier(client))
+	defer s.options.Shaper.Release(uuid)
+
+	if err := s.admit(conn); err != nil {
+		s.rejected(uuid, metrics.RejectSessionLimit)
+		return err
+	}
+	// A pending client becomes active with its first admitted session
+	s.clientManager.ActivateClient(uuid)
+	metrics.SessionsActive.Inc()
+	defer metrics.SessionsActive.Dec()
 
 	defer func() {
 		s.connMutex.Lock()
-		delete(s.connections, uuid)
+		delete(s.connections, conn.id)
 		s.connMutex.Unlock()
-		
-		// Update traffic stats
-		s.clientManager.UpdateTraffic(uuid, conn.bytesUp, conn.bytesDown)
+
+		// Account traffic not yet flushed
+		s.flushUsage(conn)
 	}()
 
+	// Create TUN connection
+	tunConn, err := s.createTunConnection()
+	if err != nil {
+		return fmt.Errorf("failed to create tun connection: %v", err)
+	}
+	defer tunConn.Close()
+	conn.tunConn = tunConn
+
+	header := metadata.Pairs("session-id", conn.id)
+	if assignedIP != nil {
+		header.Set("assigned-ip", assignedIP.String())
+	}
+	if err := stream.SendHeader(header); err != nil {
+		return fmt.Errorf("failed to send header: %v", err)
+	}
+	metrics.HandshakeDuration.Observe(time.Since(handshakeStart).Seconds())
+	conn.logger.Info("Session started", "remote_addr", conn.remoteAddr, "client_version", conn.clientVersion)
+	s.publishSessionStarted(conn)
+
 	// Start goroutines for data transfer
 	errChan := make(chan error, 2)
-	
+
 	go s.handleStreamToTun(conn, errChan)
 	go s.handleTunToStream(conn, errChan)
 	go s.keepAlive(conn)
+	go s.accountUsage(conn)
 
 	// Wait for error or context cancellation
 	select {
 	case err := <-errChan:
 		return err
 	case <-ctx.Done():
-		return ctx.Err()
+		return context.Cause(ctx)
 	}
 }
 
@@ -141,10 +294,15 @@ func (s *Server) handleStreamToTun(conn *Connection, errChan chan error) {
 			return
 		}
 
+		metrics.Bytes.WithLabelValues(metrics.DirectionIn).Add(float64(len(msg.Data)))
+
 		// Decrypt the frame
 		decrypted, err := conn.cipher.Decrypt(msg.Data)
 		if err != nil {
-			log.Printf("Decryption error: %v", err)
+			metrics.DecryptFailures.Inc()
+			if ok, suppressed := conn.packetLog.Allow(); ok {
+				conn.logger.Warn("Decryption failed", "error", err, "suppressed", suppressed)
+			}
 			continue
 		}
 
@@ -155,16 +313,23 @@ func (s *Server) handleStreamToTun(conn *Connection, errChan chan error) {
 		}
 		frame.Type = decrypted[0]
 		frame.Data = decrypted[1:]
+		metrics.Frames.WithLabelValues(metrics.DirectionIn, metrics.FrameType(frame.Type)).Inc()
 
 		switch frame.Type {
 		case protocol.FrameTypeData:
+			// Apply the client's upload limit
+			if err := conn.limiter.Upload.Wait(conn.ctx, len(frame.Data)); err != nil {
+				errChan <- err
+				return
+			}
+
 			// Write to TUN interface
 			_, err := conn.tunConn.Write(frame.Data)
 			if err != nil {
 				errChan <- fmt.Errorf("tun write error: %v", err)
 				return
 			}
-			conn.bytesDown += int64(len(frame.Data))
+			conn.bytesDown.Add(int64(len(frame.Data)))
 
 		case protocol.FrameTypePing:
 			// Send pong response
@@ -173,17 +338,21 @@ func (s *Server) handleStreamToTun(conn *Connection, errChan chan error) {
 				Data: frame.Data,
 			}
 			s.sendFrame(conn, pongFrame)
-			conn.lastPing = time.Now()
+			conn.lastPing.Store(time.Now().UnixNano())
 
 		case protocol.FrameTypePong:
-			conn.lastPing = time.Now()
+			now := time.Now()
+			conn.lastPing.Store(now.UnixNano())
+			if rtt, ok := rttFromPong(frame.Data, now); ok {
+				conn.rtt.Store(int64(rtt))
+			}
 		}
 	}
 }
 
 func (s *Server) handleTunToStream(conn *Connection, errChan chan error) {
 	buf := make([]byte, 1500) // MTU size
-	
+
 	for {
 		select {
 		case <-conn.ctx.Done():
@@ -200,6 +369,12 @@ func (s *Server) handleTunToStream(conn *Connection, errChan chan error) {
 		}
 
 		if n > 0 {
+			// Apply the client's download limit
+			if err := conn.limiter.Download.Wait(conn.ctx, n); err != nil {
+				errChan <- err
+				return
+			}
+
 			// Create data frame
 			frame := &protocol.Frame{
 				Type: protocol.FrameTypeData,
@@ -211,13 +386,19 @@ func (s *Server) handleTunToStream(conn *Connection, errChan chan error) {
 				errChan <- fmt.Errorf("send frame error: %v", err)
 				return
 			}
-			
-			conn.bytesUp += int64(n)
+
+			conn.bytesUp.Add(int64(n))
 		}
 	}
 }
 
-func (s *Server) sendFrame(conn *Connection, frame *protocol.Frame) error {
+func (s *Server) sendFrame(conn *Connection, frame *protocol.Frame) (err error) {
+	defer func() {
+		if err != nil {
+			metrics.SendDrops.Inc()
+		}
+	}()
+
 	// Serialize frame
 	frameData := make([]byte, 1+len(frame.Data))
 	frameData[0] = frame.Type
@@ -229,9 +410,19 @@ func (s *Server) sendFrame(conn *Connection, frame *protocol.Frame) error {
 		return err
 	}
 
-	// Send via gRPC stream
+	// Send via gRPC stream; Send must not be called concurrently
 	msg := &pb.TunnelFrame{Data: encrypted}
-	return conn.stream.Send(msg)
+	metrics.SendQueueDepth.Inc()
+	conn.sendMutex.Lock()
+	metrics.SendQueueDepth.Dec()
+	defer conn.sendMutex.Unlock()
+	if err := conn.stream.Send(msg); err != nil {
+		return err
+	}
+
+	metrics.Frames.WithLabelValues(metrics.DirectionOut, metrics.FrameType(frame.Type)).Inc()
+	metrics.Bytes.WithLabelValues(metrics.DirectionOut).Add(float64(len(encrypted)))
+	return nil
 }
 
 func (s *Server) keepAlive(conn *Connection) {
@@ -244,30 +435,56 @@ func (s *Server) keepAlive(conn *Connection) {
 			return
 		case <-ticker.C:
 			// Check if connection is alive
-			if time.Since(conn.lastPing) > 30*time.Second {
-				log.Printf("Connection timeout for client %s", conn.client.UUID)
-				conn.cancel()
+			if time.Since(time.Unix(0, conn.lastPing.Load())) > 30*time.Second {
+				conn.logger.Info("Keepalive timeout")
+				metrics.KeepaliveTimeouts.Inc()
+				conn.close(ReasonTimeout)
+				return
+			}
+
+			// Drop sessions whose credentials were rotated or revoked
+			if !s.stillAuthorized(conn) {
+				conn.logger.Info("Credentials no longer valid")
+				conn.close(ReasonRevoked)
 				return
 			}
 
 			// Send ping
 			pingFrame := &protocol.Frame{
 				Type: protocol.FrameTypePing,
-				Data: []byte("ping"),
+				Data: pingPayload(time.Now()),
 			}
-			
+
 			if err := s.sendFrame(conn, pingFrame); err != nil {
-				log.Printf("Failed to send ping: %v", err)
-				conn.cancel()
+				conn.logger.Warn("Failed to send ping", "error", err)
+				conn.cancel(err)
 				return
 			}
 		}
 	}
 }
 
+func (s *Server) stillAuthorized(conn *Connection) bool {
+	client, exists := s.clientManager.GetClient(conn.client.UUID)
+	return exists && client.Secret == conn.secret
+}
+
 func (s *Server) createTunConnection() (net.Conn, error) {
 	// This is a placeholder - in real implementation, this would create
 	// a connection to the TUN interface or routing system
 	// For now, we'll create a simple TCP connection to a local service
 	return net.Dial("tcp", "127.0.0.1:8080")
 }
+
+func (s *Server) authFailed(uuid, reason string) {
+	metrics.AuthAttempts.WithLabelValues(metrics.AuthFailure, reason).Inc()
+	s.logger.Debug("Connect rejected", "client_id", uuid, "reason", reason)
+	s.recordAuthFailure(reason)
+}
+
+// rejected turns away a client whose credentials are valid. It is not an
+// authentication failure and does not count towards auth.failure_burst.
+func (s *Server) rejected(uuid, reason string) {
+	metrics.ConnectRejections.WithLabelValues(reason).Inc()
+	s.logger.Debug("Connect rejected", "client_id", uuid, "reason", reason)
+}
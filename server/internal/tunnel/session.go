@@ -0,0 +1,289 @@
+package tunnel
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+
+	"yagnoetik-vpn/internal/auth"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// Close reasons reported to clients and in session listings.
+const (
+	ReasonKicked    = "disconnected by admin"
+	ReasonSuspended = "client suspended"
+	ReasonExpired   = "subscription expired"
+	ReasonDeleted   = "client deleted"
+	ReasonTimeout   = "keepalive timeout"
+	ReasonRevoked   = "credentials revoked"
+	ReasonReplaced  = "replaced by a newer session"
+)
+
+var ErrSessionLimit = errors.New("session limit reached")
+
+// SessionInfo is a point-in-time view of a live tunnel session.
+type SessionInfo struct {
+	ID            string        `json:"id"`
+	ClientUUID    string        `json:"client_uuid"`
+	ClientName    string        `json:"client_name"`
+	RemoteAddr    string        `json:"remote_addr"`
+	ClientVersion string        `json:"client_version,omitempty"`
+	AssignedIP    string        `json:"assigned_ip"`
+	StartedAt     time.Time     `json:"started_at"`
+	LastPing      time.Time     `json:"last_ping"`
+	RTT           time.Duration `json:"rtt_ns"`
+	BytesUp       int64         `json:"bytes_up"`
+	BytesDown     int64         `json:"bytes_down"`
+}
+
+func (conn *Connection) info() SessionInfo {
+	return SessionInfo{
+		ID:            conn.id,
+		ClientUUID:    conn.client.UUID,
+		ClientName:    conn.client.Name,
+		RemoteAddr:    conn.remoteAddr,
+		ClientVersion: conn.clientVersion,
+		AssignedIP:    conn.assignedAddr(),
+		StartedAt:     conn.startedAt,
+		LastPing:      time.Unix(0, conn.lastPing.Load()),
+		RTT:           time.Duration(conn.rtt.Load()),
+		BytesUp:       conn.bytesUp.Load(),
+		BytesDown:     conn.bytesDown.Load(),
+	}
+}
+
+// close ends the session; the reason is returned to the client as the
+// stream error.
+func (conn *Connection) close(reason string) {
+	conn.cancel(errors.New(reason))
+}
+
+// admit registers the session, enforcing the client's concurrent session
+// limit. Counting and registering happen under one lock so that parallel
+// connects of the same client cannot both slip under the limit.
+func (s *Server) admit(conn *Connection) error {
+	max, policy := conn.client.SessionLimit(s.options.DefaultMaxSessions, s.options.DefaultSessionPolicy)
+
+	s.connMutex.Lock()
+	defer s.connMutex.Unlock()
+
+	if max > 0 {
+		existing := make([]*Connection, 0)
+		for _, other := range s.connections {
+			if other.client.UUID == conn.client.UUID {
+				existing = append(existing, other)
+			}
+		}
+
+		if len(existing) >= max {
+			if policy != auth.SessionPolicyReplace {
+				return ErrSessionLimit
+			}
+			sort.Slice(existing, func(i, j int) bool {
+				return existing[i].startedAt.Before(existing[j].startedAt)
+			})
+			for _, old := range existing[:len(existing)-max+1] {
+				old.close(ReasonReplaced)
+				delete(s.connections, old.id)
+			}
+		}
+	}
+
+	s.connections[conn.id] = conn
+	return nil
+}
+
+// Sessions lists all live sessions.
+func (s *Server) Sessions() []SessionInfo {
+	s.connMutex.RLock()
+	defer s.connMutex.RUnlock()
+
+	sessions := make([]SessionInfo, 0, len(s.connections))
+	for _, conn := range s.connections {
+		sessions = append(sessions, conn.info())
+	}
+	return sessions
+}
+
+// CloseSession kicks a single session by ID.
+func (s *Server) CloseSession(id, reason string) bool {
+	s.connMutex.RLock()
+	defer s.connMutex.RUnlock()
+
+	conn, exists := s.connections[id]
+	if exists {
+		conn.close(reason)
+	}
+	return exists
+}
+
+// CloseClientSessions kicks every session of a client and returns how many
+// were closed.
+func (s *Server) CloseClientSessions(uuid, reason string) int {
+	s.connMutex.RLock()
+	defer s.connMutex.RUnlock()
+
+	closed := 0
+	for _, conn := range s.connections {
+		if conn.client.UUID == uuid {
+			conn.close(reason)
+			closed++
+		}
+	}
+	return closed
+}
+
+func newSessionID() string {
+	bytes := make([]byte, 8)
+	rand.Read(bytes)
+	return hex.EncodeToString(bytes)
+}
+
+// assignedAddr is the session's tunnel address, or "" when it has none.
+func (conn *Connection) assignedAddr() string {
+	if conn.assignedIP == nil {
+		return ""
+	}
+	return conn.assignedIP.String()
+}
+
+// remoteAddr returns the client address. The X-Real-IP header set by the
+// fronting nginx is only believed when the peer is loopback or one of the
+// trusted proxies; anyone else could send it to disguise their address.
+func (s *Server) remoteAddr(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	if s.trustedPeer(p.Addr) {
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if addr, err := netip.ParseAddr(firstValue(md, "x-real-ip")); err == nil {
+				return addr.String()
+			}
+		}
+	}
+	return p.Addr.String()
+}
+
+func (s *Server) trustedPeer(addr net.Addr) bool {
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return false
+	}
+	ip := ap.Addr().Unmap()
+	if ip.IsLoopback() {
+		return true
+	}
+	for _, prefix := range s.options.TrustedProxies {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// firstValue returns the first value of a metadata key, or "".
+func firstValue(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// ParseNetwork parses the network tunnel addresses are assigned from: an
+// IPv4 prefix from /8 to /30, or "off" to assign none.
+func ParseNetwork(s string) (netip.Prefix, error) {
+	if s == "off" {
+		return netip.Prefix{}, nil
+	}
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil || !prefix.Addr().Is4() || prefix.Bits() < 8 || prefix.Bits() > 30 {
+		return netip.Prefix{}, fmt.Errorf("%q is not an IPv4 network from /8 to /30", s)
+	}
+	return prefix.Masked(), nil
+}
+
+// ipPool hands out tunnel addresses from an IPv4 network, skipping the
+// network, gateway and broadcast addresses. The addresses are only
+// informational, so running out of them does not limit sessions.
+type ipPool struct {
+	base  uint32 // network address
+	hosts uint32 // usable addresses, starting at base+2
+	inUse map[uint32]bool
+	mutex sync.Mutex
+}
+
+// newIPPool returns nil for an invalid prefix, which disables addressing.
+func newIPPool(network netip.Prefix) *ipPool {
+	if !network.IsValid() {
+		return nil
+	}
+	base := network.Addr().As4()
+	return &ipPool{
+		base:  binary.BigEndian.Uint32(base[:]),
+		hosts: 1<<(32-network.Bits()) - 3,
+		inUse: make(map[uint32]bool),
+	}
+}
+
+// acquire returns the lowest free address, or nil when addressing is
+// disabled or every address is in use.
+func (p *ipPool) acquire() net.IP {
+	if p == nil {
+		return nil
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for offset := uint32(2); offset < p.hosts+2; offset++ {
+		if !p.inUse[offset] {
+			p.inUse[offset] = true
+			ip := make(net.IP, 4)
+			binary.BigEndian.PutUint32(ip, p.base+offset)
+			return ip
+		}
+	}
+	return nil
+}
+
+func (p *ipPool) release(ip net.IP) {
+	if p == nil || ip == nil {
+		return
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if ip4 := ip.To4(); ip4 != nil {
+		delete(p.inUse, binary.BigEndian.Uint32(ip4)-p.base)
+	}
+}
+
+// pingPayload carries the send time so the pong echo yields the RTT.
+func pingPayload(now time.Time) []byte {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, uint64(now.UnixNano()))
+	return data
+}
+
+func rttFromPong(data []byte, now time.Time) (time.Duration, bool) {
+	if len(data) != 8 {
+		return 0, false
+	}
+	sent := time.Unix(0, int64(binary.BigEndian.Uint64(data)))
+	rtt := now.Sub(sent)
+	if rtt < 0 || rtt > time.Minute {
+		return 0, false
+	}
+	return rtt, true
+}
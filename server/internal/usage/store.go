@@ -0,0 +1,251 @@
+package usage
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"yagnoetik-vpn/internal/durations"
+)
+
+// maxPoints bounds the size of a single query result.
+const maxPoints = 10000
+
+var ErrInvalidStep = errors.New("step must be a multiple of a recorded resolution")
+
+// Point is the traffic of one client during the bucket starting at Time.
+// Directions follow auth.Client: BytesUp is sent to the client and
+// BytesDown is received from it.
+type Point struct {
+	Time      time.Time `json:"time"`
+	BytesUp   int64     `json:"bytes_up"`
+	BytesDown int64     `json:"bytes_down"`
+}
+
+// Resolution is a bucket size and how long buckets of that size are kept.
+type Resolution struct {
+	Step      time.Duration
+	Retention time.Duration
+}
+
+func DefaultResolutions() []Resolution {
+	return []Resolution{
+		{Step: 5 * time.Minute, Retention: 48 * time.Hour},
+		{Step: time.Hour, Retention: 30 * 24 * time.Hour},
+		{Step: 24 * time.Hour, Retention: 365 * 24 * time.Hour},
+	}
+}
+
+// Store keeps per-client traffic time series. Every sample is added to each
+// resolution at once, so coarse series stay available after the fine ones
+// have been dropped.
+type Store struct {
+	resolutions []Resolution         // finest first
+	series      map[string][][]Point // keyed by client UUID, then resolution
+	mutex       sync.RWMutex
+}
+
+func NewStore(resolutions []Resolution) *Store {
+	sorted := append([]Resolution(nil), resolutions...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Step < sorted[j].Step })
+	return &Store{
+		resolutions: sorted,
+		series:      make(map[string][][]Point),
+	}
+}
+
+func (s *Store) Resolutions() []Resolution {
+	return append([]Resolution(nil), s.resolutions...)
+}
+
+// Record adds traffic observed at the given time. Buckets that fell out of
+// their retention are dropped as new ones are opened, and by Prune for
+// clients without new traffic.
+func (s *Store) Record(uuid string, bytesUp, bytesDown int64, at time.Time) {
+	if bytesUp == 0 && bytesDown == 0 {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	series, exists := s.series[uuid]
+	if !exists {
+		series = make([][]Point, len(s.resolutions))
+		s.series[uuid] = series
+	}
+
+	for i, r := range s.resolutions {
+		start := at.Truncate(r.Step).UTC()
+		points := series[i]
+		if n := len(points); n > 0 && points[n-1].Time.Equal(start) {
+			points[n-1].BytesUp += bytesUp
+			points[n-1].BytesDown += bytesDown
+			continue
+		}
+
+		points = append(points, Point{Time: start, BytesUp: bytesUp, BytesDown: bytesDown})
+		series[i] = dropBefore(points, at.Add(-r.Retention))
+	}
+}
+
+// Prune drops buckets that fell out of their retention, and the history of
+// clients left without any.
+func (s *Store) Prune(now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for uuid, series := range s.series {
+		empty := true
+		for i, r := range s.resolutions {
+			series[i] = dropBefore(series[i], now.Add(-r.Retention))
+			if len(series[i]) > 0 {
+				empty = false
+			}
+		}
+		if empty {
+			delete(s.series, uuid)
+		}
+	}
+}
+
+// dropBefore drops the points of a series that start before cutoff.
+func dropBefore(points []Point, cutoff time.Time) []Point {
+	drop := 0
+	for drop < len(points) && points[drop].Time.Before(cutoff) {
+		drop++
+	}
+	if drop == 0 {
+		return points
+	}
+	// Copy, so that the dropped points can be freed
+	return append([]Point(nil), points[drop:]...)
+}
+
+// Query returns the client's traffic between from and to in buckets of step.
+// A zero step picks the finest resolution still retained at from. Buckets
+// without traffic are included with zero counts.
+func (s *Store) Query(uuid string, from, to time.Time, step time.Duration) ([]Point, time.Duration, error) {
+	index, step, err := s.pick(from, step)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	from = from.Truncate(step).UTC()
+	if !to.After(from) {
+		return []Point{}, step, nil
+	}
+	count := int((to.Sub(from) + step - 1) / step)
+	if count > maxPoints {
+		return nil, 0, fmt.Errorf("range too large for step %s: %d points, at most %d", step, count, maxPoints)
+	}
+
+	points := make([]Point, count)
+	for i := range points {
+		points[i].Time = from.Add(time.Duration(i) * step)
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if series, exists := s.series[uuid]; exists {
+		for _, p := range series[index] {
+			if p.Time.Before(from) || !p.Time.Before(to) {
+				continue
+			}
+			bucket := &points[p.Time.Sub(from)/step]
+			bucket.BytesUp += p.BytesUp
+			bucket.BytesDown += p.BytesDown
+		}
+	}
+	return points, step, nil
+}
+
+// pick chooses the resolution a query is answered from: the finest one that
+// divides step and still covers from, falling back to the coarsest one that
+// divides step.
+func (s *Store) pick(from time.Time, step time.Duration) (int, time.Duration, error) {
+	if len(s.resolutions) == 0 {
+		return 0, 0, ErrInvalidStep
+	}
+	if step < 0 {
+		return 0, 0, ErrInvalidStep
+	}
+
+	now := time.Now()
+	fallback := -1
+	for i, r := range s.resolutions {
+		if step != 0 && step%r.Step != 0 {
+			continue
+		}
+		fallback = i
+		if !from.Before(now.Add(-r.Retention)) {
+			if step == 0 {
+				step = r.Step
+			}
+			return i, step, nil
+		}
+	}
+	if fallback < 0 {
+		return 0, 0, ErrInvalidStep
+	}
+	if step == 0 {
+		step = s.resolutions[fallback].Step
+	}
+	return fallback, step, nil
+}
+
+// Series returns every retained bucket of the client keyed by resolution
+// step, such as "5m0s".
+func (s *Store) Series(uuid string) map[string][]Point {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	result := make(map[string][]Point)
+	series := s.series[uuid]
+	for i, r := range s.resolutions {
+		points := make([]Point, 0)
+		if series != nil {
+			points = append(points, series[i]...)
+		}
+		result[r.Step.String()] = points
+	}
+	return result
+}
+
+// Delete drops the client's history.
+func (s *Store) Delete(uuid string) {
+	s.mutex.Lock()
+	delete(s.series, uuid)
+	s.mutex.Unlock()
+}
+
+// ParseResolutions parses a list of the form "5m:48h,1h:30d,1d:365d" where
+// each entry is step:retention. Durations accept a "d" suffix for days.
+func ParseResolutions(s string) ([]Resolution, error) {
+	resolutions := make([]Resolution, 0)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		step, retention, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid resolution %q: expected step:retention", entry)
+		}
+		var r Resolution
+		var err error
+		if r.Step, err = durations.Parse(strings.TrimSpace(step)); err != nil || r.Step <= 0 {
+			return nil, fmt.Errorf("invalid step in %q", entry)
+		}
+		if r.Retention, err = durations.Parse(strings.TrimSpace(retention)); err != nil || r.Retention < r.Step {
+			return nil, fmt.Errorf("invalid retention in %q", entry)
+		}
+		resolutions = append(resolutions, r)
+	}
+	return resolutions, nil
+}
@@ -0,0 +1,311 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"yagnoetik-vpn/internal/events"
+	"yagnoetik-vpn/internal/metrics"
+)
+
+// Headers sent with every delivery.
+const (
+	HeaderEvent    = "X-Yagnoetik-Event"
+	HeaderDelivery = "X-Yagnoetik-Delivery"
+	// HeaderSignature is "t=<unix time>,v1=<hex HMAC-SHA256>"; see Sign.
+	HeaderSignature = "X-Yagnoetik-Signature"
+)
+
+// PingEvent is the type of the test deliveries sent by Test.
+const PingEvent = "webhook.ping"
+
+// scheduleInterval is how often due retries are looked for.
+const scheduleInterval = time.Second
+
+// Sign returns the signature header for a payload: the HMAC-SHA256 of
+// "<timestamp>.<body>" keyed with the subscription's secret. Receivers
+// recompute it and reject old timestamps so that deliveries cannot be
+// replayed.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// Run sends the bus's events to the subscriptions until ctx is cancelled.
+func (d *Dispatcher) Run(ctx context.Context) {
+	for i := 0; i < d.options.Workers; i++ {
+		go d.worker(ctx)
+	}
+	go d.schedule(ctx)
+	d.consume(ctx)
+}
+
+// consume queues deliveries for each event on the bus. When the bus drops
+// the dispatcher for falling behind, it resubscribes after the last event
+// it handled.
+func (d *Dispatcher) consume(ctx context.Context) {
+	var last uint64
+	for {
+		sub, backlog, complete := d.bus.Subscribe(last, 0)
+		if !complete {
+			d.options.Logger.Warn("Webhook deliveries skipped events the bus no longer holds", "after_event", last)
+		}
+		for _, e := range backlog {
+			d.enqueue(e)
+			last = e.ID
+		}
+
+	receive:
+		for {
+			select {
+			case <-ctx.Done():
+				sub.Close()
+				return
+			case e, ok := <-sub.C:
+				if !ok {
+					break receive
+				}
+				d.enqueue(e)
+				last = e.ID
+			}
+		}
+	}
+}
+
+// enqueue queues a delivery of the event for every enabled subscription
+// that selects it.
+func (d *Dispatcher) enqueue(e events.Event) {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		d.options.Logger.Error("Failed to encode webhook payload", "event_id", e.ID, "error", err)
+		return
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for _, sub := range d.subscriptions {
+		if sub.Enabled && events.Match(sub.Events, e.Type) {
+			d.add(sub.ID, e, payload)
+		}
+	}
+}
+
+// Test queues a webhook.ping delivery to a subscription, whether or not it
+// is enabled.
+func (d *Dispatcher) Test(id string) (Delivery, error) {
+	e := events.Event{
+		Type: PingEvent,
+		Time: time.Now().UTC(),
+		Data: map[string]string{"subscription_id": id},
+	}
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return Delivery{}, err
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if _, exists := d.subscriptions[id]; !exists {
+		return Delivery{}, ErrNotFound
+	}
+	return d.add(id, e, payload).copy(), nil
+}
+
+// add appends a pending delivery to the log. The caller must hold d.mutex.
+func (d *Dispatcher) add(subscriptionID string, e events.Event, payload []byte) *Delivery {
+	id, _ := randomHex(8)
+	now := time.Now()
+	delivery := &Delivery{
+		ID:             id,
+		SubscriptionID: subscriptionID,
+		EventID:        e.ID,
+		EventType:      e.Type,
+		Status:         StatusPending,
+		CreatedAt:      now,
+		NextAttemptAt:  now,
+		Attempts:       make([]Attempt, 0),
+		Payload:        payload,
+	}
+	d.deliveries = append(d.deliveries, delivery)
+	d.byID[id] = delivery
+	d.prune()
+	d.kick()
+	return delivery
+}
+
+// prune drops the oldest finished deliveries beyond LogSize. The caller
+// must hold d.mutex.
+func (d *Dispatcher) prune() {
+	excess := len(d.deliveries) - d.options.LogSize
+	if excess <= 0 {
+		return
+	}
+	kept := d.deliveries[:0]
+	droppedDead := false
+	for _, delivery := range d.deliveries {
+		if excess > 0 && delivery.Status != StatusPending {
+			delete(d.byID, delivery.ID)
+			excess--
+			droppedDead = droppedDead || delivery.Status == StatusDead
+			continue
+		}
+		kept = append(kept, delivery)
+	}
+	clear(d.deliveries[len(kept):])
+	d.deliveries = kept
+	if droppedDead {
+		d.save()
+	}
+}
+
+// kick wakes the scheduler. The caller must hold d.mutex.
+func (d *Dispatcher) kick() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// schedule hands due deliveries to the workers.
+func (d *Dispatcher) schedule(ctx context.Context) {
+	ticker := time.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+
+		now := time.Now()
+		var due []*Delivery
+		d.mutex.Lock()
+		for _, delivery := range d.deliveries {
+			if delivery.Status == StatusPending && !delivery.inFlight && !delivery.NextAttemptAt.After(now) {
+				delivery.inFlight = true
+				due = append(due, delivery)
+			}
+		}
+		d.mutex.Unlock()
+
+		for _, delivery := range due {
+			select {
+			case <-ctx.Done():
+				return
+			case d.work <- delivery:
+			}
+		}
+	}
+}
+
+func (d *Dispatcher) worker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case delivery := <-d.work:
+			d.attempt(ctx, delivery)
+		}
+	}
+}
+
+// attempt sends a delivery once and records the outcome: delivered, due
+// again after a backoff, or dead once its attempts are used up.
+func (d *Dispatcher) attempt(ctx context.Context, delivery *Delivery) {
+	d.mutex.Lock()
+	sub, exists := d.subscriptions[delivery.SubscriptionID]
+	if !exists {
+		// Deleted while the delivery was waiting for a worker
+		d.remove(delivery)
+		d.mutex.Unlock()
+		return
+	}
+	target, secret := sub.URL, sub.secret
+	d.mutex.Unlock()
+
+	start := time.Now()
+	status, err := d.send(ctx, target, secret, delivery)
+	attempt := Attempt{At: start, StatusCode: status, Duration: time.Since(start)}
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	delivery.inFlight = false
+	if ctx.Err() != nil {
+		// Shutting down; the attempt does not count
+		return
+	}
+	delivery.Attempts = append(delivery.Attempts, attempt)
+	delivery.tries++
+	logger := d.options.Logger.With("delivery_id", delivery.ID, "subscription_id", delivery.SubscriptionID, "event_type", delivery.EventType)
+	switch {
+	case err == nil:
+		delivery.Status = StatusDelivered
+		metrics.WebhookAttempts.WithLabelValues(metrics.WebhookDelivered).Inc()
+	case delivery.tries >= d.options.MaxAttempts:
+		delivery.Status = StatusDead
+		d.save()
+		metrics.WebhookAttempts.WithLabelValues(metrics.WebhookDead).Inc()
+		logger.Warn("Webhook delivery moved to the dead-letter queue", "attempts", delivery.tries, "error", err)
+	default:
+		delivery.NextAttemptAt = time.Now().Add(d.backoff(delivery.tries))
+		metrics.WebhookAttempts.WithLabelValues(metrics.WebhookRetry).Inc()
+		logger.Debug("Webhook delivery failed, will retry", "attempts", delivery.tries, "next_attempt_at", delivery.NextAttemptAt, "error", err)
+	}
+}
+
+// backoff returns the delay after the given number of failed tries.
+func (d *Dispatcher) backoff(tries int) time.Duration {
+	delay := d.options.Backoff
+	for i := 1; i < tries && delay < d.options.MaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, d.options.MaxBackoff)
+}
+
+// send POSTs the payload and returns the response status. Any status
+// outside 2xx is an error.
+func (d *Dispatcher) send(ctx context.Context, target, secret string, delivery *Delivery) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.options.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Yagnoetik-Webhooks")
+	req.Header.Set(HeaderEvent, delivery.EventType)
+	req.Header.Set(HeaderDelivery, delivery.ID)
+	req.Header.Set(HeaderSignature, Sign(secret, time.Now().Unix(), delivery.Payload))
+
+	resp, err := d.options.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("receiver returned %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
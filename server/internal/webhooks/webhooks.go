@@ -0,0 +1,524 @@
+package webhooks
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"yagnoetik-vpn/internal/events"
+	"yagnoetik-vpn/internal/storage"
+)
+
+// secretPrefix marks signing secrets generated by the server.
+const secretPrefix = "whsec_"
+
+var (
+	ErrNotFound         = errors.New("webhook not found")
+	ErrDeliveryNotFound = errors.New("delivery not found")
+	// ErrNotDead is returned when redelivering or discarding a delivery
+	// that is not in the dead-letter queue.
+	ErrNotDead = errors.New("delivery is not in the dead-letter queue")
+)
+
+// Subscription sends the events it selects to a URL. The signing secret is
+// only returned when the subscription is created or its secret rotated.
+type Subscription struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// Events holds event types or groups such as "quota"; empty selects
+	// every event.
+	Events      []string  `json:"events"`
+	Description string    `json:"description"`
+	Enabled     bool      `json:"enabled"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	secret      string
+}
+
+// SubscriptionUpdate is a partial change to a subscription; nil fields are
+// left untouched.
+type SubscriptionUpdate struct {
+	URL         *string
+	Events      *[]string
+	Description *string
+	Enabled     *bool
+}
+
+type DeliveryStatus string
+
+const (
+	// StatusPending deliveries are waiting for their first or next attempt.
+	StatusPending   DeliveryStatus = "pending"
+	StatusDelivered DeliveryStatus = "delivered"
+	// StatusDead deliveries used up their attempts and wait in the
+	// dead-letter queue to be redelivered or discarded.
+	StatusDead DeliveryStatus = "dead"
+)
+
+// Attempt is one try at sending a delivery.
+type Attempt struct {
+	At time.Time `json:"at"`
+	// StatusCode is the receiver's HTTP status, 0 if no response arrived.
+	StatusCode int           `json:"status_code"`
+	Error      string        `json:"error,omitempty"`
+	Duration   time.Duration `json:"duration_ns"`
+}
+
+// Delivery is one event sent to one subscription.
+type Delivery struct {
+	ID             string         `json:"id"`
+	SubscriptionID string         `json:"subscription_id"`
+	EventID        uint64         `json:"event_id"`
+	EventType      string         `json:"event_type"`
+	Status         DeliveryStatus `json:"status"`
+	CreatedAt      time.Time      `json:"created_at"`
+	NextAttemptAt  time.Time      `json:"next_attempt_at"`
+	Attempts       []Attempt      `json:"attempts"`
+	// Payload is the request body, the JSON of the event.
+	Payload []byte `json:"-"`
+	// tries counts attempts since the delivery was queued or redelivered
+	tries    int
+	inFlight bool
+}
+
+// Query selects deliveries; zero fields are not filtered on. Results are
+// newest first.
+type Query struct {
+	SubscriptionID string
+	Status         DeliveryStatus
+	EventType      string
+	Limit          int
+}
+
+// Options tunes delivery. Zero fields use the defaults.
+type Options struct {
+	// MaxAttempts is how many times a delivery is tried before it goes to
+	// the dead-letter queue; default 8.
+	MaxAttempts int
+	// Backoff is the delay before the first retry, doubled for each one
+	// after up to MaxBackoff; defaults 30s and 1h.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Timeout bounds one attempt; default 10s.
+	Timeout time.Duration
+	// Workers is how many deliveries are sent at once; default 4.
+	Workers int
+	// LogSize caps the delivery log. Once it is full the oldest delivered
+	// and dead deliveries are dropped; pending ones are always kept.
+	// Default 10000.
+	LogSize int
+	// Client sends the requests; nil uses one that does not follow
+	// redirects.
+	Client *http.Client
+	// Logger defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// Dispatcher keeps webhook subscriptions and sends them the events of a bus.
+type Dispatcher struct {
+	bus           *events.Bus
+	options       Options
+	subscriptions map[string]*Subscription
+	deliveries    []*Delivery // oldest first
+	byID          map[string]*Delivery
+	// path, if set, is the file the subscriptions and the dead-letter queue
+	// are saved to
+	path  string
+	work  chan *Delivery
+	wake  chan struct{}
+	mutex sync.Mutex
+}
+
+// savedState is what a dispatcher saves: the subscriptions with their
+// secrets and the dead deliveries with their payloads. Pending deliveries
+// are not saved and are lost on restart.
+type savedState struct {
+	Subscriptions []savedSubscription `json:"subscriptions"`
+	DeadLetters   []savedDelivery     `json:"dead_letters"`
+}
+
+type savedSubscription struct {
+	Subscription
+	Secret string `json:"secret"`
+}
+
+type savedDelivery struct {
+	Delivery
+	Payload []byte `json:"payload"`
+}
+
+func NewDispatcher(bus *events.Bus, options Options) *Dispatcher {
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = 8
+	}
+	if options.Backoff <= 0 {
+		options.Backoff = 30 * time.Second
+	}
+	if options.MaxBackoff <= 0 {
+		options.MaxBackoff = time.Hour
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = 10 * time.Second
+	}
+	if options.Workers <= 0 {
+		options.Workers = 4
+	}
+	if options.LogSize <= 0 {
+		options.LogSize = 10000
+	}
+	if options.Client == nil {
+		options.Client = &http.Client{
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+	}
+	if options.Logger == nil {
+		options.Logger = slog.Default()
+	}
+	return &Dispatcher{
+		bus:           bus,
+		options:       options,
+		subscriptions: make(map[string]*Subscription),
+		byID:          make(map[string]*Delivery),
+		work:          make(chan *Delivery),
+		wake:          make(chan struct{}, 1),
+	}
+}
+
+// Open creates a dispatcher whose subscriptions and dead-letter queue are
+// saved to the file at path, and loads those already there.
+func Open(path string, bus *events.Bus, options Options) (*Dispatcher, error) {
+	var saved savedState
+	if err := storage.ReadJSON(path, &saved); err != nil {
+		return nil, err
+	}
+
+	d := NewDispatcher(bus, options)
+	d.path = path
+	for _, s := range saved.Subscriptions {
+		sub := s.Subscription
+		sub.secret = s.Secret
+		d.subscriptions[sub.ID] = &sub
+	}
+	for _, s := range saved.DeadLetters {
+		delivery := s.Delivery
+		delivery.Payload = s.Payload
+		delivery.Status = StatusDead
+		d.deliveries = append(d.deliveries, &delivery)
+		d.byID[delivery.ID] = &delivery
+	}
+	return d, nil
+}
+
+// save writes the subscriptions and the dead-letter queue to the
+// dispatcher's file. The caller must hold d.mutex.
+func (d *Dispatcher) save() {
+	if d.path == "" {
+		return
+	}
+	state := savedState{
+		Subscriptions: make([]savedSubscription, 0, len(d.subscriptions)),
+		DeadLetters:   make([]savedDelivery, 0),
+	}
+	for _, sub := range d.subscriptions {
+		state.Subscriptions = append(state.Subscriptions, savedSubscription{Subscription: *sub, Secret: sub.secret})
+	}
+	sort.Slice(state.Subscriptions, func(i, j int) bool {
+		return state.Subscriptions[i].CreatedAt.Before(state.Subscriptions[j].CreatedAt)
+	})
+	for _, delivery := range d.deliveries {
+		if delivery.Status == StatusDead {
+			state.DeadLetters = append(state.DeadLetters, savedDelivery{Delivery: delivery.copy(), Payload: delivery.Payload})
+		}
+	}
+	if err := storage.WriteJSON(d.path, state); err != nil {
+		d.options.Logger.Error("Failed to save webhooks", "error", err)
+	}
+}
+
+// ValidateURL checks that a webhook URL is an absolute http or https URL.
+func ValidateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid webhook URL %q, want an http or https URL", raw)
+	}
+	return nil
+}
+
+// validateEvents checks that every pattern selects at least one event type.
+func validateEvents(patterns []string) error {
+	for _, p := range patterns {
+		known := false
+		for _, t := range events.Types {
+			if events.Match([]string{p}, t) {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown event type %q", p)
+		}
+	}
+	return nil
+}
+
+// Create adds an enabled subscription and returns it with its signing
+// secret. An empty secret generates one.
+func (d *Dispatcher) Create(rawURL string, eventTypes []string, description, secret string) (Subscription, string, error) {
+	if err := ValidateURL(rawURL); err != nil {
+		return Subscription{}, "", err
+	}
+	if err := validateEvents(eventTypes); err != nil {
+		return Subscription{}, "", err
+	}
+	id, err := randomHex(8)
+	if err != nil {
+		return Subscription{}, "", err
+	}
+	if secret == "" {
+		if secret, err = newSecret(); err != nil {
+			return Subscription{}, "", err
+		}
+	}
+
+	now := time.Now()
+	sub := &Subscription{
+		ID:          id,
+		URL:         rawURL,
+		Events:      append([]string{}, eventTypes...),
+		Description: description,
+		Enabled:     true,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		secret:      secret,
+	}
+
+	d.mutex.Lock()
+	d.subscriptions[id] = sub
+	d.save()
+	d.mutex.Unlock()
+
+	return *sub, secret, nil
+}
+
+func (d *Dispatcher) Get(id string) (Subscription, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	sub, exists := d.subscriptions[id]
+	if !exists {
+		return Subscription{}, ErrNotFound
+	}
+	return *sub, nil
+}
+
+// List returns the subscriptions, oldest first.
+func (d *Dispatcher) List() []Subscription {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	subs := make([]Subscription, 0, len(d.subscriptions))
+	for _, sub := range d.subscriptions {
+		subs = append(subs, *sub)
+	}
+	sort.Slice(subs, func(i, j int) bool {
+		return subs[i].CreatedAt.Before(subs[j].CreatedAt)
+	})
+	return subs
+}
+
+func (d *Dispatcher) Update(id string, update SubscriptionUpdate) (Subscription, error) {
+	if update.URL != nil {
+		if err := ValidateURL(*update.URL); err != nil {
+			return Subscription{}, err
+		}
+	}
+	if update.Events != nil {
+		if err := validateEvents(*update.Events); err != nil {
+			return Subscription{}, err
+		}
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	sub, exists := d.subscriptions[id]
+	if !exists {
+		return Subscription{}, ErrNotFound
+	}
+	if update.URL != nil {
+		sub.URL = *update.URL
+	}
+	if update.Events != nil {
+		sub.Events = append([]string{}, (*update.Events)...)
+	}
+	if update.Description != nil {
+		sub.Description = *update.Description
+	}
+	if update.Enabled != nil {
+		sub.Enabled = *update.Enabled
+	}
+	sub.UpdatedAt = time.Now()
+	d.save()
+	return *sub, nil
+}
+
+// RotateSecret replaces the signing secret; deliveries sent from then on,
+// including retries, are signed with the new one.
+func (d *Dispatcher) RotateSecret(id string) (Subscription, string, error) {
+	secret, err := newSecret()
+	if err != nil {
+		return Subscription{}, "", err
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	sub, exists := d.subscriptions[id]
+	if !exists {
+		return Subscription{}, "", ErrNotFound
+	}
+	sub.secret = secret
+	sub.UpdatedAt = time.Now()
+	d.save()
+	return *sub, secret, nil
+}
+
+// Delete removes a subscription. Its pending deliveries are dropped; its
+// delivered and dead ones stay in the log.
+func (d *Dispatcher) Delete(id string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if _, exists := d.subscriptions[id]; !exists {
+		return ErrNotFound
+	}
+	delete(d.subscriptions, id)
+
+	kept := d.deliveries[:0]
+	for _, delivery := range d.deliveries {
+		if delivery.SubscriptionID == id && delivery.Status == StatusPending && !delivery.inFlight {
+			delete(d.byID, delivery.ID)
+			continue
+		}
+		kept = append(kept, delivery)
+	}
+	clear(d.deliveries[len(kept):])
+	d.deliveries = kept
+	d.save()
+	return nil
+}
+
+// Deliveries returns copies of the deliveries matching q, newest first.
+func (d *Dispatcher) Deliveries(q Query) []Delivery {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	result := make([]Delivery, 0)
+	for i := len(d.deliveries) - 1; i >= 0; i-- {
+		delivery := d.deliveries[i]
+		if (q.SubscriptionID != "" && delivery.SubscriptionID != q.SubscriptionID) ||
+			(q.Status != "" && delivery.Status != q.Status) ||
+			(q.EventType != "" && !events.Match([]string{q.EventType}, delivery.EventType)) {
+			continue
+		}
+		result = append(result, delivery.copy())
+		if q.Limit > 0 && len(result) >= q.Limit {
+			break
+		}
+	}
+	return result
+}
+
+func (d *Dispatcher) Delivery(id string) (Delivery, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	delivery, exists := d.byID[id]
+	if !exists {
+		return Delivery{}, ErrDeliveryNotFound
+	}
+	return delivery.copy(), nil
+}
+
+// Redeliver moves a dead delivery back to the queue with a fresh set of
+// attempts.
+func (d *Dispatcher) Redeliver(id string) (Delivery, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	delivery, exists := d.byID[id]
+	switch {
+	case !exists:
+		return Delivery{}, ErrDeliveryNotFound
+	case delivery.Status != StatusDead:
+		return Delivery{}, ErrNotDead
+	}
+	if _, exists := d.subscriptions[delivery.SubscriptionID]; !exists {
+		return Delivery{}, fmt.Errorf("subscription %s was deleted", delivery.SubscriptionID)
+	}
+	delivery.Status = StatusPending
+	delivery.NextAttemptAt = time.Now()
+	delivery.tries = 0
+	d.save()
+	d.kick()
+	return delivery.copy(), nil
+}
+
+// Discard drops a dead delivery from the dead-letter queue and the log.
+func (d *Dispatcher) Discard(id string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	delivery, exists := d.byID[id]
+	switch {
+	case !exists:
+		return ErrDeliveryNotFound
+	case delivery.Status != StatusDead:
+		return ErrNotDead
+	}
+	d.remove(delivery)
+	d.save()
+	return nil
+}
+
+// remove drops a delivery from the log. The caller must hold d.mutex.
+func (d *Dispatcher) remove(delivery *Delivery) {
+	delete(d.byID, delivery.ID)
+	for i, other := range d.deliveries {
+		if other == delivery {
+			d.deliveries = append(d.deliveries[:i], d.deliveries[i+1:]...)
+			return
+		}
+	}
+}
+
+func (delivery *Delivery) copy() Delivery {
+	c := *delivery
+	c.Attempts = append([]Attempt{}, delivery.Attempts...)
+	return c
+}
+
+func newSecret() (string, error) {
+	s, err := randomHex(24)
+	if err != nil {
+		return "", err
+	}
+	return secretPrefix + s, nil
+}
+
+func randomHex(n int) (string, error) {
+	bytes := make([]byte, n)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
@@ -0,0 +1,366 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"yagnoetik-vpn/internal/events"
+)
+
+const testSecret = "whsec_test"
+
+// receiver is a webhook endpoint that checks signatures the way a
+// subscriber would and answers with the statuses it is given, then 200.
+type receiver struct {
+	t        *testing.T
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+	mutex    sync.Mutex
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	if err := verify(testSecret, r.Header.Get(HeaderSignature), body, time.Now()); err != nil {
+		rc.t.Errorf("delivery %s: %v", r.Header.Get(HeaderDelivery), err)
+	}
+
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+	rc.requests = append(rc.requests, r)
+	rc.bodies = append(rc.bodies, body)
+	status := http.StatusOK
+	if len(rc.statuses) > 0 {
+		status, rc.statuses = rc.statuses[0], rc.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func (rc *receiver) count() int {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+	return len(rc.requests)
+}
+
+// verify checks a signature header independently of Sign: the HMAC of
+// "<t>.<body>" must match v1 and t must be recent.
+func verify(secret, header string, body []byte, now time.Time) error {
+	var timestamp, signature string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signature = value
+		}
+	}
+	t, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("signature %q has no timestamp", header)
+	}
+	if d := now.Sub(time.Unix(t, 0)); d < -time.Minute || d > 5*time.Minute {
+		return fmt.Errorf("signature timestamp is %s old", d)
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + string(body)))
+	want := hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(signature), []byte(want)) {
+		return fmt.Errorf("signature %q does not match the body", header)
+	}
+	return nil
+}
+
+// start runs a dispatcher until the test ends.
+func start(t *testing.T, d *Dispatcher) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go d.Run(ctx)
+}
+
+// waitStatus waits for a delivery to reach the given status.
+func waitStatus(t *testing.T, d *Dispatcher, id string, status DeliveryStatus) Delivery {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		delivery, err := d.Delivery(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if delivery.Status == status {
+			return delivery
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("delivery %s is %s after 10s, want %s", id, delivery.Status, status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSignature(t *testing.T) {
+	body := []byte(`{"type":"webhook.ping"}`)
+	header := Sign(testSecret, 1700000000, body)
+	if !strings.HasPrefix(header, "t=1700000000,v1=") {
+		t.Fatalf("Sign = %q, want t=1700000000,v1=<hex>", header)
+	}
+	if err := verify(testSecret, header, body, time.Unix(1700000000, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if verify("whsec_other", header, body, time.Unix(1700000000, 0)) == nil {
+		t.Error("signature verified with another secret")
+	}
+	if verify(testSecret, header, []byte(`{"type":"client.deleted"}`), time.Unix(1700000000, 0)) == nil {
+		t.Error("signature verified for another body")
+	}
+	if verify(testSecret, header, body, time.Unix(1700000000, 0).Add(time.Hour)) == nil {
+		t.Error("an hour old signature verified")
+	}
+}
+
+func TestDeliverySigned(t *testing.T) {
+	rc := &receiver{t: t}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	bus := events.NewBus(100)
+	d := NewDispatcher(bus, Options{})
+	sub, _, err := d.Create(server.URL, []string{"client"}, "", testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start(t, d)
+
+	// The dispatcher only sees events published once it has subscribed, so
+	// publish until one arrives
+	deadline := time.Now().Add(10 * time.Second)
+	for len(d.Deliveries(Query{})) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no event was queued after 10s")
+		}
+		bus.Publish(events.QuotaWarning, "uuid-1", nil) // not selected
+		bus.Publish(events.ClientCreated, "uuid-1", map[string]string{"name": "test"})
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := d.Test(sub.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	deliveries := d.Deliveries(Query{})
+	if deliveries[0].EventType != PingEvent {
+		t.Errorf("newest delivery is %s, want the ping", deliveries[0].EventType)
+	}
+	for _, delivery := range deliveries {
+		waitStatus(t, d, delivery.ID, StatusDelivered)
+	}
+
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+	for i, r := range rc.requests {
+		if r.Header.Get(HeaderEvent) == events.QuotaWarning {
+			t.Error("delivered an event the subscription does not select")
+		}
+		if r.Header.Get(HeaderDelivery) == "" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("request %d headers: %v", i, r.Header)
+		}
+	}
+}
+
+func TestRetryWithBackoff(t *testing.T) {
+	rc := &receiver{t: t, statuses: []int{http.StatusInternalServerError, http.StatusBadGateway}}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	const backoff = 200 * time.Millisecond
+	d := NewDispatcher(events.NewBus(100), Options{MaxAttempts: 5, Backoff: backoff})
+	sub, _, err := d.Create(server.URL, nil, "", testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start(t, d)
+
+	queued, err := d.Test(sub.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delivery := waitStatus(t, d, queued.ID, StatusDelivered)
+
+	var codes []int
+	for _, a := range delivery.Attempts {
+		codes = append(codes, a.StatusCode)
+	}
+	if fmt.Sprint(codes) != "[500 502 200]" {
+		t.Fatalf("attempt statuses %v, want [500 502 200]", codes)
+	}
+	if delivery.Attempts[0].Error == "" || delivery.Attempts[2].Error != "" {
+		t.Errorf("attempt errors: %+v", delivery.Attempts)
+	}
+	// The delay doubles after each failure
+	for i, want := range []time.Duration{backoff, 2 * backoff} {
+		if gap := delivery.Attempts[i+1].At.Sub(delivery.Attempts[i].At); gap < want {
+			t.Errorf("retry %d came after %s, want at least %s", i+1, gap, want)
+		}
+	}
+
+	// Every retry carries the same body, freshly signed
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+	for i := 1; i < len(rc.bodies); i++ {
+		if string(rc.bodies[i]) != string(rc.bodies[0]) {
+			t.Errorf("retry %d sent a different body", i)
+		}
+	}
+}
+
+func TestBackoffIsCapped(t *testing.T) {
+	d := NewDispatcher(events.NewBus(1), Options{Backoff: time.Second, MaxBackoff: 5 * time.Second})
+	for tries, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 10: 5 * time.Second} {
+		if got := d.backoff(tries); got != want {
+			t.Errorf("backoff after %d tries = %s, want %s", tries, got, want)
+		}
+	}
+}
+
+func TestDeadLetter(t *testing.T) {
+	rc := &receiver{t: t, statuses: []int{503, 503, 503}}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	d := NewDispatcher(events.NewBus(100), Options{MaxAttempts: 3, Backoff: 10 * time.Millisecond})
+	sub, _, err := d.Create(server.URL, nil, "", testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start(t, d)
+
+	queued, err := d.Test(sub.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead := waitStatus(t, d, queued.ID, StatusDead)
+	if len(dead.Attempts) != 3 || rc.count() != 3 {
+		t.Fatalf("dead after %d attempts and %d requests, want 3", len(dead.Attempts), rc.count())
+	}
+	if queue := d.Deliveries(Query{Status: StatusDead}); len(queue) != 1 || queue[0].ID != queued.ID {
+		t.Fatalf("dead-letter queue %+v, want the delivery", queue)
+	}
+	if err := d.Discard("missing"); err != ErrDeliveryNotFound {
+		t.Errorf("Discard of an unknown delivery: %v", err)
+	}
+
+	// Redelivery gets a fresh set of attempts; the receiver now accepts
+	if _, err := d.Redeliver(queued.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Redeliver(queued.ID); err != ErrNotDead {
+		t.Errorf("second Redeliver: %v, want ErrNotDead", err)
+	}
+	delivered := waitStatus(t, d, queued.ID, StatusDelivered)
+	if len(delivered.Attempts) != 4 {
+		t.Errorf("delivered with %d attempts in the log, want 4", len(delivered.Attempts))
+	}
+	if err := d.Discard(queued.ID); err != ErrNotDead {
+		t.Errorf("Discard of a delivered delivery: %v, want ErrNotDead", err)
+	}
+}
+
+func TestDeliveryLog(t *testing.T) {
+	server := httptest.NewServer(&receiver{t: t})
+	defer server.Close()
+
+	d := NewDispatcher(events.NewBus(100), Options{LogSize: 3})
+	a, _, _ := d.Create(server.URL, []string{"client"}, "", testSecret)
+	b, _, _ := d.Create(server.URL, []string{"quota"}, "", testSecret)
+	start(t, d)
+
+	var ids []string
+	for i := 0; i < 4; i++ {
+		delivery, err := d.Test(a.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		waitStatus(t, d, delivery.ID, StatusDelivered)
+		ids = append(ids, delivery.ID)
+	}
+	d.enqueue(events.Event{ID: 1, Type: events.QuotaExceeded, Time: time.Now(), Client: "uuid-1"})
+
+	all := d.Deliveries(Query{})
+	if len(all) != 3 {
+		t.Fatalf("log holds %d deliveries, want LogSize 3", len(all))
+	}
+	if _, err := d.Delivery(ids[0]); err != ErrDeliveryNotFound {
+		t.Errorf("oldest delivery still in the log: %v", err)
+	}
+	if all[0].EventType != events.QuotaExceeded || all[1].ID != ids[3] || all[2].ID != ids[2] {
+		t.Errorf("log is not newest first: %s %s %s", all[0].ID, all[1].ID, all[2].ID)
+	}
+
+	if got := d.Deliveries(Query{SubscriptionID: a.ID, Limit: 1}); len(got) != 1 || got[0].ID != ids[3] {
+		t.Errorf("subscription query with limit: %+v", got)
+	}
+	if got := d.Deliveries(Query{EventType: "quota"}); len(got) != 1 || got[0].SubscriptionID != b.ID {
+		t.Errorf("event group query: %+v", got)
+	}
+	detail, err := d.Delivery(ids[3])
+	if err != nil || !strings.Contains(string(detail.Payload), PingEvent) {
+		t.Errorf("delivery payload %q, %v", detail.Payload, err)
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	rc := &receiver{t: t, statuses: []int{500}}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "webhooks.json")
+	d, err := Open(path, events.NewBus(100), Options{MaxAttempts: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, _, err := d.Create(server.URL, []string{"client"}, "billing", testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go d.Run(ctx)
+	queued, err := d.Test(sub.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitStatus(t, d, queued.ID, StatusDead)
+	cancel()
+
+	reopened, err := Open(path, events.NewBus(100), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := reopened.Get(sub.ID); err != nil || got.URL != server.URL || got.Description != "billing" {
+		t.Fatalf("reloaded subscription %+v, %v", got, err)
+	}
+	dead, err := reopened.Delivery(queued.ID)
+	if err != nil || dead.Status != StatusDead || len(dead.Attempts) != 1 || len(dead.Payload) == 0 {
+		t.Fatalf("reloaded dead letter %+v, %v", dead, err)
+	}
+
+	// The reloaded secret still signs: the receiver verifies the redelivery
+	start(t, reopened)
+	if _, err := reopened.Redeliver(queued.ID); err != nil {
+		t.Fatal(err)
+	}
+	waitStatus(t, reopened, queued.ID, StatusDelivered)
+	if rc.count() != 2 {
+		t.Errorf("receiver got %d requests, want 2", rc.count())
+	}
+}